@@ -0,0 +1,121 @@
+package ech
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// NewCertManager returns an [autocert.Manager] that obtains and renews the
+// certificates of the ECH public names from Let's Encrypt, with the ACME
+// TLS-ALPN-01 challenge. The certificates and the ACME account key are stored
+// alongside the keys, in store's [KeyStore.CertCache]. The email address is
+// optional. It is used by the CA to notify about problems with the
+// certificates.
+//
+// A certificate for the public name is needed to complete the handshake of the
+// connections whose ECH config is rejected, e.g. to send retry_configs. With
+// a [Router], the public name's handler can use the manager's TLS config,
+// which also answers the ACME challenges:
+//
+//	manager := ech.NewCertManager(store, "admin@example.com", "public.example.com")
+//	router.HandleFunc("public.example.com", func(conn *ech.Conn) {
+//		tc := manager.TLSConfig()
+//		tc.EncryptedClientHelloKeys = keys
+//		server := tls.Server(conn, tc)
+//		// ...
+//	})
+//
+// By using NewCertManager, the caller accepts the CA's Terms of Service.
+func NewCertManager(store *KeyStore, email string, publicNames ...string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      store.CertCache(),
+		HostPolicy: autocert.HostWhitelist(publicNames...),
+		Email:      email,
+	}
+}
+
+// CertCache returns an [autocert.Cache] that stores data in the directory
+// next to the key store, i.e. the key store's path followed by ".certs". The
+// data is encrypted at rest with the key store's [KeyWrapper].
+func (s *KeyStore) CertCache() autocert.Cache {
+	return &certCache{
+		dir:     s.path + ".certs",
+		wrapper: s.wrapper,
+	}
+}
+
+type certCache struct {
+	dir     string
+	wrapper KeyWrapper
+}
+
+func (c *certCache) path(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || filepath.Base(key) != key {
+		return "", fmt.Errorf("invalid cache key %q", key)
+	}
+	return filepath.Join(c.dir, key), nil
+}
+
+// Get implements [autocert.Cache].
+func (c *certCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	path, err := c.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, autocert.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.wrapper.Unwrap(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// Put implements [autocert.Cache].
+func (c *certCache) Put(ctx context.Context, key string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := c.wrapper.Wrap(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, ciphertext)
+}
+
+// Delete implements [autocert.Cache].
+func (c *certCache) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := c.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package ech
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// altSvc is an alternative service advertised in an Alt-Svc header.
+// RFC 7838
+type altSvc struct {
+	protocol string
+	host     string
+	port     uint16
+	maxAge   time.Duration
+}
+
+// parseAltSvc parses the values of Alt-Svc headers. It returns clear=true when
+// the header is "clear", i.e. all the alternative services of the origin must
+// be forgotten. Invalid values are ignored.
+func parseAltSvc(values []string) (services []altSvc, clear bool) {
+	for _, v := range values {
+		for _, alt := range splitQuoted(v, ',') {
+			alt = strings.TrimSpace(alt)
+			if alt == "clear" {
+				return nil, true
+			}
+			params := splitQuoted(alt, ';')
+			proto, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+			if !ok {
+				continue
+			}
+			proto, err := url.PathUnescape(proto)
+			if err != nil {
+				continue
+			}
+			if authority, err = strconv.Unquote(authority); err != nil {
+				continue
+			}
+			host, port, err := net.SplitHostPort(authority)
+			if err != nil {
+				continue
+			}
+			p, err := strconv.ParseUint(port, 10, 16)
+			if err != nil || p == 0 {
+				continue
+			}
+			svc := altSvc{
+				protocol: proto,
+				host:     host,
+				port:     uint16(p),
+				maxAge:   24 * time.Hour,
+			}
+			for _, param := range params[1:] {
+				k, v, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.TrimSpace(k) != "ma" {
+					continue
+				}
+				v = strings.TrimSpace(v)
+				if uv, err := strconv.Unquote(v); err == nil {
+					v = uv
+				}
+				if ma, err := strconv.ParseUint(v, 10, 32); err == nil {
+					svc.maxAge = time.Duration(ma) * time.Second
+				}
+			}
+			services = append(services, svc)
+		}
+	}
+	return services, false
+}
+
+// splitQuoted splits s at each sep that isn't in a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var out []string
+	var quoted, escaped bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			out = append(out, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(out, s[start:])
+}
+
+// altSvcCache contains the alternative services of each origin.
+type altSvcCache struct {
+	mu      sync.Mutex
+	entries map[string][]altSvcEntry
+}
+
+type altSvcEntry struct {
+	host    string
+	port    uint16
+	expires time.Time
+}
+
+// update records the alternative services that use protocol for origin.
+func (c *altSvcCache) update(origin, protocol string, values []string) {
+	services, clear := parseAltSvc(values)
+	if !clear && len(services) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string][]altSvcEntry)
+	}
+	// Each Alt-Svc header replaces the previous ones.
+	delete(c.entries, origin)
+	now := timeNow()
+	for _, s := range services {
+		if s.protocol != protocol || s.maxAge == 0 {
+			continue
+		}
+		c.entries[origin] = append(c.entries[origin], altSvcEntry{
+			host:    s.host,
+			port:    s.port,
+			expires: now.Add(s.maxAge),
+		})
+	}
+}
+
+// get returns the first alternative service of origin that hasn't expired.
+func (c *altSvcCache) get(origin string) (altSvcEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := timeNow()
+	for _, e := range c.entries[origin] {
+		if now.Before(e.expires) {
+			return e, true
+		}
+	}
+	delete(c.entries, origin)
+	return altSvcEntry{}, false
+}
+
+// remove forgets the alternative services of origin.
+func (c *altSvcCache) remove(origin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, origin)
+}
@@ -0,0 +1,70 @@
+package ech
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAltSvc(t *testing.T) {
+	for _, tc := range []struct {
+		values []string
+		want   []altSvc
+		clear  bool
+	}{
+		{
+			values: []string{`h3=":443"`},
+			want:   []altSvc{{protocol: "h3", port: 443, maxAge: 24 * time.Hour}},
+		},
+		{
+			values: []string{`h3=":8443"; ma=60; persist=1, h2="alt.example.com:443";ma="3600"`},
+			want: []altSvc{
+				{protocol: "h3", port: 8443, maxAge: time.Minute},
+				{protocol: "h2", host: "alt.example.com", port: 443, maxAge: time.Hour},
+			},
+		},
+		{
+			values: []string{`h3="[2001:db8::1]:443"`, `w%3Dx%3Ay=":80"`},
+			want: []altSvc{
+				{protocol: "h3", host: "2001:db8::1", port: 443, maxAge: 24 * time.Hour},
+				{protocol: "w=x:y", port: 80, maxAge: 24 * time.Hour},
+			},
+		},
+		{
+			values: []string{`h3=":0", h3=bad, h3="a,b:1;c", h3`},
+		},
+		{
+			values: []string{"clear"},
+			clear:  true,
+		},
+	} {
+		got, clear := parseAltSvc(tc.values)
+		if !reflect.DeepEqual(got, tc.want) || clear != tc.clear {
+			t.Errorf("parseAltSvc(%q) = %+v, %v, want %+v, %v", tc.values, got, clear, tc.want, tc.clear)
+		}
+	}
+}
+
+func TestAltSvcCache(t *testing.T) {
+	now := time.Date(2025, 2, 25, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	defer func() { timeNow = time.Now }()
+
+	var c altSvcCache
+	c.update("https://example.com:443", "h3", []string{`h2=":443", h3=":8443"; ma=60`})
+	if e, ok := c.get("https://example.com:443"); !ok || e.port != 8443 {
+		t.Errorf("get() = %+v, %v", e, ok)
+	}
+	now = now.Add(2 * time.Minute)
+	if e, ok := c.get("https://example.com:443"); ok {
+		t.Errorf("get() after expiration = %+v, %v", e, ok)
+	}
+
+	c.update("https://example.com:443", "h3", []string{`h3=":8443"`})
+	c.update("https://example.com:443", "h3", []string{"clear"})
+	if e, ok := c.get("https://example.com:443"); ok {
+		t.Errorf("get() after clear = %+v, %v", e, ok)
+	}
+}
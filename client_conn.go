@@ -0,0 +1,436 @@
+package ech
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ErrNoUsableConfig is returned by [NewClientConn] when none of the configs in
+// the ECH Config List can be used.
+var ErrNoUsableConfig = errors.New("no usable ech config")
+
+var _ net.Conn = (*ClientConn)(nil)
+
+// NewClientConn returns a [ClientConn] that uses the first usable config from
+// configList to encrypt the ClientHello messages written to conn.
+func NewClientConn(conn net.Conn, configList []byte) (*ClientConn, error) {
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return nil, err
+	}
+	for _, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil || !spec.supported() {
+			continue
+		}
+		kem, err := hpke.NewKEM(spec.KEM)
+		if err != nil {
+			continue
+		}
+		pubKey, err := kem.NewPublicKey(spec.PublicKey)
+		if err != nil {
+			continue
+		}
+		for _, cs := range spec.CipherSuites {
+			kdf, err := hpke.NewKDF(cs.KDF)
+			if err != nil {
+				continue
+			}
+			aead, err := hpke.NewAEAD(cs.AEAD)
+			if err != nil || cs.AEAD == 0xffff { // Export-only
+				continue
+			}
+			return &ClientConn{
+				Conn:   conn,
+				config: cfg,
+				spec:   spec,
+				suite:  cs,
+				pubKey: pubKey,
+				kdf:    kdf,
+				aead:   aead,
+			}, nil
+		}
+	}
+	return nil, ErrNoUsableConfig
+}
+
+// ClientConn is the client side counterpart of [Conn]. It is meant to be used
+// with TLS implementations that can't construct Encrypted Client Hello messages
+// themselves, as defined in RFC 9849.
+//
+// The ClientHello written to ClientConn by the TLS implementation is used as
+// the ClientHelloInner. It must offer TLS 1.3 and include an
+// encrypted_client_hello extension of type inner. ClientConn encodes it, with
+// outer extensions compression and padding, encrypts it, and sends a
+// ClientHelloOuter with the config's public name instead. A ClientHello retried
+// after a HelloRetryRequest is handled the same way.
+//
+// ClientConn then inspects the ServerHello to determine whether the server
+// accepted ECH. When ECH is rejected, the server completes the handshake with
+// the ClientHelloOuter, which the TLS implementation doesn't know about, and
+// the handshake is expected to fail.
+//
+//	conn, err := net.Dial("tcp", "public.example.com:443")
+//	if err != nil {
+//		// ...
+//	}
+//	clientConn, err := ech.NewClientConn(conn, configList)
+//	if err != nil {
+//		// ...
+//	}
+//	tlsConn := customtls.Client(clientConn, ...)
+type ClientConn struct {
+	net.Conn // The underlying connection
+
+	config Config
+	spec   ConfigSpec
+	suite  CipherSuite
+	pubKey hpke.PublicKey
+	kdf    hpke.KDF
+	aead   hpke.AEAD
+
+	hpkeCtx     *hpke.Sender
+	outerRandom []byte
+
+	readBuf          []byte
+	readErr          error
+	writeBuf         []byte
+	readPassthrough  bool
+	writePassthrough bool
+
+	mu          sync.Mutex
+	inners      [][]byte
+	hrr         []byte
+	echAccepted bool
+}
+
+// ECHAccepted returns true if the server accepted the ClientHelloInner. It
+// is only meaningful after the ServerHello has been read.
+func (c *ClientConn) ECHAccepted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.echAccepted
+}
+
+func (c *ClientConn) Read(b []byte) (int, error) {
+	if !c.readPassthrough && len(c.readBuf) == 0 && c.readErr == nil {
+		r, err := readRecord(c.Conn)
+		if err == nil {
+			err = c.inspectRead(r)
+		}
+		c.readBuf, c.readErr = r, err
+	}
+	if len(c.readBuf) > 0 {
+		n := copy(b, c.readBuf)
+		c.readBuf = c.readBuf[n:]
+		if len(c.readBuf) == 0 {
+			return n, c.readErr
+		}
+		return n, nil
+	}
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *ClientConn) inspectRead(record []byte) error {
+	switch {
+	case record[0] == 23:
+		c.readPassthrough = true
+	case record[0] == 22 && len(record) > 5 && record[5] == 2: // Handshake / ServerHello
+		h, err := parseServerHello(record[5:])
+		if err != nil {
+			return fmt.Errorf("%w: parseServerHello: %v", ErrDecodeError, err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if len(c.inners) == 0 {
+			return fmt.Errorf("%w: ServerHello before ClientHello", ErrUnexpectedMessage)
+		}
+		if h.IsHelloRetryRequest() {
+			if c.hrr != nil {
+				return fmt.Errorf("%w: more than one HelloRetryRequest", ErrUnexpectedMessage)
+			}
+			c.hrr = bytes.Clone(record[5:])
+			return nil
+		}
+		c.readPassthrough = true
+		c.echAccepted, err = c.checkAcceptConfirmation(h.CipherSuite, record[5:])
+		return err
+	}
+	return nil
+}
+
+// checkAcceptConfirmation verifies the ECH acceptance signal in the ServerHello
+// message, as specified in Section 7.2 of RFC 9849.
+func (c *ClientConn) checkAcceptConfirmation(cipherSuite uint16, serverHello []byte) (bool, error) {
+	newHash := func() hash.Hash { return sha256.New() }
+	if cipherSuite == 0x1302 { // TLS_AES_256_GCM_SHA384
+		newHash = func() hash.Hash { return sha512.New384() }
+	}
+	if len(serverHello) < 38 {
+		return false, ErrDecodeError
+	}
+	transcript := newHash()
+	if c.hrr != nil && len(c.inners) == 2 {
+		// RFC 8446 Section 4.4.1
+		// When the server responds to a ClientHello with a
+		// HelloRetryRequest, the value of ClientHello1 is replaced with a
+		// special synthetic handshake message of handshake type
+		// "message_hash" containing Hash(ClientHello1).
+		h := newHash()
+		h.Write(c.inners[0])
+		transcript.Write([]byte{254, 0, 0, uint8(h.Size())})
+		transcript.Write(h.Sum(nil))
+		transcript.Write(c.hrr)
+		transcript.Write(c.inners[1])
+	} else {
+		transcript.Write(c.inners[0])
+	}
+	// The last 8 bytes of ServerHello.random are replaced with zeros.
+	transcript.Write(serverHello[:30])
+	transcript.Write(make([]byte, 8))
+	transcript.Write(serverHello[38:])
+
+	// The inner random is at offset 6 in the handshake message.
+	prk, err := hkdf.Extract(newHash, c.inners[0][6:38], nil)
+	if err != nil {
+		return false, err
+	}
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16(8)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 ech accept confirmation"))
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(transcript.Sum(nil))
+	})
+	label, err := b.Bytes()
+	if err != nil {
+		return false, err
+	}
+	confirmation, err := hkdf.Expand(newHash, prk, string(label), 8)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(confirmation, serverHello[30:38]), nil
+}
+
+func (c *ClientConn) Write(b []byte) (int, error) {
+	if c.writePassthrough && len(c.writeBuf) == 0 {
+		return c.Conn.Write(b)
+	}
+	c.writeBuf = append(c.writeBuf, b...)
+	for len(c.writeBuf) >= 5 {
+		length := uint32(c.writeBuf[3])<<8 | uint32(c.writeBuf[4])
+		if length > 16384 {
+			return 0, fmt.Errorf("%w: record length %d > 16384", ErrDecodeError, length)
+		}
+		sz := int(length) + 5
+		if sz > len(c.writeBuf) {
+			break
+		}
+		record := c.writeBuf[:sz]
+		switch {
+		case record[0] == 23:
+			c.writePassthrough = true
+		case record[0] == 22 && sz > 5 && record[5] == 1: // Handshake / ClientHello
+			var err error
+			if record, err = c.encryptClientHello(record); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := c.Conn.Write(record); err != nil {
+			return 0, err
+		}
+		c.writeBuf = c.writeBuf[sz:]
+	}
+	return len(b), nil
+}
+
+// encryptClientHello returns a ClientHelloOuter record that contains the
+// encrypted ClientHelloInner from record, as specified in Section 6.1 of
+// RFC 9849.
+func (c *ClientConn) encryptClientHello(record []byte) ([]byte, error) {
+	inner, err := parseClientHello(record[5:])
+	if err != nil {
+		return nil, err
+	}
+	if inner.echExt == nil || inner.echExt.Type != 1 {
+		return nil, fmt.Errorf("%w: ClientHelloInner encrypted_client_hello missing", ErrMissingExtension)
+	}
+	if !inner.tls13 {
+		return nil, fmt.Errorf("%w: inner doesn't offer tls 1.3", ErrIllegalParameter)
+	}
+	innerMsg, err := inner.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	isRetry := len(c.inners) > 0
+	if isRetry && (c.hrr == nil || len(c.inners) > 1) {
+		return nil, fmt.Errorf("%w: ClientHello without HelloRetryRequest", ErrUnexpectedMessage)
+	}
+
+	// Section 5.1
+	// Extensions that are identical in ClientHelloInner and
+	// ClientHelloOuter are replaced with a single ech_outer_extensions
+	// extension. Since the ClientHelloInner must be reconstructed exactly,
+	// only the longest contiguous sequence of them is compressed.
+	var start, count int
+	for i := 0; i < len(inner.Extensions); {
+		if !isCompressibleExtension(inner.Extensions[i].Type) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(inner.Extensions) && isCompressibleExtension(inner.Extensions[j].Type) {
+			j++
+		}
+		if j-i > count {
+			start, count = i, j-i
+		}
+		i = j
+	}
+
+	encoded := &clientHello{
+		LegacyVersion:            inner.LegacyVersion,
+		Random:                   inner.Random,
+		CipherSuite:              inner.CipherSuite,
+		LegacyCompressionMethods: inner.LegacyCompressionMethods,
+	}
+	outer := &clientHello{
+		LegacyVersion:            inner.LegacyVersion,
+		Random:                   c.outerRandom,
+		LegacySessionID:          inner.LegacySessionID,
+		CipherSuite:              inner.CipherSuite,
+		LegacyCompressionMethods: inner.LegacyCompressionMethods,
+		Extensions:               []extension{serverNameExtension(string(c.spec.PublicName))},
+	}
+	if !isRetry {
+		outer.Random = make([]byte, 32)
+		rand.Read(outer.Random)
+	}
+	for i, ext := range inner.Extensions {
+		if isCompressibleExtension(ext.Type) {
+			outer.Extensions = append(outer.Extensions, ext)
+		}
+		switch {
+		case count > 0 && i == start:
+			var b cryptobyte.Builder
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, e := range inner.Extensions[start : start+count] {
+					b.AddUint16(e.Type)
+				}
+			})
+			data, err := b.Bytes()
+			if err != nil {
+				return nil, err
+			}
+			encoded.Extensions = append(encoded.Extensions, extension{Type: 0xfd00, Data: data})
+		case count > 0 && i > start && i < start+count:
+		default:
+			encoded.Extensions = append(encoded.Extensions, ext)
+		}
+	}
+	encodedMsg, err := encoded.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	encodedMsg = encodedMsg[9:]
+
+	// Section 6.1.3
+	var padding int
+	if inner.ServerName != "" {
+		padding = max(0, int(c.spec.MaximumNameLength)-len(inner.ServerName))
+	} else {
+		padding = int(c.spec.MaximumNameLength) + 9
+	}
+	padding += 31 - ((len(encodedMsg) + padding - 1) % 32)
+	encodedMsg = append(encodedMsg, make([]byte, padding)...)
+
+	var enc []byte
+	if !isRetry {
+		info := append([]byte("tls ech\x00"), c.config...)
+		if enc, c.hpkeCtx, err = hpke.NewSender(c.pubKey, c.kdf, c.aead, info); err != nil {
+			return nil, err
+		}
+		c.outerRandom = outer.Random
+	} else {
+		// Section 6.1.5
+		// The client MUST use an empty enc in the retried ClientHelloOuter.
+		enc = []byte{}
+	}
+
+	// Section 5.2
+	// The ClientHelloOuterAAD is the ClientHelloOuter with the payload
+	// replaced with zeros. The AEAD tag is 16 bytes for all the supported
+	// algorithms.
+	outer.Extensions = append(outer.Extensions, echOuterExtension(c.spec.ID, c.suite, enc, make([]byte, len(encodedMsg)+16)))
+	aad, err := outer.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	payload, err := c.hpkeCtx.Seal(aad[9:], encodedMsg)
+	if err != nil {
+		return nil, err
+	}
+	outer.Extensions[len(outer.Extensions)-1] = echOuterExtension(c.spec.ID, c.suite, enc, payload)
+	out, err := outer.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	c.inners = append(c.inners, innerMsg[5:])
+	return out, nil
+}
+
+// isCompressibleExtension returns true if the extension can be copied from the
+// ClientHelloInner to the ClientHelloOuter. The server_name, ALPN,
+// pre_shared_key, early_data, and ECH extensions are never copied.
+func isCompressibleExtension(extType uint16) bool {
+	switch extType {
+	case 0, 16, 41, 42, 0xfd00, 0xfe0d:
+		return false
+	}
+	return true
+}
+
+func serverNameExtension(name string) extension {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(0x00) // name_type: host_name
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes([]byte(name))
+		})
+	})
+	return extension{Type: 0, Data: b.BytesOrPanic()}
+}
+
+func echOuterExtension(configID uint8, suite CipherSuite, enc, payload []byte) extension {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint8(0x00) // type: outer
+	b.AddUint16(suite.KDF)
+	b.AddUint16(suite.AEAD)
+	b.AddUint8(configID)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(enc)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(payload)
+	})
+	return extension{Type: 0xfe0d, Data: b.BytesOrPanic()}
+}
@@ -0,0 +1,152 @@
+package ech
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+// TestClientConn is an end-to-end test where a go client's ClientHelloInner is
+// decrypted by a first Conn, encrypted again by a ClientConn with a different
+// config, and decrypted by a second Conn in front of the backend server.
+//
+//	tls.Client -> Conn(keys1) -> ClientConn(config2) -> Conn(keys2) -> tls.Server
+func TestClientConn(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		curves []tls.CurveID
+	}{
+		{"Simple", nil},
+		{"HelloRetryRequest", []tls.CurveID{tls.CurveP256}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			privKey1, config1, err := NewConfig(1, []byte("public.example.com"))
+			if err != nil {
+				t.Fatalf("NewConfig: %v", err)
+			}
+			configList1, err := ConfigList([]Config{config1})
+			if err != nil {
+				t.Fatalf("ConfigList: %v", err)
+			}
+			privKey2, config2, err := NewConfig(2, []byte("public.example.com"))
+			if err != nil {
+				t.Fatalf("NewConfig: %v", err)
+			}
+			configList2, err := ConfigList([]Config{config2})
+			if err != nil {
+				t.Fatalf("ConfigList: %v", err)
+			}
+			tlsCert, err := testutil.NewCert("private.example.com")
+			if err != nil {
+				t.Fatalf("NewCert: %v", err)
+			}
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(tlsCert.Leaf)
+
+			ln, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatalf("net.Listen: %v", err)
+			}
+			defer ln.Close()
+
+			go func() {
+				serverConn, err := ln.Accept()
+				if err != nil {
+					t.Errorf("ln.Accept: %v", err)
+					return
+				}
+				conn, err := NewConn(t.Context(), serverConn, WithKeys([]Key{{Config: config2, PrivateKey: privKey2.Bytes()}}))
+				if err != nil {
+					t.Errorf("NewConn: %v", err)
+					return
+				}
+				if got, want := conn.ServerName(), "private.example.com"; got != want {
+					t.Errorf("ServerName() = %q, want %q", got, want)
+				}
+				server := tls.Server(conn, &tls.Config{
+					Certificates:     []tls.Certificate{tlsCert},
+					CurvePreferences: tc.curves,
+				})
+				server.Write([]byte("hello\n"))
+				server.Close()
+			}()
+
+			frontConn, clientConn := net.Pipe()
+			ch := make(chan *ClientConn, 1)
+			go func() {
+				conn, err := NewConn(t.Context(), frontConn, WithKeys([]Key{{Config: config1, PrivateKey: privKey1.Bytes()}}))
+				if err != nil {
+					t.Errorf("NewConn: %v", err)
+					close(ch)
+					return
+				}
+				backend, err := net.Dial("tcp", ln.Addr().String())
+				if err != nil {
+					t.Errorf("net.Dial: %v", err)
+					close(ch)
+					return
+				}
+				cc, err := NewClientConn(backend, configList2)
+				if err != nil {
+					t.Errorf("NewClientConn: %v", err)
+					close(ch)
+					return
+				}
+				ch <- cc
+				relay(conn, cc)
+			}()
+
+			client := tls.Client(clientConn, &tls.Config{
+				ServerName:                     "private.example.com",
+				RootCAs:                        rootCAs,
+				EncryptedClientHelloConfigList: configList1,
+			})
+			defer client.Close()
+			b := make([]byte, 1024)
+			n, err := client.Read(b)
+			if err != nil {
+				t.Fatalf("client.Read: %v", err)
+			}
+			if got, want := string(b[:n]), "hello\n"; got != want {
+				t.Errorf("client.Read = %q, want %q", got, want)
+			}
+			if !client.ConnectionState().ECHAccepted {
+				t.Error("client ECHAccepted = false")
+			}
+			cc := <-ch
+			if cc == nil {
+				t.FailNow()
+			}
+			if !cc.ECHAccepted() {
+				t.Error("ClientConn ECHAccepted() = false")
+			}
+		})
+	}
+}
+
+// TestClientConnMissingInnerExt verifies that a ClientHello without the
+// encrypted_client_hello extension is rejected.
+func TestClientConnMissingInnerExt(t *testing.T) {
+	_, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ConfigList([]Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	cc, err := NewClientConn(newFakeConn(nil), configList)
+	if err != nil {
+		t.Fatalf("NewClientConn: %v", err)
+	}
+	if _, err := cc.Write(newClientHello("private", "tls1.3").bytes()); !errors.Is(err, ErrMissingExtension) {
+		t.Fatalf("Write() = %v, want ErrMissingExtension", err)
+	}
+	if _, err := NewClientConn(newFakeConn(nil), []byte{0, 0}); !errors.Is(err, ErrNoUsableConfig) {
+		t.Fatalf("NewClientConn() = %v, want ErrNoUsableConfig", err)
+	}
+}
@@ -1,6 +1,7 @@
 package ech
 
 import (
+	"crypto/tls"
 	"fmt"
 	"slices"
 	"strings"
@@ -17,8 +18,10 @@ type clientHello struct {
 	LegacyCompressionMethods []byte
 	Extensions               []extension
 
-	ServerName string
-	ALPNProtos []string
+	ServerName      string
+	ALPNProtos      []string
+	SupportedGroups []tls.CurveID
+	KeyShareGroups  []tls.CurveID
 
 	hasECHOuterExtensions bool
 	tls13                 bool
@@ -127,19 +130,24 @@ func parseClientHello(buf []byte) (*clientHello, error) {
 	//      };
 	// } Handshake;
 	s := cryptobyte.String(buf)
+	end := len(buf)
+	decodeErr := func(field string) error {
+		return &ParseError{Message: "ClientHello", Index: -1, Field: field, Offset: end - len(s)}
+	}
 	var msgType uint8
 	if !s.ReadUint8(&msgType) { // msg_type(1)
-		return nil, ErrDecodeError
+		return nil, decodeErr("msg_type")
 	}
 	if msgType != 0x01 { // ClientHello
 		return nil, fmt.Errorf("%w: msg_type 0x%x != 0x01", ErrUnexpectedMessage, msgType)
 	}
 	var ss cryptobyte.String
 	if !s.ReadUint24LengthPrefixed(&ss) {
-		return nil, ErrDecodeError
+		return nil, decodeErr("length")
 	}
 	zeros := s
 	s = ss
+	end = len(buf) - len(zeros)
 
 	// https://datatracker.ietf.org/doc/html/rfc8446#section-4.1.2
 	// ClientHello
@@ -157,23 +165,23 @@ func parseClientHello(buf []byte) (*clientHello, error) {
 	//     Extension extensions<8..2^16-1>;
 	//   } ClientHello;
 	if !s.ReadUint16(&hello.LegacyVersion) { // legacy_version
-		return nil, ErrDecodeError
+		return nil, decodeErr("legacy_version")
 	}
 	if !s.ReadBytes(&hello.Random, 32) { // random
-		return nil, ErrDecodeError
+		return nil, decodeErr("random")
 	}
 
 	var v cryptobyte.String
 	if !s.ReadUint8LengthPrefixed(&v) { // legacy_session_id
-		return nil, ErrDecodeError
+		return nil, decodeErr("legacy_session_id")
 	}
 	hello.LegacySessionID = slices.Clone(v)
 	if !s.ReadUint16LengthPrefixed(&v) { // cipher_suites
-		return nil, ErrDecodeError
+		return nil, decodeErr("cipher_suites")
 	}
 	hello.CipherSuite = slices.Clone(v)
 	if !s.ReadUint8LengthPrefixed(&v) { // legacy_compression_methods
-		return nil, ErrDecodeError
+		return nil, decodeErr("legacy_compression_methods")
 	}
 	hello.LegacyCompressionMethods = slices.Clone(v)
 	//if len(hello.LegacyCompressionMethods) != 1 || hello.LegacyCompressionMethods[0] != 0x0 {
@@ -182,8 +190,9 @@ func parseClientHello(buf []byte) (*clientHello, error) {
 
 	var extensions cryptobyte.String
 	if !s.ReadUint16LengthPrefixed(&extensions) {
-		return nil, ErrDecodeError
+		return nil, decodeErr("extensions")
 	}
+	extEnd := end - len(s)
 
 	// https://datatracker.ietf.org/doc/html/rfc8446#section-4.2
 	// Extensions
@@ -204,7 +213,7 @@ func parseClientHello(buf []byte) (*clientHello, error) {
 		var extType uint16
 		var data cryptobyte.String
 		if !extensions.ReadUint16(&extType) || !extensions.ReadUint16LengthPrefixed(&data) {
-			return nil, ErrDecodeError
+			return nil, &ParseError{Message: "ClientHello", Index: -1, Field: "extension", Offset: extEnd - len(extensions)}
 		}
 		hello.Extensions = append(hello.Extensions, extension{
 			Type: extType,
@@ -227,6 +236,8 @@ func parseClientHello(buf []byte) (*clientHello, error) {
 func (c *clientHello) parseExtensions() error {
 	c.ServerName = ""
 	c.ALPNProtos = nil
+	c.SupportedGroups = nil
+	c.KeyShareGroups = nil
 	c.hasECHOuterExtensions = false
 	c.tls13 = false
 	c.echExt = nil
@@ -302,6 +313,50 @@ func (c *clientHello) parseExtensions() error {
 				c.ALPNProtos = append(c.ALPNProtos, string(protocolName))
 			}
 
+		case 10:
+			// https://datatracker.ietf.org/doc/html/rfc8446#section-4.2.7
+			// Supported Groups
+			//
+			// struct {
+			//     NamedGroup named_group_list<2..2^16-1>;
+			// } NamedGroupList;
+			var namedGroupList cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&namedGroupList) {
+				return fmt.Errorf("%w: named group list", ErrDecodeError)
+			}
+			for !namedGroupList.Empty() {
+				var group uint16
+				if !namedGroupList.ReadUint16(&group) {
+					return fmt.Errorf("%w: named group", ErrDecodeError)
+				}
+				c.SupportedGroups = append(c.SupportedGroups, tls.CurveID(group))
+			}
+
+		case 51:
+			// https://datatracker.ietf.org/doc/html/rfc8446#section-4.2.8
+			// Key Share
+			//
+			// struct {
+			//     NamedGroup group;
+			//     opaque key_exchange<1..2^16-1>;
+			// } KeyShareEntry;
+			//
+			// struct {
+			//     KeyShareEntry client_shares<0..2^16-1>;
+			// } KeyShareClientHello;
+			var clientShares cryptobyte.String
+			if !data.ReadUint16LengthPrefixed(&clientShares) {
+				return fmt.Errorf("%w: client shares", ErrDecodeError)
+			}
+			for !clientShares.Empty() {
+				var group uint16
+				var keyExchange cryptobyte.String
+				if !clientShares.ReadUint16(&group) || !clientShares.ReadUint16LengthPrefixed(&keyExchange) {
+					return fmt.Errorf("%w: key share entry", ErrDecodeError)
+				}
+				c.KeyShareGroups = append(c.KeyShareGroups, tls.CurveID(group))
+			}
+
 		case 43:
 			// struct {
 			//   select (Handshake.msg_type) {
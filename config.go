@@ -1,10 +1,14 @@
 package ech
 
 import (
+	"bytes"
 	"crypto/ecdh"
+	"crypto/hpke"
 	"crypto/rand"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"slices"
 
 	"golang.org/x/crypto/cryptobyte"
 )
@@ -25,20 +29,156 @@ func ConfigList(configs []Config) ([]byte, error) {
 	return b.Bytes()
 }
 
+var (
+	// ErrConfigIDCollision is returned by [MergeConfigLists] when different
+	// configs have the same config ID.
+	ErrConfigIDCollision = errors.New("config id collision")
+	// ErrConfigListTooLarge is returned by [TruncateConfigList] when the
+	// first config doesn't fit in the maximum size.
+	ErrConfigListTooLarge = errors.New("config list too large")
+)
+
+// MergeConfigLists merges serialized Encrypted Client Hello (ECH) Config
+// Lists, e.g. from several client-facing servers, into one Config List.
+// The configs keep their relative order, and duplicate configs are dropped.
+// It returns an error that matches [ErrConfigIDCollision] if different
+// configs have the same config ID, because clients would have no way to
+// tell which key the server should use.
+func MergeConfigLists(configLists ...[]byte) ([]byte, error) {
+	var merged []Config
+	ids := make(map[uint8]Config)
+	for _, configList := range configLists {
+		configs, err := splitConfigList(configList)
+		if err != nil {
+			return nil, err
+		}
+		for _, cfg := range configs {
+			spec, err := cfg.Spec()
+			if err != nil {
+				// Configs with unknown versions don't have a
+				// config ID that we can check.
+				if !slices.ContainsFunc(merged, func(c Config) bool { return bytes.Equal(c, cfg) }) {
+					merged = append(merged, cfg)
+				}
+				continue
+			}
+			if prev, exists := ids[spec.ID]; exists {
+				if !bytes.Equal(prev, cfg) {
+					return nil, fmt.Errorf("%w: %d", ErrConfigIDCollision, spec.ID)
+				}
+				continue
+			}
+			ids[spec.ID] = cfg
+			merged = append(merged, cfg)
+		}
+	}
+	return ConfigList(merged)
+}
+
+// TruncateConfigList removes configs from the end of a serialized Encrypted
+// Client Hello (ECH) Config List until its serialized size is at most maxSize
+// bytes, e.g. to fit in a DNS record. It returns an error that matches
+// [ErrConfigListTooLarge] if the first config alone is too large.
+func TruncateConfigList(configList []byte, maxSize int) ([]byte, error) {
+	if len(configList) <= maxSize {
+		return configList, nil
+	}
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return nil, err
+	}
+	size := 2
+	for i, cfg := range configs {
+		if size+len(cfg) > maxSize {
+			if i == 0 {
+				return nil, fmt.Errorf("%w: %d > %d", ErrConfigListTooLarge, size+len(cfg), maxSize)
+			}
+			return ConfigList(configs[:i])
+		}
+		size += len(cfg)
+	}
+	return configList, nil
+}
+
 // ParseConfigList parses a serialized Encrypted Client Hello (ECH) Config List.
+// Configs that can't be used, i.e. configs with an unknown version, an
+// unsupported KEM, or mandatory extensions, are skipped. Use
+// [ParseConfigListWithSkipped] to get them too.
 func ParseConfigList(configList []byte) ([]ConfigSpec, error) {
+	specs, _, err := ParseConfigListWithSkipped(configList)
+	return specs, err
+}
+
+// ParseConfigListWithSkipped parses a serialized Encrypted Client Hello (ECH)
+// Config List, like [ParseConfigList]. The configs that can't be used are
+// returned in their serialized form in skipped, in the order in which they
+// appear in the list. Clients are required to ignore these configs, which are
+// usually for versions or algorithms that this package doesn't support.
+func ParseConfigListWithSkipped(configList []byte) (specs []ConfigSpec, skipped []Config, err error) {
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil && spec.Version == 0xfe0d {
+			if pe := (*ParseError)(nil); errors.As(err, &pe) {
+				pe.Index = i
+			}
+			return nil, nil, err
+		}
+		if err != nil || !spec.supported() {
+			skipped = append(skipped, cfg)
+			continue
+		}
+		specs = append(specs, spec)
+	}
+	return specs, skipped, nil
+}
+
+// filterConfigList returns a serialized Encrypted Client Hello (ECH) Config
+// List without the configs that can't be used by a client, i.e. configs with
+// an unknown version, an unsupported KEM, or mandatory extensions. It returns
+// nil if no usable configs remain.
+func filterConfigList(configList []byte) ([]byte, error) {
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return nil, err
+	}
+	var usable []Config
+	for _, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil || !spec.supported() {
+			continue
+		}
+		usable = append(usable, cfg)
+	}
+	if len(usable) == 0 {
+		return nil, nil
+	}
+	if len(usable) == len(configs) {
+		return configList, nil
+	}
+	return ConfigList(usable)
+}
+
+// splitConfigList returns the serialized configs in a serialized Encrypted
+// Client Hello (ECH) Config List.
+func splitConfigList(configList []byte) ([]Config, error) {
 	s := cryptobyte.String(configList)
 	var ss cryptobyte.String
-	if !s.ReadUint16LengthPrefixed(&ss) {
-		return nil, ErrDecodeError
+	if !s.ReadUint16LengthPrefixed(&ss) || !s.Empty() {
+		return nil, &ParseError{Message: "ECHConfigList", Index: -1, Field: "length", Offset: 0}
 	}
-	var list []ConfigSpec
+	var list []Config
 	for !ss.Empty() {
-		spec, err := parseConfig(&ss)
-		if err != nil {
-			return nil, err
+		var version uint16
+		var data cryptobyte.String
+		raw := ss
+		if !ss.ReadUint16(&version) || !ss.ReadUint16LengthPrefixed(&data) {
+			return nil, &ParseError{Message: "ECHConfigList", Index: len(list), Field: "ECHConfig", Offset: len(configList) - len(raw)}
 		}
-		list = append(list, spec)
+		list = append(list, Config(raw[:len(raw)-len(ss)]))
 	}
 	return list, nil
 }
@@ -48,6 +188,8 @@ func ParseConfigList(configList []byte) ([]ConfigSpec, error) {
 //   - DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, ChaCha20Poly1305.
 //   - DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-256-GCM.
 //   - DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM.
+//
+// Use [NewConfigWithOptions] for other KEMs.
 func NewConfig(id uint8, publicName []byte) (*ecdh.PrivateKey, Config, error) {
 	if l := len(publicName); l == 0 || l > 255 {
 		return nil, nil, errors.New("invalid public name length")
@@ -56,33 +198,151 @@ func NewConfig(id uint8, publicName []byte) (*ecdh.PrivateKey, Config, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	c := ConfigSpec{
+	conf, err := newConfigSpec(id, KEMX25519, privKey.PublicKey().Bytes(), publicName).Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKey, conf, nil
+}
+
+// KEM identifiers, as defined in RFC 9180 Section 7.1.
+const (
+	KEMP256   uint16 = 0x0010 // DHKEM(P-256, HKDF-SHA256)
+	KEMP384   uint16 = 0x0011 // DHKEM(P-384, HKDF-SHA384)
+	KEMP521   uint16 = 0x0012 // DHKEM(P-521, HKDF-SHA512)
+	KEMX25519 uint16 = 0x0020 // DHKEM(X25519, HKDF-SHA256)
+)
+
+// HPKE KDF identifiers, as defined in RFC 9180 Section 7.2.
+const (
+	KDFHKDFSHA256 uint16 = 0x0001 // HKDF-SHA256
+	KDFHKDFSHA384 uint16 = 0x0002 // HKDF-SHA384
+	KDFHKDFSHA512 uint16 = 0x0003 // HKDF-SHA512
+)
+
+// HPKE AEAD identifiers, as defined in RFC 9180 Section 7.3.
+const (
+	AEADAES128GCM        uint16 = 0x0001 // AES-128-GCM
+	AEADAES256GCM        uint16 = 0x0002 // AES-256-GCM
+	AEADChaCha20Poly1305 uint16 = 0x0003 // ChaCha20Poly1305
+)
+
+// ConfigOption is an option for [NewConfigWithOptions].
+type ConfigOption func(*configOptions)
+
+type configOptions struct {
+	kem               uint16
+	cipherSuites      []CipherSuite
+	maximumNameLength *uint8
+}
+
+// WithKEM sets the KEM used by the config. The default is [KEMX25519].
+// [KEMP256], [KEMP384], and [KEMP521] are also supported. NewConfigWithOptions
+// returns an error if any other KEM is used, e.g. DHKEM(X448, HKDF-SHA512),
+// which isn't supported by the Go standard library.
+func WithKEM(kem uint16) ConfigOption {
+	return func(o *configOptions) {
+		o.kem = kem
+	}
+}
+
+// WithCipherSuites sets the HPKE cipher suites advertised by the config, in
+// order of preference. Any combination of [KDFHKDFSHA256], [KDFHKDFSHA384],
+// [KDFHKDFSHA512] with [AEADAES128GCM], [AEADAES256GCM],
+// [AEADChaCha20Poly1305] is supported. The default is HKDF-SHA256 with
+// ChaCha20Poly1305, AES-256-GCM, and AES-128-GCM.
+//
+// [Conn] accepts any of the cipher suites advertised by the config that the
+// client selects.
+func WithCipherSuites(suites ...CipherSuite) ConfigOption {
+	return func(o *configOptions) {
+		o.cipherSuites = suites
+	}
+}
+
+// WithMaximumNameLength sets the config's maximum_name_length, i.e. the
+// length of the longest name that the client-facing server serves. Clients
+// pad their ClientHelloInner to hide the length of the name that they
+// connect to, up to this length. The default is the length of the public
+// name plus 16, which may not be enough to hide the length of some names. Use
+// [MaximumNameLength] to compute it from a list of names.
+func WithMaximumNameLength(n uint8) ConfigOption {
+	return func(o *configOptions) {
+		o.maximumNameLength = &n
+	}
+}
+
+// MaximumNameLength returns the length of the longest name, up to 255. It can
+// be used with [WithMaximumNameLength].
+func MaximumNameLength(names ...string) uint8 {
+	var n int
+	for _, name := range names {
+		n = max(n, len(name))
+	}
+	return uint8(min(n, 255))
+}
+
+// NewConfigWithOptions generates an Encrypted Client Hello (ECH) Config and a
+// private key, like [NewConfig], with the given options. The private key is
+// serialized as defined in RFC 9180, and can be used as a [Key]'s PrivateKey.
+func NewConfigWithOptions(id uint8, publicName []byte, opts ...ConfigOption) ([]byte, Config, error) {
+	o := configOptions{
+		kem: KEMX25519,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if l := len(publicName); l == 0 || l > 255 {
+		return nil, nil, errors.New("invalid public name length")
+	}
+	kem, err := hpke.NewKEM(o.kem)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unsupported KEM 0x%04x: %w", o.kem, err)
+	}
+	for _, cs := range o.cipherSuites {
+		if _, err := hpke.NewKDF(cs.KDF); err != nil || cs.KDF > KDFHKDFSHA512 {
+			return nil, nil, fmt.Errorf("unsupported KDF 0x%04x", cs.KDF)
+		}
+		if _, err := hpke.NewAEAD(cs.AEAD); err != nil || cs.AEAD > AEADChaCha20Poly1305 {
+			return nil, nil, fmt.Errorf("unsupported AEAD 0x%04x", cs.AEAD)
+		}
+	}
+	privKey, err := kem.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	privKeyBytes, err := privKey.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	spec := newConfigSpec(id, o.kem, privKey.PublicKey().Bytes(), publicName)
+	if len(o.cipherSuites) > 0 {
+		spec.CipherSuites = o.cipherSuites
+	}
+	if o.maximumNameLength != nil {
+		spec.MaximumNameLength = *o.maximumNameLength
+	}
+	conf, err := spec.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	return privKeyBytes, conf, nil
+}
+
+func newConfigSpec(id uint8, kem uint16, publicKey, publicName []byte) ConfigSpec {
+	return ConfigSpec{
 		Version:   0xfe0d,
 		ID:        id,
-		KEM:       0x0020, // DHKEM(X25519, HKDF-SHA256)
-		PublicKey: privKey.PublicKey().Bytes(),
+		KEM:       kem,
+		PublicKey: publicKey,
 		CipherSuites: []CipherSuite{
-			{
-				KDF:  0x0001, // HKDF-SHA256
-				AEAD: 0x0003, // ChaCha20Poly1305
-			},
-			{
-				KDF:  0x0001, // HKDF-SHA256
-				AEAD: 0x0002, // AES-256-GCM
-			},
-			{
-				KDF:  0x0001, // HKDF-SHA256
-				AEAD: 0x0001, // AES-128-GCM
-			},
+			{KDF: KDFHKDFSHA256, AEAD: AEADChaCha20Poly1305},
+			{KDF: KDFHKDFSHA256, AEAD: AEADAES256GCM},
+			{KDF: KDFHKDFSHA256, AEAD: AEADAES128GCM},
 		},
 		MaximumNameLength: uint8(min(len(publicName)+16, 255)),
 		PublicName:        publicName,
 	}
-	conf, err := c.Bytes()
-	if err != nil {
-		return nil, nil, err
-	}
-	return privKey, conf, nil
 }
 
 // Spec returns the structured version of cfg.
@@ -92,44 +352,65 @@ func (cfg Config) Spec() (ConfigSpec, error) {
 
 func parseConfig(s *cryptobyte.String) (ConfigSpec, error) {
 	var out ConfigSpec
+	start := len(*s)
+	decodeErr := func(field string, rest cryptobyte.String) error {
+		return &ParseError{Message: "ECHConfig", Index: -1, Field: field, Offset: start - len(rest)}
+	}
 	if !s.ReadUint16(&out.Version) {
-		return out, ErrDecodeError
+		return out, decodeErr("version", *s)
 	}
 	if out.Version != 0xfe0d {
-		return out, ErrDecodeError
+		return out, fmt.Errorf("%w: unsupported version 0x%04x", ErrDecodeError, out.Version)
 	}
 	var ss cryptobyte.String
 	if !s.ReadUint16LengthPrefixed(&ss) {
-		return out, ErrDecodeError
+		return out, decodeErr("length", *s)
+	}
+	// contents returns an error for a field in ss. nested is the number of
+	// bytes left in the vector being decoded, which precedes ss.
+	contents := func(field string, nested int) error {
+		return &ParseError{Message: "ECHConfig", Index: -1, Field: field, Offset: start - len(*s) - len(ss) - nested}
 	}
 	if !ss.ReadUint8(&out.ID) {
-		return out, ErrDecodeError
+		return out, contents("config_id", 0)
 	}
 	if !ss.ReadUint16(&out.KEM) {
-		return out, ErrDecodeError
+		return out, contents("kem_id", 0)
 	}
 	if !ss.ReadUint16LengthPrefixed((*cryptobyte.String)(&out.PublicKey)) {
-		return out, ErrDecodeError
+		return out, contents("public_key", 0)
 	}
 	var cs cryptobyte.String
 	if !ss.ReadUint16LengthPrefixed(&cs) {
-		return out, ErrDecodeError
+		return out, contents("cipher_suites", 0)
 	}
 	for !cs.Empty() {
 		var suite CipherSuite
 		if !cs.ReadUint16(&suite.KDF) {
-			return out, ErrDecodeError
+			return out, contents("kdf_id", len(cs))
 		}
 		if !cs.ReadUint16(&suite.AEAD) {
-			return out, ErrDecodeError
+			return out, contents("aead_id", len(cs))
 		}
 		out.CipherSuites = append(out.CipherSuites, suite)
 	}
 	if !ss.ReadUint8(&out.MaximumNameLength) {
-		return out, ErrDecodeError
+		return out, contents("maximum_name_length", 0)
 	}
 	if !ss.ReadUint8LengthPrefixed((*cryptobyte.String)(&out.PublicName)) {
-		return out, ErrDecodeError
+		return out, contents("public_name", 0)
+	}
+	// ECHConfigExtension extensions<0..2^16-1>;
+	var exts cryptobyte.String
+	if !ss.ReadUint16LengthPrefixed(&exts) {
+		return out, contents("extensions", 0)
+	}
+	for !exts.Empty() {
+		var ext ConfigExtension
+		if !exts.ReadUint16(&ext.Type) || !exts.ReadUint16LengthPrefixed((*cryptobyte.String)(&ext.Data)) {
+			return out, contents("extension", len(exts))
+		}
+		out.Extensions = append(out.Extensions, ext)
 	}
 	return out, nil
 }
@@ -144,6 +425,35 @@ type ConfigSpec struct {
 	CipherSuites      []CipherSuite
 	MaximumNameLength uint8
 	PublicName        []byte
+	Extensions        []ConfigExtension
+}
+
+// ConfigExtension is an ECHConfigExtension, as specified in Section 4.2 of
+// RFC 9849. Extensions with the high bit of Type set are mandatory.
+type ConfigExtension struct {
+	Type uint16
+	Data []byte
+}
+
+// Mandatory reports whether the extension is mandatory. Clients must skip
+// configs with mandatory extensions that they don't support.
+func (e ConfigExtension) Mandatory() bool {
+	return e.Type&0x8000 != 0
+}
+
+// supported reports whether the config can be used by a client, i.e. its KEM
+// is supported and it doesn't have mandatory extensions. No mandatory
+// extensions are currently supported.
+func (c ConfigSpec) supported() bool {
+	if _, err := hpke.NewKEM(c.KEM); err != nil {
+		return false
+	}
+	for _, ext := range c.Extensions {
+		if ext.Mandatory() {
+			return false
+		}
+	}
+	return true
 }
 
 type CipherSuite struct {
@@ -171,11 +481,18 @@ func (c ConfigSpec) Bytes() (Config, error) {
 				b.AddUint16(cs.AEAD)
 			}
 		})
-		b.AddUint8(uint8(min(len(c.PublicName)+16, 255)))
+		b.AddUint8(c.MaximumNameLength)
 		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
 			b.AddBytes(c.PublicName)
 		})
-		b.AddUint16(0) // extensions
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, ext := range c.Extensions {
+				b.AddUint16(ext.Type)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(ext.Data)
+				})
+			}
+		})
 	})
 	conf, err := b.Bytes()
 	if err != nil {
@@ -2,6 +2,9 @@ package ech
 
 import (
 	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"slices"
 	"testing"
 )
 
@@ -28,3 +31,370 @@ func TestConfig(t *testing.T) {
 		t.Fatalf("Bytes = %v, want %v", got, want)
 	}
 }
+
+func TestNewConfigWithOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		kem   uint16
+		curve ecdh.Curve
+	}{
+		{"P-256", KEMP256, ecdh.P256()},
+		{"P-384", KEMP384, ecdh.P384()},
+		{"P-521", KEMP521, ecdh.P521()},
+		{"X25519", KEMX25519, ecdh.X25519()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			privKey, config, err := NewConfigWithOptions(1, []byte("public.example.com"), WithKEM(tc.kem))
+			if err != nil {
+				t.Fatalf("NewConfigWithOptions: %v", err)
+			}
+			spec, err := config.Spec()
+			if err != nil {
+				t.Fatalf("Spec() = %v", err)
+			}
+			if got, want := spec.KEM, tc.kem; got != want {
+				t.Errorf("KEM = 0x%04x, want 0x%04x", got, want)
+			}
+			pubKey, err := tc.curve.NewPublicKey(spec.PublicKey)
+			if err != nil {
+				t.Fatalf("NewPublicKey: %v", err)
+			}
+			inner := newClientHello("private", "echExtInner", "tls1.3")
+			outer := newClientHello("public", "tls1.3", config, pubKey, inner)
+			conn, err := NewConn(t.Context(), newFakeConn(outer.bytes()), WithKeys([]Key{{Config: config, PrivateKey: privKey}}))
+			if err != nil {
+				t.Fatalf("NewConn: %v", err)
+			}
+			if !conn.ECHAccepted() {
+				t.Error("ECHAccepted() = false")
+			}
+		})
+	}
+	// DHKEM(X448, HKDF-SHA512) isn't supported.
+	if _, _, err := NewConfigWithOptions(1, []byte("public.example.com"), WithKEM(0x0021)); err == nil {
+		t.Error("NewConfigWithOptions(X448) succeeded unexpectedly")
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	var suites []CipherSuite
+	for _, kdf := range []uint16{KDFHKDFSHA256, KDFHKDFSHA384, KDFHKDFSHA512} {
+		for _, aead := range []uint16{AEADAES128GCM, AEADAES256GCM, AEADChaCha20Poly1305} {
+			suites = append(suites, CipherSuite{KDF: kdf, AEAD: aead})
+		}
+	}
+	privKey, config, err := NewConfigWithOptions(1, []byte("public.example.com"), WithCipherSuites(suites...))
+	if err != nil {
+		t.Fatalf("NewConfigWithOptions: %v", err)
+	}
+	spec, err := config.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if got, want := spec.CipherSuites, suites; !slices.Equal(got, want) {
+		t.Errorf("CipherSuites = %v, want %v", got, want)
+	}
+	pubKey, err := ecdh.X25519().NewPublicKey(spec.PublicKey)
+	if err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey}}
+	kdfNames := map[uint16]string{KDFHKDFSHA384: "hkdf-sha384", KDFHKDFSHA512: "hkdf-sha512"}
+	aeadNames := map[uint16]string{AEADAES128GCM: "aes-128", AEADAES256GCM: "aes-256"}
+
+	for _, suite := range suites {
+		opts := []any{"public", "tls1.3", config, pubKey}
+		if n, ok := kdfNames[suite.KDF]; ok {
+			opts = append(opts, n)
+		}
+		if n, ok := aeadNames[suite.AEAD]; ok {
+			opts = append(opts, n)
+		}
+		opts = append(opts, newClientHello("private", "echExtInner", "tls1.3"))
+		outer := newClientHello(opts...)
+		conn, err := NewConn(t.Context(), newFakeConn(outer.bytes()), WithKeys(keys))
+		if err != nil {
+			t.Fatalf("NewConn: %v", err)
+		}
+		if _, got, ok := conn.ECHConfig(); !ok || got != suite {
+			t.Errorf("ECHConfig() = %v, %v, want %v", got, ok, suite)
+		}
+	}
+
+	// The client's cipher suite isn't advertised by the config.
+	privKey, config, err = NewConfigWithOptions(1, []byte("public.example.com"), WithCipherSuites(CipherSuite{KDF: KDFHKDFSHA384, AEAD: AEADAES128GCM}))
+	if err != nil {
+		t.Fatalf("NewConfigWithOptions: %v", err)
+	}
+	if spec, err = config.Spec(); err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if pubKey, err = ecdh.X25519().NewPublicKey(spec.PublicKey); err != nil {
+		t.Fatalf("NewPublicKey: %v", err)
+	}
+	outer := newClientHello("public", "tls1.3", config, pubKey, newClientHello("private", "echExtInner", "tls1.3"))
+	conn, err := NewConn(t.Context(), newFakeConn(outer.bytes()), WithKeys([]Key{{Config: config, PrivateKey: privKey}}))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if conn.ECHAccepted() {
+		t.Error("ECHAccepted() = true, want false")
+	}
+
+	if _, _, err := NewConfigWithOptions(1, []byte("public.example.com"), WithCipherSuites(CipherSuite{KDF: 0x0010, AEAD: AEADAES128GCM})); err == nil {
+		t.Error("NewConfigWithOptions() with unsupported KDF succeeded unexpectedly")
+	}
+}
+
+func TestConfigExtensions(t *testing.T) {
+	_, conf, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	spec, err := conf.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if len(spec.Extensions) != 0 {
+		t.Errorf("Extensions = %v, want none", spec.Extensions)
+	}
+	spec.Extensions = []ConfigExtension{
+		{Type: 0x1234, Data: []byte("foo")},
+		{Type: 0x0001, Data: []byte{}},
+	}
+	conf, err = spec.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+	spec2, err := conf.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if got, want := spec2.Extensions, spec.Extensions; !slices.EqualFunc(got, want, func(a, b ConfigExtension) bool {
+		return a.Type == b.Type && bytes.Equal(a.Data, b.Data)
+	}) {
+		t.Errorf("Extensions = %v, want %v", got, want)
+	}
+	conf2, err := spec2.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+	if !bytes.Equal(conf, conf2) {
+		t.Errorf("Bytes() = %v, want %v", conf2, conf)
+	}
+}
+
+func TestMandatoryConfigExtensions(t *testing.T) {
+	_, conf1, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	_, conf2, err := NewConfig(2, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	spec, err := conf2.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	spec.Extensions = []ConfigExtension{{Type: 0x8001, Data: []byte("foo")}}
+	if conf2, err = spec.Bytes(); err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+
+	configList, err := ConfigList([]Config{conf1, conf2})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	specs, err := ParseConfigList(configList)
+	if err != nil {
+		t.Fatalf("ParseConfigList: %v", err)
+	}
+	if len(specs) != 1 || specs[0].ID != 1 {
+		t.Errorf("ParseConfigList() = %v, want config 1 only", specs)
+	}
+
+	filtered, err := filterConfigList(configList)
+	if err != nil {
+		t.Fatalf("filterConfigList: %v", err)
+	}
+	want, err := ConfigList([]Config{conf1})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if !bytes.Equal(filtered, want) {
+		t.Errorf("filterConfigList() = %v, want %v", filtered, want)
+	}
+
+	onlyMandatory, err := ConfigList([]Config{conf2})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if filtered, err := filterConfigList(onlyMandatory); err != nil || filtered != nil {
+		t.Errorf("filterConfigList() = %v, %v, want nil, nil", filtered, err)
+	}
+	if _, err := NewClientConn(newFakeConn(nil), onlyMandatory); err != ErrNoUsableConfig {
+		t.Errorf("NewClientConn() = %v, want ErrNoUsableConfig", err)
+	}
+}
+
+func TestParseConfigListWithSkipped(t *testing.T) {
+	_, conf1, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	spec, err := conf1.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	spec.ID = 2
+	spec.KEM = 0x0021 // DHKEM(X448, HKDF-SHA512)
+	conf2, err := spec.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() = %v", err)
+	}
+	// A config with a future version.
+	conf3 := Config{0xfe, 0xff, 0x00, 0x03, 1, 2, 3}
+
+	configList, err := ConfigList([]Config{conf3, conf1, conf2})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	specs, skipped, err := ParseConfigListWithSkipped(configList)
+	if err != nil {
+		t.Fatalf("ParseConfigListWithSkipped: %v", err)
+	}
+	if len(specs) != 1 || specs[0].ID != 1 {
+		t.Errorf("specs = %v, want config 1 only", specs)
+	}
+	if got, want := skipped, []Config{conf3, conf2}; !slices.EqualFunc(got, want, func(a, b Config) bool { return bytes.Equal(a, b) }) {
+		t.Errorf("skipped = %v, want %v", got, want)
+	}
+	if specs, err := ParseConfigList(configList); err != nil || len(specs) != 1 {
+		t.Errorf("ParseConfigList() = %v, %v", specs, err)
+	}
+	if _, err := ParseConfigList(configList[:len(configList)-1]); err == nil {
+		t.Error("ParseConfigList() succeeded unexpectedly")
+	}
+}
+
+func TestMergeConfigLists(t *testing.T) {
+	var configs []Config
+	for id := range uint8(3) {
+		_, conf, err := NewConfig(id, []byte("public.example.com"))
+		if err != nil {
+			t.Fatalf("NewConfig: %v", err)
+		}
+		configs = append(configs, conf)
+	}
+	list1, err := ConfigList(configs[:2])
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	list2, err := ConfigList(configs[1:])
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	merged, err := MergeConfigLists(list1, list2)
+	if err != nil {
+		t.Fatalf("MergeConfigLists: %v", err)
+	}
+	want, err := ConfigList(configs)
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if !bytes.Equal(merged, want) {
+		t.Errorf("MergeConfigLists() = %v, want %v", merged, want)
+	}
+
+	_, other, err := NewConfig(1, []byte("other.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	list3, err := ConfigList([]Config{other})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if _, err := MergeConfigLists(list1, list3); !errors.Is(err, ErrConfigIDCollision) {
+		t.Errorf("MergeConfigLists() = %v, want ErrConfigIDCollision", err)
+	}
+
+	truncated, err := TruncateConfigList(merged, len(merged)-1)
+	if err != nil {
+		t.Fatalf("TruncateConfigList: %v", err)
+	}
+	if !bytes.Equal(truncated, list1) {
+		t.Errorf("TruncateConfigList() = %v, want %v", truncated, list1)
+	}
+	if got, err := TruncateConfigList(merged, len(merged)); err != nil || !bytes.Equal(got, merged) {
+		t.Errorf("TruncateConfigList() = %v, %v, want %v", got, err, merged)
+	}
+	if _, err := TruncateConfigList(merged, 10); !errors.Is(err, ErrConfigListTooLarge) {
+		t.Errorf("TruncateConfigList() = %v, want ErrConfigListTooLarge", err)
+	}
+}
+
+func TestMaximumNameLength(t *testing.T) {
+	n := MaximumNameLength("a.example.com", "very-long-backend-name.example.com", "b.example.com")
+	if got, want := n, uint8(34); got != want {
+		t.Fatalf("MaximumNameLength() = %d, want %d", got, want)
+	}
+	_, conf, err := NewConfigWithOptions(1, []byte("public.example.com"), WithMaximumNameLength(n))
+	if err != nil {
+		t.Fatalf("NewConfigWithOptions: %v", err)
+	}
+	spec, err := conf.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if got, want := spec.MaximumNameLength, n; got != want {
+		t.Errorf("MaximumNameLength = %d, want %d", got, want)
+	}
+	_, conf, err = NewConfigWithOptions(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfigWithOptions: %v", err)
+	}
+	if spec, err = conf.Spec(); err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	if got, want := spec.MaximumNameLength, uint8(len("public.example.com")+16); got != want {
+		t.Errorf("MaximumNameLength = %d, want %d", got, want)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, conf, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	spec, err := conf.Spec()
+	if err != nil {
+		t.Fatalf("Spec() = %v", err)
+	}
+	// Truncate the config in the middle of public_name.
+	bad := slices.Clone(conf[:len(conf)-4])
+	bad[2], bad[3] = 0, byte(len(bad)-4)
+
+	configList, err := ConfigList([]Config{conf, bad})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	_, err = ParseConfigList(configList)
+	if !errors.Is(err, ErrDecodeError) {
+		t.Fatalf("ParseConfigList() = %v, want ErrDecodeError", err)
+	}
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("ParseConfigList() = %v, want ParseError", err)
+	}
+	// version(2) length(2) config_id(1) kem_id(2) public_key(2+32)
+	// cipher_suites(2+12) maximum_name_length(1), then public_name's
+	// length prefix(1).
+	want := ParseError{Message: "ECHConfig", Index: 1, Field: "public_name", Offset: 4 + 1 + 2 + 2 + len(spec.PublicKey) + 2 + 12 + 1 + 1}
+	if *pe != want {
+		t.Errorf("ParseError = %+v, want %+v", *pe, want)
+	}
+	if got, want := pe.Error(), "decode error: ECHConfig #1: public_name at offset 57"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
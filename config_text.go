@@ -0,0 +1,82 @@
+package ech
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	// https://www.rfc-editor.org/rfc/rfc9180#section-7.1
+	kemNames = map[uint16]string{
+		0x0000:    "Reserved",
+		KEMP256:   "DHKEM(P-256, HKDF-SHA256)",
+		KEMP384:   "DHKEM(P-384, HKDF-SHA384)",
+		KEMP521:   "DHKEM(P-521, HKDF-SHA512)",
+		KEMX25519: "DHKEM(X25519, HKDF-SHA256)",
+		0x0021:    "DHKEM(X448, HKDF-SHA512)",
+	}
+
+	// https://www.rfc-editor.org/rfc/rfc9180#section-7.2
+	kdfNames = map[uint16]string{
+		0x0000:        "Reserved",
+		KDFHKDFSHA256: "HKDF-SHA256",
+		KDFHKDFSHA384: "HKDF-SHA384",
+		KDFHKDFSHA512: "HKDF-SHA512",
+	}
+
+	// https://www.rfc-editor.org/rfc/rfc9180#section-7.3
+	aeadNames = map[uint16]string{
+		0x0000:               "Reserved",
+		AEADAES128GCM:        "AES-128-GCM",
+		AEADAES256GCM:        "AES-256-GCM",
+		AEADChaCha20Poly1305: "ChaCha20Poly1305",
+		0xFFFF:               "Export-only",
+	}
+)
+
+func codePointName(m map[uint16]string, v uint16) string {
+	if name, ok := m[v]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// String returns a human-readable representation of the config. See
+// [ConfigSpec.MarshalText].
+func (cfg Config) String() string {
+	spec, err := cfg.Spec()
+	if err != nil {
+		return fmt.Sprintf("invalid config (%v): 0x%x", err, []byte(cfg))
+	}
+	b, _ := spec.MarshalText()
+	return string(b)
+}
+
+// MarshalText returns a human-readable, multi-line representation of the
+// config, with the names of the KEM, KDF, and AEAD algorithms.
+func (c ConfigSpec) MarshalText() ([]byte, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "version: 0x%04x\n", c.Version)
+	fmt.Fprintf(&sb, "key_config:\n")
+	fmt.Fprintf(&sb, "  config_id:  0x%02x\n", c.ID)
+	fmt.Fprintf(&sb, "  kem_id:     %s (0x%04x)\n", codePointName(kemNames, c.KEM), c.KEM)
+	fmt.Fprintf(&sb, "  public_key: 0x%x\n", c.PublicKey)
+	fmt.Fprintf(&sb, "  cipher_suites:\n")
+	for _, cs := range c.CipherSuites {
+		fmt.Fprintf(&sb, "    - %s\n", cs)
+	}
+	fmt.Fprintf(&sb, "maximum_name_length: %d\n", c.MaximumNameLength)
+	fmt.Fprintf(&sb, "public_name:         %s\n", c.PublicName)
+	if len(c.Extensions) > 0 {
+		fmt.Fprintf(&sb, "extensions:\n")
+		for _, ext := range c.Extensions {
+			fmt.Fprintf(&sb, "  - 0x%04x: 0x%x\n", ext.Type, ext.Data)
+		}
+	}
+	return []byte(sb.String()), nil
+}
+
+// String returns a human-readable representation of the cipher suite.
+func (cs CipherSuite) String() string {
+	return fmt.Sprintf("%s (0x%04x), %s (0x%04x)", codePointName(kdfNames, cs.KDF), cs.KDF, codePointName(aeadNames, cs.AEAD), cs.AEAD)
+}
@@ -0,0 +1,32 @@
+package ech
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestConfigString(t *testing.T) {
+	privKey, conf, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	want := fmt.Sprintf(`version: 0xfe0d
+key_config:
+  config_id:  0x01
+  kem_id:     DHKEM(X25519, HKDF-SHA256) (0x0020)
+  public_key: 0x%x
+  cipher_suites:
+    - HKDF-SHA256 (0x0001), ChaCha20Poly1305 (0x0003)
+    - HKDF-SHA256 (0x0001), AES-256-GCM (0x0002)
+    - HKDF-SHA256 (0x0001), AES-128-GCM (0x0001)
+maximum_name_length: 34
+public_name:         public.example.com
+`, privKey.PublicKey().Bytes())
+	if got := conf.String(); got != want {
+		t.Errorf("String() = %s\nwant %s", got, want)
+	}
+	if got := (Config{1, 2, 3}).String(); !strings.HasPrefix(got, "invalid config") {
+		t.Errorf("String() = %q", got)
+	}
+}
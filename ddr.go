@@ -0,0 +1,264 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/c2FmZQ/ech/dns"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ErrNoDesignatedResolver is returned by [Resolver.Upgrade] when the bootstrap
+// resolver doesn't designate any encrypted resolver that can be verified.
+var ErrNoDesignatedResolver = errors.New("no designated resolver")
+
+// ddrRootCAs is used to verify the certificates of the designated resolvers.
+// A nil value means the system roots. It is set by tests.
+var ddrRootCAs *x509.CertPool
+
+// NewBootstrapResolver returns a Resolver that sends unencrypted DNS queries
+// to the resolver at addr, e.g. one obtained from DHCP. addr is an IP address
+// with an optional port number. The default port number is 53.
+// [NewSystemResolver] uses the resolver that is configured on the system.
+//
+// Unencrypted DNS queries can be observed and modified by anyone on the
+// network path. The bootstrap Resolver should be upgraded to an encrypted
+// resolver with [Resolver.Upgrade]. [Transport] does that automatically.
+func NewBootstrapResolver(addr string) (*Resolver, error) {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		ip, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q", addr)
+		}
+		ap = netip.AddrPortFrom(ip, 53)
+	}
+	return &Resolver{
+		bootstrapAddr: ap.String(),
+		cache:         newResolverCache(),
+	}, nil
+}
+
+// Upgrade uses Discovery of Designated Resolvers (RFC 9462) to find the
+// DNS-over-HTTPS resolvers designated by a bootstrap resolver, and returns a
+// Resolver that uses the first one that can be verified.
+//
+// A designated resolver is verified when its TLS certificate is valid for its
+// name and for the IP address of the bootstrap resolver (RFC 9462 section
+// 4.2). The returned Resolver connects to the designated resolver's IP
+// address, and verifies its certificate with its name.
+func (r *Resolver) Upgrade(ctx context.Context) (*Resolver, error) {
+	if r.bootstrapAddr == "" {
+		return nil, errors.New("not a bootstrap resolver")
+	}
+	bootstrap := netip.MustParseAddrPort(r.bootstrapAddr).Addr()
+	records, err := r.resolveOne(ctx, "_dns.resolver.arpa", "SVCB", &expiry{})
+	if err != nil {
+		return nil, err
+	}
+	var designated []designatedResolver
+	for _, v := range records {
+		if d, ok := parseDesignatedResolver(v.(dns.SVCB)); ok {
+			designated = append(designated, d)
+		}
+	}
+	slices.SortStableFunc(designated, func(a, b designatedResolver) int {
+		return int(a.priority) - int(b.priority)
+	})
+	var errs []error
+	for _, d := range designated {
+		addrs := d.addrs
+		if len(addrs) == 0 {
+			addrs = []netip.Addr{bootstrap}
+		}
+		for _, addr := range addrs {
+			addr = withZone(addr, bootstrap.Zone())
+			hostPort := net.JoinHostPort(addr.String(), strconv.Itoa(int(d.port)))
+			if err := verifyDesignatedResolver(ctx, d.name, hostPort, bootstrap); err != nil {
+				errs = append(errs, fmt.Errorf("%s (%s): %w", d.name, hostPort, err))
+				continue
+			}
+			return &Resolver{
+				baseURL:      url.URL{Scheme: "https", Host: hostPort, Path: d.path},
+				cache:        newResolverCache(),
+				logger:       r.logger,
+				minTTL:       r.minTTL,
+				maxTTL:       r.maxTTL,
+				maxEntrySize: r.maxEntrySize,
+			}, nil
+		}
+	}
+	return nil, errors.Join(append([]error{ErrNoDesignatedResolver}, errs...)...)
+}
+
+// bootstrapZone returns the IPv6 zone of the address of a bootstrap Resolver,
+// if any. The link-local addresses in its answers are on the same link.
+func (r *Resolver) bootstrapZone() string {
+	if r.bootstrapAddr == "" {
+		return ""
+	}
+	ap, err := netip.ParseAddrPort(r.bootstrapAddr)
+	if err != nil {
+		return ""
+	}
+	return ap.Addr().Zone()
+}
+
+// designatedResolver is a DNS-over-HTTPS resolver from a DDR SVCB record.
+type designatedResolver struct {
+	priority uint16
+	name     string
+	port     uint16
+	path     string
+	addrs    []netip.Addr
+}
+
+// parseDesignatedResolver returns the DNS-over-HTTPS resolver described by
+// rr. Only resolvers that support h2 are returned. RFC 9461
+func parseDesignatedResolver(rr dns.SVCB) (designatedResolver, bool) {
+	d := designatedResolver{
+		priority: rr.Priority,
+		name:     strings.TrimSuffix(rr.Target, "."),
+		port:     443,
+	}
+	if d.priority == 0 || d.name == "" {
+		return d, false
+	}
+	var h2 bool
+	for _, p := range rr.Params {
+		v := cryptobyte.String(p.Value)
+		switch p.Key {
+		case 1: // alpn
+			for !v.Empty() {
+				var proto cryptobyte.String
+				if !v.ReadUint8LengthPrefixed(&proto) {
+					return d, false
+				}
+				if string(proto) == "h2" {
+					h2 = true
+				}
+			}
+		case 3: // port
+			if !v.ReadUint16(&d.port) {
+				return d, false
+			}
+		case 4, 6: // ipv4hint, ipv6hint
+			n := 4
+			if p.Key == 6 {
+				n = 16
+			}
+			for !v.Empty() {
+				var ip []byte
+				if !v.ReadBytes(&ip, n) {
+					return d, false
+				}
+				addr, _ := netip.AddrFromSlice(ip)
+				d.addrs = append(d.addrs, addr.Unmap())
+			}
+		case 7: // dohpath
+			// Only the POST method is used, without the dns variable.
+			d.path, _, _ = strings.Cut(string(p.Value), "{")
+		}
+	}
+	if !h2 || !strings.HasPrefix(d.path, "/") {
+		return d, false
+	}
+	return d, true
+}
+
+// verifyDesignatedResolver connects to the designated resolver at addr and
+// verifies that its certificate is valid for name and for the IP address of
+// the bootstrap resolver.
+func verifyDesignatedResolver(ctx context.Context, name, addr string, bootstrap netip.Addr) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	d := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName: name,
+			RootCAs:    ddrRootCAs,
+			NextProtos: []string{"h2"},
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The IP addresses in certificates don't have zones.
+	cert := conn.(*tls.Conn).ConnectionState().PeerCertificates[0]
+	return cert.VerifyHostname(bootstrap.WithZone("").String())
+}
+
+// resolverUpgrade contains the result of the upgrade of a bootstrap Resolver.
+type resolverUpgrade struct {
+	mu        sync.Mutex
+	bootstrap *Resolver
+	resolver  *Resolver
+	retryAt   time.Time
+	// done is closed when the upgrade in progress completes.
+	done chan struct{}
+}
+
+// get returns the Resolver that r was upgraded to. When the upgrade fails, r is
+// returned, and the upgrade is tried again after a few minutes. Concurrent
+// calls wait for the upgrade in progress, or return r when their ctx is done.
+func (u *resolverUpgrade) get(ctx context.Context, r *Resolver) *Resolver {
+	u.mu.Lock()
+	for u.bootstrap == r && u.done != nil {
+		done := u.done
+		u.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return r
+		}
+		u.mu.Lock()
+	}
+	if u.bootstrap == r {
+		if up := u.resolver; up != nil {
+			u.mu.Unlock()
+			return up
+		}
+		if timeNow().Before(u.retryAt) {
+			u.mu.Unlock()
+			return r
+		}
+	}
+	done := make(chan struct{})
+	defer close(done)
+	u.bootstrap, u.resolver, u.done = r, nil, done
+	u.mu.Unlock()
+
+	// The lock isn't held while the designated resolvers are probed.
+	up, err := r.Upgrade(ctx)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.done != done {
+		// The bootstrap Resolver was replaced in the meantime.
+		if err != nil {
+			return r
+		}
+		return up
+	}
+	u.done = nil
+	if err != nil {
+		logger(r.logger).Info("ech: resolver upgrade failed", "bootstrap", r.bootstrapAddr, "err", err)
+		u.retryAt = timeNow().Add(5 * time.Minute)
+		return r
+	}
+	logger(r.logger).Debug("ech: resolver upgraded", "bootstrap", r.bootstrapAddr, "resolver", up.baseURL.String())
+	u.resolver = up
+	return up
+}
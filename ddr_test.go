@@ -0,0 +1,229 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func TestNewBootstrapResolver(t *testing.T) {
+	for _, tc := range []struct {
+		addr, want string
+	}{
+		{"192.0.2.1", "192.0.2.1:53"},
+		{"192.0.2.1:5353", "192.0.2.1:5353"},
+		{"2001:db8::1", "[2001:db8::1]:53"},
+		{"[2001:db8::1]:5353", "[2001:db8::1]:5353"},
+		{"dns.example.com", ""},
+	} {
+		r, err := NewBootstrapResolver(tc.addr)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("NewBootstrapResolver(%q) succeeded unexpectedly", tc.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NewBootstrapResolver(%q): %v", tc.addr, err)
+			continue
+		}
+		if got := r.bootstrapAddr; got != tc.want {
+			t.Errorf("NewBootstrapResolver(%q) = %q, want %q", tc.addr, got, tc.want)
+		}
+	}
+}
+
+// startTestDoHServer starts a TLS server with a certificate for names, and
+// returns its port number.
+func startTestDoHServer(t *testing.T, rootCAs *x509.CertPool, names ...string) uint16 {
+	t.Helper()
+	tlsCert, err := testutil.NewCert(names...)
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs.AddCert(tlsCert.Leaf)
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return uint16(server.Listener.Addr().(*net.TCPAddr).Port)
+}
+
+func designationRR(priority uint16, target string, port uint16) dns.RR {
+	return dns.RR{
+		Name: "_dns.resolver.arpa", Type: 64, Class: 1, TTL: 60,
+		Data: dns.SVCB{
+			Priority: priority,
+			Target:   target,
+			Params: []dns.SVCBParam{
+				{Key: 1, Value: []byte("\x02h2")},
+				{Key: 3, Value: []byte{byte(port >> 8), byte(port)}},
+				{Key: 7, Value: []byte("/dns-query{?dns}")},
+			},
+		},
+	}
+}
+
+func TestResolverUpgrade(t *testing.T) {
+	rootCAs := x509.NewCertPool()
+	ddrRootCAs = rootCAs
+	t.Cleanup(func() { ddrRootCAs = nil })
+
+	// The certificate of this server doesn't have the IP address of the
+	// bootstrap resolver.
+	badPort := startTestDoHServer(t, rootCAs, "bad.example.com")
+	goodPort := startTestDoHServer(t, rootCAs, "dns.example.com", "127.0.0.1")
+
+	addr := testutil.StartTestDo53Server(t, []dns.RR{
+		designationRR(1, "bad.example.com", badPort),
+		designationRR(2, "dns.example.com", goodPort),
+		{
+			Name: "private.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 1},
+		},
+	})
+	bootstrap, err := NewBootstrapResolver(addr)
+	if err != nil {
+		t.Fatalf("NewBootstrapResolver: %v", err)
+	}
+
+	res, err := bootstrap.Resolve(t.Context(), "private.example.com")
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got, want := res.Address, []net.IP{{192, 0, 2, 1}}; len(got) != 1 || !got[0].Equal(want[0]) {
+		t.Errorf("Address = %v, want %v", got, want)
+	}
+
+	up, err := bootstrap.Upgrade(t.Context())
+	if err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	want := "https://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(int(goodPort))) + "/dns-query"
+	if got := up.baseURL.String(); got != want {
+		t.Errorf("Upgrade() = %q, want %q", got, want)
+	}
+
+	transport := NewTransport()
+	transport.Resolver = bootstrap
+	if got := transport.resolver(t.Context()).baseURL.String(); got != want {
+		t.Errorf("Transport resolver = %q, want %q", got, want)
+	}
+	transport.DisableResolverUpgrade = true
+	if got := transport.resolver(t.Context()); got != bootstrap {
+		t.Errorf("Transport resolver = %v, want bootstrap", got)
+	}
+
+	// No designated resolver can be verified.
+	addr = testutil.StartTestDo53Server(t, []dns.RR{
+		designationRR(1, "bad.example.com", badPort),
+	})
+	if bootstrap, err = NewBootstrapResolver(addr); err != nil {
+		t.Fatalf("NewBootstrapResolver: %v", err)
+	}
+	if _, err := bootstrap.Upgrade(t.Context()); !errors.Is(err, ErrNoDesignatedResolver) {
+		t.Errorf("Upgrade() = %v, want ErrNoDesignatedResolver", err)
+	}
+	transport.DisableResolverUpgrade = false
+	transport.Resolver = bootstrap
+	if got := transport.resolver(t.Context()); got != bootstrap {
+		t.Errorf("Transport resolver = %v, want bootstrap", got)
+	}
+}
+
+// TestResolverUpgradeAddressHint verifies that the certificate of a designated
+// resolver doesn't need to have its own IP address, only the bootstrap
+// resolver's.
+func TestResolverUpgradeAddressHint(t *testing.T) {
+	rootCAs := x509.NewCertPool()
+	ddrRootCAs = rootCAs
+	t.Cleanup(func() { ddrRootCAs = nil })
+
+	tlsCert, err := testutil.NewCert("dns.example.com", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs.AddCert(tlsCert.Leaf)
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("net.Listen: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.Listener.Close()
+	server.Listener = ln
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{tlsCert}}
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+
+	rr := designationRR(1, "dns.example.com", port)
+	svcb := rr.Data.(dns.SVCB)
+	svcb.Params = append(svcb.Params, dns.SVCBParam{Key: 4, Value: []byte{127, 0, 0, 2}})
+	rr.Data = svcb
+	bootstrap, err := NewBootstrapResolver(testutil.StartTestDo53Server(t, []dns.RR{rr}))
+	if err != nil {
+		t.Fatalf("NewBootstrapResolver: %v", err)
+	}
+	up, err := bootstrap.Upgrade(t.Context())
+	if err != nil {
+		t.Fatalf("Upgrade: %v", err)
+	}
+	want := "https://" + net.JoinHostPort("127.0.0.2", strconv.Itoa(int(port))) + "/dns-query"
+	if got := up.baseURL.String(); got != want {
+		t.Errorf("Upgrade() = %q, want %q", got, want)
+	}
+}
+
+// TestResolverUpgradeConcurrent verifies that the callers that wait for an
+// upgrade in progress can give up when their context is done.
+func TestResolverUpgradeConcurrent(t *testing.T) {
+	rootCAs := x509.NewCertPool()
+	ddrRootCAs = rootCAs
+	t.Cleanup(func() { ddrRootCAs = nil })
+
+	port := startTestDoHServer(t, rootCAs, "dns.example.com", "127.0.0.1")
+	addr := testutil.StartTestDo53Server(t, []dns.RR{
+		designationRR(1, "dns.example.com", port),
+	}, testutil.WithLatency(500*time.Millisecond))
+	bootstrap, err := NewBootstrapResolver(addr)
+	if err != nil {
+		t.Fatalf("NewBootstrapResolver: %v", err)
+	}
+
+	var u resolverUpgrade
+	ch := make(chan *Resolver)
+	go func() {
+		ch <- u.get(t.Context(), bootstrap)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if got := u.get(ctx, bootstrap); got != bootstrap {
+		t.Errorf("get() = %v, want bootstrap", got)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Errorf("get() took %v", d)
+	}
+
+	up := <-ch
+	if up == bootstrap {
+		t.Fatal("get() = bootstrap, want upgraded resolver")
+	}
+	if got := u.get(t.Context(), bootstrap); got != up {
+		t.Errorf("get() = %v, want %v", got, up)
+	}
+}
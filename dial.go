@@ -1,19 +1,34 @@
 package ech
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"iter"
+	"log/slog"
 	"net"
+	"net/netip"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrECHNotAccepted is returned by [Dialer.Dial] when RequireECHAccepted is
+// set and the server didn't accept Encrypted Client Hello.
+var ErrECHNotAccepted = errors.New("ech not accepted")
+
+var errNoECHConfigList = errors.New("unable to get ECH config list")
+
+// errNoSockets is returned by the DialFuncs set by NewDialer and Transport on
+// platforms that don't have sockets, i.e. js/wasm.
+var errNoSockets = fmt.Errorf("%w: %w: sockets aren't available on js/wasm, DialFunc must be set", ErrInvalidConfiguration, errors.ErrUnsupported)
+
 // Dial connects to the given network and address. Name resolution is done with
 // [DefaultResolver]. It uses HTTPS DNS records to retrieve the server's
 // Encrypted Client Hello (ECH) Config List and uses it automatically if found.
@@ -39,29 +54,46 @@ func Dial(ctx context.Context, network, addr string, tc *tls.Config) (*tls.Conn,
 
 // NewDialer returns a [tls.Conn] Dialer.
 func NewDialer() *Dialer[*tls.Conn] {
-	return &Dialer[*tls.Conn]{
-		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*tls.Conn, error) {
+	netDialer := &net.Dialer{
+		Resolver: &net.Resolver{
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("not using go resolver")
+			},
+		},
+	}
+	d := &Dialer[*tls.Conn]{
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	d.DialFunc = func(ctx context.Context, network, addr string, tc *tls.Config) (*tls.Conn, error) {
+		proxy := contextProxy(ctx, d.Proxy)
+		if proxy == nil && d.TCPOptions == nil && haveSockets {
 			tlsDialer := &tls.Dialer{
-				NetDialer: &net.Dialer{
-					Resolver: &net.Resolver{
-						Dial: func(context.Context, string, string) (net.Conn, error) {
-							return nil, errors.New("not using go resolver")
-						},
-					},
-				},
-				Config: tc,
+				NetDialer: netDialer,
+				Config:    tc,
 			}
 			conn, err := tlsDialer.DialContext(ctx, network, addr)
 			if err != nil {
 				return nil, err
 			}
 			return conn.(*tls.Conn), nil
-		},
+		}
+		rawConn, err := dialNet(ctx, proxy, netDialer, d.TCPOptions, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn := tls.Client(rawConn, tc)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return conn, nil
 	}
+	return d
 }
 
-// newNetDialer returns a plaintext [net.Conn] Dialer.
-func newNetDialer() *Dialer[net.Conn] {
+// newNetDialer returns a plaintext [net.Conn] Dialer. The connections are
+// established with the Proxy and TCPOptions of the Dialer returned by parent.
+func newNetDialer(parent func() *Dialer[*tls.Conn]) *Dialer[net.Conn] {
 	d := &net.Dialer{
 		Resolver: &net.Resolver{
 			Dial: func(context.Context, string, string) (net.Conn, error) {
@@ -71,7 +103,8 @@ func newNetDialer() *Dialer[net.Conn] {
 	}
 	return &Dialer[net.Conn]{
 		DialFunc: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
-			return d.DialContext(ctx, network, addr)
+			p := parent()
+			return dialNet(ctx, contextProxy(ctx, p.Proxy), d, p.TCPOptions, network, addr)
 		},
 	}
 }
@@ -90,6 +123,36 @@ type Dialer[T any] struct {
 	// By default, when RequireECH is false, Dial falls back to regular
 	// plaintext Client Hello when a Config List isn't found.
 	RequireECH bool
+	// RequireECHAccepted is a stricter version of RequireECH. It also
+	// verifies that the server actually accepted ECH after the handshake,
+	// and closes the connection if it didn't. It requires a connection type
+	// with a ConnectionState() tls.ConnectionState method, e.g.
+	// [tls.Conn], or ConnectionStateFunc. Dial fails with other connection
+	// types.
+	RequireECHAccepted bool
+	// ClientSessionCache is used for TLS session resumption when the
+	// tls.Config passed to Dial doesn't have one. Sessions are keyed by
+	// server name, i.e. the name in the ClientHelloInner. NewDialer sets
+	// it to an LRU cache.
+	ClientSessionCache tls.ClientSessionCache
+	// KeyLogWriter, if set, is used as the KeyLogWriter of the tls.Config
+	// when the one passed to Dial doesn't have one. The TLS master secrets
+	// are written to it in NSS key log format, e.g. to decrypt the
+	// handshakes with Wireshark. It is used with TCP and QUIC connections.
+	// Using it compromises security, and should only be done for
+	// debugging.
+	//
+	//	f, err := os.OpenFile(os.Getenv("SSLKEYLOGFILE"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	//	if err != nil {
+	//		// ...
+	//	}
+	//	dialer.KeyLogWriter = f
+	KeyLogWriter io.Writer
+	// Hosts, if set, maps host names to static addresses and ECH config
+	// lists. It is consulted before the Resolver. The names must be
+	// lowercase and without a trailing dot. When Dialer is used by
+	// Transport, this value is ignored.
+	Hosts map[string]StaticHost
 	// Resolver specifies the resolver to use for DNS lookups. If nil,
 	// DefaultResolver is used. When Dialer is used by Transport, this
 	// value is ignored.
@@ -100,19 +163,112 @@ type Dialer[T any] struct {
 	// PublicName and use it to establish a TLS connection with the server,
 	// which should return the real Config List in RetryConfigList.
 	PublicName string
+	// Policies, if set, maps host name patterns to policies that override
+	// RequireECH, RequireECHAccepted, and PublicName for the matching
+	// hosts, e.g. to require ECH for ".private.example.com" only. The
+	// patterns are the same as those of [Router], and must be lowercase
+	// and without a trailing dot. The policy of the most specific
+	// matching pattern is used.
+	Policies map[string]DialPolicy
 	// MaxConcurrency specifies the maximum number of connections that can
 	// be attempted in parallel by Dial when the network address resolves to
 	// multiple targets. The default value is 3.
 	MaxConcurrency int
 	// ConcurrencyDelay is the amount of time to wait before initiating a
-	// new concurrent connection attempt. The default is 1s.
+	// new concurrent connection attempt, i.e. the Connection Attempt Delay
+	// of RFC 8305. A new attempt is also started as soon as the previous
+	// one fails. The default is 250ms.
 	ConcurrencyDelay time.Duration
+	// Race, if set, makes Dial start connection attempts to the first
+	// MaxConcurrency targets immediately, without ConcurrencyDelay. The
+	// first connection to be established is returned and the other
+	// attempts are canceled. This is for latency-critical clients that
+	// can afford more connection attempts.
+	Race bool
 	// Timeout is the amount of time to wait for a single connection to be
 	// established. The default value is 30s.
 	Timeout time.Duration
+	// TotalTimeout, if set, is the amount of time that Dial can take in
+	// total, including name resolution and all the connection attempts.
+	// The time left is divided fairly between the targets that haven't
+	// been attempted yet, so that one slow target can't use up the whole
+	// budget. Each attempt is still limited by Timeout.
+	TotalTimeout time.Duration
+	// MaxECHRetries is the maximum number of times that a connection is
+	// retried with the RetryConfigList that the server returns when it
+	// rejects ECH. The default value is 1.
+	MaxECHRetries int
+	// DisableECHRetry disables the retries with the server's
+	// RetryConfigList. When the server rejects ECH, Dial fails.
+	DisableECHRetry bool
+	// OnECHRejected, if set, is called when the server rejects ECH and
+	// returns a retry config list with retryListLen bytes, which may be
+	// zero. Frequent rejections usually indicate that the ECH config list
+	// published in DNS is stale.
+	OnECHRejected func(addr string, retryListLen int)
+	// OnFallbackToPlaintextSNI, if set, is called when a connection to addr
+	// is attempted without ECH, i.e. with the real server name in the
+	// plaintext ClientHello, because no ECH config list is available.
+	OnFallbackToPlaintextSNI func(addr string)
+	// OnAttempt, if set, is called after each connection attempt with the
+	// result of the attempt and how long it took.
+	OnAttempt func(addr string, err error, duration time.Duration)
+	// ConfigPins, if set, is used to verify that the ECH config lists
+	// from DNS match the ones that were previously accepted by the same
+	// hosts. The hosts are pinned when the server accepts ECH with a
+	// config list from DNS or from its retry configs.
+	//
+	// When a host's config list doesn't match its pin, e.g. because the
+	// host rotated its keys, the server name isn't sent with the new keys.
+	// The dialer offers a GREASE config with the same public name instead,
+	// and retries with the server's retry configs that have a pinned key.
+	// If the server accepts ECH, the host is pinned again with its retry
+	// configs. Otherwise, the target fails with [ErrConfigPinMismatch].
+	//
+	// Like RequireECHAccepted, it requires a connection type with a
+	// ConnectionState() tls.ConnectionState method, or
+	// ConnectionStateFunc. With other connection types, the hosts are never
+	// pinned.
+	ConfigPins *ConfigPinStore
+	// OnConfigPinMismatch, if set, is called when a host's ECH config list
+	// doesn't match its pin in ConfigPins. If it returns nil, the
+	// connection proceeds anyway with the config list from DNS. Otherwise,
+	// the mismatch is handled as described in ConfigPins, and the target
+	// fails with the returned error if the pin can't be confirmed.
+	OnConfigPinMismatch func(host string, err error) error
+	// TargetFilter, if set, is called for each target before it is
+	// attempted, e.g. to drop bogon addresses, to rewrite port numbers, or
+	// to only allow the addresses of an egress allowlist. It returns the
+	// target to attempt, which can be modified, and whether to attempt it
+	// at all. When all the targets of an address are dropped, the address
+	// fails with [ErrNoAddress].
+	TargetFilter func(Target) (Target, bool)
+	// Metrics, if set, receives metrics about name resolutions, connection
+	// attempts, and dials.
+	Metrics DialerMetrics
+	// Logger, if set, is used to log the connection attempts at the Debug
+	// level, and the ECH rejections and config pin mismatches at the Info
+	// and Warn levels. When it isn't set, the logger set with the
+	// package's [SetLogger] is used.
+	Logger *slog.Logger
+	// Proxy, if set, is used to establish the network connections, e.g.
+	// with [NewSOCKS5Proxy] or [NewHTTPConnectProxy]. It is used by the
+	// DialFunc set by NewDialer, and by [Transport]. Other DialFuncs may
+	// ignore it.
+	Proxy Proxy
+	// TCPOptions, if set, contains socket options for the TCP connections.
+	// It is used by the DialFunc set by NewDialer, and by [Transport].
+	// Other DialFuncs may ignore it.
+	TCPOptions *TCPOptions
 	// DialFunc must be set to a function that will be used to connect to
-	// a network address. NewDialer automatically sets this value.
+	// a network address. NewDialer automatically sets this value. The
+	// [Target] being dialed is available with [TargetFromContext].
 	DialFunc func(ctx context.Context, network, addr string, tc *tls.Config) (T, error)
+	// ConnectionStateFunc, if set, returns the TLS state of the connections
+	// returned by DialFunc, when they don't have a ConnectionState()
+	// tls.ConnectionState method. It is used to check whether the server
+	// accepted ECH, e.g. with RequireECHAccepted and ConfigPins.
+	ConnectionStateFunc func(T) tls.ConnectionState
 }
 
 // Dial connects to the given network and address. It uses HTTPS DNS records to
@@ -125,17 +281,123 @@ type Dialer[T any] struct {
 // Multiple comma-separated addresses may be provided. Dial attempts to connect
 // to them in the order they are listed.
 func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Config) (T, error) {
+	conn, _, err := d.DialWithResult(ctx, network, addr, tc)
+	return conn, err
+}
+
+// NetDialContext is like [Dialer.Dial] with a nil tls.Config. Its signature
+// matches the standard DialContext functions, e.g. for
+// [http.Transport.DialTLSContext]. The connection type T must implement
+// [net.Conn].
+func (d *Dialer[T]) NetDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.Dial(ctx, network, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	nc, ok := any(conn).(net.Conn)
+	if !ok {
+		if c, ok := any(conn).(io.Closer); ok {
+			c.Close()
+		}
+		return nil, fmt.Errorf("%T is not a net.Conn", conn)
+	}
+	return nc, nil
+}
+
+// DialContext is the same as [Dialer.NetDialContext]. It implements
+// [golang.org/x/net/proxy.ContextDialer].
+func (d *Dialer[T]) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d.NetDialContext(ctx, network, addr)
+}
+
+// DialResult describes how a connection was established by
+// [Dialer.DialWithResult].
+type DialResult struct {
+	// Target is the target of the connection that was established.
+	Target Target
+	// Tried contains the addresses that were attempted, in the order in
+	// which the attempts started.
+	Tried []netip.AddrPort
+	// Attempts is the number of connection attempts, including the
+	// retries after ECH was rejected.
+	Attempts int
+	// ECHOffered indicates whether the connection was established with an
+	// ECH config list.
+	ECHOffered bool
+	// ECHAccepted indicates whether the server accepted ECH.
+	ECHAccepted bool
+	// ECHRetries is the number of times that the connection was retried
+	// with the server's retry configs.
+	ECHRetries int
+	// ResolveDuration is the time spent resolving names.
+	ResolveDuration time.Duration
+	// ConnectDuration is the time it took to establish the connection
+	// with Target, including ECH retries.
+	ConnectDuration time.Duration
+	// Duration is the total time spent in DialWithResult.
+	Duration time.Duration
+}
+
+// DialWithResult is like [Dialer.Dial], but it also returns a [DialResult]
+// that describes how the connection was established. The DialResult is
+// returned even when Dial fails.
+func (d *Dialer[T]) DialWithResult(ctx context.Context, network, addr string, tc *tls.Config) (T, *DialResult, error) {
+	start := time.Now()
+	var rec dialRecorder
+	conn, err := d.dial(ctx, network, addr, tc, &rec)
+	if r, ok := ctx.Value(transportResolverKey).(*transportResolver); ok && err != nil {
+		r.dialFailed.Store(true)
+	}
+	result := rec.snapshot()
+	result.Duration = time.Since(start)
+	if d.Metrics != nil {
+		d.Metrics.DialDone(result.Attempts, result.ECHAccepted, result.Duration, err)
+	}
+	return conn, result, err
+}
+
+// dialRecorder collects the information for a DialResult while the workers
+// are running.
+type dialRecorder struct {
+	mu     sync.Mutex
+	result DialResult
+}
+
+func (r *dialRecorder) update(f func(*DialResult)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&r.result)
+}
+
+func (r *dialRecorder) snapshot() *DialResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := r.result
+	result.Tried = slices.Clone(result.Tried)
+	return &result
+}
+
+func (d *Dialer[T]) dial(ctx context.Context, network, addr string, tc *tls.Config, rec *dialRecorder) (T, error) {
 	var nilConn T
 	if d.DialFunc == nil {
-		return nilConn, errors.New("DialFunc must be set")
+		return nilConn, fmt.Errorf("%w: DialFunc must be set", ErrInvalidConfiguration)
+	}
+	if r, ok := ctx.Value(transportResolverKey).(*transportResolver); ok && r.tlsConfig != nil {
+		tc = r.tlsConfig
 	}
 	if tc == nil {
 		tc = &tls.Config{}
 	} else {
 		tc = tc.Clone()
 	}
+	if tc.ClientSessionCache == nil {
+		tc.ClientSessionCache = d.ClientSessionCache
+	}
+	if tc.KeyLogWriter == nil {
+		tc.KeyLogWriter = d.KeyLogWriter
+	}
 	var resolver interface {
-		Resolve(ctx context.Context, name string) (ResolveResult, error)
+		Resolve(ctx context.Context, name string, opts ...ResolveOption) (ResolveResult, error)
 	}
 	if r, ok := ctx.Value(transportResolverKey).(*transportResolver); ok {
 		resolver = r
@@ -149,11 +411,22 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 	type dialTarget struct {
 		host     string
 		resolved Target
-		err      error
+		err      *TargetError
 	}
+	// pending is the number of known targets that haven't been attempted
+	// yet, or that are being attempted.
+	var pending atomic.Int32
 	targets := iter.Seq[dialTarget](func(yield func(dialTarget) bool) {
-		for _, a := range strings.Split(addr, ",") {
-			a := strings.TrimSpace(a)
+		addrs := strings.Split(addr, ",")
+		for i := range addrs {
+			addrs[i] = strings.TrimSpace(addrs[i])
+		}
+		if _, ok := resolver.(*transportResolver); !ok {
+			if ports, ok := ctx.Value(dialFallbackPortsKey).([]uint16); ok {
+				addrs = expandPorts(addrs, ports)
+			}
+		}
+		for _, a := range addrs {
 			var host string
 			if res, ok := resolver.(*transportResolver); ok {
 				host = res.host
@@ -163,14 +436,37 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 					host = a
 				}
 			}
-			result, err := resolver.Resolve(ctx, a)
+			result, ok := ResolveResult{}, false
+			if _, isTransport := resolver.(*transportResolver); !isTransport {
+				result, ok = lookupStaticHost(d.Hosts, a)
+			}
+			var err error
+			if !ok {
+				start := time.Now()
+				result, err = resolver.Resolve(ctx, a)
+				elapsed := time.Since(start)
+				rec.update(func(r *DialResult) { r.ResolveDuration += elapsed })
+				if d.Metrics != nil {
+					d.Metrics.ResolveDone(a, elapsed, err)
+				}
+			}
 			if err != nil {
-				if !yield(dialTarget{err: fmt.Errorf("%s: %w", a, err)}) {
+				if !yield(dialTarget{err: &TargetError{Host: a, Err: err}}) {
 					return
 				}
 				continue
 			}
-			for target := range result.Targets(network) {
+			resolved := interleaveFamilies(slices.Collect(result.Targets(network)))
+			if d.TargetFilter != nil && len(resolved) > 0 {
+				if resolved = filterTargets(resolved, d.TargetFilter); len(resolved) == 0 {
+					if !yield(dialTarget{err: &TargetError{Host: a, Err: errAllTargetsFiltered}}) {
+						return
+					}
+					continue
+				}
+			}
+			pending.Add(int32(len(resolved)))
+			for _, target := range resolved {
 				if !yield(dialTarget{
 					host:     host,
 					resolved: target,
@@ -182,25 +478,37 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 	})
 
 	targetChan := make(chan dialTarget)
-	connChan := make(chan T)
-	errChan := make(chan error)
+	type dialConn struct {
+		conn     T
+		target   Target
+		offered  bool
+		retries  int
+		duration time.Duration
+	}
+	connChan := make(chan dialConn)
+	errChan := make(chan *TargetError)
 	wakeChan := make(chan struct{})
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	if d.TotalTimeout > 0 {
+		var cancelTotal context.CancelFunc
+		ctx, cancelTotal = context.WithTimeout(ctx, d.TotalTimeout)
+		defer cancelTotal()
+	}
 
-	sendErr := func(err error) {
+	sendErr := func(err *TargetError) {
 		select {
 		case <-ctx.Done():
 		case errChan <- err:
 		}
 	}
-	sendConn := func(conn T) {
+	sendConn := func(dc dialConn) {
 		select {
 		case <-ctx.Done():
-			if c, ok := any(conn).(io.Closer); ok {
+			if c, ok := any(dc.conn).(io.Closer); ok {
 				c.Close()
 			}
-		case connChan <- conn:
+		case connChan <- dc:
 		}
 	}
 	wake := func() {
@@ -212,15 +520,7 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 
 	needECH := tc.EncryptedClientHelloConfigList == nil
 	if needECH && d.PublicName != "" {
-		id := make([]byte, 1)
-		if _, err := io.ReadFull(rand.Reader, id); err != nil {
-			return nilConn, err
-		}
-		_, config, err := NewConfig(id[0], []byte(d.PublicName))
-		if err != nil {
-			return nilConn, err
-		}
-		configList, err := ConfigList([]Config{config})
+		configList, err := greaseConfigList(d.PublicName)
 		if err != nil {
 			return nilConn, err
 		}
@@ -237,7 +537,7 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 	}
 	delay := d.ConcurrencyDelay
 	if delay <= 0 {
-		delay = time.Second
+		delay = 250 * time.Millisecond
 	}
 
 	var wg sync.WaitGroup
@@ -251,24 +551,107 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 					continue
 				}
 				tc := tc.Clone()
-				if tc.ServerName == "" {
+				if sn := serverNameFromContext(ctx); sn != "" {
+					tc.ServerName = sn
+				} else if tc.ServerName == "" {
 					tc.ServerName = target.host
 				}
+				policy := d.policy(target.host)
+				if needECH && policy.PublicName != "" {
+					configList, err := greaseConfigList(policy.PublicName)
+					if err != nil {
+						sendErr(&TargetError{Host: target.host, Addr: target.resolved.Address.String(), Err: err})
+						continue
+					}
+					tc.EncryptedClientHelloConfigList = configList
+				}
+				var dnsConfigList []byte
 				if needECH && target.resolved.ECH != nil {
-					tc.EncryptedClientHelloConfigList = target.resolved.ECH
+					// Configs with mandatory extensions that we
+					// don't support must be skipped.
+					if configList, err := filterConfigList(target.resolved.ECH); err == nil && configList != nil {
+						tc.EncryptedClientHelloConfigList = configList
+						dnsConfigList = configList
+					}
 				}
-				if d.RequireECH && tc.EncryptedClientHelloConfigList == nil {
-					sendErr(fmt.Errorf("%s: unable to get ECH config list", target.host))
-					continue
+				var pinErr error
+				var retryFilter func([]byte) []byte
+				var retryConfigList []byte
+				if needECH && d.ConfigPins != nil {
+					if err := d.ConfigPins.Check(target.host, dnsConfigList); err != nil {
+						logger(d.Logger).Warn("ech: config pin mismatch", "host", target.host, "err", err)
+						if d.OnConfigPinMismatch != nil {
+							err = d.OnConfigPinMismatch(target.host, err)
+						}
+						if err != nil {
+							// The server name isn't sent to the
+							// unverified keys. A GREASE config with
+							// the same public name gets the server's
+							// retry configs instead, and only the
+							// pinned ones are used.
+							configList := pinMismatchConfigList(dnsConfigList)
+							if configList == nil {
+								sendErr(&TargetError{Host: target.host, Addr: target.resolved.Address.String(), Err: err})
+								continue
+							}
+							tc.EncryptedClientHelloConfigList = configList
+							pinErr = err
+							retryFilter = func(configList []byte) []byte {
+								retryConfigList = configList
+								return d.ConfigPins.pinnedConfigs(target.host, configList)
+							}
+						}
+					}
+				}
+				requireECH, requireAccepted := d.requireECH(ctx, target.host)
+				if tc.EncryptedClientHelloConfigList == nil {
+					if requireECH {
+						sendErr(&TargetError{Host: target.host, Addr: target.resolved.Address.String(), Err: errNoECHConfigList})
+						continue
+					}
+					logger(d.Logger).Debug("ech: no config list, using plaintext SNI", "host", target.host, "addr", target.resolved.Address.String())
+					if d.OnFallbackToPlaintextSNI != nil {
+						d.OnFallbackToPlaintextSNI(target.resolved.Address.String())
+					}
+					if d.Metrics != nil {
+						d.Metrics.Fallback(target.resolved.Address.String())
+					}
 				}
-				ctx, cancel := context.WithTimeout(ctx, timeout)
-				conn, err := d.dialOne(ctx, network, target.resolved.Address.String(), tc)
+				attemptCtx := context.WithValue(context.WithValue(ctx, dialTargetKey, &target.resolved), dialNetKey, dialNetOptions{proxy: d.Proxy, tcpOptions: d.TCPOptions})
+				ctx, cancel := context.WithTimeout(attemptCtx, attemptTimeout(ctx, timeout, int(pending.Load()), numWorkers))
+				rec.update(func(r *DialResult) { r.Tried = append(r.Tried, target.resolved.Address) })
+				start := time.Now()
+				conn, retries, err := d.dialOne(ctx, network, target.resolved.Address.String(), tc, requireAccepted || pinErr != nil, retryFilter, rec)
 				cancel()
+				pending.Add(-1)
 				if err != nil {
-					sendErr(fmt.Errorf("%s: %w", target.host, err))
+					if pinErr != nil && (errors.As(err, new(*tls.ECHRejectionError)) || errors.Is(err, ErrECHNotAccepted)) {
+						err = pinErr
+					}
+					sendErr(&TargetError{Host: target.host, Addr: target.resolved.Address.String(), Err: err})
 					continue
 				}
-				sendConn(conn)
+				if needECH && d.ConfigPins != nil {
+					if cs, ok := d.connectionState(conn); ok && cs.ECHAccepted {
+						// After a pin mismatch, ECH was accepted
+						// with a pinned key from the retry configs,
+						// and the new keys come from there too.
+						configList := tc.EncryptedClientHelloConfigList
+						if pinErr != nil {
+							configList = retryConfigList
+						}
+						if err := d.ConfigPins.Pin(target.host, configList); err != nil {
+							logger(d.Logger).Warn("ech: config pin failed", "host", target.host, "err", err)
+						}
+					}
+				}
+				sendConn(dialConn{
+					conn:     conn,
+					target:   target.resolved,
+					offered:  tc.EncryptedClientHelloConfigList != nil,
+					retries:  retries,
+					duration: time.Since(start),
+				})
 			}
 		}()
 	}
@@ -279,35 +662,48 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 	}()
 
 	go func() {
+		defer close(targetChan)
 		first := true
 		for target := range targets {
-			if !first {
+			if !first && !d.Race {
 				select {
 				case <-ctx.Done():
-					break
+					return
 				case <-wakeChan:
 				case <-time.After(delay):
 				}
 			}
 			first = false
-			targetChan <- target
+			select {
+			case <-ctx.Done():
+				return
+			case targetChan <- target:
+			}
 		}
-		close(targetChan)
 	}()
 
-	var errs []error
+	var errs []*TargetError
 	for {
 		select {
 		case <-ctx.Done():
 			return nilConn, ctx.Err()
-		case conn := <-connChan:
-			return conn, nil
+		case dc := <-connChan:
+			rec.update(func(r *DialResult) {
+				r.Target = dc.target
+				r.ECHOffered = dc.offered
+				r.ECHRetries = dc.retries
+				r.ConnectDuration = dc.duration
+				if cs, ok := d.connectionState(dc.conn); ok {
+					r.ECHAccepted = cs.ECHAccepted
+				}
+			})
+			return dc.conn, nil
 		case err, ok := <-errChan:
 			if !ok {
 				if len(errs) == 0 {
-					return nilConn, errors.New("no address")
+					return nilConn, ErrNoAddress
 				}
-				return nilConn, errors.Join(errs...)
+				return nilConn, &DialError{Errors: errs}
 			}
 			errs = append(errs, err)
 			wake()
@@ -315,19 +711,300 @@ func (d *Dialer[T]) Dial(ctx context.Context, network, addr string, tc *tls.Conf
 	}
 }
 
-func (d *Dialer[T]) dialOne(ctx context.Context, network, addr string, tc *tls.Config) (T, error) {
+type ctxDialKey int
+
+var (
+	dialTargetKey        ctxDialKey = 1
+	dialFallbackPortsKey ctxDialKey = 2
+	dialRequireECHKey    ctxDialKey = 3
+	dialServerNameKey    ctxDialKey = 4
+	dialNetKey           ctxDialKey = 5
+)
+
+// WithRequireECH returns a context that makes [Dialer.Dial] and [Transport]
+// require Encrypted Client Hello, like Dialer.RequireECH, for the dials and
+// requests that use it. The other dials and requests are unaffected.
+func WithRequireECH(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dialRequireECHKey, true)
+}
+
+func requireECHFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(dialRequireECHKey).(bool)
+	return v
+}
+
+// WithServerName returns a context that makes [Dialer.Dial] and [Transport]
+// use serverName as the TLS server name, i.e. the SNI in the encrypted
+// ClientHelloInner and the name that the server's certificate is verified
+// for, instead of the host name being dialed. The address and the ECH config
+// list are still those of the host name being dialed. It takes precedence over
+// the ServerName of the tls.Config.
+//
+// For example, to test the certificate of a new name before it is published:
+//
+//	ctx := ech.WithServerName(ctx, "new.example.com")
+//	req, err := http.NewRequestWithContext(ctx, "GET", "https://www.example.com/", nil)
+func WithServerName(ctx context.Context, serverName string) context.Context {
+	return context.WithValue(ctx, dialServerNameKey, serverName)
+}
+
+func serverNameFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(dialServerNameKey).(string)
+	return v
+}
+
+// WithFallbackPorts returns a context that makes [Dialer.Dial] try each
+// address on the fallback ports after its own port, e.g. 443 then 8443, for
+// services that are reachable on several ports. Each port is resolved
+// separately, so HTTPS records that are specific to a port are used.
+func WithFallbackPorts(ctx context.Context, ports ...uint16) context.Context {
+	return context.WithValue(ctx, dialFallbackPortsKey, ports)
+}
+
+// expandPorts returns addrs with each address followed by the same host on
+// the fallback ports. The default port of an address without a port is 443.
+func expandPorts(addrs []string, ports []uint16) []string {
+	var out []string
+	seen := make(map[string]bool)
+	add := func(a string) {
+		if !seen[a] {
+			seen[a] = true
+			out = append(out, a)
+		}
+	}
+	for _, a := range addrs {
+		add(a)
+		if strings.Contains(a, "://") {
+			continue
+		}
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			host, port = a, "443"
+		}
+		for _, p := range ports {
+			if pp := strconv.Itoa(int(p)); pp != port {
+				add(net.JoinHostPort(host, pp))
+			}
+		}
+	}
+	return out
+}
+
+// TargetFromContext returns the [Target] that is being dialed. It is meant to
+// be called by DialFunc to make protocol decisions based on the target's
+// ALPN list, ECH config list, or HTTPS record. The Target's ECH config list may
+// be different from tls.Config's EncryptedClientHelloConfigList passed to
+// DialFunc, e.g. when it was set explicitly by the caller.
+func TargetFromContext(ctx context.Context) (Target, bool) {
+	t, ok := ctx.Value(dialTargetKey).(*Target)
+	if !ok {
+		return Target{}, false
+	}
+	return *t, true
+}
+
+// dialNetOptions contains the settings of the Dialer that are used by
+// NetDialContext.
+type dialNetOptions struct {
+	proxy      Proxy
+	tcpOptions *TCPOptions
+}
+
+// NetDialContext connects to addr without TLS, like the DialFunc set by
+// [NewDialer] does before the handshake. It is meant to be called by custom
+// DialFuncs, e.g. ones that use a different TLS implementation. When ctx comes
+// from [Dialer.Dial], the connection is established with the Dialer's Proxy and
+// TCPOptions, or with the proxy that [Transport] selected for the request.
+// Unlike [Dialer.NetDialContext], it doesn't resolve addr.
+func NetDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	o, _ := ctx.Value(dialNetKey).(dialNetOptions)
+	d := &net.Dialer{
+		Resolver: &net.Resolver{
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				return nil, errors.New("not using go resolver")
+			},
+		},
+	}
+	return dialNet(ctx, contextProxy(ctx, o.proxy), d, o.tcpOptions, network, addr)
+}
+
+// attemptTimeout returns the timeout for one connection attempt. When ctx has
+// a deadline, the time left is divided between the pending targets, which
+// are attempted up to numWorkers at a time.
+func attemptTimeout(ctx context.Context, timeout time.Duration, pending, numWorkers int) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	rounds := max(1, (pending+numWorkers-1)/numWorkers)
+	return min(timeout, time.Until(deadline)/time.Duration(rounds))
+}
+
+// errAllTargetsFiltered is the error of an address whose targets are all
+// dropped by Dialer.TargetFilter.
+var errAllTargetsFiltered = fmt.Errorf("%w: all the targets were dropped by TargetFilter", ErrNoAddress)
+
+// filterTargets returns the targets that filter keeps, as modified by filter.
+// The targets without a valid address are dropped.
+func filterTargets(targets []Target, filter func(Target) (Target, bool)) []Target {
+	out := targets[:0]
+	for _, t := range targets {
+		if t, ok := filter(t); ok && t.Address.IsValid() {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// interleaveFamilies reorders the targets so that IPv6 and IPv4 addresses
+// alternate, as recommended by RFC 8305 Section 4, starting with the family
+// of the first target. Only consecutive targets with the same ECH config
+// list and ALPN, i.e. from the same HTTPS record, are reordered so that the
+// record priorities are respected.
+func interleaveFamilies(targets []Target) []Target {
+	out := make([]Target, 0, len(targets))
+	for len(targets) > 0 {
+		n := 1
+		for n < len(targets) && bytes.Equal(targets[n].ECH, targets[0].ECH) && slices.Equal(targets[n].ALPN, targets[0].ALPN) {
+			n++
+		}
+		var first, second []Target
+		for _, t := range targets[:n] {
+			if t.Address.Addr().Is4() == targets[0].Address.Addr().Is4() {
+				first = append(first, t)
+			} else {
+				second = append(second, t)
+			}
+		}
+		for len(first) > 0 || len(second) > 0 {
+			if len(first) > 0 {
+				out = append(out, first[0])
+				first = first[1:]
+			}
+			if len(second) > 0 {
+				out = append(out, second[0])
+				second = second[1:]
+			}
+		}
+		targets = targets[n:]
+	}
+	return out
+}
+
+// dialOne connects to addr, retrying with the server's retry configs when ECH
+// is rejected. If retryFilter is set, it selects the retry configs that can be
+// used. With requireAccepted, the connection fails when the server doesn't
+// accept ECH. It returns the number of retries.
+func (d *Dialer[T]) dialOne(ctx context.Context, network, addr string, tc *tls.Config, requireAccepted bool, retryFilter func([]byte) []byte, rec *dialRecorder) (T, int, error) {
 	var nilConn T
-	var retried bool
+	maxRetries := d.MaxECHRetries
+	if maxRetries <= 0 {
+		maxRetries = 1
+	}
+	if d.DisableECHRetry {
+		maxRetries = 0
+	}
+	var retries int
 retry:
+	start := time.Now()
 	conn, err := d.DialFunc(ctx, network, addr, tc)
+	if d.OnAttempt != nil {
+		d.OnAttempt(addr, err, time.Since(start))
+	}
+	rec.update(func(r *DialResult) { r.Attempts++ })
+	if d.Metrics != nil {
+		d.Metrics.AttemptDone(addr, tc.EncryptedClientHelloConfigList != nil, time.Since(start), err)
+	}
 	if err != nil {
+		logger(d.Logger).Debug("ech: connection attempt failed", "addr", addr, "err", err)
 		var echErr *tls.ECHRejectionError
-		if errors.As(err, &echErr) && len(echErr.RetryConfigList) > 0 && !retried {
-			tc.EncryptedClientHelloConfigList = echErr.RetryConfigList
-			retried = true
-			goto retry
+		if errors.As(err, &echErr) {
+			logger(d.Logger).Info("ech: ECH rejected", "addr", addr, "retryConfigListLen", len(echErr.RetryConfigList), "retries", retries)
+		}
+		if errors.As(err, &echErr) && d.OnECHRejected != nil {
+			d.OnECHRejected(addr, len(echErr.RetryConfigList))
 		}
-		return nilConn, err
+		if errors.As(err, &echErr) && len(echErr.RetryConfigList) > 0 && retries < maxRetries {
+			configList := validateRetryConfigList(tc.EncryptedClientHelloConfigList, echErr.RetryConfigList)
+			if configList != nil && retryFilter != nil {
+				configList = retryFilter(configList)
+			}
+			if configList != nil {
+				tc.EncryptedClientHelloConfigList = configList
+				retries++
+				goto retry
+			}
+		}
+		return nilConn, retries, err
+	}
+	if requireAccepted {
+		if cs, ok := d.connectionState(conn); !ok || !cs.ECHAccepted {
+			if c, ok := any(conn).(io.Closer); ok {
+				c.Close()
+			}
+			return nilConn, retries, ErrECHNotAccepted
+		}
+	}
+	return conn, retries, nil
+}
+
+// connectionState returns the TLS state of conn, if it is available.
+func (d *Dialer[T]) connectionState(conn T) (tls.ConnectionState, bool) {
+	if cs, ok := any(conn).(interface{ ConnectionState() tls.ConnectionState }); ok {
+		return cs.ConnectionState(), true
+	}
+	if d.ConnectionStateFunc != nil {
+		return d.ConnectionStateFunc(conn), true
+	}
+	return tls.ConnectionState{}, false
+}
+
+// pinMismatchConfigList returns a GREASE config list with the public name of
+// the first config in configList, or nil if there is none.
+func pinMismatchConfigList(configList []byte) []byte {
+	specs, err := ParseConfigList(configList)
+	if err != nil || len(specs) == 0 {
+		return nil
+	}
+	grease, err := greaseConfigList(string(specs[0].PublicName))
+	if err != nil {
+		return nil
+	}
+	return grease
+}
+
+// validateRetryConfigList returns the configs from retryList that are usable
+// and that have the same public name as one of the configs in configList. The
+// server that rejected ECH was authenticated with this public name, and it
+// shouldn't be able to redirect the client to a different one. It returns nil
+// if no configs remain.
+func validateRetryConfigList(configList, retryList []byte) []byte {
+	specs, err := ParseConfigList(configList)
+	if err != nil {
+		return nil
+	}
+	configs, err := splitConfigList(retryList)
+	if err != nil {
+		return nil
+	}
+	var valid []Config
+	for _, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil || !spec.supported() {
+			continue
+		}
+		if slices.ContainsFunc(specs, func(s ConfigSpec) bool {
+			return bytes.Equal(s.PublicName, spec.PublicName)
+		}) {
+			valid = append(valid, cfg)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	out, err := ConfigList(valid)
+	if err != nil {
+		return nil
 	}
-	return conn, nil
+	return out
 }
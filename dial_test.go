@@ -5,11 +5,17 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -196,6 +202,13 @@ func TestDialer(t *testing.T) {
 		Timeout:          20 * time.Millisecond,
 		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
 			t.Logf("Dial %q", addr)
+			target, ok := TargetFromContext(ctx)
+			if !ok || target.Address.String() != addr {
+				return "", fmt.Errorf("TargetFromContext() = %v, %v", target, ok)
+			}
+			if bytes.Equal(target.ECH, configList) && (target.HTTPS == nil || target.HTTPS.Priority != 1) {
+				return "", fmt.Errorf("TargetFromContext() HTTPS = %v", target.HTTPS)
+			}
 			var ech string
 			if tc.EncryptedClientHelloConfigList == nil {
 				ech = " ECH nil"
@@ -276,3 +289,927 @@ func TestDialer(t *testing.T) {
 		}
 	})
 }
+
+func TestInterleaveFamilies(t *testing.T) {
+	tgt := func(addr string, ech string) Target {
+		t := Target{Address: netip.MustParseAddrPort(addr)}
+		if ech != "" {
+			t.ECH = []byte(ech)
+		}
+		return t
+	}
+	in := []Target{
+		tgt("[2001:db8::1]:443", "a"),
+		tgt("[2001:db8::2]:443", "a"),
+		tgt("192.0.2.1:443", "a"),
+		tgt("192.0.2.2:443", "a"),
+		tgt("192.0.2.3:443", "b"),
+		tgt("[2001:db8::3]:443", "b"),
+	}
+	var got []string
+	for _, t := range interleaveFamilies(in) {
+		got = append(got, t.Address.String())
+	}
+	want := []string{
+		"[2001:db8::1]:443",
+		"192.0.2.1:443",
+		"[2001:db8::2]:443",
+		"192.0.2.2:443",
+		"192.0.2.3:443",
+		"[2001:db8::3]:443",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("interleaveFamilies() = %v, want %v", got, want)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	if got := attemptTimeout(context.Background(), 30*time.Second, 10, 3); got != 30*time.Second {
+		t.Errorf("attemptTimeout() without deadline = %v, want 30s", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		timeout             time.Duration
+		pending, numWorkers int
+		min, max            time.Duration
+	}{
+		{30 * time.Second, 1, 3, 11 * time.Second, 12 * time.Second},
+		{30 * time.Second, 6, 2, 3500 * time.Millisecond, 4 * time.Second},
+		{30 * time.Second, 7, 2, 2500 * time.Millisecond, 3 * time.Second},
+		{2 * time.Second, 6, 2, 2 * time.Second, 2 * time.Second},
+		{30 * time.Second, 0, 2, 11 * time.Second, 12 * time.Second},
+	} {
+		got := attemptTimeout(ctx, tc.timeout, tc.pending, tc.numWorkers)
+		if got < tc.min || got > tc.max {
+			t.Errorf("attemptTimeout(%v, %d, %d) = %v, want [%v, %v]", tc.timeout, tc.pending, tc.numWorkers, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestDialerTotalTimeout(t *testing.T) {
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "slow.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{
+			Priority: 1,
+			IPv4Hint: []net.IP{{1, 0, 0, 1}, {1, 0, 0, 2}, {1, 0, 0, 3}},
+		},
+	}})
+	defer dnsServer.Close()
+
+	var mu sync.Mutex
+	var attempts []time.Duration
+	dialer := &Dialer[string]{
+		Resolver: &Resolver{
+			baseURL: url.URL{
+				Scheme: "http",
+				Host:   dnsServer.Listener.Addr().String(),
+				Path:   "/dns-query",
+			},
+		},
+		MaxConcurrency: 1,
+		Timeout:        10 * time.Second,
+		TotalTimeout:   300 * time.Millisecond,
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			deadline, _ := ctx.Deadline()
+			mu.Lock()
+			attempts = append(attempts, time.Until(deadline))
+			mu.Unlock()
+			<-ctx.Done()
+			return "", ctx.Err()
+		},
+	}
+	start := time.Now()
+	if _, err := dialer.Dial(t.Context(), "tcp", "slow.example.com:443", nil); err == nil {
+		t.Fatal("Dial() succeeded unexpectedly")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Dial() took %v", d)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("attempts = %v, want 3 attempts", attempts)
+	}
+	if attempts[0] > 100*time.Millisecond {
+		t.Errorf("first attempt timeout = %v, want <= 100ms", attempts[0])
+	}
+}
+
+func TestDialerRace(t *testing.T) {
+	var mu sync.Mutex
+	var started []string
+	var canceled int
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"race.example.com": {
+				Addresses: []netip.Addr{
+					netip.MustParseAddr("192.0.2.1"),
+					netip.MustParseAddr("192.0.2.2"),
+					netip.MustParseAddr("192.0.2.3"),
+				},
+			},
+		},
+		MaxConcurrency:   3,
+		ConcurrencyDelay: time.Minute,
+		Race:             true,
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			mu.Lock()
+			started = append(started, addr)
+			mu.Unlock()
+			if addr == "192.0.2.3:443" {
+				return addr, nil
+			}
+			<-ctx.Done()
+			mu.Lock()
+			canceled++
+			mu.Unlock()
+			return "", ctx.Err()
+		},
+	}
+	start := time.Now()
+	conn, err := dialer.Dial(t.Context(), "tcp", "race.example.com:443", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Dial() took %v", d)
+	}
+	if want := "192.0.2.3:443"; conn != want {
+		t.Errorf("Dial() = %q, want %q", conn, want)
+	}
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(started) != 3 || canceled != 2 {
+		t.Errorf("started = %v, canceled = %d", started, canceled)
+	}
+}
+
+func TestExpandPorts(t *testing.T) {
+	for _, tc := range []struct {
+		addrs []string
+		ports []uint16
+		want  []string
+	}{
+		{[]string{"example.com:443"}, nil, []string{"example.com:443"}},
+		{[]string{"example.com:443"}, []uint16{443, 8443}, []string{"example.com:443", "example.com:8443"}},
+		{[]string{"example.com"}, []uint16{8443}, []string{"example.com", "example.com:8443"}},
+		{[]string{"2001:db8::1"}, []uint16{8443}, []string{"2001:db8::1", "[2001:db8::1]:8443"}},
+		{[]string{"https://example.com"}, []uint16{8443}, []string{"https://example.com"}},
+		{
+			[]string{"a.example.com:443", "b.example.com:8443"},
+			[]uint16{8443, 443},
+			[]string{"a.example.com:443", "a.example.com:8443", "b.example.com:8443", "b.example.com:443"},
+		},
+	} {
+		if got := expandPorts(tc.addrs, tc.ports); !slices.Equal(got, tc.want) {
+			t.Errorf("expandPorts(%v, %v) = %v, want %v", tc.addrs, tc.ports, got, tc.want)
+		}
+	}
+}
+
+func TestWithRequireECH(t *testing.T) {
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			return addr, nil
+		},
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", "example.com:443", nil); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if _, err := dialer.Dial(WithRequireECH(t.Context()), "tcp", "example.com:443", nil); !errors.Is(err, errNoECHConfigList) {
+		t.Fatalf("Dial() = %v, want errNoECHConfigList", err)
+	}
+}
+
+func TestWithServerName(t *testing.T) {
+	_, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ConfigList([]Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	dialer := &Dialer[*tls.Config]{
+		Hosts: map[string]StaticHost{
+			"example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}, ECH: configList},
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*tls.Config, error) {
+			return tc, nil
+		},
+	}
+	for _, tc := range []struct {
+		ctx  context.Context
+		tc   *tls.Config
+		want string
+	}{
+		{t.Context(), nil, "example.com"},
+		{t.Context(), &tls.Config{ServerName: "other.example.com"}, "other.example.com"},
+		{WithServerName(t.Context(), "vanity.example.com"), nil, "vanity.example.com"},
+		{WithServerName(t.Context(), "vanity.example.com"), &tls.Config{ServerName: "other.example.com"}, "vanity.example.com"},
+	} {
+		got, err := dialer.Dial(tc.ctx, "tcp", "example.com:443", tc.tc)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		if got.ServerName != tc.want {
+			t.Errorf("ServerName = %q, want %q", got.ServerName, tc.want)
+		}
+		if !bytes.Equal(got.EncryptedClientHelloConfigList, configList) {
+			t.Errorf("EncryptedClientHelloConfigList = %x, want %x", got.EncryptedClientHelloConfigList, configList)
+		}
+	}
+}
+
+func TestDialFallbackPorts(t *testing.T) {
+	var tried []string
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+		},
+		MaxConcurrency: 1,
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			tried = append(tried, addr)
+			if addr != "192.0.2.1:8443" {
+				return "", errors.New("connection refused")
+			}
+			return addr, nil
+		},
+	}
+	ctx := WithFallbackPorts(t.Context(), 8443, 9443)
+	conn, err := dialer.Dial(ctx, "tcp", "example.com:443", nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	if want := "192.0.2.1:8443"; conn != want {
+		t.Errorf("Dial() = %q, want %q", conn, want)
+	}
+	if want := []string{"192.0.2.1:443", "192.0.2.1:8443"}; !slices.Equal(tried, want) {
+		t.Errorf("tried = %v, want %v", tried, want)
+	}
+}
+
+func TestDialerHooks(t *testing.T) {
+	addr, _, rootCAs := startTestECHServer(t)
+	_, wrongConfig, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	wrongConfigList, err := ConfigList([]Config{wrongConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+
+	var events []string
+	dialer := NewDialer()
+	dialer.OnECHRejected = func(addr string, retryListLen int) {
+		events = append(events, fmt.Sprintf("rejected %s %v", addr, retryListLen > 0))
+	}
+	dialer.OnFallbackToPlaintextSNI = func(addr string) {
+		events = append(events, "fallback "+addr)
+	}
+	dialer.OnAttempt = func(addr string, err error, _ time.Duration) {
+		events = append(events, fmt.Sprintf("attempt %s %v", addr, err == nil))
+	}
+
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: wrongConfigList,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	want := []string{
+		"attempt " + addr + " false",
+		"rejected " + addr + " true",
+		"attempt " + addr + " true",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+
+	events = nil
+	conn, err = dialer.Dial(t.Context(), "tcp", addr, &tls.Config{
+		ServerName: "private.example.com",
+		RootCAs:    rootCAs,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	want = []string{
+		"fallback " + addr,
+		"attempt " + addr + " true",
+	}
+	if !slices.Equal(events, want) {
+		t.Errorf("events = %q, want %q", events, want)
+	}
+}
+
+func TestECHRetryPolicy(t *testing.T) {
+	addr, _, rootCAs := startTestECHServer(t)
+	_, wrongConfig, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	wrongConfigList, err := ConfigList([]Config{wrongConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tc := &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: wrongConfigList,
+	}
+
+	dialer := NewDialer()
+	dialer.DisableECHRetry = true
+	var echErr *tls.ECHRejectionError
+	if _, err := dialer.Dial(t.Context(), "tcp", addr, tc); !errors.As(err, &echErr) {
+		t.Fatalf("Dial() = %v, want ECHRejectionError", err)
+	}
+
+	// The retry config list must have the same public name.
+	_, otherConfig, err := NewConfig(2, []byte("other.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	otherConfigList, err := ConfigList([]Config{otherConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if got := validateRetryConfigList(wrongConfigList, otherConfigList); got != nil {
+		t.Errorf("validateRetryConfigList() = %v, want nil", got)
+	}
+	mixed, err := ConfigList([]Config{otherConfig, wrongConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if got := validateRetryConfigList(wrongConfigList, mixed); !bytes.Equal(got, wrongConfigList) {
+		t.Errorf("validateRetryConfigList() = %v, want %v", got, wrongConfigList)
+	}
+}
+
+func TestRequireECHAccepted(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	tc := &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: configList,
+	}
+	dialer := NewDialer()
+	dialer.RequireECHAccepted = true
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, tc)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+
+	// A DialFunc that doesn't use ECH.
+	dialer.DialFunc = func(ctx context.Context, network, addr string, tc *tls.Config) (*tls.Conn, error) {
+		tc = tc.Clone()
+		tc.EncryptedClientHelloConfigList = nil
+		d := &tls.Dialer{Config: tc}
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return conn.(*tls.Conn), nil
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", addr, tc); !errors.Is(err, ErrECHNotAccepted) {
+		t.Fatalf("Dial() = %v, want ErrECHNotAccepted", err)
+	}
+}
+
+func TestDialerHosts(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	dialer := NewDialer()
+	dialer.RequireECH = true
+	dialer.Resolver = &Resolver{
+		baseURL: url.URL{Scheme: "http", Host: "127.0.0.1:1", Path: "/dns-query"},
+	}
+	dialer.Hosts = map[string]StaticHost{
+		"private.example.com": {
+			Addresses: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+			ECH:       configList,
+		},
+	}
+	tc := &tls.Config{RootCAs: rootCAs}
+	conn, err := dialer.Dial(t.Context(), "tcp", "Private.Example.Com.:"+port, tc)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().ECHAccepted {
+		t.Error("ECHAccepted = false, want true")
+	}
+}
+
+func TestDialerConfigPins(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+	_, otherConfig, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	otherConfigList, err := ConfigList([]Config{otherConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	pins, err := NewConfigPinStore("", 0)
+	if err != nil {
+		t.Fatalf("NewConfigPinStore: %v", err)
+	}
+
+	dialer := NewDialer()
+	dialer.ConfigPins = pins
+	setECH := func(ech []byte) {
+		dialer.Hosts = map[string]StaticHost{
+			"private.example.com": {
+				Addresses: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+				ECH:       ech,
+			},
+		}
+	}
+	tc := &tls.Config{RootCAs: rootCAs}
+	target := "private.example.com:" + port
+
+	setECH(configList)
+	conn, err := dialer.Dial(t.Context(), "tcp", target, tc)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+
+	// The server's retry configs still have the pinned key.
+	setECH(otherConfigList)
+	if conn, err = dialer.Dial(t.Context(), "tcp", target, tc); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+
+	setECH(nil)
+	if _, err := dialer.Dial(t.Context(), "tcp", target, tc); !errors.Is(err, ErrConfigPinMismatch) {
+		t.Errorf("Dial() = %v, want ErrConfigPinMismatch", err)
+	}
+
+	// The server doesn't have the pinned key.
+	if err := pins.Pin("private.example.com", otherConfigList); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+	setECH(configList)
+	if _, err := dialer.Dial(t.Context(), "tcp", target, tc); !errors.Is(err, ErrConfigPinMismatch) {
+		t.Errorf("Dial() = %v, want ErrConfigPinMismatch", err)
+	}
+
+	// Warn only. The config list from DNS is used.
+	var mismatches []string
+	dialer.OnConfigPinMismatch = func(host string, err error) error {
+		mismatches = append(mismatches, host)
+		return nil
+	}
+	if conn, err = dialer.Dial(t.Context(), "tcp", target, tc); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if want := []string{"private.example.com"}; !slices.Equal(mismatches, want) {
+		t.Errorf("mismatches = %v, want %v", mismatches, want)
+	}
+}
+
+func TestDialerConfigPinsRotation(t *testing.T) {
+	newKey := func(id uint8) (tls.EncryptedClientHelloKey, []byte) {
+		privKey, config, err := NewConfig(id, []byte("public.example.com"))
+		if err != nil {
+			t.Fatalf("NewConfig: %v", err)
+		}
+		configList, err := ConfigList([]Config{config})
+		if err != nil {
+			t.Fatalf("ConfigList: %v", err)
+		}
+		return tls.EncryptedClientHelloKey{Config: config, PrivateKey: privKey.Bytes(), SendAsRetry: true}, configList
+	}
+	oldKey, oldConfigList := newKey(1)
+	newerKey, newConfigList := newKey(2)
+
+	tlsCert, err := testutil.NewCert("private.example.com", "public.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	var keys atomic.Pointer[[]tls.EncryptedClientHelloKey]
+	keys.Store(&[]tls.EncryptedClientHelloKey{oldKey})
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		GetEncryptedClientHelloKeys: func(*tls.ClientHelloInfo) ([]tls.EncryptedClientHelloKey, error) {
+			return *keys.Load(), nil
+		},
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	pins, err := NewConfigPinStore("", 0)
+	if err != nil {
+		t.Fatalf("NewConfigPinStore: %v", err)
+	}
+	dialer := NewDialer()
+	dialer.ConfigPins = pins
+	setECH := func(ech []byte) {
+		dialer.Hosts = map[string]StaticHost{
+			"private.example.com": {
+				Addresses: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+				ECH:       ech,
+			},
+		}
+	}
+	tc := &tls.Config{RootCAs: rootCAs}
+	target := "private.example.com:" + port
+	dial := func() {
+		t.Helper()
+		conn, err := dialer.Dial(t.Context(), "tcp", target, tc)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		if !conn.ConnectionState().ECHAccepted {
+			t.Error("ECHAccepted = false, want true")
+		}
+	}
+
+	setECH(oldConfigList)
+	dial()
+
+	// The server rotates its keys and keeps the old one for a while. DNS
+	// only has the new one.
+	keys.Store(&[]tls.EncryptedClientHelloKey{newerKey, oldKey})
+	setECH(newConfigList)
+	dial()
+	if err := pins.Check("private.example.com", newConfigList); err != nil {
+		t.Errorf("Check(new) = %v", err)
+	}
+
+	// The old key is gone.
+	keys.Store(&[]tls.EncryptedClientHelloKey{newerKey})
+	dial()
+	if err := pins.Check("private.example.com", oldConfigList); !errors.Is(err, ErrConfigPinMismatch) {
+		t.Errorf("Check(old) = %v, want ErrConfigPinMismatch", err)
+	}
+}
+
+func TestDialWithResult(t *testing.T) {
+	addr, _, rootCAs := startTestECHServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+	_, staleConfig, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	staleConfigList, err := ConfigList([]Config{staleConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+
+	dialer := NewDialer()
+	dialer.Hosts = map[string]StaticHost{
+		"private.example.com": {
+			Addresses: []netip.Addr{
+				netip.MustParseAddr("::1"),
+				netip.MustParseAddr("127.0.0.1"),
+			},
+			ECH: staleConfigList,
+		},
+	}
+	conn, result, err := dialer.DialWithResult(t.Context(), "tcp", "private.example.com:"+port, &tls.Config{RootCAs: rootCAs})
+	if err != nil {
+		t.Fatalf("DialWithResult: %v", err)
+	}
+	conn.Close()
+
+	want := netip.MustParseAddrPort("127.0.0.1:" + port)
+	if result.Target.Address != want {
+		t.Errorf("Target = %v, want %v", result.Target.Address, want)
+	}
+	if len(result.Tried) != 2 || result.Tried[1] != want {
+		t.Errorf("Tried = %v", result.Tried)
+	}
+	if result.Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", result.Attempts)
+	}
+	if !result.ECHOffered || !result.ECHAccepted || result.ECHRetries != 1 {
+		t.Errorf("ECHOffered = %v, ECHAccepted = %v, ECHRetries = %d", result.ECHOffered, result.ECHAccepted, result.ECHRetries)
+	}
+	if result.ConnectDuration <= 0 || result.Duration < result.ConnectDuration {
+		t.Errorf("ConnectDuration = %v, Duration = %v", result.ConnectDuration, result.Duration)
+	}
+}
+
+func TestNetDialContext(t *testing.T) {
+	hosts := map[string]StaticHost{
+		"example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+	}
+	var dialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	d1 := &Dialer[net.Conn]{
+		Hosts: hosts,
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (net.Conn, error) {
+			c, _ := net.Pipe()
+			return c, nil
+		},
+	}
+	dialContext = d1.NetDialContext
+	conn, err := dialContext(t.Context(), "tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("NetDialContext: %v", err)
+	}
+	conn.Close()
+
+	d2 := &Dialer[string]{
+		Hosts: hosts,
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			return addr, nil
+		},
+	}
+	if _, err := d2.NetDialContext(t.Context(), "tcp", "example.com:443"); err == nil {
+		t.Error("NetDialContext() succeeded unexpectedly")
+	}
+}
+
+func TestNetDialContextFunc(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	var controlled, proxied []string
+	dialer := &Dialer[net.Conn]{
+		DialFunc: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return NetDialContext(ctx, network, addr)
+		},
+		TCPOptions: &TCPOptions{
+			Control: func(network, address string, c syscall.RawConn) error {
+				controlled = append(controlled, address)
+				return nil
+			},
+		},
+	}
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if want := []string{addr}; !slices.Equal(controlled, want) {
+		t.Errorf("Control called with %v, want %v", controlled, want)
+	}
+
+	dialer.Proxy = ProxyFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		proxied = append(proxied, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	})
+	if conn, err = dialer.Dial(t.Context(), "tcp", addr, nil); err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if want := []string{addr}; !slices.Equal(proxied, want) {
+		t.Errorf("Proxy called with %v, want %v", proxied, want)
+	}
+}
+
+func TestDialerTCPOptions(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	var controlled []string
+	dialer := NewDialer()
+	dialer.TCPOptions = &TCPOptions{
+		KeepAlive: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     15 * time.Second,
+			Interval: 5 * time.Second,
+			Count:    3,
+		},
+		DisableNoDelay: true,
+		UserTimeout:    10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled = append(controlled, address)
+			return nil
+		},
+	}
+	tc := &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: configList,
+	}
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, tc)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	if !conn.ConnectionState().ECHAccepted {
+		t.Error("ECHAccepted = false, want true")
+	}
+	if want := []string{addr}; !slices.Equal(controlled, want) {
+		t.Errorf("Control called with %v, want %v", controlled, want)
+	}
+}
+
+func TestDialSessionResumption(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	dialer := NewDialer()
+	dialer.RequireECHAccepted = true
+	for i, wantResume := range []bool{false, true} {
+		conn, err := dialer.Dial(t.Context(), "tcp", addr, &tls.Config{
+			ServerName:                     "private.example.com",
+			RootCAs:                        rootCAs,
+			EncryptedClientHelloConfigList: configList,
+		})
+		if err != nil {
+			t.Fatalf("[%d] Dial: %v", i, err)
+		}
+		// Read the session ticket.
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Fatalf("[%d] ReadAll: %v", i, err)
+		}
+		conn.Close()
+		if got := conn.ConnectionState().DidResume; got != wantResume {
+			t.Errorf("[%d] DidResume = %v, want %v", i, got, wantResume)
+		}
+	}
+}
+
+func TestDialZone(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"link.local": {Addresses: []netip.Addr{netip.MustParseAddr("fe80::2%eth1")}},
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dialed = append(dialed, addr)
+			return addr, nil
+		},
+	}
+	for _, tc := range []struct {
+		addr string
+		want string
+	}{
+		{"[fe80::1%eth0]:8443", "[fe80::1%eth0]:8443"},
+		{"https://[fe80::1%25eth0]/", "[fe80::1%eth0]:443"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:443"},
+		{"link.local:443", "[fe80::2%eth1]:443"},
+	} {
+		got, err := dialer.Dial(t.Context(), "tcp", tc.addr, nil)
+		if err != nil {
+			t.Fatalf("Dial(%q): %v", tc.addr, err)
+		}
+		if got != tc.want {
+			t.Errorf("Dial(%q) = %q, want %q", tc.addr, got, tc.want)
+		}
+	}
+}
+
+func TestDialTargetFilter(t *testing.T) {
+	var mu sync.Mutex
+	var dialed []string
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"example.com": {Addresses: []netip.Addr{
+				netip.MustParseAddr("10.0.0.1"),
+				netip.MustParseAddr("192.0.2.1"),
+				netip.MustParseAddr("192.0.2.2"),
+			}},
+		},
+		TargetFilter: func(t Target) (Target, bool) {
+			if netip.MustParsePrefix("10.0.0.0/8").Contains(t.Address.Addr()) {
+				return t, false
+			}
+			if t.Address.Addr() == netip.MustParseAddr("192.0.2.2") {
+				t.Address = netip.AddrPortFrom(t.Address.Addr(), 8443)
+			}
+			return t, true
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dialed = append(dialed, addr)
+			return "", errors.New("unreachable")
+		},
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", "example.com:443", nil); err == nil {
+		t.Fatal("Dial() succeeded unexpectedly")
+	}
+	slices.Sort(dialed)
+	if want := []string{"192.0.2.1:443", "192.0.2.2:8443"}; !slices.Equal(dialed, want) {
+		t.Errorf("dialed = %v, want %v", dialed, want)
+	}
+
+	dialed = nil
+	dialer.TargetFilter = func(t Target) (Target, bool) { return t, false }
+	if _, err := dialer.Dial(t.Context(), "tcp", "example.com:443", nil); !errors.Is(err, ErrNoAddress) {
+		t.Errorf("Dial() = %v, want ErrNoAddress", err)
+	}
+	if len(dialed) != 0 {
+		t.Errorf("dialed = %v, want none", dialed)
+	}
+}
+
+func TestDialerPolicies(t *testing.T) {
+	var gotPublicName string
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"www.example.com":    {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+			"a.corp.example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.2")}},
+			"www.example.org":    {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.3")}},
+		},
+		Policies: map[string]DialPolicy{
+			".corp.example.com": {RequireECH: true},
+			"*.example.org":     {PublicName: "public.example.org"},
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			gotPublicName = ""
+			if specs, err := ParseConfigList(tc.EncryptedClientHelloConfigList); err == nil && len(specs) > 0 {
+				gotPublicName = string(specs[0].PublicName)
+			}
+			return addr, nil
+		},
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", "www.example.com:443", nil); err != nil {
+		t.Fatalf("Dial(www.example.com): %v", err)
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", "a.corp.example.com:443", nil); !errors.Is(err, errNoECHConfigList) {
+		t.Fatalf("Dial(a.corp.example.com) = %v, want errNoECHConfigList", err)
+	}
+	if _, err := dialer.Dial(t.Context(), "tcp", "www.example.org:443", nil); err != nil {
+		t.Fatalf("Dial(www.example.org): %v", err)
+	}
+	if got, want := gotPublicName, "public.example.org"; got != want {
+		t.Errorf("PublicName = %q, want %q", got, want)
+	}
+
+	// A policy can tolerate plaintext SNI when the Dialer requires ECH.
+	dialer.RequireECH = true
+	dialer.Policies["www.example.com"] = DialPolicy{AllowPlaintextSNI: true}
+	if _, err := dialer.Dial(t.Context(), "tcp", "www.example.com:443", nil); err != nil {
+		t.Fatalf("Dial(www.example.com): %v", err)
+	}
+	if _, err := dialer.Dial(WithRequireECH(t.Context()), "tcp", "www.example.com:443", nil); !errors.Is(err, errNoECHConfigList) {
+		t.Fatalf("Dial(www.example.com) = %v, want errNoECHConfigList", err)
+	}
+}
+
+func TestDialerKeyLogWriter(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	var keyLog bytes.Buffer
+	dialer := NewDialer()
+	dialer.KeyLogWriter = &keyLog
+	tc := &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: configList,
+	}
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, tc)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	if !strings.Contains(keyLog.String(), "CLIENT_HANDSHAKE_TRAFFIC_SECRET ") {
+		t.Errorf("KeyLogWriter = %q, want handshake secrets", keyLog.String())
+	}
+	if tc.KeyLogWriter != nil {
+		t.Error("tls.Config was modified")
+	}
+}
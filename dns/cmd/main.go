@@ -1,26 +1,70 @@
-// This is an example showing how to send a DoH request using [dns.DoH] and
-// [dns.Message].
+// This is an example showing how to send DNS requests using [dns.DoH],
+// [dns.DoT], [dns.Do53], and [dns.Message].
+//
+// Usage:
+//
+//	dns [-doh|-dot|-udp] [@server] <name> [type]
+//
+// The server is a DoH URL, e.g. @https://1.1.1.1/dns-query, or the address of
+// a name server, e.g. @dns.google or @8.8.8.8:53. The transport is DoH by
+// default. The default server is 1.1.1.1, and the default type is A.
+//
+// The same command with DNS-over-QUIC (-doq) is in the github.com/c2FmZQ/ech/quic
+// module, in quic/cmd/dns.
 package main
 
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/c2FmZQ/ech/dns"
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Fprintf(os.Stderr, "usage: %s <url> <name> <type>\n", filepath.Base(os.Args[0]))
+	doh := flag.Bool("doh", false, "Use DNS-over-HTTPS (RFC 8484).")
+	dot := flag.Bool("dot", false, "Use DNS-over-TLS (RFC 7858).")
+	udp := flag.Bool("udp", false, "Use unencrypted DNS over UDP, with TCP fallback.")
+	timeout := flag.Duration("timeout", 10*time.Second, "The maximum amount of time to wait for the response.")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-doh|-dot|-udp] [@server] <name> [type]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	server := "1.1.1.1"
+	// For compatibility, a DoH URL can also be passed without @.
+	if len(args) > 0 && (strings.HasPrefix(args[0], "@") || strings.Contains(args[0], "://")) {
+		server = strings.TrimPrefix(args[0], "@")
+		args = args[1:]
+	}
+	if len(args) < 1 || len(args) > 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	name := args[0]
+	typ := "A"
+	if len(args) == 2 {
+		typ = args[1]
+	}
+	n := 0
+	for _, v := range []bool{*doh, *dot, *udp} {
+		if v {
+			n++
+		}
+	}
+	if n > 1 {
+		fmt.Fprintln(os.Stderr, "only one of -doh, -dot, -udp can be used")
 		os.Exit(1)
 	}
-	url := os.Args[1]
-	name := os.Args[2]
-	typ := os.Args[3]
 
 	iType := dns.RRType(typ)
 	if iType == 0 {
@@ -40,9 +84,27 @@ func main() {
 		}},
 	}
 	qq.AddPadding()
-	result, err := dns.DoH(context.Background(), qq, url)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var result *dns.Message
+	var err error
+	switch {
+	case *dot:
+		result, err = dns.DoT(ctx, qq, server, nil)
+	case *udp:
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		result, err = dns.Do53(ctx, qq, server)
+	default:
+		if !strings.Contains(server, "://") {
+			server = "https://" + server + "/dns-query"
+		}
+		result, err = dns.DoH(ctx, qq, server)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "dns.DoH: %v", err)
+		fmt.Fprintf(os.Stderr, "%s: %v\n", server, err)
 		os.Exit(1)
 	}
 	enc := json.NewEncoder(os.Stdout)
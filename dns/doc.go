@@ -1,5 +1,7 @@
 // Package dns implements low-level DNS message encoding and decoding to
-// interface with RFC 8484 "DNS Queries over HTTPS" (DoH) services.
+// interface with RFC 8484 "DNS Queries over HTTPS" (DoH) services, RFC 7858
+// "DNS over Transport Layer Security" (DoT) services, and traditional name
+// servers.
 //
 // Example:
 //
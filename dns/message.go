@@ -333,6 +333,22 @@ func (rr RR) Bytes() []byte {
 				}
 				s.AddUint8(0)
 			}
+		case SOA:
+			for _, name := range []string{data.MName, data.RName} {
+				if name = strings.TrimSuffix(name, "."); len(name) > 0 {
+					for _, p := range strings.Split(name, ".") {
+						s.AddUint8LengthPrefixed(func(s *cryptobyte.Builder) {
+							s.AddBytes([]byte(p))
+						})
+					}
+				}
+				s.AddUint8(0)
+			}
+			s.AddUint32(data.Serial)
+			s.AddUint32(data.Refresh)
+			s.AddUint32(data.Retry)
+			s.AddUint32(data.Expire)
+			s.AddUint32(data.Minimum)
 		case []Option:
 			for _, opt := range data {
 				s.AddUint16(opt.Code)
@@ -392,6 +408,22 @@ func (rr RR) Bytes() []byte {
 					}
 				})
 			}
+		case SVCB:
+			s.AddUint16(data.Priority)
+			if target := strings.TrimSuffix(data.Target, "."); len(target) > 0 {
+				for _, p := range strings.Split(target, ".") {
+					s.AddUint8LengthPrefixed(func(s *cryptobyte.Builder) {
+						s.AddBytes([]byte(p))
+					})
+				}
+			}
+			s.AddUint8(0)
+			for _, p := range data.Params {
+				s.AddUint16(p.Key)
+				s.AddUint16LengthPrefixed(func(s *cryptobyte.Builder) {
+					s.AddBytes(p.Value)
+				})
+			}
 
 		default:
 			panic(fmt.Sprintf("cannot serialize %T", rr.Data))
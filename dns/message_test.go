@@ -373,6 +373,41 @@ func TestMessageHTTPS(t *testing.T) {
 	}
 }
 
+func TestMessageSVCB(t *testing.T) {
+	want := &Message{
+		ID: 0x1234,
+		QR: 0x1,
+		RD: 0x1,
+		RA: 0x1,
+		Question: []Question{{
+			Name:  "_dns.resolver.arpa",
+			Type:  0x40,
+			Class: 0x1,
+		}},
+		Answer: []RR{{
+			Name:  "_dns.resolver.arpa",
+			Type:  0x40,
+			Class: 0x1,
+			TTL:   0x12c,
+			Data: SVCB{
+				Priority: 0x1,
+				Target:   "dns.example.com",
+				Params: []SVCBParam{
+					{Key: 1, Value: []byte{0x02, 0x68, 0x32}},
+					{Key: 7, Value: []byte("/dns-query{?dns}")},
+				},
+			},
+		}},
+	}
+	got, err := DecodeMessage(want.Bytes())
+	if err != nil {
+		t.Fatalf("DecodeMessage: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %#v, want %#v", got, want)
+	}
+}
+
 func TestMessageLOC(t *testing.T) {
 	m := []byte{
 		0x00, 0x00, 0x81, 0x80, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x07, 0x53, 0x57, 0x31,
@@ -512,6 +547,13 @@ func TestMessageNXDomain(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("Got %#v, want %#v", got, want)
 	}
+
+	if got, err = DecodeMessage(want.Bytes()); err != nil {
+		t.Fatalf("DecodeMessage: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %#v, want %#v", got, want)
+	}
 }
 
 func TestPadding(t *testing.T) {
@@ -3,15 +3,72 @@ package dns
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"net"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 )
 
-// DoH sends a RFC 8484 DoH (DNS-over-HTTPS) request to URL.
+// errNoSockets is returned by the functions that need sockets on platforms
+// that don't have them, i.e. js/wasm.
+var errNoSockets = fmt.Errorf("%w: sockets aren't available on js/wasm", errors.ErrUnsupported)
+
+// DoH sends a RFC 8484 DoH (DNS-over-HTTPS) request to URL. On js/wasm, the
+// request is sent with the Fetch API, and the DoH server must allow
+// cross-origin requests.
 func DoH(ctx context.Context, msg *Message, URL string) (*Message, error) {
+	return doh(ctx, newHTTPClient(), msg, URL)
+}
+
+// DoHResult is the response to one of the messages of [DoHBatch].
+type DoHResult struct {
+	// Index is the index of the message in the batch.
+	Index int
+	// Message is the response. It is nil when Err isn't.
+	Message *Message
+	Err     error
+}
+
+// DoHBatch sends RFC 8484 DoH (DNS-over-HTTPS) requests for all the messages
+// to URL concurrently, and yields the responses as they arrive, i.e. not
+// necessarily in the order of the messages. The requests are sent over a
+// single connection, as multiplexed streams when the server supports HTTP/2,
+// or one after the other otherwise. Breaking out of the loop cancels the
+// outstanding requests.
+func DoHBatch(ctx context.Context, msgs []*Message, URL string) iter.Seq[DoHResult] {
+	return func(yield func(DoHResult) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		client := newHTTPClient()
+		if t, ok := client.HTTPClient.Transport.(*http.Transport); ok {
+			t.MaxConnsPerHost = 1
+			defer t.CloseIdleConnections()
+		}
+		ch := make(chan DoHResult, len(msgs))
+		for i, msg := range msgs {
+			go func() {
+				resp, err := doh(ctx, client, msg, URL)
+				ch <- DoHResult{Index: i, Message: resp, Err: err}
+			}()
+		}
+		for range msgs {
+			if !yield(<-ch) {
+				return
+			}
+		}
+	}
+}
+
+func doh(ctx context.Context, client *retryablehttp.Client, msg *Message, URL string) (*Message, error) {
 	req, err := retryablehttp.NewRequestWithContext(ctx, "POST", URL, bytes.NewReader(msg.Bytes()))
 	if err != nil {
 		return nil, err
@@ -19,8 +76,6 @@ func DoH(ctx context.Context, msg *Message, URL string) (*Message, error) {
 	req.Header.Set("accept", "application/dns-message")
 	req.Header.Set("content-type", "application/dns-message")
 	req.Header.Set("user-agent", "")
-	client := retryablehttp.NewClient()
-	client.Logger = nil
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
@@ -39,3 +94,132 @@ func DoH(ctx context.Context, msg *Message, URL string) (*Message, error) {
 	}
 	return DecodeMessage(body)
 }
+
+// Do53 sends an unencrypted RFC 1035 DNS request to the name server at addr,
+// over UDP. The request is sent again over TCP if the response is truncated.
+// A random message ID is used.
+//
+// Unencrypted DNS requests can be observed and modified by anyone on the
+// network path. Do53 should only be used to bootstrap an encrypted resolver.
+func Do53(ctx context.Context, msg *Message, addr string) (*Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	m := *msg
+	var id [2]byte
+	rand.Read(id[:])
+	m.ID = binary.BigEndian.Uint16(id[:])
+
+	result, err := exchange(ctx, "udp", &m, addr)
+	if err == nil && result.TC == 1 {
+		result, err = exchange(ctx, "tcp", &m, addr)
+	}
+	return result, err
+}
+
+// DoT sends a RFC 7858 DoT (DNS-over-TLS) request to the name server at addr,
+// e.g. "dns.google" or "1.1.1.1:853". The default port number is 853. The tc
+// argument can be nil. Otherwise, it is used to configure the TLS connection,
+// e.g. with RootCAs. When tc doesn't have a ServerName, the host part of addr
+// is used. A random message ID is used.
+func DoT(ctx context.Context, msg *Message, addr string, tc *tls.Config) (*Message, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		addr = net.JoinHostPort(addr, "853")
+	}
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc = tc.Clone()
+	if tc.ServerName == "" {
+		tc.ServerName = host
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	m := *msg
+	var id [2]byte
+	rand.Read(id[:])
+	m.ID = binary.BigEndian.Uint16(id[:])
+
+	if !haveSockets {
+		return nil, errNoSockets
+	}
+	d := &tls.Dialer{Config: tc}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchangeConn(ctx, conn, true, &m)
+}
+
+func exchange(ctx context.Context, network string, msg *Message, addr string) (*Message, error) {
+	if !haveSockets {
+		return nil, errNoSockets
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchangeConn(ctx, conn, network == "tcp", msg)
+}
+
+// exchangeConn sends msg on conn and returns the response. With stream, the
+// messages are prefixed with their length, as on TCP.
+func exchangeConn(ctx context.Context, conn net.Conn, stream bool, msg *Message) (*Message, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	b := msg.Bytes()
+	if stream {
+		b = append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...)
+	}
+	if _, err := conn.Write(b); err != nil {
+		return nil, err
+	}
+	for {
+		var buf []byte
+		if stream {
+			var sz [2]byte
+			if _, err := io.ReadFull(conn, sz[:]); err != nil {
+				return nil, err
+			}
+			buf = make([]byte, binary.BigEndian.Uint16(sz[:]))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				return nil, err
+			}
+		} else {
+			buf = make([]byte, 65535)
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			buf = buf[:n]
+		}
+		result, err := DecodeMessage(buf)
+		if err != nil {
+			return nil, err
+		}
+		// Ignore responses to other requests over UDP.
+		if result.ID != msg.ID || result.QR != 1 {
+			if stream {
+				return nil, errors.New("unexpected response")
+			}
+			continue
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,91 @@
+package dns_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func TestDoT(t *testing.T) {
+	cert, err := testutil.NewCert("dns.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+	addr := testutil.StartTestDoTServer(t, []dns.RR{{
+		Name: "www.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: net.IP{192, 0, 2, 1},
+	}}, cert)
+
+	qq := &dns.Message{
+		RD: 1,
+		Question: []dns.Question{{
+			Name:  "www.example.com",
+			Type:  1,
+			Class: 1,
+		}},
+	}
+	result, err := dns.DoT(t.Context(), qq, addr, &tls.Config{ServerName: "dns.example.com", RootCAs: rootCAs})
+	if err != nil {
+		t.Fatalf("DoT: %v", err)
+	}
+	if len(result.Answer) != 1 || !result.Answer[0].Data.(net.IP).Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("Answer = %v, want 192.0.2.1", result.Answer)
+	}
+
+	// The certificate isn't valid for 127.0.0.1.
+	if _, err := dns.DoT(t.Context(), qq, addr, &tls.Config{RootCAs: rootCAs}); err == nil {
+		t.Error("DoT succeeded unexpectedly")
+	}
+}
+
+func TestDoHBatch(t *testing.T) {
+	db := []dns.RR{
+		{Name: "a.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{192, 0, 2, 1}},
+		{Name: "b.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{192, 0, 2, 2}},
+		{Name: "c.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{192, 0, 2, 3}},
+	}
+	ts := testutil.StartTestDNSServer(t, db)
+	defer ts.Close()
+
+	var msgs []*dns.Message
+	for _, rr := range db {
+		msgs = append(msgs, &dns.Message{
+			RD: 1,
+			Question: []dns.Question{{
+				Name:  rr.Name,
+				Type:  1,
+				Class: 1,
+			}},
+		})
+	}
+	seen := make(map[int]bool)
+	for res := range dns.DoHBatch(t.Context(), msgs, ts.URL) {
+		if res.Err != nil {
+			t.Fatalf("DoHBatch[%d]: %v", res.Index, res.Err)
+		}
+		if seen[res.Index] {
+			t.Errorf("Index %d seen twice", res.Index)
+		}
+		seen[res.Index] = true
+		if got, want := res.Message.Answer, db[res.Index]; len(got) != 1 || got[0].Name != want.Name || !got[0].Data.(net.IP).Equal(want.Data.(net.IP)) {
+			t.Errorf("DoHBatch[%d] Answer = %v, want %v", res.Index, got, want)
+		}
+	}
+	if got, want := len(seen), len(msgs); got != want {
+		t.Errorf("Got %d responses, want %d", got, want)
+	}
+
+	// Breaking out of the loop early is fine.
+	for res := range dns.DoHBatch(t.Context(), msgs, ts.URL) {
+		if res.Err != nil {
+			t.Fatalf("DoHBatch[%d]: %v", res.Index, res.Err)
+		}
+		break
+	}
+}
@@ -0,0 +1,21 @@
+//go:build js
+
+package dns
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// haveSockets is false on js/wasm, where the network is only accessible with
+// the Fetch API.
+const haveSockets = false
+
+func newHTTPClient() *retryablehttp.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	// A http.Transport without dial functions uses the Fetch API.
+	client.HTTPClient.Transport = &http.Transport{}
+	return client
+}
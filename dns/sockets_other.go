@@ -0,0 +1,13 @@
+//go:build !js
+
+package dns
+
+import "github.com/hashicorp/go-retryablehttp"
+
+const haveSockets = true
+
+func newHTTPClient() *retryablehttp.Client {
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	return client
+}
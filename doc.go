@@ -58,6 +58,13 @@
 //	        }()
 //	}
 //
+// A [ech.Router] can be used to route connections to backend servers or handler
+// functions based on their ServerName.
+//
+// The [github.com/c2FmZQ/ech/proxy] package uses [ech.Conn] and [ech.Router] to
+// implement a complete split-mode reverse proxy, with a configuration file,
+// health-checked backends, and graceful reloads.
+//
 // ECH Configs and ECH ConfigLists are created with [ech.NewConfig] and [ech.ConfigList].
 //
 // Clients can use [ech.Resolve], [ech.Dial], and/or [ech.Transport] to securely connect
@@ -67,5 +74,24 @@
 // concurrent connection attempts to gracefully handle slow or unreachable addresses.
 // See [ech.Dialer] for more details.
 //
+// [ech.ClientConn] is the client side counterpart of [ech.Conn]. It constructs
+// the Encrypted Client Hello messages for TLS implementations that can't do it
+// themselves.
+//
+// [ech.Probe] checks the ECH deployment of a host, i.e. its DNS records, its
+// retry configs, and whether it accepts the configs published in DNS.
+//
+// The errors wrap sentinel errors and typed errors, e.g. [ech.DNSError] and
+// [ech.DialError], that can be inspected with [errors.Is] and [errors.As].
+// [ech.ErrorClass] and [ech.ErrorAction] return stable codes for them, and
+// whether the operation should be retried, the ECH config list rotated, or the
+// configuration fixed.
+//
+// On js/wasm, [ech.Resolver] sends its DNS-over-HTTPS queries with the Fetch
+// API, so that the HTTPS RR and ECH config list resolution can be used in
+// browsers. The features that need sockets, e.g. the default DialFunc of
+// [ech.Dialer], [ech.Transport], and [ech.NewBootstrapResolver], aren't
+// available. A [ech.Dialer] can still be used with a custom DialFunc.
+//
 // The example directory has working client and server examples.
 package ech
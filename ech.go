@@ -1,13 +1,19 @@
 package ech
 
 import (
+	"bytes"
 	"context"
-	"crypto/ecdh"
 	"crypto/hpke"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -33,6 +39,115 @@ func WithDebug(f func(format string, arg ...any)) Option {
 	}
 }
 
+// WithLogger sets the logger of the connection. The handshake messages that
+// are inspected, and the reason why a connection is aborted, are logged at the
+// Debug level. When WithLogger isn't used, the logger set with the package's
+// [SetLogger] is used. WithDebug takes precedence over the logger for the
+// handshake messages.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Conn) {
+		c.logger = l
+	}
+}
+
+// WithHandshakeTimeout sets the maximum amount of time that the TLS handshake
+// messages can be inspected, starting when NewConn is called. This includes the
+// initial ClientHello and the ClientHello that is retried after a
+// HelloRetryRequest. When the timeout expires, reads and writes fail with
+// [os.ErrDeadlineExceeded].
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(c *Conn) {
+		c.handshakeTimeout = d
+	}
+}
+
+// WithIdleTimeout sets the maximum amount of time that the connection can
+// remain idle, i.e. without any data being read or written. When the timeout
+// expires, reads and writes fail with [os.ErrDeadlineExceeded]. It disables
+// [WithSplice].
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Conn) {
+		c.idleTimeout = d
+	}
+}
+
+// WithMaxLifetime sets the maximum amount of time that the connection can be
+// used, starting when NewConn is called. When it expires, reads and writes fail
+// with [os.ErrDeadlineExceeded].
+func WithMaxLifetime(d time.Duration) Option {
+	return func(c *Conn) {
+		c.maxLifetime = d
+	}
+}
+
+// WithPlaintextHTTPHandler enables the detection of plaintext HTTP requests
+// received instead of a TLS ClientHello, e.g. when a client connects to
+// http://example.com:443/. The request is served with h, and then the
+// connection is closed. In that case, NewConn returns [ErrPlaintextHTTP].
+func WithPlaintextHTTPHandler(h http.Handler) Option {
+	return func(c *Conn) {
+		c.plaintextHTTPHandler = h
+	}
+}
+
+// WithHTTPSRedirect enables the detection of plaintext HTTP requests received
+// instead of a TLS ClientHello. The client is redirected to the same URL with
+// the https scheme. It is equivalent to
+// WithPlaintextHTTPHandler([HTTPSRedirectHandler]()).
+func WithHTTPSRedirect() Option {
+	return WithPlaintextHTTPHandler(HTTPSRedirectHandler())
+}
+
+// WithMaxHandshakeBytes sets the maximum number of bytes that can be read or
+// written while the TLS handshake messages are inspected, in each direction,
+// including the initial ClientHello. Only the plaintext handshake records
+// count, not the encrypted records that end the inspection. When the limit is
+// exceeded, the connection is aborted with [ErrHandshakeTooLarge]. By default,
+// the only limit is the maximum TLS record size.
+func WithMaxHandshakeBytes(n int) Option {
+	return func(c *Conn) {
+		c.maxHandshakeBytes = n
+	}
+}
+
+// WithMinTLS13 rejects the connections from clients that don't offer TLS 1.3
+// in their ClientHello. A protocol_version alert is sent to the client, and
+// NewConn returns [ErrProtocolVersion]. Without this option, these connections
+// are passed through unmodified. Since ECH requires TLS 1.3, this option can be
+// used to make sure that only connections that can use ECH reach the backends.
+func WithMinTLS13() Option {
+	return func(c *Conn) {
+		c.minTLS13 = true
+	}
+}
+
+// WithServerNamePolicy sets a function that decides whether the ServerName
+// requested by the client is served here. It is called after the ClientHello
+// is decrypted, with the ServerName from the ClientHelloInner when ECH is
+// accepted, or from the ClientHelloOuter otherwise. It is called again when the
+// ClientHello is retried.
+//
+// When policy returns an error, the connection is aborted with an
+// access_denied alert, or an illegal_parameter alert if the error wraps
+// [ErrIllegalParameter]. The error returned by NewConn or Read wraps both
+// [ErrAccessDenied] and the policy's error.
+func WithServerNamePolicy(policy func(serverName string) error) Option {
+	return func(c *Conn) {
+		c.serverNamePolicy = policy
+	}
+}
+
+// WithAlertFunc sets the function that maps errors to the TLS alerts that are
+// sent to the client when the connection is aborted. It returns the alert
+// description, and whether an alert should be sent at all. The default is
+// [DefaultAlert]. When an alert is sent, the error returned by NewConn, Read,
+// or Write is an [AlertError].
+func WithAlertFunc(f func(err error) (alert uint8, send bool)) Option {
+	return func(c *Conn) {
+		c.alertFunc = f
+	}
+}
+
 // NewConn returns a [Conn] that manages Encrypted Client Hello in TLS
 // connections, as defined in RFC 9849.
 //
@@ -47,8 +162,36 @@ func WithDebug(f func(format string, arg ...any)) Option {
 //
 // The ctx is used while reading the initial ClientHello only. It is not used
 // after New returns.
+//
+// When NewConn returns an error, conn is closed, after sending a TLS alert
+// when appropriate, see [WithAlertFunc].
 func NewConn(ctx context.Context, conn net.Conn, options ...Option) (outConn *Conn, err error) {
-	defer convertErrorsToAlerts(conn, err)
+	c := &Conn{
+		Conn:       conn,
+		start:      time.Now(),
+		retryCount: new(atomic.Int32),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	defer func() {
+		err = abort(conn, c.alertFunc, err)
+		if err != nil && debugEnabled(logger(c.logger)) {
+			logger(c.logger).Debug("ech: connection aborted", "remote", conn.RemoteAddr().String(), "err", err)
+		}
+	}()
+	if c.debugf == nil {
+		c.debugf = func(string, ...any) {}
+		if l := logger(c.logger); debugEnabled(l) {
+			remote := conn.RemoteAddr().String()
+			c.debugf = func(format string, args ...any) {
+				l.Debug("ech: "+strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"), "remote", remote)
+			}
+		}
+	}
+	if err := c.refreshDeadlines(); err != nil {
+		return nil, err
+	}
 	done := make(chan struct{})
 	defer close(done)
 	go func() {
@@ -58,37 +201,61 @@ func NewConn(ctx context.Context, conn net.Conn, options ...Option) (outConn *Co
 			conn.SetDeadline(time.Now())
 		}
 	}()
-	record, err := readRecord(conn)
+	var r io.Reader = conn
+	if c.plaintextHTTPHandler != nil {
+		hdr := make([]byte, 5)
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return nil, err
+		}
+		r = io.MultiReader(bytes.NewReader(hdr), conn)
+		if isPlaintextHTTP(hdr) {
+			servePlaintextHTTP(conn, r, c.plaintextHTTPHandler)
+			return nil, ErrPlaintextHTTP
+		}
+	}
+	record, err := readRecordLimit(r, c.handshakeReadLimit())
 	if err != nil {
 		return nil, err
 	}
+	c.handshakeBytesRead += len(record)
+	c.bytesRead.Add(int64(len(record)))
+	c.recordsRead.Add(1)
 	if record[0] != 22 { // TLS Handshake
 		return nil, fmt.Errorf("%w: content type %d != 22 (%q)", ErrUnexpectedMessage, record[0], record[:5])
 	}
-	outConn = &Conn{
-		Conn:       conn,
-		retryCount: new(atomic.Int32),
-	}
-	for _, opt := range options {
-		opt(outConn)
-	}
-	if outConn.debugf == nil {
-		outConn.debugf = func(string, ...any) {}
-	}
+	outConn = c
 	if outConn.outer, outConn.inner, err = outConn.handleClientHello(record, false); err != nil {
 		return outConn, err
 	}
+	if outConn.minTLS13 && !outConn.outer.tls13 {
+		return nil, fmt.Errorf("%w: client doesn't offer tls 1.3", ErrProtocolVersion)
+	}
+	if err := outConn.checkServerName(); err != nil {
+		return nil, err
+	}
 	outConn.readPassthrough = outConn.inner == nil
 	outConn.writePassthrough = outConn.inner == nil
+	if outConn.readPassthrough {
+		outConn.setHandshakeDone()
+		outConn.writeDone.Store(true)
+	}
 
+	outConn.outerRaw = bytes.Clone(record)
 	if outConn.inner != nil {
 		outConn.readBuf, err = outConn.inner.Marshal()
+		outConn.innerRaw = outConn.readBuf
 	} else {
 		outConn.readBuf, err = outConn.outer.Marshal()
 	}
 	if err != nil {
 		return outConn, err
 	}
+	if err := outConn.callOnClientHello(); err != nil {
+		return nil, err
+	}
+	if err := outConn.refreshDeadlines(); err != nil {
+		return outConn, err
+	}
 	return outConn, nil
 }
 
@@ -96,19 +263,101 @@ func NewConn(ctx context.Context, conn net.Conn, options ...Option) (outConn *Co
 type Conn struct {
 	net.Conn // The underlying connection
 
-	outer *clientHello
-	inner *clientHello
+	outer    *clientHello
+	inner    *clientHello
+	outerRaw []byte
+	innerRaw []byte
+
+	hpkeCtx        *hpke.Recipient
+	echConfig      ConfigSpec
+	echCipherSuite CipherSuite
+
+	keys                 []Key
+	debugf               func(string, ...any)
+	logger               *slog.Logger
+	plaintextHTTPHandler http.Handler
+	minTLS13             bool
+	serverNamePolicy     func(string) error
+	alertFunc            func(error) (uint8, bool)
+	keysFunc             func(*ClientHelloInfo) ([]Key, error)
+	onClientHello        func(*Conn, *ClientHelloInfo, *ClientHelloInfo) error
+	metadata             atomic.Pointer[any]
+	splice               bool
+	keyUsage             *KeyUsage
+
+	maxHandshakeBytes     int
+	handshakeBytesRead    int
+	handshakeBytesWritten int
+	readBuf               []byte
+	readErr               error
+	writeBuf              []byte
+	retryCount            *atomic.Int32
+	readPassthrough       bool
+	writePassthrough      bool
+	// hdr holds the header of the record being read, and the message type
+	// of handshake records. The rest of the record, i.e. readRemaining
+	// bytes, is read directly into the caller's buffer.
+	hdr           [6]byte
+	readRemaining int
+
+	start            time.Time
+	handshakeTimeout time.Duration
+	idleTimeout      time.Duration
+	maxLifetime      time.Duration
+	handshakeDone    atomic.Bool
+	deadlineMu       sync.Mutex
+	readDeadline     time.Time
+	writeDeadline    time.Time
+
+	bytesRead         atomic.Int64
+	bytesWritten      atomic.Int64
+	recordsRead       atomic.Int64
+	recordsWritten    atomic.Int64
+	handshakeDuration atomic.Int64
+	writeDone         atomic.Bool
+}
+
+// ConnStats contains statistics about a [Conn].
+type ConnStats struct {
+	// BytesRead is the number of bytes read from the underlying connection.
+	BytesRead int64
+	// BytesWritten is the number of bytes written to the underlying
+	// connection.
+	BytesWritten int64
+	// RecordsRead is the number of TLS records read and inspected.
+	RecordsRead int64
+	// RecordsWritten is the number of TLS records written and inspected.
+	RecordsWritten int64
+	// HandshakeDuration is the time between the call to NewConn and the end
+	// of the inspection of the client's handshake messages. It is zero
+	// until then.
+	HandshakeDuration time.Duration
+	// ReadPassthrough indicates that the data from the client is no longer
+	// inspected.
+	ReadPassthrough bool
+	// WritePassthrough indicates that the data to the client is no longer
+	// inspected.
+	WritePassthrough bool
+}
 
-	hpkeCtx *hpke.Recipient
+// Stats returns the connection's current statistics. It is safe to call
+// concurrently with Read and Write, e.g. to log a summary when the connection
+// is closed.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		BytesRead:         c.bytesRead.Load(),
+		BytesWritten:      c.bytesWritten.Load(),
+		RecordsRead:       c.recordsRead.Load(),
+		RecordsWritten:    c.recordsWritten.Load(),
+		HandshakeDuration: time.Duration(c.handshakeDuration.Load()),
+		ReadPassthrough:   c.handshakeDone.Load(),
+		WritePassthrough:  c.writeDone.Load(),
+	}
+}
 
-	keys             []Key
-	debugf           func(string, ...any)
-	readBuf          []byte
-	readErr          error
-	writeBuf         []byte
-	retryCount       *atomic.Int32
-	readPassthrough  bool
-	writePassthrough bool
+func (c *Conn) setHandshakeDone() {
+	c.handshakeDuration.Store(int64(time.Since(c.start)))
+	c.handshakeDone.Store(true)
 }
 
 // ECHPresented indicates whether the client presented an Encrypted Client
@@ -123,6 +372,34 @@ func (c *Conn) ECHAccepted() bool {
 	return c != nil && c.inner != nil
 }
 
+// HelloRetryRequested indicates whether the server sent a HelloRetryRequest,
+// i.e. whether the client had to send its ClientHello again.
+func (c *Conn) HelloRetryRequested() bool {
+	return c != nil && c.retryCount.Load() > 0
+}
+
+// ECHConfig returns the ECH config that was used to decrypt the client's
+// Encrypted Client Hello, and the HPKE cipher suite that the client selected.
+// The returned bool is false when ECH wasn't accepted.
+func (c *Conn) ECHConfig() (ConfigSpec, CipherSuite, bool) {
+	if !c.ECHAccepted() {
+		return ConfigSpec{}, CipherSuite{}, false
+	}
+	return c.echConfig, c.echCipherSuite, true
+}
+
+// checkServerName applies the ServerName policy set with
+// [WithServerNamePolicy], if any.
+func (c *Conn) checkServerName() error {
+	if c.serverNamePolicy == nil {
+		return nil
+	}
+	if err := c.serverNamePolicy(c.ServerName()); err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	return nil
+}
+
 // ServerName returns the SNI value extracted from the ClientHello.
 func (c *Conn) ServerName() string {
 	if c != nil && c.inner != nil {
@@ -145,6 +422,52 @@ func (c *Conn) ALPNProtos() []string {
 	return nil
 }
 
+// SupportedGroups returns the key exchange groups that the client supports,
+// extracted from the ClientHello's supported_groups extension, in the client's
+// order of preference.
+func (c *Conn) SupportedGroups() []tls.CurveID {
+	if c != nil && c.inner != nil {
+		return slices.Clone(c.inner.SupportedGroups)
+	}
+	if c != nil && c.outer != nil {
+		return slices.Clone(c.outer.SupportedGroups)
+	}
+	return nil
+}
+
+// KeyShareGroups returns the key exchange groups for which the client sent a
+// key share in the ClientHello's key_share extension, e.g.
+// [tls.X25519MLKEM768] for post-quantum capable clients.
+func (c *Conn) KeyShareGroups() []tls.CurveID {
+	if c != nil && c.inner != nil {
+		return slices.Clone(c.inner.KeyShareGroups)
+	}
+	if c != nil && c.outer != nil {
+		return slices.Clone(c.outer.KeyShareGroups)
+	}
+	return nil
+}
+
+// OuterClientHello returns the TLS record that contained the first ClientHello,
+// exactly as it was received from the client. When ECH is used, this is the
+// ClientHelloOuter.
+func (c *Conn) OuterClientHello() []byte {
+	if c == nil {
+		return nil
+	}
+	return slices.Clone(c.outerRaw)
+}
+
+// InnerClientHello returns the first ClientHelloInner, after decryption and
+// decoding, serialized as a TLS record. This is what is forwarded to the
+// backend server. It returns nil when ECH was not accepted.
+func (c *Conn) InnerClientHello() []byte {
+	if c == nil {
+		return nil
+	}
+	return slices.Clone(c.innerRaw)
+}
+
 func (c *Conn) handleClientHello(record []byte, isRetry bool) (outer, inner *clientHello, err error) {
 	if outer, err = parseClientHello(record[5:]); err != nil {
 		return nil, nil, err
@@ -156,13 +479,22 @@ func (c *Conn) handleClientHello(record []byte, isRetry bool) (outer, inner *cli
 	if outer.hasECHOuterExtensions {
 		return nil, nil, fmt.Errorf("%w: ClientHelloOuter has ech_outer_extensions", ErrIllegalParameter)
 	}
+	if !isRetry && c.keysFunc != nil {
+		if c.keys, err = c.keysFunc(newClientHelloInfo(outer, record)); err != nil {
+			return nil, nil, err
+		}
+	}
 	// Section 7
 	// In split mode, a client-facing server which receives a ClientHello with
 	// ECHClientHello.type of inner MUST abort with an "illegal_parameter" alert.
 	if len(c.keys) > 0 && outer.echExt != nil && outer.echExt.Type == 1 {
 		return nil, nil, fmt.Errorf("%w: ClientHelloOuter has ech type inner", ErrIllegalParameter)
 	}
-	if inner, err = c.processEncryptedClientHello(outer, isRetry); err != nil && err != errNoMatch {
+	inner, err = c.processEncryptedClientHello(outer, isRetry)
+	if !isRetry && c.keyUsage != nil && len(c.keys) > 0 && outer.tls13 && outer.echExt != nil {
+		c.keyUsage.record(outer.echExt.ConfigID, inner != nil)
+	}
+	if err != nil && err != errNoMatch {
 		return nil, nil, err
 	}
 	if isRetry {
@@ -175,6 +507,11 @@ func (c *Conn) handleClientHello(record []byte, isRetry bool) (outer, inner *cli
 
 // processEncryptedClientHello implements the Client-Facing Server logic
 // specified in Section 7.1.
+//
+// The client's cipher suite must be advertised by the config. crypto/hpke
+// supports all the KDFs and AEADs of RFC 9180, i.e. HKDF-SHA256, HKDF-SHA384,
+// and HKDF-SHA512 with AES-128-GCM, AES-256-GCM, and ChaCha20Poly1305, so any
+// of their combinations can be decrypted, including in imported configs.
 func (c *Conn) processEncryptedClientHello(h *clientHello, isRetry bool) (*clientHello, error) {
 	if isRetry { // Section 7.1.1
 		if h.echExt == nil {
@@ -197,11 +534,11 @@ func (c *Conn) processEncryptedClientHello(h *clientHello, isRetry bool) (*clien
 		}
 		needCtx := c.hpkeCtx == nil && len(h.echExt.Enc) > 0
 		if needCtx {
-			pk, err := ecdh.X25519().NewPrivateKey(key.PrivateKey)
+			kem, err := hpke.NewKEM(cfg.KEM)
 			if err != nil {
 				continue
 			}
-			privKey, err := hpke.NewDHKEMPrivateKey(pk)
+			privKey, err := kem.NewPrivateKey(key.PrivateKey)
 			if err != nil {
 				continue
 			}
@@ -237,6 +574,9 @@ func (c *Conn) processEncryptedClientHello(h *clientHello, isRetry bool) (*clien
 		if string(cfg.PublicName) != h.ServerName {
 			return nil, ErrIllegalParameter
 		}
+		c.echConfig = cfg
+		c.echCipherSuite = h.echExt.CipherSuite
+		break
 	}
 	if innerBytes == nil {
 		// Section 7.1.1, regarding a retried ClientHello:
@@ -316,51 +656,148 @@ func (c *Conn) processEncryptedClientHello(h *clientHello, isRetry bool) (*clien
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
-	if !c.readPassthrough && len(c.readBuf) == 0 && c.readErr == nil {
-		r, err := readRecord(c.Conn)
-		if len(r) >= 5 {
-			if r[0] == 22 {
-				c.debugf("Read %s(%d) %s\n", contentType(r[0]), r[0], handshakeMessageTypes[r[5]])
-			} else {
-				c.debugf("Read %s(%d)\n", contentType(r[0]), r[0])
-			}
-		}
-		switch {
-		case err != nil:
-			c.debugf("Read error %v\n", err)
-			c.readErr = err
-		case r[0] == 23:
-			c.readPassthrough = true
-		case r[0] == 22 && r[5] == 1 && c.retryCount.Load() == 1:
-			c.debugf("Handshake Retried ClientHello\n")
-			c.readPassthrough = true
-			_, inner, err := c.handleClientHello(r, true)
-			if err != nil {
-				c.readErr = err
-				convertErrorsToAlerts(c, err)
-				return 0, err
-			}
-			r, c.readErr = inner.Marshal()
+	if err := c.refreshDeadlines(); err != nil {
+		return 0, err
+	}
+	if !c.readPassthrough && len(c.readBuf) == 0 && c.readRemaining == 0 && c.readErr == nil {
+		if err := c.readRecordHeader(); err != nil {
+			return 0, err
 		}
-		c.readBuf = r
 	}
 	if len(c.readBuf) > 0 {
 		n := copy(b, c.readBuf)
 		c.readBuf = c.readBuf[n:]
-		if len(c.readBuf) == 0 {
+		if len(c.readBuf) == 0 && c.readRemaining == 0 {
 			return n, c.readErr
 		}
 		return n, nil
 	}
+	if c.readRemaining > 0 {
+		n, err := c.Conn.Read(b[:min(len(b), c.readRemaining)])
+		c.readRemaining -= n
+		c.bytesRead.Add(int64(n))
+		return n, err
+	}
 	if c.readErr != nil {
 		return 0, c.readErr
 	}
-	return c.Conn.Read(b)
+	n, err := c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	return n, err
+}
+
+// readRecordHeader reads the header of the next record, and the message type
+// of handshake records. Only the retried ClientHello is read in full, because
+// it is decrypted and rewritten. The payloads of the other records are relayed
+// to the caller as they are read, without buffering.
+func (c *Conn) readRecordHeader() error {
+	hdr, err := c.readHeader()
+	c.readBuf = hdr
+	if errors.Is(err, ErrHandshakeTooLarge) {
+		c.readBuf = nil
+		c.readErr = abort(c.Conn, c.alertFunc, err)
+		return c.readErr
+	}
+	if err != nil {
+		c.debugf("Read error %v\n", err)
+		c.readErr = err
+		return nil
+	}
+	length := int(hdr[3])<<8 | int(hdr[4])
+	c.readRemaining = length - (len(hdr) - 5)
+	c.handshakeBytesRead += 5 + length
+	c.recordsRead.Add(1)
+	if hdr[0] == 22 && len(hdr) > 5 {
+		c.debugf("Read %s(%d) %s\n", contentType(hdr[0]), hdr[0], handshakeMessageTypes[hdr[5]])
+	} else {
+		c.debugf("Read %s(%d)\n", contentType(hdr[0]), hdr[0])
+	}
+	switch {
+	case hdr[0] == 23:
+		// The payload is read in passthrough mode.
+		c.readRemaining = 0
+		c.readPassthrough = true
+		c.setHandshakeDone()
+	case hdr[0] == 22 && len(hdr) > 5 && hdr[5] == 1 && c.retryCount.Load() == 1:
+		c.debugf("Handshake Retried ClientHello\n")
+		c.readPassthrough = true
+		c.setHandshakeDone()
+		record := make([]byte, 5+length)
+		copy(record, hdr)
+		n, err := io.ReadFull(c.Conn, record[len(hdr):])
+		c.bytesRead.Add(int64(n))
+		c.readRemaining = 0
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			c.debugf("Read error %v\n", err)
+			c.readBuf, c.readErr = record[:len(hdr)+n], err
+			return nil
+		}
+		_, inner, err := c.handleClientHello(record, true)
+		if err == nil {
+			err = c.checkServerName()
+		}
+		if err != nil {
+			c.readBuf = nil
+			c.readErr = abort(c.Conn, c.alertFunc, err)
+			return c.readErr
+		}
+		c.readBuf, c.readErr = inner.Marshal()
+	case hdr[0] == 22 && len(hdr) > 5 && hdr[5] == 1:
+		c.readBuf = nil
+		c.readErr = abort(c.Conn, c.alertFunc, fmt.Errorf("%w: ClientHello without HelloRetryRequest", ErrIllegalParameter))
+		return c.readErr
+	}
+	return nil
+}
+
+// readHeader reads the 5-byte header of the next record into c.hdr, followed by
+// the message type if it is a non-empty handshake record. The record length is
+// checked against the handshake limits. The header is returned, even when
+// there is an error.
+func (c *Conn) readHeader() ([]byte, error) {
+	n, err := io.ReadFull(c.Conn, c.hdr[:5])
+	c.bytesRead.Add(int64(n))
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		c.handshakeBytesRead += n
+		return c.hdr[:n], err
+	}
+	length := int(c.hdr[3])<<8 | int(c.hdr[4])
+	if length > 16384 {
+		return c.hdr[:5], fmt.Errorf("%w: record length %d > 16384", ErrDecodeError, length)
+	}
+	// The ChangeCipherSpec and application data records aren't inspected,
+	// and the first application data record ends the inspection.
+	if limit := min(c.handshakeReadLimit(), 16384); length > limit && c.hdr[0] != 20 && c.hdr[0] != 23 {
+		return c.hdr[:5], fmt.Errorf("%w: record length %d > %d", ErrHandshakeTooLarge, length, max(limit, 0))
+	}
+	if c.hdr[0] != 22 || length == 0 {
+		return c.hdr[:5], nil
+	}
+	n, err = io.ReadFull(c.Conn, c.hdr[5:])
+	c.bytesRead.Add(int64(n))
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		return c.hdr[:5], err
+	}
+	return c.hdr[:], nil
 }
 
 func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.refreshDeadlines(); err != nil {
+		return 0, err
+	}
 	if c.writePassthrough && len(c.writeBuf) == 0 {
-		return c.Conn.Write(b)
+		n, err := c.Conn.Write(b)
+		c.bytesWritten.Add(int64(n))
+		return n, err
 	}
 	c.writeBuf = append(c.writeBuf, b...)
 	for len(c.writeBuf) >= 5 {
@@ -369,13 +806,22 @@ func (c *Conn) Write(b []byte) (int, error) {
 			return 0, fmt.Errorf("%w: record length %d > 16384", ErrDecodeError, length)
 		}
 		sz := int(length) + 5
+		// The ChangeCipherSpec and application data records don't
+		// count, e.g. the server's encrypted flight.
+		recType := c.writeBuf[0]
+		if c.maxHandshakeBytes > 0 && !c.writePassthrough && recType != 20 && recType != 23 && c.handshakeBytesWritten+sz > c.maxHandshakeBytes {
+			return 0, abort(c.Conn, c.alertFunc, fmt.Errorf("%w: %d > %d", ErrHandshakeTooLarge, c.handshakeBytesWritten+sz, c.maxHandshakeBytes))
+		}
 		if sz > len(c.writeBuf) {
 			break
 		}
+		c.handshakeBytesWritten += sz
+		c.recordsWritten.Add(1)
 		if err := c.inspectWrite(c.writeBuf[:sz]); err != nil {
 			return 0, err
 		}
 		n, err := c.Conn.Write(c.writeBuf[:sz])
+		c.bytesWritten.Add(int64(n))
 		c.writeBuf = c.writeBuf[n:]
 		if err != nil {
 			return min(len(b), n), err
@@ -387,6 +833,91 @@ func (c *Conn) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// handshakeReadLimit returns the maximum length of the next handshake record
+// that can be read.
+func (c *Conn) handshakeReadLimit() int {
+	if c.maxHandshakeBytes <= 0 {
+		return 16384
+	}
+	return c.maxHandshakeBytes - c.handshakeBytesRead - 5
+}
+
+// SetDeadline implements [net.Conn]. The deadline is combined with the
+// timeouts set with [WithHandshakeTimeout], [WithIdleTimeout], and
+// [WithMaxLifetime]. The earliest one applies.
+func (c *Conn) SetDeadline(t time.Time) error {
+	if !c.hasTimeouts() {
+		return c.Conn.SetDeadline(t)
+	}
+	c.deadlineMu.Lock()
+	c.readDeadline, c.writeDeadline = t, t
+	c.deadlineMu.Unlock()
+	return c.refreshDeadlines()
+}
+
+// SetReadDeadline implements [net.Conn]. The deadline is combined with the
+// timeouts set with [WithHandshakeTimeout], [WithIdleTimeout], and
+// [WithMaxLifetime]. The earliest one applies.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if !c.hasTimeouts() {
+		return c.Conn.SetReadDeadline(t)
+	}
+	c.deadlineMu.Lock()
+	c.readDeadline = t
+	c.deadlineMu.Unlock()
+	return c.refreshDeadlines()
+}
+
+// SetWriteDeadline implements [net.Conn]. The deadline is combined with the
+// timeouts set with [WithHandshakeTimeout], [WithIdleTimeout], and
+// [WithMaxLifetime]. The earliest one applies.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if !c.hasTimeouts() {
+		return c.Conn.SetWriteDeadline(t)
+	}
+	c.deadlineMu.Lock()
+	c.writeDeadline = t
+	c.deadlineMu.Unlock()
+	return c.refreshDeadlines()
+}
+
+// refreshDeadlines sets the read and write deadlines of the underlying
+// connection to the earliest of the deadlines set by the caller and the ones
+// derived from the timeout options.
+func (c *Conn) refreshDeadlines() error {
+	if !c.hasTimeouts() {
+		return nil
+	}
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	var d time.Time
+	if c.idleTimeout > 0 {
+		d = time.Now().Add(c.idleTimeout)
+	}
+	if c.maxLifetime > 0 {
+		d = earliest(d, c.start.Add(c.maxLifetime))
+	}
+	if c.handshakeTimeout > 0 && !c.handshakeDone.Load() {
+		d = earliest(d, c.start.Add(c.handshakeTimeout))
+	}
+	if err := c.Conn.SetReadDeadline(earliest(d, c.readDeadline)); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(earliest(d, c.writeDeadline))
+}
+
+func (c *Conn) hasTimeouts() bool {
+	return c.handshakeTimeout > 0 || c.idleTimeout > 0 || c.maxLifetime > 0
+}
+
+// earliest returns the earliest of a and b. A zero value means no deadline.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
 func (c *Conn) inspectWrite(record []byte) error {
 	recType := c.writeBuf[0]
 	msgType := c.writeBuf[5]
@@ -398,6 +929,7 @@ func (c *Conn) inspectWrite(record []byte) error {
 	switch {
 	case recType == 23:
 		c.writePassthrough = true
+		c.writeDone.Store(true)
 	case recType == 22 && msgType == 2: // Handshake / ServerHello
 		h, err := parseServerHello(c.writeBuf[5:])
 		if err != nil {
@@ -405,9 +937,64 @@ func (c *Conn) inspectWrite(record []byte) error {
 		}
 		if h.IsHelloRetryRequest() {
 			c.debugf("HelloRetryRequest: %s\n", h)
-			c.writePassthrough = true
-			c.retryCount.Add(1)
+			// The server can send at most one HelloRetryRequest per
+			// connection. Keep inspecting the server's messages until
+			// the handshake is done to enforce that.
+			if c.retryCount.Add(1) > 1 {
+				return abort(c.Conn, c.alertFunc, fmt.Errorf("%w: more than one HelloRetryRequest", ErrIllegalParameter))
+			}
 		}
 	}
 	return nil
 }
+
+// ProxyTo connects to the backend server at addr and forwards the TLS
+// connection to it, including the ClientHello that was already processed.
+// Data is copied in both directions until either side closes its connection.
+// Both connections are closed when ProxyTo returns.
+//
+// The ctx is used while connecting to the backend server only.
+func (c *Conn) ProxyTo(ctx context.Context, addr string) error {
+	var d net.Dialer
+	backend, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	return relay(c, backend)
+}
+
+// relay copies data between a and b in both directions until both sides are
+// done. Then, it closes both connections.
+func relay(a, b net.Conn) error {
+	ch := make(chan error, 2)
+	cp := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		closeWrite(dst)
+		ch <- err
+	}
+	go cp(a, b)
+	go cp(b, a)
+	err1, err2 := <-ch, <-ch
+	a.Close()
+	b.Close()
+	for _, err := range []error{err1, err2} {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeWrite shuts down the writing side of conn, if supported. Otherwise, the
+// connection is closed.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(*Conn); ok {
+		conn = c.Conn
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
@@ -2,12 +2,18 @@ package ech
 
 import (
 	"bytes"
+	"context"
 	"crypto/hpke"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/c2FmZQ/ech/testutil"
 )
@@ -79,6 +85,12 @@ func TestConn(t *testing.T) {
 	t.Logf("Outer ALPNProtos: %s", outConn.outer.ALPNProtos)
 	t.Logf("Inner ServerName: %s", outConn.inner.ServerName)
 	t.Logf("Inner ALPNProtos: %s", outConn.inner.ALPNProtos)
+	if got, want := outConn.KeyShareGroups(), tls.X25519MLKEM768; !slices.Contains(got, want) {
+		t.Errorf("KeyShareGroups() = %v, want %v", got, want)
+	}
+	if got, want := outConn.SupportedGroups(), tls.X25519; !slices.Contains(got, want) {
+		t.Errorf("SupportedGroups() = %v, want %v", got, want)
+	}
 
 	server := tls.Server(outConn, &tls.Config{
 		Certificates: []tls.Certificate{tlsCert},
@@ -98,6 +110,20 @@ func TestConn(t *testing.T) {
 	if got, want := <-ch, "hi!\n"; got != want {
 		t.Fatalf("Client read %q, want %q", got, want)
 	}
+	stats := outConn.Stats()
+	t.Logf("Stats: %+v", stats)
+	if !stats.ReadPassthrough || !stats.WritePassthrough {
+		t.Errorf("Stats() passthrough = %v, %v, want true, true", stats.ReadPassthrough, stats.WritePassthrough)
+	}
+	if stats.BytesRead < int64(len(outConn.OuterClientHello())) || stats.BytesWritten == 0 {
+		t.Errorf("Stats() bytes = %d, %d", stats.BytesRead, stats.BytesWritten)
+	}
+	if stats.RecordsRead < 2 || stats.RecordsWritten < 2 {
+		t.Errorf("Stats() records = %d, %d", stats.RecordsRead, stats.RecordsWritten)
+	}
+	if stats.HandshakeDuration <= 0 {
+		t.Errorf("Stats() HandshakeDuration = %v", stats.HandshakeDuration)
+	}
 }
 
 // TestConn is an end-to-end test with a go client and a go server where the
@@ -281,6 +307,12 @@ func TestNoInner(t *testing.T) {
 	if got, want := conn.ECHAccepted(), false; got != want {
 		t.Errorf("ECHAccepted = %v, want %v", got, want)
 	}
+	if got, want := conn.OuterClientHello(), outer.bytes(); !bytes.Equal(got, want) {
+		t.Errorf("OuterClientHello() = %v, want %v", got, want)
+	}
+	if got := conn.InnerClientHello(); got != nil {
+		t.Errorf("InnerClientHello() = %v, want nil", got)
+	}
 }
 
 // TestNoInner verifies that a ECH extensions is ignored when ClientHello
@@ -315,6 +347,111 @@ func TestTLS12(t *testing.T) {
 	if got, want := conn.ECHAccepted(), false; got != want {
 		t.Errorf("ECHAccepted = %v, want %v", got, want)
 	}
+	if _, _, ok := conn.ECHConfig(); ok {
+		t.Error("ECHConfig() ok, want !ok")
+	}
+}
+
+// TestMinTLS13 verifies that a TLS 1.2 ClientHello is rejected with
+// WithMinTLS13.
+func TestMinTLS13(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	outer := newClientHello("public", config, pubKey, inner)
+	c := newFakeConn(outer.bytes())
+
+	if _, err := NewConn(t.Context(), c, WithKeys(keys), WithMinTLS13()); !errors.Is(err, ErrProtocolVersion) {
+		t.Fatalf("NewConn() = %v, want ErrProtocolVersion", err)
+	}
+	if got, want := c.Writer.(*bytes.Buffer).Bytes(), []byte{21, 3, 3, 0, 2, 2, 70}; !bytes.Equal(got, want) {
+		t.Errorf("Written = %v, want %v", got, want)
+	}
+
+	outer = newClientHello("public", "tls1.3", config, pubKey, inner)
+	c = newFakeConn(outer.bytes())
+	conn, err := NewConn(t.Context(), c, WithKeys(keys), WithMinTLS13())
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if got, want := conn.ECHAccepted(), true; got != want {
+		t.Errorf("ECHAccepted = %v, want %v", got, want)
+	}
+}
+
+// TestServerNamePolicy verifies that the ServerName policy is applied to the
+// ClientHelloInner, or the ClientHelloOuter when ECH isn't accepted.
+func TestClientHelloParseError(t *testing.T) {
+	// A ClientHello truncated in the middle of legacy_session_id.
+	body := append(append([]byte{3, 3}, make([]byte, 32)...), 32, 0, 0)
+	msg := append([]byte{1, 0, 0, byte(len(body))}, body...)
+	record := append([]byte{22, 3, 1, 0, byte(len(msg))}, msg...)
+
+	_, err := NewConn(t.Context(), newFakeConn(record))
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("NewConn() = %v, want ParseError", err)
+	}
+	if want := (ParseError{Message: "ClientHello", Index: -1, Field: "legacy_session_id", Offset: 39}); *pe != want {
+		t.Errorf("ParseError = %+v, want %+v", *pe, want)
+	}
+	if !errors.Is(err, ErrDecodeError) {
+		t.Errorf("NewConn() = %v, want ErrDecodeError", err)
+	}
+}
+
+func TestServerNamePolicy(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+	errDenied := errors.New("denied")
+	policy := func(name string) error {
+		switch name {
+		case "private.example.com":
+			return nil
+		case "public.example.com":
+			return errDenied
+		default:
+			return ErrIllegalParameter
+		}
+	}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	for _, tc := range []struct {
+		name      string
+		hello     *testClientHello
+		wantErr   error
+		wantAlert byte
+	}{
+		{"Accepted", newClientHello("public", "tls1.3", config, pubKey, inner), nil, 0},
+		{"Outer", newClientHello("public", "tls1.3"), errDenied, 49},
+		{"Other", newClientHello("tls1.3"), ErrIllegalParameter, 47},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeConn(tc.hello.bytes())
+			_, err := NewConn(t.Context(), c, WithKeys(keys), WithServerNamePolicy(policy))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("NewConn() = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil {
+				return
+			}
+			if !errors.Is(err, ErrAccessDenied) {
+				t.Errorf("NewConn() = %v, want ErrAccessDenied", err)
+			}
+			if got, want := c.Writer.(*bytes.Buffer).Bytes(), []byte{21, 3, 3, 0, 2, 2, tc.wantAlert}; !bytes.Equal(got, want) {
+				t.Errorf("Written = %v, want %v", got, want)
+			}
+		})
+	}
 }
 
 // TestValidInner verifies that a valid ECH extension is correctly handled.
@@ -345,6 +482,12 @@ func TestValidInner(t *testing.T) {
 	if got, want := conn.ECHAccepted(), true; got != want {
 		t.Errorf("ECHAccepted = %v, want %v", got, want)
 	}
+	if got, want := conn.OuterClientHello(), outer.bytes(); !bytes.Equal(got, want) {
+		t.Errorf("OuterClientHello() = %v, want %v", got, want)
+	}
+	if got, want := conn.InnerClientHello(), inner.bytes(); !bytes.Equal(got, want) {
+		t.Errorf("InnerClientHello() = %v, want %v", got, want)
+	}
 }
 
 // TestCheckPublicName verifies that if the SNI in ClientHelloOuter doesn't
@@ -383,6 +526,71 @@ func TestOuterHasECHOuterExt(t *testing.T) {
 	}
 }
 
+// TestAlertError verifies that the alerts sent by NewConn are reported with
+// AlertError, and that the mapping can be customized.
+func TestAlertError(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+	outer := newClientHello("public", "tls1.3", "ech_outer_extensions")
+
+	for _, tc := range []struct {
+		name      string
+		alertFunc func(error) (uint8, bool)
+		wantAlert uint8
+		wantSent  bool
+	}{
+		{"Default", nil, 47, true},
+		{"Custom", func(error) (uint8, bool) { return 40, true }, 40, true},
+		{"NoAlert", func(error) (uint8, bool) { return 0, false }, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeConn(outer.bytes())
+			_, err := NewConn(t.Context(), c, WithKeys(keys), WithAlertFunc(tc.alertFunc))
+			if !errors.Is(err, ErrIllegalParameter) {
+				t.Fatalf("NewConn() = %v, want ErrIllegalParameter", err)
+			}
+			var alertErr *AlertError
+			if got, want := errors.As(err, &alertErr), tc.wantSent; got != want {
+				t.Fatalf("NewConn() = %v, AlertError %v, want %v", err, got, want)
+			}
+			var want []byte
+			if tc.wantSent {
+				if got, want := alertErr.Alert, tc.wantAlert; got != want {
+					t.Errorf("Alert = %d, want %d", got, want)
+				}
+				want = []byte{21, 3, 3, 0, 2, 2, tc.wantAlert}
+			}
+			if got := c.Writer.(*bytes.Buffer).Bytes(); !bytes.Equal(got, want) {
+				t.Errorf("Written = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// TestAlertErrorCanceled verifies that no alert is sent when the context is
+// canceled before the ClientHello is received.
+func TestAlertErrorCanceled(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := NewConn(ctx, server)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("NewConn() = %v, want os.ErrDeadlineExceeded", err)
+	}
+	var alertErr *AlertError
+	if errors.As(err, &alertErr) {
+		t.Errorf("NewConn() = %v, want no AlertError", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Read() = %v, want io.EOF", err)
+	}
+}
+
 // TestValidRetry verifies that a ClientHello with an ECH extension is properly
 // decrypted/decoded after a HelloRetryRequest.
 func TestValidRetry(t *testing.T) {
@@ -414,9 +622,15 @@ func TestValidRetry(t *testing.T) {
 	if got, want := conn.ECHAccepted(), true; got != want {
 		t.Errorf("ECHAccepted = %v, want %v", got, want)
 	}
+	if conn.HelloRetryRequested() {
+		t.Error("HelloRetryRequested() = true before HelloRetryRequest")
+	}
 	if _, err := conn.Write(helloRetryReq()); err != nil {
 		t.Fatalf("Write(helloRetryReq): %v", err)
 	}
+	if !conn.HelloRetryRequested() {
+		t.Error("HelloRetryRequested() = false after HelloRetryRequest")
+	}
 	if buf, err := readRecord(conn); err != nil {
 		t.Fatalf("Second ClientHello: %v", err)
 	} else if got, want := buf, inner2.bytes(); !bytes.Equal(got, want) {
@@ -424,6 +638,71 @@ func TestValidRetry(t *testing.T) {
 	}
 }
 
+// TestTooManyHelloRetryRequests verifies that a second HelloRetryRequest is
+// rejected.
+func TestTooManyHelloRetryRequests(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner1 := newClientHello("private", "echExtInner", "tls1.3")
+	outer1 := newClientHello("public", "tls1.3", config, pubKey, inner1)
+	inner2 := newClientHello("private", "echExtInner", "tls1.3")
+	outer2 := newClientHello("public", "tls1.3", outer1.hpkeCtx, config, pubKey, inner2)
+	c := newFakeConn(append(outer1.bytes(), outer2.bytes()...))
+
+	conn, err := NewConn(t.Context(), c, WithKeys(keys))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("First ClientHello: %v", err)
+	}
+	if _, err := conn.Write(helloRetryReq()); err != nil {
+		t.Fatalf("Write(helloRetryReq): %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("Second ClientHello: %v", err)
+	}
+	if _, err := conn.Write(helloRetryReq()); !errors.Is(err, ErrIllegalParameter) {
+		t.Fatalf("Write(helloRetryReq) = %v, want ErrIllegalParameter", err)
+	}
+	if got, want := c.Writer.(*bytes.Buffer).Bytes(), []byte{21, 3, 3, 0, 2, 2, 47}; !bytes.HasSuffix(got, want) {
+		t.Errorf("Written = %v, want suffix %v", got, want)
+	}
+}
+
+// TestUnexpectedClientHello verifies that a second ClientHello without a
+// HelloRetryRequest is rejected.
+func TestUnexpectedClientHello(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner1 := newClientHello("private", "echExtInner", "tls1.3")
+	outer1 := newClientHello("public", "tls1.3", config, pubKey, inner1)
+	inner2 := newClientHello("private", "echExtInner", "tls1.3")
+	outer2 := newClientHello("public", "tls1.3", outer1.hpkeCtx, config, pubKey, inner2)
+	c := newFakeConn(append(outer1.bytes(), outer2.bytes()...))
+
+	conn, err := NewConn(t.Context(), c, WithKeys(keys))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("First ClientHello: %v", err)
+	}
+	if _, err := readRecord(conn); !errors.Is(err, ErrIllegalParameter) {
+		t.Fatalf("Second ClientHello = %v, want ErrIllegalParameter", err)
+	}
+}
+
 // TestRetryChangesServerName verifies that changing he SNI in a retry
 // ClientHelloInner is rejected.
 func TestRetryChangesServerName(t *testing.T) {
@@ -681,6 +960,79 @@ func TestInnerTLS12(t *testing.T) {
 	}
 }
 
+// TestConnCipherSuites is an end-to-end test with a go client and a go server
+// for each of the supported HPKE cipher suites.
+func TestConnCipherSuites(t *testing.T) {
+	tlsCert, err := testutil.NewCert("private.example.com", "public.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	for _, kdf := range []uint16{KDFHKDFSHA256, KDFHKDFSHA384, KDFHKDFSHA512} {
+		for _, aead := range []uint16{AEADAES128GCM, AEADAES256GCM, AEADChaCha20Poly1305} {
+			suite := CipherSuite{KDF: kdf, AEAD: aead}
+			t.Run(fmt.Sprintf("%d-%d", kdf, aead), func(t *testing.T) {
+				privKey, config, err := NewConfigWithOptions(1, []byte("public.example.com"), WithCipherSuites(suite))
+				if err != nil {
+					t.Fatalf("NewConfigWithOptions: %v", err)
+				}
+				configList, err := ConfigList([]Config{config})
+				if err != nil {
+					t.Fatalf("ConfigList: %v", err)
+				}
+				serverConn, clientConn := net.Pipe()
+				defer serverConn.Close()
+				defer clientConn.Close()
+
+				ch := make(chan error)
+				go func() {
+					client := tls.Client(clientConn, &tls.Config{
+						ServerName:                     "private.example.com",
+						RootCAs:                        rootCAs,
+						EncryptedClientHelloConfigList: configList,
+					})
+					if _, err := client.Write([]byte("hello\n")); err != nil {
+						ch <- err
+						return
+					}
+					if !client.ConnectionState().ECHAccepted {
+						ch <- errors.New("client ECHAccepted = false")
+						return
+					}
+					ch <- nil
+				}()
+
+				conn, err := NewConn(t.Context(), serverConn, WithKeys([]Key{{Config: config, PrivateKey: privKey}}))
+				if err != nil {
+					t.Fatalf("NewConn: %v", err)
+				}
+				if _, got, ok := conn.ECHConfig(); !ok || got != suite {
+					t.Errorf("ECHConfig() = %v, %v, want %v", got, ok, suite)
+				}
+				if got, want := conn.ServerName(), "private.example.com"; got != want {
+					t.Errorf("ServerName() = %q, want %q", got, want)
+				}
+				server := tls.Server(conn, &tls.Config{
+					Certificates: []tls.Certificate{tlsCert},
+				})
+				b := make([]byte, 1024)
+				n, err := server.Read(b)
+				if err != nil {
+					t.Fatalf("server.Read: %v", err)
+				}
+				if got, want := string(b[:n]), "hello\n"; got != want {
+					t.Errorf("server.Read() = %q, want %q", got, want)
+				}
+				if err := <-ch; err != nil {
+					t.Errorf("client: %v", err)
+				}
+			})
+		}
+	}
+}
+
 // TestValidInnerAES verifies that a valid ECH extension using AES is correctly
 // handled.
 func TestValidInnerAES(t *testing.T) {
@@ -713,6 +1065,225 @@ func TestValidInnerAES(t *testing.T) {
 			} else if got, want := buf, inner.bytes(); !bytes.Equal(got, want) {
 				t.Fatalf("ClientHello = %v, want %v", got, want)
 			}
+			spec, suite, ok := conn.ECHConfig()
+			if !ok {
+				t.Fatal("ECHConfig() not ok")
+			}
+			if got, want := spec.ID, uint8(1); got != want {
+				t.Errorf("ECHConfig() ID = %d, want %d", got, want)
+			}
+			if got, want := string(spec.PublicName), "public.example.com"; got != want {
+				t.Errorf("ECHConfig() PublicName = %q, want %q", got, want)
+			}
+			if got, want := suite, (CipherSuite{KDF: 1, AEAD: m[aead]}); got != want {
+				t.Errorf("ECHConfig() CipherSuite = %v, want %v", got, want)
+			}
 		})
 	}
 }
+
+// TestHandshakeTimeout verifies that the handshake timeout applies to the
+// retried ClientHello after a HelloRetryRequest.
+func TestHandshakeTimeout(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	outer := newClientHello("public", "tls1.3", config, pubKey, inner)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		client.Write(outer.bytes())
+		io.Copy(io.Discard, client)
+	}()
+
+	conn, err := NewConn(t.Context(), server, WithKeys(keys), WithHandshakeTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	defer conn.Close()
+	if buf, err := readRecord(conn); err != nil {
+		t.Fatalf("First ClientHello: %v", err)
+	} else if got, want := buf, inner.bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("First ClientHello = %v, want %v", got, want)
+	}
+	if _, err := conn.Write(helloRetryReq()); err != nil {
+		t.Fatalf("Write(helloRetryReq): %v", err)
+	}
+	if _, err := readRecord(conn); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Second ClientHello: %v, want ErrDeadlineExceeded", err)
+	}
+}
+
+// TestIdleTimeout verifies that the idle timeout and max lifetime are enforced
+// after the handshake.
+func TestIdleTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opt  Option
+	}{
+		{"IdleTimeout", WithIdleTimeout(50 * time.Millisecond)},
+		{"MaxLifetime", WithMaxLifetime(50 * time.Millisecond)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outer := newClientHello("private", "tls1.3")
+			client, server := net.Pipe()
+			defer client.Close()
+			go client.Write(outer.bytes())
+
+			conn, err := NewConn(t.Context(), server, tc.opt)
+			if err != nil {
+				t.Fatalf("NewConn: %v", err)
+			}
+			defer conn.Close()
+			if _, err := readRecord(conn); err != nil {
+				t.Fatalf("ClientHello: %v", err)
+			}
+			start := time.Now()
+			if _, err := conn.Read(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("Read: %v, want ErrDeadlineExceeded", err)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("Read returned after %s", d)
+			}
+		})
+	}
+}
+
+// TestMaxHandshakeBytes verifies that the handshake size limit is enforced.
+func TestMaxHandshakeBytes(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pubKey := privKey.PublicKey()
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	outer := newClientHello("public", "tls1.3", config, pubKey, inner)
+	size := len(outer.bytes())
+
+	c := newFakeConn(outer.bytes())
+	if _, err := NewConn(t.Context(), c, WithKeys(keys), WithMaxHandshakeBytes(size-1)); !errors.Is(err, ErrHandshakeTooLarge) {
+		t.Fatalf("NewConn: %v, want ErrHandshakeTooLarge", err)
+	}
+
+	c = newFakeConn(append(outer.bytes(), outer.bytes()...))
+	conn, err := NewConn(t.Context(), c, WithKeys(keys), WithMaxHandshakeBytes(size))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("First ClientHello: %v", err)
+	}
+	if _, err := conn.Write(helloRetryReq()); err != nil {
+		t.Fatalf("Write(helloRetryReq): %v", err)
+	}
+	if _, err := readRecord(conn); !errors.Is(err, ErrHandshakeTooLarge) {
+		t.Fatalf("Second ClientHello: %v, want ErrHandshakeTooLarge", err)
+	}
+
+	// The encrypted records that follow the ClientHello don't count.
+	appData := append([]byte{23, 3, 3, 0x0f, 0xa0}, make([]byte, 4000)...)
+	c = newFakeConn(append(outer.bytes(), appData...))
+	if conn, err = NewConn(t.Context(), c, WithKeys(keys), WithMaxHandshakeBytes(size)); err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("ClientHello: %v", err)
+	}
+	if got, err := readRecord(conn); err != nil || !bytes.Equal(got, appData) {
+		t.Fatalf("Application data: %d bytes, %v", len(got), err)
+	}
+	if _, err := conn.Write(appData); err != nil {
+		t.Fatalf("Write(appData): %v", err)
+	}
+	if got := c.Writer.(*bytes.Buffer).Bytes(); !bytes.Equal(got, appData) {
+		t.Errorf("Written %d bytes, want %d", len(got), len(appData))
+	}
+
+	// A large plaintext handshake record is rejected with an alert.
+	c = newFakeConn(outer.bytes())
+	if conn, err = NewConn(t.Context(), c, WithKeys(keys), WithMaxHandshakeBytes(size)); err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	handshake := append([]byte{22, 3, 3, 0x0f, 0xa0, 11}, make([]byte, 3999)...)
+	_, err = conn.Write(handshake)
+	var alertErr *AlertError
+	if !errors.Is(err, ErrHandshakeTooLarge) || !errors.As(err, &alertErr) {
+		t.Fatalf("Write(handshake): %v, want ErrHandshakeTooLarge with an alert", err)
+	}
+	if got, want := c.Writer.(*bytes.Buffer).Bytes(), []byte{21, 3, 3, 0, 2, 2, 40}; !bytes.Equal(got, want) {
+		t.Errorf("Written = %v, want %v", got, want)
+	}
+}
+
+func TestStreamingRead(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	outer := newClientHello("public", "tls1.3", config, privKey.PublicKey(), inner)
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	c := &fakeConn{Reader: io.MultiReader(bytes.NewReader(outer.bytes()), pr), Writer: &bytes.Buffer{}}
+	conn, err := NewConn(t.Context(), c, WithKeys(keys))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("ClientHello: %v", err)
+	}
+
+	// Only the beginning of a 1000-byte handshake record is sent. It must be
+	// returned without waiting for the rest of the record.
+	payload := make([]byte, 100)
+	payload[0] = 20 // Finished
+	go pw.Write(append([]byte{22, 3, 3, 0x03, 0xe8}, payload...))
+
+	var got []byte
+	buf := make([]byte, 4096)
+	for len(got) < 105 {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if want := append([]byte{22, 3, 3, 0x03, 0xe8}, payload...); !bytes.Equal(got, want) {
+		t.Errorf("Read() = %v, want %v", got, want)
+	}
+
+	// The rest of the record is relayed, followed by the next record.
+	rest := append(make([]byte, 900), 23, 3, 3, 0, 1, 0xff)
+	go pw.Write(rest)
+	got = got[:0]
+	for len(got) < len(rest) {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, rest) {
+		t.Errorf("Read() = %v, want %v", got, rest)
+	}
+	stats := conn.Stats()
+	if !stats.ReadPassthrough {
+		t.Error("ReadPassthrough = false, want true")
+	}
+	if want := int64(len(outer.bytes()) + 105 + len(rest)); stats.BytesRead != want {
+		t.Errorf("BytesRead = %d, want %d", stats.BytesRead, want)
+	}
+	if stats.RecordsRead != 3 {
+		t.Errorf("RecordsRead = %d, want 3", stats.RecordsRead)
+	}
+}
@@ -0,0 +1,247 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+var (
+	// ErrInvalidConfiguration is wrapped by the errors that are caused by
+	// an invalid configuration of this package, e.g. a [Dialer] without a
+	// DialFunc.
+	ErrInvalidConfiguration = errors.New("invalid configuration")
+	// ErrNoAddress is returned by [Dialer.Dial] when the name resolution
+	// doesn't return any address to connect to.
+	ErrNoAddress = errors.New("no address")
+)
+
+// DNSError is returned by [Resolver] when a name server responds with an
+// error. It wraps the sentinel error of the response code, if any, e.g.
+// [ErrNonExistentDomain].
+type DNSError struct {
+	// Name is the name that was queried.
+	Name string
+	// Type is the type of the query, e.g. "HTTPS".
+	Type string
+	// RCode is the response code.
+	RCode uint16
+}
+
+func (e *DNSError) Error() string {
+	if err := rcode[e.RCode]; err != nil {
+		return fmt.Sprintf("%s (%s): %v (%d)", e.Name, e.Type, err, e.RCode)
+	}
+	return fmt.Sprintf("%s (%s): response code %d", e.Name, e.Type, e.RCode)
+}
+
+func (e *DNSError) Unwrap() error {
+	return rcode[e.RCode]
+}
+
+// TargetError is the error of one of the targets of [Dialer.Dial].
+type TargetError struct {
+	// Host is the host name of the target, or the address that failed to
+	// resolve.
+	Host string
+	// Addr is the network address of the target, e.g. "192.0.2.1:443". It
+	// is empty when the name resolution failed.
+	Addr string
+	// Err is the reason why the target failed.
+	Err error
+}
+
+func (e *TargetError) Error() string {
+	// Some errors, e.g. from ConfigPinStore.Check, already start with the
+	// host name.
+	if s := e.Err.Error(); strings.HasPrefix(s, e.Host+": ") {
+		return s
+	}
+	return e.Host + ": " + e.Err.Error()
+}
+
+func (e *TargetError) Unwrap() error {
+	return e.Err
+}
+
+// DialError is returned by [Dialer.Dial] when all the targets fail. It has one
+// [TargetError] per failed target, in the order in which they failed.
+//
+// [errors.Is] and [errors.As] match the errors of all the targets. Use
+// [ErrorAction] to decide what to do about the error as a whole.
+type DialError struct {
+	Errors []*TargetError
+}
+
+func (e *DialError) Error() string {
+	s := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "\n")
+}
+
+func (e *DialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Action is the recommended reaction to an error, as returned by
+// [ErrorAction]. The values are stable, and can be used e.g. in logs and
+// metrics labels.
+type Action string
+
+const (
+	// ActionNone: there is no error.
+	ActionNone Action = "none"
+	// ActionRetry: the error is transient, e.g. a timeout, a refused
+	// connection, or a name server failure. The same operation may
+	// succeed later.
+	ActionRetry Action = "retry"
+	// ActionRotateConfig: the ECH config list is stale or unusable, e.g.
+	// the server rejected ECH without usable retry configs, or the config
+	// list doesn't match its pin. On a client, the config list must be
+	// fetched again. On a server, the config list published in DNS must be
+	// updated to match the server's keys.
+	ActionRotateConfig Action = "rotate_config"
+	// ActionFixConfiguration: the error is caused by a configuration bug,
+	// e.g. an invalid Dialer or Resolver, or colliding config IDs.
+	// Retrying won't help.
+	ActionFixConfiguration Action = "fix_configuration"
+	// ActionFail: the operation failed permanently, e.g. the name doesn't
+	// exist, ECH is required but not available, the server's certificate
+	// is invalid, or the peer violated the protocol. Retrying won't help.
+	ActionFail Action = "fail"
+)
+
+// ErrorAction returns the recommended reaction to an error returned by this
+// package, e.g. by [Dialer.Dial], [Resolver.Resolve], or [NewConn].
+//
+// For a [DialError], the most useful action of all the targets is returned,
+// in this order: ActionFixConfiguration, ActionRotateConfig, ActionRetry,
+// ActionFail.
+func ErrorAction(err error) Action {
+	if err == nil {
+		return ActionNone
+	}
+	var dialErr *DialError
+	if errors.As(err, &dialErr) && len(dialErr.Errors) > 0 {
+		action := ActionFail
+		for _, e := range dialErr.Errors {
+			if a := ErrorAction(e.Err); actionRank(a) > actionRank(action) {
+				action = a
+			}
+		}
+		return action
+	}
+	switch ErrorClass(err) {
+	case ErrorClassTimeout, ErrorClassRefused, ErrorClassNetwork:
+		return ActionRetry
+	case ErrorClassDNS:
+		if errors.Is(err, ErrServerFailure) || errors.Is(err, ErrQueryRefused) {
+			return ActionRetry
+		}
+		return ActionFail
+	case ErrorClassECHRejected, ErrorClassECHConfig:
+		return ActionRotateConfig
+	case ErrorClassConfig:
+		return ActionFixConfiguration
+	default:
+		return ActionFail
+	}
+}
+
+func actionRank(a Action) int {
+	switch a {
+	case ActionFixConfiguration:
+		return 3
+	case ActionRotateConfig:
+		return 2
+	case ActionRetry:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Error classes returned by [ErrorClass]. The values are stable.
+const (
+	ErrorClassNone        = "none"
+	ErrorClassCanceled    = "canceled"
+	ErrorClassTimeout     = "timeout"
+	ErrorClassDNS         = "dns"
+	ErrorClassRefused     = "refused"
+	ErrorClassECHRejected = "ech_rejected"
+	ErrorClassECHRequired = "ech_required"
+	// ErrorClassECHConfig is the class of the errors caused by an unusable
+	// ECH config list, i.e. [ErrNoUsableConfig] and
+	// [ErrConfigPinMismatch].
+	ErrorClassECHConfig = "ech_config"
+	ErrorClassTLS       = "tls"
+	// ErrorClassProtocol is the class of the errors that [Conn] returns
+	// when a client violates the TLS or ECH protocol, and sends an alert
+	// for, e.g. [ErrIllegalParameter] or [ErrDecryptError].
+	ErrorClassProtocol = "protocol"
+	// ErrorClassConfig is the class of the errors caused by an invalid
+	// configuration, e.g. [ErrInvalidConfiguration] or
+	// [ErrConfigIDCollision].
+	ErrorClassConfig  = "config"
+	ErrorClassNetwork = "network"
+	ErrorClassOther   = "other"
+)
+
+// ErrorClass returns a coarse classification of an error returned by this
+// package, suitable for use as a metrics label. A [DialError] is classified
+// by the first matching class of any of its targets.
+func ErrorClass(err error) string {
+	var echErr *tls.ECHRejectionError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var dnsErr *DNSError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ErrorClassNone
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, ErrInvalidConfiguration), errors.Is(err, ErrConfigIDCollision), errors.Is(err, ErrConfigListTooLarge),
+		errors.Is(err, ErrKeyStoreDecrypt):
+		return ErrorClassConfig
+	case errors.As(err, &echErr):
+		return ErrorClassECHRejected
+	case errors.Is(err, ErrNoUsableConfig), errors.Is(err, ErrConfigPinMismatch):
+		return ErrorClassECHConfig
+	case errors.Is(err, ErrECHNotAccepted), errors.Is(err, errNoECHConfigList):
+		return ErrorClassECHRequired
+	case errors.As(err, &dnsErr), errors.Is(err, ErrInvalidName), errors.Is(err, ErrNoAddress), errors.Is(err, ErrFormatError),
+		errors.Is(err, ErrServerFailure), errors.Is(err, ErrNonExistentDomain), errors.Is(err, ErrNotImplemented),
+		errors.Is(err, ErrQueryRefused):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassRefused
+	case errors.Is(err, ErrUnexpectedMessage), errors.Is(err, ErrIllegalParameter), errors.Is(err, ErrDecodeError),
+		errors.Is(err, ErrMissingExtension), errors.Is(err, ErrDecryptError), errors.Is(err, ErrHandshakeTooLarge),
+		errors.Is(err, ErrProtocolVersion), errors.Is(err, ErrAccessDenied), errors.Is(err, ErrPlaintextHTTP):
+		return ErrorClassProtocol
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr):
+		return ErrorClassTLS
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	default:
+		return ErrorClassOther
+	}
+}
@@ -0,0 +1,111 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func TestErrorAction(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want Action
+	}{
+		{nil, ActionNone},
+		{context.Canceled, ActionFail},
+		{context.DeadlineExceeded, ActionRetry},
+		{&DNSError{Name: "example.com", Type: "A", RCode: 2}, ActionRetry},
+		{&DNSError{Name: "example.com", Type: "A", RCode: 3}, ActionFail},
+		{&DNSError{Name: "example.com", Type: "A", RCode: 9}, ActionFail},
+		{&tls.ECHRejectionError{}, ActionRotateConfig},
+		{fmt.Errorf("foo: %w", ErrConfigPinMismatch), ActionRotateConfig},
+		{ErrECHNotAccepted, ActionFail},
+		{fmt.Errorf("%w: foo", ErrInvalidConfiguration), ActionFixConfiguration},
+		{ErrConfigIDCollision, ActionFixConfiguration},
+		{&AlertError{Alert: 47, Err: ErrIllegalParameter}, ActionFail},
+		{&DialError{Errors: []*TargetError{
+			{Host: "a", Err: &DNSError{RCode: 3}},
+			{Host: "b", Err: &net.OpError{Op: "dial", Err: errors.New("unreachable")}},
+		}}, ActionRetry},
+		{&DialError{Errors: []*TargetError{
+			{Host: "a", Err: context.DeadlineExceeded},
+			{Host: "b", Err: &tls.ECHRejectionError{}},
+		}}, ActionRotateConfig},
+	} {
+		if got := ErrorAction(tc.err); got != tc.want {
+			t.Errorf("ErrorAction(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestDNSError(t *testing.T) {
+	dnsServer := testutil.StartTestDNSServer(t, nil, testutil.WithRCode("bad.example.com", 2))
+	defer dnsServer.Close()
+	resolver, err := NewResolver("http://" + dnsServer.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	_, err = resolver.Resolve(t.Context(), "bad.example.com")
+	var dnsErr *DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("Resolve() = %v, want DNSError", err)
+	}
+	if got, want := dnsErr.RCode, uint16(2); got != want {
+		t.Errorf("RCode = %d, want %d", got, want)
+	}
+	if !errors.Is(err, ErrServerFailure) {
+		t.Errorf("Resolve() = %v, want ErrServerFailure", err)
+	}
+	if got, want := ErrorClass(err), ErrorClassDNS; got != want {
+		t.Errorf("ErrorClass() = %q, want %q", got, want)
+	}
+}
+
+func TestDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	ln.Close()
+
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "www.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: addr.IP,
+	}}, testutil.WithNXDOMAIN(testutil.SOA("example.com", 60)))
+	defer dnsServer.Close()
+	resolver, err := NewResolver("http://" + dnsServer.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+
+	dialer := NewDialer()
+	dialer.Resolver = resolver
+	_, err = dialer.Dial(t.Context(), "tcp", fmt.Sprintf("nx.example.com:%d,www.example.com:%d", addr.Port, addr.Port), nil)
+	var dialErr *DialError
+	if !errors.As(err, &dialErr) {
+		t.Fatalf("Dial() = %v, want DialError", err)
+	}
+	if got, want := len(dialErr.Errors), 2; got != want {
+		t.Fatalf("len(Errors) = %d, want %d", got, want)
+	}
+	if e := dialErr.Errors[0]; e.Addr != "" || !errors.Is(e, ErrNonExistentDomain) {
+		t.Errorf("Errors[0] = %+v, want NXDOMAIN", e)
+	}
+	if e := dialErr.Errors[1]; e.Host != "www.example.com" || e.Addr != addr.String() || ErrorClass(e) != ErrorClassRefused {
+		t.Errorf("Errors[1] = %+v (%s), want connection refused", e, ErrorClass(e))
+	}
+	if got, want := ErrorAction(err), ActionRetry; got != want {
+		t.Errorf("ErrorAction() = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(err.Error(), "nx.example.com:") || !strings.Contains(err.Error(), "\nwww.example.com: ") {
+		t.Errorf("Error() = %q", err)
+	}
+}
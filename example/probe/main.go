@@ -0,0 +1,75 @@
+// This is an example showing how to use [ech.Probe] to check the Encrypted
+// Client Hello (ECH) deployment of a host, e.g. for monitoring.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+func main() {
+	resolver := flag.String("resolver", "cloudflare", "One of cloudflare, google, wikimedia, insecure")
+	jsonOutput := flag.Bool("json", false, "Output the report in JSON format.")
+	timeout := flag.Duration("timeout", 30*time.Second, "The maximum amount of time to spend probing.")
+	flag.Parse()
+
+	var r *ech.Resolver
+	switch *resolver {
+	case "cloudflare":
+		r = ech.CloudflareResolver()
+	case "google":
+		r = ech.GoogleResolver()
+	case "wikimedia":
+		r = ech.WikimediaResolver()
+	case "insecure":
+		r = ech.InsecureGoResolver()
+	default:
+		var err error
+		if r, err = ech.NewResolver(*resolver); err != nil {
+			fmt.Fprintf(os.Stderr, "--resolver: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if len(flag.Args()) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: probe <host[:port]>")
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	report, err := (&ech.Prober{Resolver: r}).Probe(ctx, flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Probe: %v\n", err)
+		os.Exit(1)
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	}
+	fmt.Printf("Host: %s\n", report.Host)
+	fmt.Printf("DNS has ECH: %v\n", report.DNSHasECH)
+	for _, c := range report.DNSConfigs {
+		fmt.Printf("  DNS config: id=%d public_name=%s suites=%v\n", c.ID, c.PublicName, c.CipherSuites)
+	}
+	fmt.Printf("Public name: %s\n", report.PublicName)
+	fmt.Printf("Retry configs presented: %v\n", report.RetryConfigsPresented)
+	for _, c := range report.RetryConfigs {
+		fmt.Printf("  Retry config: id=%d public_name=%s suites=%v\n", c.ID, c.PublicName, c.CipherSuites)
+	}
+	if report.GREASEError != "" {
+		fmt.Printf("GREASE error: %s\n", report.GREASEError)
+	}
+	fmt.Printf("ECH accepted: %v\n", report.ECHAccepted)
+	if report.ECHError != "" {
+		fmt.Printf("ECH error: %s\n", report.ECHError)
+	}
+	fmt.Printf("Duration: %s\n", report.Duration)
+}
@@ -0,0 +1,84 @@
+// This is an example of a split-mode Client-Facing Server using the
+// [proxy.Server] to decrypt the TLS Encrypted Client Hello (ECH) message and
+// forward the connections to backend servers based on the encrypted Server
+// Name Indication (SNI).
+//
+// The keys are stored in an encrypted key store. The passphrase is read from
+// the ECH_KEYSTORE_PASSPHRASE environment variable. The configuration and the
+// keys are reloaded on SIGHUP, on the platforms that have it.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/proxy"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8443", "The TCP address to use.")
+	configFile := flag.String("config", "config.json", "The configuration file.")
+	keyStore := flag.String("keystore", "ech-keys", "The key store file.")
+	publicName := flag.String("public-name", "public.example.com", "The public name to use when the key store is created.")
+	flag.Parse()
+
+	passphrase := os.Getenv("ECH_KEYSTORE_PASSPHRASE")
+	if passphrase == "" {
+		log.Fatal("ECH_KEYSTORE_PASSPHRASE must be set")
+	}
+	store := ech.NewKeyStore(*keyStore, ech.NewPassphraseKeyWrapper([]byte(passphrase)))
+	keys, err := store.Load()
+	if errors.Is(err, fs.ErrNotExist) {
+		keys, err = store.Rotate([]byte(*publicName), 2)
+	}
+	if err != nil {
+		log.Fatalf("KeyStore: %v", err)
+	}
+	configs := make([]ech.Config, 0, len(keys))
+	for _, k := range keys {
+		configs = append(configs, k.Config)
+	}
+	configList, err := ech.ConfigList(configs)
+	if err != nil {
+		log.Fatalf("ConfigList: %v", err)
+	}
+	log.Printf("ConfigList: %s", base64.StdEncoding.EncodeToString(configList))
+
+	server := &proxy.Server{
+		ConfigFile: *configFile,
+		KeyStore:   store,
+		Logf:       log.Printf,
+	}
+	defer server.Close()
+	if err := server.Reload(); err != nil {
+		log.Fatalf("Reload: %v", err)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("net.Listen: %v", err)
+	}
+	log.Printf("Accepting connections on %s", ln.Addr().String())
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	notifyReload(func() {
+		if err := server.Reload(); err != nil {
+			log.Printf("Reload: %v", err)
+		}
+	})
+
+	if err := server.Serve(ctx, ln); err != nil {
+		log.Fatalf("Serve: %v", err)
+	}
+	log.Print("Shut down")
+}
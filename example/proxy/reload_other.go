@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// notifyReload does nothing on the platforms that don't have SIGHUP.
+func notifyReload(reload func()) {}
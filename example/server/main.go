@@ -49,6 +49,33 @@ func main() {
 		SendAsRetry: true,
 	}}
 
+	router := ech.NewRouter()
+	router.HandleFunc(*publicName, func(conn *ech.Conn) {
+		server := tls.Server(conn, &tls.Config{
+			Certificates:             []tls.Certificate{tlsCert},
+			EncryptedClientHelloKeys: echKeys,
+		})
+		fmt.Fprintf(server, "Hello, this is %s\n", *publicName)
+		fmt.Fprintf(server, "ServerName: %s\n", conn.ServerName())
+		fmt.Fprintf(server, "ALPNProtos: %s\n", conn.ALPNProtos())
+		fmt.Fprintf(server, "ECHPresented: %v\n", conn.ECHPresented())
+		fmt.Fprintf(server, "ECHAccepted: %v\n", conn.ECHAccepted())
+		server.Close()
+	})
+	router.HandleFunc("*", func(conn *ech.Conn) {
+		// The TLS connection can terminate here, or it could be forwarded
+		// to another backend server with router.Handle().
+		server := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+		})
+		fmt.Fprintf(server, "Hello, this is a private server\n")
+		fmt.Fprintf(server, "ServerName: %s\n", conn.ServerName())
+		fmt.Fprintf(server, "ALPNProtos: %s\n", conn.ALPNProtos())
+		fmt.Fprintf(server, "ECHPresented: %v\n", conn.ECHPresented())
+		fmt.Fprintf(server, "ECHAccepted: %v\n", conn.ECHAccepted())
+		server.Close()
+	})
+
 	ln, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("net.Listen: %v", err)
@@ -72,29 +99,8 @@ func main() {
 			log.Printf("ServerName: %s", conn.ServerName())
 			log.Printf("ALPNProtos: %s", conn.ALPNProtos())
 
-			switch host := conn.ServerName(); host {
-			case *publicName:
-				server := tls.Server(conn, &tls.Config{
-					Certificates:             []tls.Certificate{tlsCert},
-					EncryptedClientHelloKeys: echKeys,
-				})
-				fmt.Fprintf(server, "Hello, this is %s\n", *publicName)
-				fmt.Fprintf(server, "ServerName: %s\n", conn.ServerName())
-				fmt.Fprintf(server, "ALPNProtos: %s\n", conn.ALPNProtos())
-				fmt.Fprintf(server, "ECHPresented: %v\n", conn.ECHPresented())
-				fmt.Fprintf(server, "ECHAccepted: %v\n", conn.ECHAccepted())
-				server.Close()
-
-			default:
-				server := tls.Server(conn, &tls.Config{
-					Certificates: []tls.Certificate{tlsCert},
-				})
-				fmt.Fprintf(server, "Hello, this is a private server\n")
-				fmt.Fprintf(server, "ServerName: %s\n", conn.ServerName())
-				fmt.Fprintf(server, "ALPNProtos: %s\n", conn.ALPNProtos())
-				fmt.Fprintf(server, "ECHPresented: %v\n", conn.ECHPresented())
-				fmt.Fprintf(server, "ECHAccepted: %v\n", conn.ECHAccepted())
-				server.Close()
+			if err := router.Serve(ctx, conn); err != nil {
+				log.Printf("Serve: %v", err)
 			}
 		}()
 	}
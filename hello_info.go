@@ -0,0 +1,144 @@
+package ech
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// ClientHelloInfo contains information extracted from a ClientHello message.
+type ClientHelloInfo struct {
+	// ServerName is the value of the server_name extension.
+	ServerName string
+	// ALPNProtos is the list of ALPN protocols offered by the client.
+	ALPNProtos []string
+	// SupportedGroups is the list of key exchange groups supported by the
+	// client.
+	SupportedGroups []tls.CurveID
+	// KeyShareGroups is the list of key exchange groups for which the client
+	// sent a key share.
+	KeyShareGroups []tls.CurveID
+	// TLS13 indicates whether the client offers TLS 1.3.
+	TLS13 bool
+	// ECHPresented indicates whether the ClientHello contains an
+	// encrypted_client_hello extension of type outer.
+	ECHPresented bool
+	// ECHConfigID is the config ID from the encrypted_client_hello
+	// extension, when ECHPresented is true.
+	ECHConfigID uint8
+	// ECHCipherSuite is the HPKE cipher suite from the
+	// encrypted_client_hello extension, when ECHPresented is true.
+	ECHCipherSuite CipherSuite
+	// Raw is the TLS record that contains the ClientHello message.
+	Raw []byte
+}
+
+func newClientHelloInfo(h *clientHello, raw []byte) *ClientHelloInfo {
+	info := &ClientHelloInfo{
+		ServerName:      h.ServerName,
+		ALPNProtos:      slices.Clone(h.ALPNProtos),
+		SupportedGroups: slices.Clone(h.SupportedGroups),
+		KeyShareGroups:  slices.Clone(h.KeyShareGroups),
+		TLS13:           h.tls13,
+		Raw:             slices.Clone(raw),
+	}
+	if h.echExt != nil && h.echExt.Type == 0 {
+		info.ECHPresented = true
+		info.ECHConfigID = h.echExt.ConfigID
+		info.ECHCipherSuite = h.echExt.CipherSuite
+	}
+	return info
+}
+
+// WithKeysFunc sets a function that selects the keys used to decrypt the
+// client's Encrypted Client Hello, e.g. based on the ClientHelloOuter's
+// ServerName or ECHConfigID. It is called once per connection, before the
+// first ClientHello is decrypted. The keys that it returns replace the ones
+// set with [WithKeys] for this connection. When it returns an error, NewConn
+// returns that error.
+func WithKeysFunc(f func(outer *ClientHelloInfo) ([]Key, error)) Option {
+	return func(c *Conn) {
+		c.keysFunc = f
+	}
+}
+
+// WithOnClientHello sets a function that is called with the first
+// ClientHello before NewConn returns. The outer argument is the
+// ClientHelloOuter, or the only ClientHello when ECH isn't used. The inner
+// argument is the decrypted ClientHelloInner, or nil when ECH isn't accepted.
+//
+// The function can inspect the connection, and attach metadata to it with
+// [Conn.SetMetadata]. When it returns an error, the connection is aborted with
+// an access_denied alert, or an illegal_parameter alert if the error wraps
+// [ErrIllegalParameter], and NewConn returns an error that wraps both
+// [ErrAccessDenied] and the function's error.
+func WithOnClientHello(f func(conn *Conn, outer, inner *ClientHelloInfo) error) Option {
+	return func(c *Conn) {
+		c.onClientHello = f
+	}
+}
+
+// SetMetadata attaches arbitrary metadata to the connection, e.g. from the
+// function set with [WithOnClientHello].
+func (c *Conn) SetMetadata(v any) {
+	c.metadata.Store(&v)
+}
+
+// Metadata returns the metadata attached to the connection with
+// [Conn.SetMetadata], or nil.
+func (c *Conn) Metadata() any {
+	if v := c.metadata.Load(); v != nil {
+		return *v
+	}
+	return nil
+}
+
+// callOnClientHello calls the function set with [WithOnClientHello], if any.
+func (c *Conn) callOnClientHello() error {
+	if c.onClientHello == nil {
+		return nil
+	}
+	outer := newClientHelloInfo(c.outer, c.outerRaw)
+	var inner *ClientHelloInfo
+	if c.inner != nil {
+		inner = newClientHelloInfo(c.inner, c.innerRaw)
+	}
+	if err := c.onClientHello(c, outer, inner); err != nil {
+		return fmt.Errorf("%w: %w", ErrAccessDenied, err)
+	}
+	return nil
+}
+
+// DecryptClientHello parses a ClientHello handshake message without a TLS
+// record header, e.g. one reassembled from the CRYPTO frames of QUIC Initial
+// packets, and decrypts its Encrypted Client Hello with keys.
+//
+// It returns the ClientHelloOuter, or the only ClientHello when ECH isn't used,
+// and the ClientHelloInner, or nil when ECH isn't accepted. The Raw fields
+// contain the handshake messages, without a record header.
+func DecryptClientHello(msg []byte, keys []Key) (outer, inner *ClientHelloInfo, err error) {
+	h, err := parseClientHello(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.hasECHOuterExtensions {
+		return nil, nil, fmt.Errorf("%w: ClientHelloOuter has ech_outer_extensions", ErrIllegalParameter)
+	}
+	if len(keys) > 0 && h.echExt != nil && h.echExt.Type == 1 {
+		return nil, nil, fmt.Errorf("%w: ClientHelloOuter has ech type inner", ErrIllegalParameter)
+	}
+	c := &Conn{keys: keys}
+	in, err := c.processEncryptedClientHello(h, false)
+	if err != nil && err != errNoMatch {
+		return nil, nil, err
+	}
+	outer = newClientHelloInfo(h, msg)
+	if in != nil {
+		record, err := in.Marshal()
+		if err != nil {
+			return nil, nil, err
+		}
+		inner = newClientHelloInfo(in, record[5:])
+	}
+	return outer, inner, nil
+}
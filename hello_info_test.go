@@ -0,0 +1,122 @@
+package ech
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestOnClientHello(t *testing.T) {
+	privKey1, config1, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	privKey2, config2, err := NewConfig(2, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := map[uint8][]Key{
+		1: {{Config: config1, PrivateKey: privKey1.Bytes()}},
+		2: {{Config: config2, PrivateKey: privKey2.Bytes()}},
+	}
+	keysFunc := func(outer *ClientHelloInfo) ([]Key, error) {
+		if !outer.ECHPresented {
+			return nil, nil
+		}
+		return keys[outer.ECHConfigID], nil
+	}
+	errVeto := errors.New("veto")
+	onClientHello := func(conn *Conn, outer, inner *ClientHelloInfo) error {
+		if inner == nil {
+			return errVeto
+		}
+		if !bytes.Equal(inner.Raw, conn.InnerClientHello()) {
+			t.Errorf("inner.Raw = %v, want %v", inner.Raw, conn.InnerClientHello())
+		}
+		conn.SetMetadata(inner.ServerName + " via " + outer.ServerName)
+		return nil
+	}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	for _, tc := range []struct {
+		name    string
+		hello   *testClientHello
+		wantErr error
+	}{
+		{"Config1", newClientHello("public", "tls1.3", config1, privKey1.PublicKey(), inner), nil},
+		{"Config2", newClientHello("public", "tls1.3", config2, privKey2.PublicKey(), inner), nil},
+		{"NoECH", newClientHello("public", "tls1.3"), errVeto},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newFakeConn(tc.hello.bytes())
+			conn, err := NewConn(t.Context(), c, WithKeysFunc(keysFunc), WithOnClientHello(onClientHello))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("NewConn() = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				if got, want := c.Writer.(*bytes.Buffer).Bytes(), []byte{21, 3, 3, 0, 2, 2, 49}; !bytes.Equal(got, want) {
+					t.Errorf("Written = %v, want %v", got, want)
+				}
+				return
+			}
+			if got, want := conn.Metadata(), "private.example.com via public.example.com"; got != want {
+				t.Errorf("Metadata() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecryptClientHello(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	for _, tc := range []struct {
+		name      string
+		hello     *testClientHello
+		keys      []Key
+		wantOuter string
+		wantInner string
+		wantErr   error
+	}{
+		{"ECH", newClientHello("public", "tls1.3", config, privKey.PublicKey(), inner), keys, "public.example.com", "private.example.com", nil},
+		{"NoKeys", newClientHello("public", "tls1.3", config, privKey.PublicKey(), inner), nil, "public.example.com", "", nil},
+		{"NoECH", newClientHello("public", "tls1.3"), keys, "public.example.com", "", nil},
+		{"OuterExtensions", newClientHello("public", "tls1.3", "ech_outer_extensions"), keys, "", "", ErrIllegalParameter},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := tc.hello.bytes()[5:]
+			outer, inner, err := DecryptClientHello(msg, tc.keys)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("DecryptClientHello() = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if got, want := outer.ServerName, tc.wantOuter; got != want {
+				t.Errorf("outer.ServerName = %q, want %q", got, want)
+			}
+			if !bytes.Equal(outer.Raw, msg) {
+				t.Errorf("outer.Raw = %v, want %v", outer.Raw, msg)
+			}
+			if tc.wantInner == "" {
+				if inner != nil {
+					t.Errorf("inner = %v, want nil", inner)
+				}
+				return
+			}
+			if inner == nil {
+				t.Fatal("inner is nil")
+			}
+			if got, want := inner.ServerName, tc.wantInner; got != want {
+				t.Errorf("inner.ServerName = %q, want %q", got, want)
+			}
+			if _, err := parseClientHello(inner.Raw); err != nil {
+				t.Errorf("inner.Raw: %v", err)
+			}
+		})
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"crypto/ecdh"
 	"crypto/hpke"
+	"crypto/tls"
+	"crypto/x509"
 	"io"
 	"net"
 	"slices"
+	"testing"
 	"time"
 
+	"github.com/c2FmZQ/ech/testutil"
 	"golang.org/x/crypto/cryptobyte"
 )
 
@@ -66,6 +70,7 @@ func newClientHello(opts ...any) *testClientHello {
 		},
 	}
 	aead := hpke.ChaCha20Poly1305()
+	kdf := hpke.HKDFSHA256()
 	var pubKey *ecdh.PublicKey
 	var inner *testClientHello
 	var config Config
@@ -85,6 +90,10 @@ func newClientHello(opts ...any) *testClientHello {
 			aead = hpke.AES128GCM()
 		case "aes-256":
 			aead = hpke.AES256GCM()
+		case "hkdf-sha384":
+			kdf = hpke.HKDFSHA384()
+		case "hkdf-sha512":
+			kdf = hpke.HKDFSHA512()
 		default:
 			if c, ok := opt.(Config); ok {
 				config = c
@@ -110,7 +119,7 @@ func newClientHello(opts ...any) *testClientHello {
 			if err != nil {
 				panic(err)
 			}
-			enc, hpkeCtx, err := hpke.NewSender(pub, hpke.HKDFSHA256(), aead, info)
+			enc, hpkeCtx, err := hpke.NewSender(pub, kdf, aead, info)
 			if err != nil {
 				panic(err)
 			}
@@ -118,7 +127,7 @@ func newClientHello(opts ...any) *testClientHello {
 			encap = enc
 		}
 		innerBytes := inner.bytes()[9:]
-		h.addClientHelloExtOuter(config[4], aead.ID(), encap, make([]byte, len(innerBytes)+16))
+		h.addClientHelloExtOuter(config[4], kdf.ID(), aead.ID(), encap, make([]byte, len(innerBytes)+16))
 		h.parse()
 		aad, err := h.marshalAAD()
 		if err != nil {
@@ -129,7 +138,7 @@ func newClientHello(opts ...any) *testClientHello {
 			panic(err)
 		}
 		h.clientHello.Extensions = h.clientHello.Extensions[:len(h.clientHello.Extensions)-1]
-		h.addClientHelloExtOuter(config[4], aead.ID(), encap, payload)
+		h.addClientHelloExtOuter(config[4], kdf.ID(), aead.ID(), encap, payload)
 	}
 	h.parse()
 	return h
@@ -196,10 +205,10 @@ func (h *testClientHello) addClientHelloExtInner() {
 	})
 }
 
-func (h *testClientHello) addClientHelloExtOuter(id uint8, aead uint16, encap, payload []byte) {
+func (h *testClientHello) addClientHelloExtOuter(id uint8, kdf, aead uint16, encap, payload []byte) {
 	var b cryptobyte.Builder
 	b.AddUint8(0x00)
-	b.AddUint16(0x0001)
+	b.AddUint16(kdf)
 	b.AddUint16(aead)
 	b.AddUint8(id)
 	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
@@ -232,3 +241,52 @@ func helloRetryReq() []byte {
 	}
 	return m
 }
+
+// startTestECHServer starts a TLS server with ECH that writes "Hello!\n" to
+// each connection.
+func startTestECHServer(t *testing.T) (addr string, configList []byte, rootCAs *x509.CertPool) {
+	t.Helper()
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	if configList, err = ConfigList([]Config{config}); err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("private.example.com", "public.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs = x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes(), SendAsRetry: true}}
+	serverConfig := &tls.Config{
+		Certificates:             []tls.Certificate{tlsCert},
+		EncryptedClientHelloKeys: keys,
+	}
+	go func() {
+		for {
+			serverConn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer serverConn.Close()
+				conn, err := NewConn(t.Context(), serverConn, WithKeys(keys))
+				if err != nil {
+					return
+				}
+				server := tls.Server(conn, serverConfig)
+				server.Write([]byte("Hello!\n"))
+				server.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String(), configList, rootCAs
+}
@@ -0,0 +1,63 @@
+package ech
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/c2FmZQ/ech/dns"
+)
+
+// StaticHost is a static name resolution entry for [Dialer.Hosts].
+type StaticHost struct {
+	// Addresses are the IP addresses of the host. IPv6 link-local
+	// addresses can have a zone, e.g. fe80::1%eth0. All of them use the
+	// zone of the first address that has one.
+	Addresses []netip.Addr
+	// ECH is the ECH config list to use with this host. When it is empty,
+	// the host is dialed without ECH.
+	ECH []byte
+	// ALPN is the list of application protocols supported by the host.
+	// It is only used when ECH is set.
+	ALPN []string
+}
+
+// lookupStaticHost returns the result of resolving name with hosts. name may
+// include a port number.
+func lookupStaticHost(hosts map[string]StaticHost, name string) (ResolveResult, bool) {
+	if len(hosts) == 0 {
+		return ResolveResult{}, false
+	}
+	result := ResolveResult{
+		Port: 443,
+	}
+	if h, p, err := net.SplitHostPort(name); err == nil {
+		if pp, err := strconv.ParseUint(p, 10, 16); err == nil {
+			name = h
+			if pp > 0 {
+				result.Port = uint16(pp)
+			}
+		}
+	}
+	host, ok := hosts[strings.TrimSuffix(strings.ToLower(name), ".")]
+	if !ok {
+		return ResolveResult{}, false
+	}
+	result.Address = make([]net.IP, 0, len(host.Addresses))
+	for _, a := range host.Addresses {
+		result.Address = append(result.Address, net.IP(a.Unmap().AsSlice()))
+		if result.Zone == "" {
+			result.Zone = a.Zone()
+		}
+	}
+	if len(host.ECH) > 0 {
+		result.HTTPS = []dns.HTTPS{{
+			Priority:      1,
+			ALPN:          host.ALPN,
+			NoDefaultALPN: len(host.ALPN) > 0,
+			ECH:           host.ECH,
+		}}
+	}
+	return result, true
+}
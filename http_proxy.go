@@ -0,0 +1,157 @@
+package ech
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+var _ Proxy = (*httpConnectProxy)(nil)
+
+// NewHTTPConnectProxy returns a [Proxy] that connects through the HTTP proxy
+// server at proxyURL with the CONNECT method. The scheme of proxyURL must be
+// http or https. With https, the connection to the proxy server itself uses
+// TLS with tc, which may be nil. If proxyURL contains a username and password,
+// they are sent to the proxy server with Basic authentication.
+//
+// The proxy server receives the IP address of the remote server, not its
+// name. The TLS handshake with the remote server, including Encrypted Client
+// Hello, goes through the tunnel.
+func NewHTTPConnectProxy(proxyURL *url.URL, tc *tls.Config) (Proxy, error) {
+	p := &httpConnectProxy{
+		url: proxyURL,
+	}
+	switch proxyURL.Scheme {
+	case "http":
+	case "https":
+		if tc == nil {
+			tc = &tls.Config{}
+		} else {
+			tc = tc.Clone()
+		}
+		if tc.ServerName == "" {
+			tc.ServerName = proxyURL.Hostname()
+		}
+		p.tc = tc
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	p.addr = proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if p.tc != nil {
+			port = "443"
+		}
+		p.addr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+password))
+	}
+	return p, nil
+}
+
+// DialViaConnect connects to target through the HTTP proxy server at proxyURL
+// with the CONNECT method, and then does the TLS handshake with Encrypted
+// Client Hello through the tunnel. The proxy is used like
+// [NewHTTPConnectProxy] with a nil tls.Config, and target is dialed like
+// [Dial], i.e. its ECH config list is retrieved from DNS, unless it is set in
+// tc.
+//
+// DialViaConnect is equivalent to:
+//
+//	proxy, err := NewHTTPConnectProxy(proxyURL, nil)
+//	dialer := NewDialer()
+//	dialer.Proxy = proxy
+//	conn, err := dialer.Dial(ctx, "tcp", target, tc)
+func DialViaConnect(ctx context.Context, proxyURL *url.URL, target string, tc *tls.Config) (*tls.Conn, error) {
+	proxy, err := NewHTTPConnectProxy(proxyURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDialer()
+	d.Proxy = proxy
+	return d.Dial(ctx, "tcp", target, tc)
+}
+
+type httpConnectProxy struct {
+	url    *url.URL
+	addr   string
+	tc     *tls.Config
+	auth   string
+	dialer net.Dialer
+}
+
+// DialContext connects to addr through the HTTP proxy. Only TCP is supported.
+func (p *httpConnectProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("http proxy: unsupported network %q", network)
+	}
+	var conn net.Conn
+	var err error
+	if p.tc != nil {
+		d := &tls.Dialer{NetDialer: &p.dialer, Config: p.tc}
+		conn, err = d.DialContext(ctx, "tcp", p.addr)
+	} else {
+		conn, err = p.dialer.DialContext(ctx, "tcp", p.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	stop := withContextDeadline(ctx, conn)
+	br, err := p.connect(conn, addr)
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+func (p *httpConnectProxy) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.auth != "" {
+		req.Header.Set("Proxy-Authorization", p.auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	return br, nil
+}
+
+// bufferedConn is a net.Conn that reads from r first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,197 @@
+package interop
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// GoClient returns a [Client] that uses [crypto/tls]. It is the reference
+// client of the harness. It doesn't support the GREASE scenario.
+func GoClient() Client {
+	return goClient{}
+}
+
+type goClient struct{}
+
+func (goClient) Name() string {
+	return "go"
+}
+
+func (goClient) Supports(s Scenario) bool {
+	return s != GREASE
+}
+
+func (goClient) Connect(ctx context.Context, s Scenario, p Params) error {
+	d := &tls.Dialer{
+		Config: &tls.Config{
+			ServerName:                     p.ServerName,
+			RootCAs:                        p.RootCAs,
+			EncryptedClientHelloConfigList: p.ConfigList,
+		},
+	}
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !conn.(*tls.Conn).ConnectionState().ECHAccepted {
+		return errors.New("ECH not accepted")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	_, err = io.ReadAll(conn)
+	return err
+}
+
+// CaptureClient returns a [Client] that replays a ClientHello captured from
+// another client, e.g. Firefox or Chrome, for the GREASE scenario. The
+// clientHello argument is the TLS record that contains the ClientHello, as
+// sent on the wire. Since its ECH extension can't be decrypted with the
+// harness's keys, the client only verifies that the server responds with a
+// ServerHello.
+func CaptureClient(name string, clientHello []byte) Client {
+	return &captureClient{name: name, clientHello: clientHello}
+}
+
+type captureClient struct {
+	name        string
+	clientHello []byte
+}
+
+func (c *captureClient) Name() string {
+	return "capture:" + c.name
+}
+
+func (c *captureClient) Supports(s Scenario) bool {
+	return s == GREASE
+}
+
+func (c *captureClient) Connect(ctx context.Context, s Scenario, p Params) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(c.clientHello); err != nil {
+		return err
+	}
+	// Record header, followed by the handshake message type.
+	var hdr [6]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] == 21 {
+		return fmt.Errorf("server sent alert %d", hdr[5])
+	}
+	if hdr[0] != 22 || hdr[5] != 2 {
+		return fmt.Errorf("unexpected response %x, want ServerHello", hdr)
+	}
+	return nil
+}
+
+// CommandClient is a [Client] that runs an external command for each
+// scenario, e.g. BoringSSL's bssl client or OpenSSL's s_client. The scenario
+// passes when the command exits with status 0, and fails otherwise.
+//
+// The arguments can contain the following placeholders, which are replaced
+// with the scenario's [Params]: {addr}, {host}, {port}, {server_name},
+// {public_name}, {config_list} (base64), {config_list_file}, and {ca_file}.
+//
+// The command's standard input is empty.
+type CommandClient struct {
+	// ClientName identifies the client in the results.
+	ClientName string
+	// Args are the command lines of the supported scenarios. The first
+	// element is the path of the command.
+	Args map[Scenario][]string
+}
+
+// BoringSSLClient returns a [CommandClient] that uses BoringSSL's bssl tool at
+// path for the Accept, Reject, and HelloRetry scenarios. The arguments may
+// need to be adjusted for the installed version.
+func BoringSSLClient(path string) *CommandClient {
+	args := []string{path, "client", "-connect", "{addr}", "-server-name", "{server_name}", "-root-certs", "{ca_file}", "-ech-config-list", "{config_list_file}"}
+	return &CommandClient{
+		ClientName: "bssl",
+		Args: map[Scenario][]string{
+			Accept:     args,
+			Reject:     args,
+			HelloRetry: args,
+		},
+	}
+}
+
+// OpenSSLClient returns a [CommandClient] that uses the s_client command of an
+// ECH-enabled OpenSSL at path for the Accept, Reject, and HelloRetry
+// scenarios. The arguments may need to be adjusted for the installed version.
+func OpenSSLClient(path string) *CommandClient {
+	args := []string{path, "s_client", "-connect", "{addr}", "-servername", "{server_name}", "-CAfile", "{ca_file}", "-verify_return_error", "-ech_config_list", "{config_list}"}
+	return &CommandClient{
+		ClientName: "openssl",
+		Args: map[Scenario][]string{
+			Accept:     args,
+			Reject:     args,
+			HelloRetry: args,
+		},
+	}
+}
+
+// Name implements [Client].
+func (c *CommandClient) Name() string {
+	return c.ClientName
+}
+
+// Supports implements [Client].
+func (c *CommandClient) Supports(s Scenario) bool {
+	return len(c.Args[s]) > 0
+}
+
+// Connect implements [Client].
+func (c *CommandClient) Connect(ctx context.Context, s Scenario, p Params) error {
+	host, port, _ := net.SplitHostPort(p.Addr)
+	r := strings.NewReplacer(
+		"{addr}", p.Addr,
+		"{host}", host,
+		"{port}", port,
+		"{server_name}", p.ServerName,
+		"{public_name}", p.PublicName,
+		"{config_list}", base64.StdEncoding.EncodeToString(p.ConfigList),
+		"{config_list_file}", p.ConfigListFile,
+		"{ca_file}", p.CAFile,
+	)
+	args := slices.Clone(c.Args[s])
+	for i := range args {
+		args[i] = r.Replace(args[i])
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader("")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, lastLines(out.String(), 5))
+	}
+	return nil
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.Join(lines[max(0, len(lines)-n):], "\n")
+}
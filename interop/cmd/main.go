@@ -0,0 +1,90 @@
+// This command runs the [interop] conformance harness against the Go TLS
+// client and, optionally, external clients and captured ClientHello messages.
+//
+// Usage:
+//
+//	cmd [-bssl path] [-openssl path] [-capture name=file ...]
+//
+// A capture file contains the TLS record of a ClientHello, as sent on the
+// wire, e.g. exported from a packet capture of Firefox or Chrome.
+//
+// The exit status is 1 when any scenario fails.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/c2FmZQ/ech/interop"
+)
+
+type captures []interop.Client
+
+func (c *captures) String() string {
+	return ""
+}
+
+func (c *captures) Set(v string) error {
+	name, file, ok := strings.Cut(v, "=")
+	if !ok {
+		file = v
+		name = v
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	*c = append(*c, interop.CaptureClient(name, b))
+	return nil
+}
+
+func main() {
+	bssl := flag.String("bssl", "", "The path of BoringSSL's bssl tool.")
+	openssl := flag.String("openssl", "", "The path of an ECH-enabled openssl tool.")
+	noGo := flag.Bool("no-go", false, "Don't run the Go TLS client.")
+	verbose := flag.Bool("v", false, "Show the details of the connections.")
+	var caps captures
+	flag.Var(&caps, "capture", "A captured ClientHello, as name=file. Can be repeated.")
+	flag.Parse()
+
+	var clients []interop.Client
+	if !*noGo {
+		clients = append(clients, interop.GoClient())
+	}
+	if *bssl != "" {
+		clients = append(clients, interop.BoringSSLClient(*bssl))
+	}
+	if *openssl != "" {
+		clients = append(clients, interop.OpenSSLClient(*openssl))
+	}
+	clients = append(clients, caps...)
+	if len(clients) == 0 {
+		fmt.Fprintln(os.Stderr, "no clients")
+		os.Exit(1)
+	}
+
+	h := &interop.Harness{}
+	if *verbose {
+		h.Debugf = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, format+"\n", args...)
+		}
+	}
+	results, err := h.Run(context.Background(), clients...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Run: %v\n", err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, r := range results {
+		fmt.Println(r)
+		if !r.Passed() {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,348 @@
+// Package interop is a conformance harness that runs the [ech.Conn] server
+// against TLS clients, e.g. BoringSSL's bssl tool, OpenSSL's s_client, or
+// ClientHello messages captured from browsers, and verifies that ECH is
+// accepted, rejected, and retried as expected.
+//
+//	h := &interop.Harness{}
+//	results, err := h.Run(ctx,
+//		interop.GoClient(),
+//		interop.BoringSSLClient("/usr/local/bin/bssl"),
+//	)
+//	if err != nil {
+//		// ...
+//	}
+//	for _, r := range results {
+//		fmt.Println(r)
+//	}
+package interop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// Scenario is a conformance test case.
+type Scenario string
+
+const (
+	// Accept: the client uses the server's current ECH config. The server
+	// must accept ECH, and the handshake must complete with the
+	// ServerName from the ClientHelloInner.
+	Accept Scenario = "accept"
+	// Reject: the client uses a stale ECH config with the server's public
+	// name. The server must reject ECH, complete the handshake with the
+	// public name, and send retry_configs. The client must then abort the
+	// handshake with an ech_required alert.
+	Reject Scenario = "reject"
+	// HelloRetry: like Accept, but the server only supports a key
+	// exchange group for which the client didn't send a key share, which
+	// forces a HelloRetryRequest. ECH must be accepted in the retried
+	// ClientHello.
+	HelloRetry Scenario = "hrr"
+	// GREASE: the client sends a GREASE ECH extension, or a ClientHello
+	// whose ECH extension wasn't encrypted for the server's keys, e.g. a
+	// captured ClientHello. The server must ignore the extension, and
+	// respond with a ServerHello for the outer ServerName.
+	GREASE Scenario = "grease"
+)
+
+// Scenarios are all the scenarios, in the order in which they are run.
+var Scenarios = []Scenario{Accept, Reject, HelloRetry, GREASE}
+
+// Params are the parameters of a client connection.
+type Params struct {
+	// Addr is the address of the server, e.g. "127.0.0.1:1234".
+	Addr string
+	// ServerName is the name to put in the ClientHelloInner, or in the
+	// only ClientHello for the GREASE scenario.
+	ServerName string
+	// PublicName is the public name of the ECH configs.
+	PublicName string
+	// ConfigList is the ECH config list to use. It is nil for the GREASE
+	// scenario.
+	ConfigList []byte
+	// ConfigListFile is the path of a file that contains ConfigList.
+	ConfigListFile string
+	// RootCAs contains the certificate of the CA that issued the server's
+	// certificate.
+	RootCAs *x509.CertPool
+	// CAFile is the path of a PEM file that contains the certificate of
+	// the CA.
+	CAFile string
+}
+
+// Client is a TLS client under test.
+type Client interface {
+	// Name identifies the client in the results.
+	Name() string
+	// Supports indicates whether the client can run the scenario.
+	Supports(s Scenario) bool
+	// Connect connects to the server with the parameters of the scenario,
+	// completes the handshake, and reads the server's response until
+	// EOF. It returns an error if any of that fails.
+	Connect(ctx context.Context, s Scenario, p Params) error
+}
+
+// Result is the outcome of a scenario with a client.
+type Result struct {
+	Client   string
+	Scenario Scenario
+	// Skipped indicates that the client doesn't support the scenario.
+	Skipped bool
+	// Err is the reason why the scenario failed, or nil if it passed.
+	Err      error
+	Duration time.Duration
+}
+
+// Passed indicates whether the scenario passed or was skipped.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("SKIP %s %s", r.Client, r.Scenario)
+	case r.Err != nil:
+		return fmt.Sprintf("FAIL %s %s: %v", r.Client, r.Scenario, r.Err)
+	default:
+		return fmt.Sprintf("PASS %s %s (%s)", r.Client, r.Scenario, r.Duration.Round(time.Millisecond))
+	}
+}
+
+// Harness runs the [ech.Conn] server against clients.
+type Harness struct {
+	// PublicName is the public name of the ECH configs. The default value
+	// is "public.example.com".
+	PublicName string
+	// ServerName is the name of the private server. The default value is
+	// "private.example.com".
+	ServerName string
+	// Timeout is the maximum amount of time of each scenario. The default
+	// value is 10s.
+	Timeout time.Duration
+	// Debugf, if set, is used to log the details of the connections.
+	Debugf func(format string, args ...any)
+}
+
+// observation is what the server saw of a connection.
+type observation struct {
+	echPresented bool
+	echAccepted  bool
+	helloRetry   bool
+	serverName   string
+	// err is the error from the handshake, or from reading the client's
+	// data until EOF.
+	err error
+}
+
+func (o observation) String() string {
+	return fmt.Sprintf("ECHPresented=%v ECHAccepted=%v HelloRetry=%v ServerName=%q Err=%v", o.echPresented, o.echAccepted, o.helloRetry, o.serverName, o.err)
+}
+
+// Run runs all the scenarios that the clients support, one at a time. An error
+// is returned if the harness itself fails. The failures of the clients are
+// reported in the results.
+func (h *Harness) Run(ctx context.Context, clients ...Client) ([]Result, error) {
+	publicName := h.PublicName
+	if publicName == "" {
+		publicName = "public.example.com"
+	}
+	serverName := h.ServerName
+	if serverName == "" {
+		serverName = "private.example.com"
+	}
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	dir, err := os.MkdirTemp("", "ech-interop-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	privKey, config, err := ech.NewConfig(1, []byte(publicName))
+	if err != nil {
+		return nil, err
+	}
+	keys := []ech.Key{{Config: config, PrivateKey: privKey.Bytes(), SendAsRetry: true}}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		return nil, err
+	}
+	_, staleConfig, err := ech.NewConfig(2, []byte(publicName))
+	if err != nil {
+		return nil, err
+	}
+	staleConfigList, err := ech.ConfigList([]ech.Config{staleConfig})
+	if err != nil {
+		return nil, err
+	}
+	cert, err := newCert(publicName, serverName)
+	if err != nil {
+		return nil, err
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Leaf.Raw}), 0o600); err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+	srv := &server{
+		ctx:     ctx,
+		timeout: timeout,
+		keys:    keys,
+		cert:    cert,
+		obs:     make(chan observation, 10),
+	}
+	go srv.serve(ln)
+
+	var results []Result
+	for _, s := range Scenarios {
+		p := Params{
+			Addr:       ln.Addr().String(),
+			ServerName: serverName,
+			PublicName: publicName,
+			RootCAs:    rootCAs,
+			CAFile:     caFile,
+		}
+		switch s {
+		case Accept, HelloRetry:
+			p.ConfigList = configList
+		case Reject:
+			p.ConfigList = staleConfigList
+		}
+		if p.ConfigList != nil {
+			p.ConfigListFile = filepath.Join(dir, string(s)+".echconfiglist")
+			if err := os.WriteFile(p.ConfigListFile, p.ConfigList, 0o600); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range clients {
+			if !c.Supports(s) {
+				results = append(results, Result{Client: c.Name(), Scenario: s, Skipped: true})
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return results, err
+			}
+			results = append(results, h.runOne(ctx, srv, c, s, p, timeout))
+		}
+	}
+	return results, nil
+}
+
+func (h *Harness) runOne(ctx context.Context, srv *server, c Client, s Scenario, p Params, timeout time.Duration) Result {
+	// Discard the observations of the previous scenarios.
+	for len(srv.obs) > 0 {
+		<-srv.obs
+	}
+	srv.helloRetry.Store(s == HelloRetry)
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	clientErr := c.Connect(ctx, s, p)
+	r := Result{Client: c.Name(), Scenario: s, Duration: time.Since(start)}
+	h.debugf("%s %s: client: %v", c.Name(), s, clientErr)
+
+	var o observation
+	select {
+	case o = <-srv.obs:
+	case <-ctx.Done():
+		r.Err = fmt.Errorf("no connection observed by the server (client: %v)", clientErr)
+		return r
+	}
+	h.debugf("%s %s: server: %s", c.Name(), s, o)
+	r.Err = validate(s, p, o, clientErr)
+	return r
+}
+
+// validate checks the client's error and the server's observation against the
+// expected behavior of the scenario.
+func validate(s Scenario, p Params, o observation, clientErr error) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	switch s {
+	case Accept, HelloRetry:
+		check(clientErr == nil, "client failed: %v", clientErr)
+		check(o.echPresented, "ECH not presented")
+		check(o.echAccepted, "ECH not accepted")
+		check(o.serverName == p.ServerName, "ServerName = %q, want %q", o.serverName, p.ServerName)
+		check(o.err == nil, "server connection failed: %v", o.err)
+		check(o.helloRetry == (s == HelloRetry), "HelloRetryRequest = %v, want %v", o.helloRetry, s == HelloRetry)
+	case Reject:
+		check(clientErr != nil, "client succeeded with a rejected ECH config")
+		check(o.echPresented, "ECH not presented")
+		check(!o.echAccepted, "ECH accepted with a stale config")
+		check(o.serverName == p.PublicName, "ServerName = %q, want %q", o.serverName, p.PublicName)
+		check(o.err != nil && strings.Contains(o.err.Error(), "encrypted client hello required"),
+			"server connection error = %v, want ech_required alert", o.err)
+	case GREASE:
+		check(clientErr == nil, "client failed: %v", clientErr)
+		check(o.echPresented, "ECH not presented")
+		check(!o.echAccepted, "ECH accepted with GREASE")
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Harness) debugf(format string, args ...any) {
+	if h.Debugf != nil {
+		h.Debugf(format, args...)
+	}
+}
+
+// newCert returns a self-signed certificate for names.
+func newCert(names ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	templ := &x509.Certificate{
+		Issuer:                pkix.Name{CommonName: names[0]},
+		Subject:               pkix.Name{CommonName: names[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              names,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, templ, templ, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(b)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{b}, PrivateKey: key, Leaf: leaf}, nil
+}
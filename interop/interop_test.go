@@ -0,0 +1,106 @@
+package interop
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// captureConn records the first write, i.e. the ClientHello, and fails the
+// handshake.
+type captureConn struct {
+	net.Conn
+	written []byte
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	if c.written == nil {
+		c.written = append([]byte{}, b...)
+	}
+	return len(b), nil
+}
+
+func (c *captureConn) Read([]byte) (int, error) {
+	return 0, errors.New("capture done")
+}
+
+func (c *captureConn) Close() error {
+	return nil
+}
+
+func captureClientHello(t *testing.T) []byte {
+	t.Helper()
+	_, config, err := ech.NewConfig(9, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	conn := &captureConn{}
+	tls.Client(conn, &tls.Config{
+		ServerName:                     "private.example.com",
+		EncryptedClientHelloConfigList: configList,
+	}).Handshake()
+	if len(conn.written) == 0 {
+		t.Fatal("no ClientHello captured")
+	}
+	return conn.written
+}
+
+func TestHarness(t *testing.T) {
+	h := &Harness{Debugf: t.Logf}
+	results, err := h.Run(t.Context(), GoClient(), CaptureClient("go", captureClientHello(t)))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		if !r.Passed() {
+			t.Errorf("%s", r)
+		}
+		got = append(got, strings.Fields(r.String())[0]+" "+r.Client+" "+string(r.Scenario))
+	}
+	want := []string{
+		"PASS go accept", "SKIP capture:go accept",
+		"PASS go reject", "SKIP capture:go reject",
+		"PASS go hrr", "SKIP capture:go hrr",
+		"SKIP go grease", "PASS capture:go grease",
+	}
+	if g, w := strings.Join(got, ","), strings.Join(want, ","); g != w {
+		t.Errorf("Results = %v, want %v", got, want)
+	}
+}
+
+func TestHarnessFailures(t *testing.T) {
+	h := &Harness{Timeout: 300 * time.Millisecond, Debugf: t.Logf}
+	results, err := h.Run(t.Context(),
+		// Doesn't connect at all.
+		&CommandClient{ClientName: "true", Args: map[Scenario][]string{Accept: {"true", "{addr}"}}},
+		// Connects without ECH.
+		CaptureClient("no-ech", func() []byte {
+			conn := &captureConn{}
+			tls.Client(conn, &tls.Config{ServerName: "private.example.com"}).Handshake()
+			return conn.written
+		}()),
+	)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var failed []string
+	for _, r := range results {
+		if !r.Passed() {
+			t.Logf("%s", r)
+			failed = append(failed, r.Client+" "+string(r.Scenario))
+		}
+	}
+	if got, want := strings.Join(failed, ","), "true accept,capture:no-ech grease"; got != want {
+		t.Errorf("Failed = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package interop
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// server is the ech.Conn server under test. It terminates the TLS connections
+// and writes a short message to the client.
+type server struct {
+	ctx     context.Context
+	timeout time.Duration
+	keys    []ech.Key
+	cert    tls.Certificate
+	obs     chan observation
+	// helloRetry forces a HelloRetryRequest when the client sends a key
+	// share for X25519 or X25519MLKEM768.
+	helloRetry atomic.Bool
+}
+
+func (s *server) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *server) handle(netConn net.Conn) {
+	defer netConn.Close()
+	conn, err := ech.NewConn(s.ctx, netConn, ech.WithKeys(s.keys), ech.WithHandshakeTimeout(s.timeout))
+	if err != nil {
+		s.report(observation{err: err})
+		return
+	}
+	tc := &tls.Config{
+		Certificates:             []tls.Certificate{s.cert},
+		EncryptedClientHelloKeys: s.keys,
+	}
+	if s.helloRetry.Load() {
+		tc.CurvePreferences = []tls.CurveID{tls.CurveP256}
+	}
+	server := tls.Server(conn, tc)
+	server.SetDeadline(time.Now().Add(s.timeout))
+	// The client's alerts, e.g. ech_required, can arrive after the server
+	// considers the handshake complete. They are returned by Read.
+	err = server.Handshake()
+	if err == nil {
+		server.Write([]byte("Hello from ech.Conn\n"))
+		server.CloseWrite()
+		_, err = io.Copy(io.Discard, server)
+	}
+	s.report(observation{
+		echPresented: conn.ECHPresented(),
+		echAccepted:  conn.ECHAccepted(),
+		helloRetry:   conn.HelloRetryRequested(),
+		serverName:   conn.ServerName(),
+		err:          err,
+	})
+}
+
+// report sends the observation to the scenario in progress, if any.
+func (s *server) report(o observation) {
+	select {
+	case s.obs <- o:
+	default:
+	}
+}
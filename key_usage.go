@@ -0,0 +1,87 @@
+package ech
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// WithKeyUsage enables the collection of key usage counters in u. The same
+// [KeyUsage] is typically shared by all the connections of a server.
+func WithKeyUsage(u *KeyUsage) Option {
+	return func(c *Conn) {
+		c.keyUsage = u
+	}
+}
+
+// NewKeyUsage returns a new [KeyUsage].
+func NewKeyUsage() *KeyUsage {
+	return &KeyUsage{
+		counters: make(map[uint8]*keyUsageCounters),
+	}
+}
+
+// KeyUsage tracks how many connections selected each config ID. Operators can
+// use it to find out when an old config is no longer used by clients and can
+// safely be removed from the key set.
+type KeyUsage struct {
+	mu       sync.Mutex
+	counters map[uint8]*keyUsageCounters
+}
+
+type keyUsageCounters struct {
+	accepted atomic.Int64
+	failed   atomic.Int64
+}
+
+// KeyUsageStats contains the key usage counters for one config ID.
+type KeyUsageStats struct {
+	// ConfigID is the config ID selected by the clients.
+	ConfigID uint8
+	// Accepted is the number of connections where the ClientHelloInner was
+	// successfully decrypted.
+	Accepted int64
+	// Failed is the number of connections where the ClientHelloInner could
+	// not be decrypted, e.g. because the client used a config that isn't
+	// in the key set anymore.
+	Failed int64
+}
+
+// Stats returns the key usage counters of all the config IDs that were
+// selected by at least one client, ordered by config ID.
+func (u *KeyUsage) Stats() []KeyUsageStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]KeyUsageStats, 0, len(u.counters))
+	for _, id := range slices.Sorted(maps.Keys(u.counters)) {
+		out = append(out, KeyUsageStats{
+			ConfigID: id,
+			Accepted: u.counters[id].accepted.Load(),
+			Failed:   u.counters[id].failed.Load(),
+		})
+	}
+	return out
+}
+
+// Reset sets all the counters to zero.
+func (u *KeyUsage) Reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	clear(u.counters)
+}
+
+func (u *KeyUsage) record(id uint8, accepted bool) {
+	u.mu.Lock()
+	c, ok := u.counters[id]
+	if !ok {
+		c = &keyUsageCounters{}
+		u.counters[id] = c
+	}
+	u.mu.Unlock()
+	if accepted {
+		c.accepted.Add(1)
+	} else {
+		c.failed.Add(1)
+	}
+}
@@ -0,0 +1,39 @@
+package ech
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestKeyUsage(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	otherKey, _, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+	usage := NewKeyUsage()
+
+	for _, pubKey := range []any{privKey.PublicKey(), privKey.PublicKey(), otherKey.PublicKey()} {
+		inner := newClientHello("private", "echExtInner", "tls1.3")
+		outer := newClientHello("public", "tls1.3", config, pubKey, inner)
+		if _, err := NewConn(t.Context(), newFakeConn(outer.bytes()), WithKeys(keys), WithKeyUsage(usage)); err != nil {
+			t.Fatalf("NewConn: %v", err)
+		}
+	}
+	// Without ECH.
+	if _, err := NewConn(t.Context(), newFakeConn(newClientHello("public", "tls1.3").bytes()), WithKeys(keys), WithKeyUsage(usage)); err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+
+	if got, want := usage.Stats(), []KeyUsageStats{{ConfigID: 1, Accepted: 2, Failed: 1}}; !slices.Equal(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	usage.Reset()
+	if got := usage.Stats(); len(got) != 0 {
+		t.Errorf("Stats() = %+v, want none", got)
+	}
+}
@@ -0,0 +1,115 @@
+package ech
+
+import (
+	"bytes"
+	"crypto/hpke"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ValidateKeys verifies that each key's private key is valid and corresponds
+// to the public key in its config, and that the config IDs are unique.
+func ValidateKeys(keys []Key) error {
+	var errs []error
+	ids := make(map[uint8]int)
+	for i, key := range keys {
+		spec, err := Config(key.Config).Spec()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key %d: %w", i, err))
+			continue
+		}
+		if prev, exists := ids[spec.ID]; exists {
+			errs = append(errs, fmt.Errorf("key %d: %w: %d, same as key %d", i, ErrConfigIDCollision, spec.ID, prev))
+		}
+		ids[spec.ID] = i
+		if err := checkKey(spec, key.PrivateKey); err != nil {
+			errs = append(errs, fmt.Errorf("key %d (config id %d): %w", i, spec.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MatchConfigList verifies that each config in the serialized Encrypted
+// Client Hello (ECH) Config List, e.g. as published in DNS, corresponds to one
+// of the keys, and vice versa. A published config without a matching key
+// can't be decrypted by the server, and a key without a published config
+// isn't used by the clients.
+func MatchConfigList(configList []byte, keys []Key) error {
+	if err := ValidateKeys(keys); err != nil {
+		return err
+	}
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	used := make([]bool, len(keys))
+	for _, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil {
+			continue
+		}
+		found := false
+		for i, key := range keys {
+			if bytes.Equal(key.Config, cfg) {
+				used[i] = true
+				found = true
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("config id %d (%s): no matching key", spec.ID, spec.PublicName))
+		}
+	}
+	for i, key := range keys {
+		if used[i] {
+			continue
+		}
+		spec, _ := Config(key.Config).Spec()
+		errs = append(errs, fmt.Errorf("key %d (config id %d): config not in config list", i, spec.ID))
+	}
+	return errors.Join(errs...)
+}
+
+// DeriveConfigID returns a config ID derived from the SHA-256 hash of
+// publicKey, so that the same public key always gets the same config ID. If the
+// derived ID is already used by one of the keys with a different public key,
+// the next unused ID is returned instead. It returns an error if all the
+// config IDs are in use.
+func DeriveConfigID(publicKey []byte, keys []Key) (uint8, error) {
+	used := make(map[uint8]bool)
+	for _, key := range keys {
+		spec, err := Config(key.Config).Spec()
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(spec.PublicKey, publicKey) {
+			return spec.ID, nil
+		}
+		used[spec.ID] = true
+	}
+	h := sha256.Sum256(publicKey)
+	for i := range 256 {
+		if id := h[0] + uint8(i); !used[id] {
+			return id, nil
+		}
+	}
+	return 0, errors.New("no config id available")
+}
+
+// checkKey verifies that privKey is a valid private key for the config's KEM
+// and that it corresponds to the config's public key.
+func checkKey(spec ConfigSpec, privKey []byte) error {
+	kem, err := hpke.NewKEM(spec.KEM)
+	if err != nil {
+		return fmt.Errorf("unsupported KEM 0x%04x: %w", spec.KEM, err)
+	}
+	pk, err := kem.NewPrivateKey(privKey)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+	if !bytes.Equal(pk.PublicKey().Bytes(), spec.PublicKey) {
+		return errors.New("private key doesn't match config")
+	}
+	return nil
+}
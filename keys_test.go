@@ -0,0 +1,90 @@
+package ech
+
+import (
+	"testing"
+)
+
+func TestValidateKeys(t *testing.T) {
+	privKey1, config1, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	privKey2, config2, err := NewConfigWithOptions(2, []byte("public.example.com"), WithKEM(KEMP256))
+	if err != nil {
+		t.Fatalf("NewConfigWithOptions: %v", err)
+	}
+	keys := []Key{
+		{Config: config1, PrivateKey: privKey1.Bytes()},
+		{Config: config2, PrivateKey: privKey2},
+	}
+	if err := ValidateKeys(keys); err != nil {
+		t.Errorf("ValidateKeys() = %v", err)
+	}
+	if err := ValidateKeys([]Key{{Config: config1, PrivateKey: make([]byte, 32)}}); err == nil {
+		t.Error("ValidateKeys() with wrong private key succeeded unexpectedly")
+	}
+	if err := ValidateKeys([]Key{keys[0], keys[0]}); err == nil {
+		t.Error("ValidateKeys() with duplicate IDs succeeded unexpectedly")
+	}
+
+	configList, err := ConfigList([]Config{config1, config2})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if err := MatchConfigList(configList, keys); err != nil {
+		t.Errorf("MatchConfigList() = %v", err)
+	}
+	if err := MatchConfigList(configList, keys[:1]); err == nil {
+		t.Error("MatchConfigList() with missing key succeeded unexpectedly")
+	}
+	partial, err := ConfigList([]Config{config1})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	if err := MatchConfigList(partial, keys); err == nil {
+		t.Error("MatchConfigList() with unpublished key succeeded unexpectedly")
+	}
+}
+
+func TestDeriveConfigID(t *testing.T) {
+	privKey, config, err := NewConfig(0, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	pub := privKey.PublicKey().Bytes()
+	id, err := DeriveConfigID(pub, nil)
+	if err != nil {
+		t.Fatalf("DeriveConfigID: %v", err)
+	}
+	if id2, err := DeriveConfigID(pub, nil); err != nil || id2 != id {
+		t.Errorf("DeriveConfigID() = %d, %v, want %d", id2, err, id)
+	}
+
+	// Another key with the same ID.
+	spec, err := config.Spec()
+	if err != nil {
+		t.Fatalf("Spec: %v", err)
+	}
+	spec.ID = id
+	spec.PublicKey = make([]byte, 32)
+	other, err := spec.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	keys := []Key{{Config: other}}
+	if got, err := DeriveConfigID(pub, keys); err != nil || got != id+1 {
+		t.Errorf("DeriveConfigID() = %d, %v, want %d", got, err, id+1)
+	}
+
+	// The key itself is in the key set.
+	spec.ID = 42
+	spec.PublicKey = pub
+	self, err := spec.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes: %v", err)
+	}
+	keys = append(keys, Key{Config: self})
+	if got, err := DeriveConfigID(pub, keys); err != nil || got != 42 {
+		t.Errorf("DeriveConfigID() = %d, %v, want 42", got, err)
+	}
+}
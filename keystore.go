@@ -0,0 +1,265 @@
+package ech
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrKeyStoreDecrypt is returned by [KeyStore.Load] when the key store can't be
+// decrypted, e.g. because the passphrase is wrong.
+var ErrKeyStoreDecrypt = errors.New("key store decryption failed")
+
+const keyStoreMagic = "ECHKEYS1"
+
+// KeyWrapper encrypts and decrypts the content of a [KeyStore]. It can be
+// implemented with a Key Management Service (KMS), or with
+// [NewPassphraseKeyWrapper].
+type KeyWrapper interface {
+	Wrap(plaintext []byte) ([]byte, error)
+	Unwrap(ciphertext []byte) ([]byte, error)
+}
+
+// NewPassphraseKeyWrapper returns a [KeyWrapper] that encrypts data with
+// AES-256-GCM, using a key derived from passphrase with scrypt.
+func NewPassphraseKeyWrapper(passphrase []byte) KeyWrapper {
+	return &passphraseKeyWrapper{passphrase: passphrase}
+}
+
+type passphraseKeyWrapper struct {
+	passphrase []byte
+}
+
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptSaltSz = 16
+)
+
+func (w *passphraseKeyWrapper) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(w.passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap encrypts plaintext. The output is salt || nonce || ciphertext.
+func (w *passphraseKeyWrapper) Wrap(plaintext []byte) ([]byte, error) {
+	salt := make([]byte, scryptSaltSz)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := w.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := append(salt, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Unwrap decrypts ciphertext produced by Wrap.
+func (w *passphraseKeyWrapper) Unwrap(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < scryptSaltSz {
+		return nil, ErrKeyStoreDecrypt
+	}
+	aead, err := w.aead(ciphertext[:scryptSaltSz])
+	if err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[scryptSaltSz:]
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrKeyStoreDecrypt
+	}
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrKeyStoreDecrypt
+	}
+	return plaintext, nil
+}
+
+// NewKeyStore returns a [KeyStore] that stores keys in the file at path,
+// encrypted with wrapper.
+func NewKeyStore(path string, wrapper KeyWrapper) *KeyStore {
+	return &KeyStore{
+		path:    path,
+		wrapper: wrapper,
+	}
+}
+
+// KeyStore persists Encrypted Client Hello (ECH) keys in a file, encrypted at
+// rest. The keys are typically loaded when the server starts, and saved every
+// time they are rotated.
+//
+//	store := ech.NewKeyStore("/path/to/keys", ech.NewPassphraseKeyWrapper(passphrase))
+//	keys, err := store.Rotate([]byte("public.example.com"), 3)
+//	...
+//	conn, err := ech.NewConn(ctx, serverConn, ech.WithKeys(keys))
+type KeyStore struct {
+	path    string
+	wrapper KeyWrapper
+	mu      sync.Mutex
+}
+
+// Load reads and decrypts the keys from the key store. It returns an error
+// that matches [fs.ErrNotExist] if the key store doesn't exist yet.
+func (s *KeyStore) Load() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+func (s *KeyStore) load() ([]Key, error) {
+	b, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(keyStoreMagic) || string(b[:len(keyStoreMagic)]) != keyStoreMagic {
+		return nil, fmt.Errorf("%s: invalid key store", s.path)
+	}
+	plaintext, err := s.wrapper.Unwrap(b[len(keyStoreMagic):])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	keys, err := decodeKeys(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return keys, nil
+}
+
+// Save encrypts and writes the keys to the key store, replacing any keys that
+// were there before. The file is replaced atomically.
+func (s *KeyStore) Save(keys []Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save(keys)
+}
+
+func (s *KeyStore) save(keys []Key) error {
+	plaintext, err := encodeKeys(keys)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := s.wrapper.Wrap(plaintext)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append([]byte(keyStoreMagic), ciphertext...))
+}
+
+// writeFileAtomic replaces the file at path with data atomically.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Rotate generates a new key with [NewConfigWithOptions], with a config ID
+// from [DeriveConfigID], and saves it to the
+// key store along with the existing keys, keeping at most maxKeys keys. The
+// new key is first in the list, and the oldest keys are removed. The key
+// store is created if it doesn't exist. Rotate returns the updated list of
+// keys.
+func (s *KeyStore) Rotate(publicName []byte, maxKeys int, opts ...ConfigOption) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, err := s.load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if maxKeys < 1 {
+		maxKeys = 1
+	}
+	keys = keys[:min(len(keys), maxKeys-1)]
+
+	privKey, config, err := NewConfigWithOptions(0, publicName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := config.Spec()
+	if err != nil {
+		return nil, err
+	}
+	if spec.ID, err = DeriveConfigID(spec.PublicKey, keys); err != nil {
+		return nil, err
+	}
+	if config, err = spec.Bytes(); err != nil {
+		return nil, err
+	}
+	keys = append([]Key{{Config: config, PrivateKey: privKey, SendAsRetry: true}}, keys...)
+	if err := s.save(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func encodeKeys(keys []Key) ([]byte, error) {
+	b := cryptobyte.NewBuilder(nil)
+	for _, k := range keys {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(k.Config)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(k.PrivateKey)
+		})
+		var retry uint8
+		if k.SendAsRetry {
+			retry = 1
+		}
+		b.AddUint8(retry)
+	}
+	return b.Bytes()
+}
+
+func decodeKeys(data []byte) ([]Key, error) {
+	s := cryptobyte.String(data)
+	var keys []Key
+	for !s.Empty() {
+		var config, privKey cryptobyte.String
+		var retry uint8
+		if !s.ReadUint16LengthPrefixed(&config) || !s.ReadUint16LengthPrefixed(&privKey) || !s.ReadUint8(&retry) {
+			return nil, ErrDecodeError
+		}
+		keys = append(keys, Key{
+			Config:      slices.Clone([]byte(config)),
+			PrivateKey:  slices.Clone([]byte(privKey)),
+			SendAsRetry: retry != 0,
+		})
+	}
+	return keys, nil
+}
@@ -0,0 +1,63 @@
+package ech
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	store := NewKeyStore(path, NewPassphraseKeyWrapper([]byte("secret")))
+
+	if _, err := store.Load(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load() = %v, want ErrNotExist", err)
+	}
+	var ids []uint8
+	for range 3 {
+		keys, err := store.Rotate([]byte("public.example.com"), 2)
+		if err != nil {
+			t.Fatalf("Rotate: %v", err)
+		}
+		spec, err := Config(keys[0].Config).Spec()
+		if err != nil {
+			t.Fatalf("Spec: %v", err)
+		}
+		ids = append([]uint8{spec.ID}, ids...)
+	}
+	keys, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := len(keys), 2; got != want {
+		t.Fatalf("len(keys) = %d, want %d", got, want)
+	}
+	for i, k := range keys {
+		spec, err := Config(k.Config).Spec()
+		if err != nil {
+			t.Fatalf("Spec: %v", err)
+		}
+		if spec.ID != ids[i] {
+			t.Errorf("keys[%d].ID = %d, want %d", i, spec.ID, ids[i])
+		}
+		if !k.SendAsRetry {
+			t.Errorf("keys[%d].SendAsRetry = false", i)
+		}
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if bytes.Contains(raw, keys[0].PrivateKey) {
+		t.Error("key store contains plaintext private key")
+	}
+
+	other := NewKeyStore(path, NewPassphraseKeyWrapper([]byte("wrong")))
+	if _, err := other.Load(); !errors.Is(err, ErrKeyStoreDecrypt) {
+		t.Errorf("Load() = %v, want ErrKeyStoreDecrypt", err)
+	}
+}
@@ -0,0 +1,36 @@
+package ech
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+var defaultLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger that this package uses when a [Resolver],
+// [Dialer], [Transport], or [Conn] doesn't have its own logger. The default is
+// [slog.Default]. A nil value restores the default. To disable logging, use a
+// logger with [slog.DiscardHandler].
+//
+// Warnings are logged at the Warn level, e.g. when an alias chain is too long,
+// and the details of connections at the Debug level.
+func SetLogger(l *slog.Logger) {
+	defaultLogger.Store(l)
+}
+
+// logger returns l, or the package's logger if l is nil.
+func logger(l *slog.Logger) *slog.Logger {
+	if l != nil {
+		return l
+	}
+	if l := defaultLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// debugEnabled indicates whether l logs debug messages.
+func debugEnabled(l *slog.Logger) bool {
+	return l.Enabled(context.Background(), slog.LevelDebug)
+}
@@ -0,0 +1,94 @@
+package ech
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func newTestLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), &buf
+}
+
+func TestLogger(t *testing.T) {
+	db := []dns.RR{
+		testutil.HTTPSAlias("loop1.example.com", "loop2.example.com"),
+		testutil.HTTPSAlias("loop2.example.com", "loop1.example.com"),
+		{
+			Name: "loop1.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 3},
+		},
+	}
+	dnsServer := testutil.StartTestDNSServer(t, db)
+	defer dnsServer.Close()
+
+	// The package's logger is used by default.
+	pkgLogger, pkgBuf := newTestLogger()
+	SetLogger(pkgLogger)
+	t.Cleanup(func() { SetLogger(nil) })
+
+	resolver, err := NewResolver("http://" + dnsServer.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	if _, err := resolver.Resolve(t.Context(), "loop1.example.com"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got, want := pkgBuf.String(), `level=WARN msg="ech: alias loop detected" name=loop1.example.com`; !strings.Contains(got, want) {
+		t.Errorf("package log = %q, want %q", got, want)
+	}
+
+	// The Resolver's own logger takes precedence.
+	pkgBuf.Reset()
+	resLogger, resBuf := newTestLogger()
+	resolver.SetLogger(resLogger)
+	resolver.SetCacheSize(0)
+	if _, err := resolver.Resolve(t.Context(), "loop1.example.com"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if got := pkgBuf.String(); got != "" {
+		t.Errorf("package log = %q, want empty", got)
+	}
+	if got, want := resBuf.String(), "alias loop detected"; !strings.Contains(got, want) {
+		t.Errorf("resolver log = %q, want %q", got, want)
+	}
+
+	// Conn logs the handshake messages and the errors at the Debug level.
+	connLogger, connBuf := newTestLogger()
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	keys := []Key{{Config: config, PrivateKey: privKey.Bytes()}}
+	inner := newClientHello("private", "echExtInner", "tls1.3")
+	outer := newClientHello("public", "tls1.3", config, privKey.PublicKey(), inner)
+	alert := []byte{21, 3, 3, 0, 2, 1, 0}
+	conn, err := NewConn(t.Context(), newFakeConn(append(outer.bytes(), alert...)), WithKeys(keys), WithLogger(connLogger))
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("readRecord: %v", err)
+	}
+	if _, err := readRecord(conn); err != nil {
+		t.Fatalf("readRecord: %v", err)
+	}
+	if got, want := connBuf.String(), `level=DEBUG msg="ech: Read alert(21)"`; !strings.Contains(got, want) {
+		t.Errorf("conn log = %q, want %q", got, want)
+	}
+	if _, err := NewConn(t.Context(), newFakeConn([]byte{22, 3, 1, 0, 1, 0}), WithLogger(connLogger)); err == nil {
+		t.Fatal("NewConn succeeded with an invalid ClientHello")
+	}
+	if got, want := connBuf.String(), `msg="ech: connection aborted"`; !strings.Contains(got, want) {
+		t.Errorf("conn log = %q, want %q", got, want)
+	}
+	if got := pkgBuf.String(); got != "" {
+		t.Errorf("package log = %q, want empty", got)
+	}
+}
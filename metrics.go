@@ -0,0 +1,21 @@
+package ech
+
+import "time"
+
+// DialerMetrics receives metrics from a [Dialer]. The methods are called
+// synchronously from the Dialer's goroutines. Implementations must be safe for
+// concurrent use and should return quickly.
+type DialerMetrics interface {
+	// ResolveDone is called after each name resolution.
+	ResolveDone(name string, duration time.Duration, err error)
+	// AttemptDone is called after each connection attempt. echOffered
+	// reports whether an ECH config list was offered to the server.
+	AttemptDone(addr string, echOffered bool, duration time.Duration, err error)
+	// Fallback is called when a connection to addr is attempted without
+	// ECH.
+	Fallback(addr string)
+	// DialDone is called when Dial returns. attempts is the number of
+	// connection attempts. echAccepted is only true when the connection
+	// type reports it, e.g. [tls.Conn].
+	DialDone(attempts int, echAccepted bool, duration time.Duration, err error)
+}
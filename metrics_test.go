@@ -0,0 +1,108 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *testMetrics) add(format string, args ...any) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf(format, args...))
+}
+
+func (m *testMetrics) ResolveDone(name string, _ time.Duration, err error) {
+	m.add("resolve %s %s", name, ErrorClass(err))
+}
+
+func (m *testMetrics) AttemptDone(addr string, echOffered bool, _ time.Duration, err error) {
+	m.add("attempt %s %v %s", addr, echOffered, ErrorClass(err))
+}
+
+func (m *testMetrics) Fallback(addr string) {
+	m.add("fallback %s", addr)
+}
+
+func (m *testMetrics) DialDone(attempts int, echAccepted bool, _ time.Duration, err error) {
+	m.add("dial %d %v %s", attempts, echAccepted, ErrorClass(err))
+}
+
+func TestDialerMetrics(t *testing.T) {
+	addr, _, rootCAs := startTestECHServer(t)
+	_, wrongConfig, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	wrongConfigList, err := ConfigList([]Config{wrongConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+
+	metrics := &testMetrics{}
+	dialer := NewDialer()
+	dialer.Metrics = metrics
+	conn, err := dialer.Dial(t.Context(), "tcp", addr, &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: wrongConfigList,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+	conn, err = dialer.Dial(t.Context(), "tcp", addr, &tls.Config{
+		ServerName: "private.example.com",
+		RootCAs:    rootCAs,
+	})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+
+	want := []string{
+		"resolve " + addr + " none",
+		"attempt " + addr + " true ech_rejected",
+		"attempt " + addr + " true none",
+		"dial 2 true none",
+		"resolve " + addr + " none",
+		"fallback " + addr,
+		"attempt " + addr + " false none",
+		"dial 1 false none",
+	}
+	if !slices.Equal(metrics.events, want) {
+		t.Errorf("events = %q, want %q", metrics.events, want)
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{nil, ErrorClassNone},
+		{fmt.Errorf("foo: %w", context.Canceled), ErrorClassCanceled},
+		{context.DeadlineExceeded, ErrorClassTimeout},
+		{&tls.ECHRejectionError{}, ErrorClassECHRejected},
+		{ErrECHNotAccepted, ErrorClassECHRequired},
+		{fmt.Errorf("%w: foo", ErrNonExistentDomain), ErrorClassDNS},
+		{syscall.ECONNREFUSED, ErrorClassRefused},
+		{tls.AlertError(40), ErrorClassTLS},
+		{errors.New("foo"), ErrorClassOther},
+	} {
+		if got := ErrorClass(tc.err); got != tc.want {
+			t.Errorf("ErrorClass(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ech
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+const (
+	pemTypePrivateKey = "PRIVATE KEY"
+	pemTypeECHConfig  = "ECHCONFIG"
+)
+
+// MarshalPEM encodes an Encrypted Client Hello (ECH) key and its config in the
+// PEM format used by other ECH servers, e.g. OpenSSL, nginx, and lighttpd. The
+// output contains a PKCS #8 PRIVATE KEY block followed by an ECHCONFIG block
+// with a Config List that contains key.Config.
+func MarshalPEM(key Key) ([]byte, error) {
+	spec, err := Config(key.Config).Spec()
+	if err != nil {
+		return nil, err
+	}
+	curve, err := kemCurve(spec.KEM)
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := curve.NewPrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(privKey.PublicKey().Bytes(), spec.PublicKey) {
+		return nil, errors.New("private key doesn't match config")
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, err
+	}
+	configList, err := ConfigList([]Config{key.Config})
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, &pem.Block{Type: pemTypePrivateKey, Bytes: der}); err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(&buf, &pem.Block{Type: pemTypeECHConfig, Bytes: configList}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ParsePEM decodes an Encrypted Client Hello (ECH) key and its config encoded
+// with [MarshalPEM], or by other ECH servers that use the same format. The
+// ECHCONFIG block must contain exactly one config. The returned key has
+// SendAsRetry set to true.
+func ParsePEM(data []byte) (Key, error) {
+	var der, configList []byte
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		switch block.Type {
+		case pemTypePrivateKey:
+			if der != nil {
+				return Key{}, errors.New("multiple private keys")
+			}
+			der = block.Bytes
+		case pemTypeECHConfig:
+			if configList != nil {
+				return Key{}, errors.New("multiple ech configs")
+			}
+			configList = block.Bytes
+		}
+	}
+	if der == nil {
+		return Key{}, errors.New("missing private key")
+	}
+	if configList == nil {
+		return Key{}, errors.New("missing ech config")
+	}
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(configs) != 1 {
+		return Key{}, fmt.Errorf("ech config list contains %d configs, want 1", len(configs))
+	}
+	spec, err := configs[0].Spec()
+	if err != nil {
+		return Key{}, err
+	}
+	curve, err := kemCurve(spec.KEM)
+	if err != nil {
+		return Key{}, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return Key{}, err
+	}
+	var privKey *ecdh.PrivateKey
+	switch k := k.(type) {
+	case *ecdh.PrivateKey:
+		privKey = k
+	case *ecdsa.PrivateKey:
+		if privKey, err = k.ECDH(); err != nil {
+			return Key{}, err
+		}
+	default:
+		return Key{}, fmt.Errorf("unsupported private key type %T", k)
+	}
+	if privKey.Curve() != curve || !bytes.Equal(privKey.PublicKey().Bytes(), spec.PublicKey) {
+		return Key{}, errors.New("private key doesn't match config")
+	}
+	return Key{
+		Config:      configs[0],
+		PrivateKey:  privKey.Bytes(),
+		SendAsRetry: true,
+	}, nil
+}
+
+// kemCurve returns the curve used by a DHKEM.
+func kemCurve(kem uint16) (ecdh.Curve, error) {
+	switch kem {
+	case KEMP256:
+		return ecdh.P256(), nil
+	case KEMP384:
+		return ecdh.P384(), nil
+	case KEMP521:
+		return ecdh.P521(), nil
+	case KEMX25519:
+		return ecdh.X25519(), nil
+	default:
+		return nil, fmt.Errorf("unsupported KEM 0x%04x", kem)
+	}
+}
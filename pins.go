@@ -0,0 +1,226 @@
+package ech
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrConfigPinMismatch is returned by [ConfigPinStore.Check] when the ECH
+// config list of a host doesn't match the one that was pinned.
+var ErrConfigPinMismatch = errors.New("ech config pin mismatch")
+
+// NewConfigPinStore returns a [ConfigPinStore] that persists the pins in the
+// file at path. The pins that already exist in the file are loaded. If path is
+// empty, the pins are only kept in memory. Pins expire when they haven't been
+// confirmed for maxAge. A zero maxAge means that pins don't expire.
+func NewConfigPinStore(path string, maxAge time.Duration) (*ConfigPinStore, error) {
+	s := &ConfigPinStore{
+		path:   path,
+		maxAge: maxAge,
+		pins:   make(map[string]configPin),
+		now:    time.Now,
+	}
+	if path == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.pins); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// ConfigPinStore remembers the ECH public keys that were last used
+// successfully with each host, i.e. trust on first use. It protects against
+// a malicious DNS resolver that swaps or removes a host's ECH config list to
+// force the client to fall back to the plaintext server name.
+//
+// A host is pinned with [ConfigPinStore.Pin] after ECH was accepted by the
+// server. After that, [ConfigPinStore.Check] fails until the pin expires if
+// the host's config list doesn't contain any of the pinned keys. When used
+// with a [Dialer], such a host can still rotate its keys as long as it keeps
+// a pinned key in its retry configs for a while, see [Dialer.ConfigPins].
+type ConfigPinStore struct {
+	path   string
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	pins map[string]configPin
+}
+
+type configPin struct {
+	Keys []string  `json:"keys"`
+	Seen time.Time `json:"seen"`
+}
+
+// Check verifies that configList matches the pin for host, if there is one.
+// A nil configList only matches when host isn't pinned.
+func (s *ConfigPinStore) Check(host string, configList []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pin, ok := s.pins[pinKey(host)]
+	if !ok || s.expired(pin) {
+		return nil
+	}
+	keys, err := configListKeys(configList)
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if slices.Contains(pin.Keys, k) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %w", host, ErrConfigPinMismatch)
+}
+
+// Pin records the keys of configList for host, replacing any previous pin.
+// It should be called after the server accepted ECH with configList. When
+// host is already pinned with the same keys, the pin is only confirmed, and
+// saved, once per tenth of maxAge, or once per day if pins don't expire.
+func (s *ConfigPinStore) Pin(host string, configList []byte) error {
+	keys, err := configListKeys(configList)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.New("no usable configs")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().UTC()
+	if pin, ok := s.pins[pinKey(host)]; ok && sameKeys(pin.Keys, keys) && now.Sub(pin.Seen) < s.confirmInterval() {
+		return nil
+	}
+	s.pins[pinKey(host)] = configPin{Keys: keys, Seen: now}
+	return s.save()
+}
+
+// confirmInterval returns how often a pin with unchanged keys is confirmed.
+func (s *ConfigPinStore) confirmInterval() time.Duration {
+	if s.maxAge > 0 {
+		return s.maxAge / 10
+	}
+	return 24 * time.Hour
+}
+
+// pinnedConfigs returns the configs from configList whose keys are pinned for
+// host, or nil if there are none.
+func (s *ConfigPinStore) pinnedConfigs(host string, configList []byte) []byte {
+	s.mu.Lock()
+	pin, ok := s.pins[pinKey(host)]
+	s.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	configs, err := splitConfigList(configList)
+	if err != nil {
+		return nil
+	}
+	var pinned []Config
+	for _, cfg := range configs {
+		spec, err := cfg.Spec()
+		if err != nil || !spec.supported() {
+			continue
+		}
+		if slices.Contains(pin.Keys, fmt.Sprintf("%x", sha256.Sum256(spec.PublicKey))) {
+			pinned = append(pinned, cfg)
+		}
+	}
+	if len(pinned) == 0 {
+		return nil
+	}
+	out, err := ConfigList(pinned)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// Forget removes the pin for host.
+func (s *ConfigPinStore) Forget(host string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pins, pinKey(host))
+	return s.save()
+}
+
+func (s *ConfigPinStore) expired(pin configPin) bool {
+	return s.maxAge > 0 && s.now().Sub(pin.Seen) > s.maxAge
+}
+
+func (s *ConfigPinStore) save() error {
+	for host, pin := range s.pins {
+		if s.expired(pin) {
+			delete(s.pins, host)
+		}
+	}
+	if s.path == "" {
+		return nil
+	}
+	b, err := json.Marshal(s.pins)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// sameKeys reports whether a and b contain the same keys.
+func sameKeys(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
+
+func pinKey(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// configListKeys returns the SHA-256 hashes of the public keys of the usable
+// configs in configList.
+func configListKeys(configList []byte) ([]string, error) {
+	if configList == nil {
+		return nil, nil
+	}
+	specs, err := ParseConfigList(configList)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		if !spec.supported() {
+			continue
+		}
+		keys = append(keys, fmt.Sprintf("%x", sha256.Sum256(spec.PublicKey)))
+	}
+	return keys, nil
+}
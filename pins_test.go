@@ -0,0 +1,106 @@
+package ech
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigPinStore(t *testing.T) {
+	newConfigList := func(id uint8) []byte {
+		_, config, err := NewConfig(id, []byte("public.example.com"))
+		if err != nil {
+			t.Fatalf("NewConfig: %v", err)
+		}
+		configList, err := ConfigList([]Config{config})
+		if err != nil {
+			t.Fatalf("ConfigList: %v", err)
+		}
+		return configList
+	}
+	list1, list2 := newConfigList(1), newConfigList(2)
+	both, err := MergeConfigLists(list1, list2)
+	if err != nil {
+		t.Fatalf("MergeConfigLists: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "pins.json")
+	store, err := NewConfigPinStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewConfigPinStore: %v", err)
+	}
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	if err := store.Check("example.com", list1); err != nil {
+		t.Errorf("Check() before Pin = %v", err)
+	}
+	if err := store.Pin("example.com", list1); err != nil {
+		t.Fatalf("Pin: %v", err)
+	}
+
+	// Reload from disk.
+	if store, err = NewConfigPinStore(path, time.Hour); err != nil {
+		t.Fatalf("NewConfigPinStore: %v", err)
+	}
+	store.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		host       string
+		configList []byte
+		want       error
+	}{
+		{"example.com", list1, nil},
+		{"Example.COM.", list1, nil},
+		{"example.com", both, nil},
+		{"example.com", list2, ErrConfigPinMismatch},
+		{"example.com", nil, ErrConfigPinMismatch},
+		{"other.example.com", list2, nil},
+		{"other.example.com", nil, nil},
+	} {
+		if err := store.Check(tc.host, tc.configList); !errors.Is(err, tc.want) {
+			t.Errorf("Check(%q) = %v, want %v", tc.host, err, tc.want)
+		}
+	}
+
+	now = now.Add(2 * time.Hour)
+	if err := store.Check("example.com", list2); err != nil {
+		t.Errorf("Check() after expiry = %v", err)
+	}
+	now = now.Add(-2 * time.Hour)
+
+	// The pin is only saved again when the keys change, or when it needs
+	// to be confirmed.
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	for _, tc := range []struct {
+		advance    time.Duration
+		configList []byte
+		wantSaved  bool
+	}{
+		{time.Minute, list1, false},
+		{time.Minute, both, true},
+		{time.Minute, both, false},
+		{10 * time.Minute, both, true},
+	} {
+		now = now.Add(tc.advance)
+		if err := store.Pin("example.com", tc.configList); err != nil {
+			t.Fatalf("Pin: %v", err)
+		}
+		_, err := os.Stat(path)
+		if got := err == nil; got != tc.wantSaved {
+			t.Errorf("Pin(%v) saved = %v, want %v", tc.advance, got, tc.wantSaved)
+		}
+		os.Remove(path)
+	}
+
+	if err := store.Forget("example.com"); err != nil {
+		t.Fatalf("Forget: %v", err)
+	}
+	if err := store.Check("example.com", list2); err != nil {
+		t.Errorf("Check() after Forget = %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package ech
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ErrPlaintextHTTP is returned by [NewConn] when a plaintext HTTP request was
+// received and served instead of a TLS connection. See
+// [WithPlaintextHTTPHandler].
+var ErrPlaintextHTTP = errors.New("plaintext http request")
+
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// HTTPSRedirectHandler returns a [http.Handler] that redirects all requests to
+// the same URL with the https scheme.
+func HTTPSRedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, req, "https://"+host+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// isPlaintextHTTP returns true if hdr, the first 5 bytes received on a
+// connection, look like the beginning of a HTTP request.
+func isPlaintextHTTP(hdr []byte) bool {
+	for _, m := range httpMethods {
+		m += " "
+		if len(m) > len(hdr) {
+			m = m[:len(hdr)]
+		}
+		if bytes.HasPrefix(hdr, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// servePlaintextHTTP serves one HTTP request with h, and then closes conn. The
+// request is read from r.
+func servePlaintextHTTP(conn net.Conn, r io.Reader, h http.Handler) {
+	c := &httpConn{Conn: conn, r: r, closed: make(chan struct{})}
+	srv := &http.Server{
+		Handler: h,
+	}
+	srv.SetKeepAlivesEnabled(false)
+	srv.Serve(&oneConnListener{conn: c})
+	<-c.closed
+}
+
+// httpConn is a net.Conn that reads from r and signals when it is closed.
+type httpConn struct {
+	net.Conn
+	r      io.Reader
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *httpConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *httpConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { close(c.closed) })
+	return err
+}
+
+// oneConnListener is a net.Listener that returns a single connection.
+type oneConnListener struct {
+	conn net.Conn
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	if l.conn == nil {
+		return nil, net.ErrClosed
+	}
+	c := l.conn
+	l.conn = nil
+	return c, nil
+}
+
+func (l *oneConnListener) Close() error {
+	return nil
+}
+
+func (l *oneConnListener) Addr() net.Addr {
+	if l.conn == nil {
+		return &net.TCPAddr{}
+	}
+	return l.conn.LocalAddr()
+}
@@ -0,0 +1,57 @@
+package ech
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestPlaintextHTTPRedirect(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ch := make(chan *http.Response)
+	go func() {
+		defer close(ch)
+		if _, err := client.Write([]byte("GET /foo?bar=1 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")); err != nil {
+			t.Errorf("client.Write: %v", err)
+			return
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Errorf("http.ReadResponse: %v", err)
+			return
+		}
+		resp.Body.Close()
+		ch <- resp
+	}()
+
+	if _, err := NewConn(t.Context(), server, WithHTTPSRedirect()); !errors.Is(err, ErrPlaintextHTTP) {
+		t.Fatalf("NewConn: %v, want ErrPlaintextHTTP", err)
+	}
+	resp := <-ch
+	if resp == nil {
+		t.FailNow()
+	}
+	if got, want := resp.StatusCode, http.StatusMovedPermanently; got != want {
+		t.Errorf("StatusCode = %d, want %d", got, want)
+	}
+	if got, want := resp.Header.Get("Location"), "https://example.com/foo?bar=1"; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+}
+
+func TestPlaintextHTTPWithTLS(t *testing.T) {
+	outer := newClientHello("private", "tls1.3")
+	c := newFakeConn(outer.bytes())
+
+	conn, err := NewConn(t.Context(), c, WithHTTPSRedirect())
+	if err != nil {
+		t.Fatalf("NewConn: %v", err)
+	}
+	if got, want := conn.ServerName(), "private.example.com"; got != want {
+		t.Errorf("ServerName() = %q, want %q", got, want)
+	}
+}
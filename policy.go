@@ -0,0 +1,44 @@
+package ech
+
+import "context"
+
+// DialPolicy overrides some of the [Dialer]'s settings for the host names that
+// match a pattern of [Dialer.Policies].
+type DialPolicy struct {
+	// RequireECH is like Dialer.RequireECH, for the matching hosts only.
+	RequireECH bool
+	// RequireECHAccepted is like Dialer.RequireECHAccepted, for the
+	// matching hosts only.
+	RequireECHAccepted bool
+	// AllowPlaintextSNI lets the matching hosts be dialed without ECH when
+	// no config list is available, even when the Dialer's RequireECH or
+	// RequireECHAccepted is set. It doesn't apply to the dials that use a
+	// context from [WithRequireECH].
+	AllowPlaintextSNI bool
+	// PublicName, if set, is used instead of Dialer.PublicName for the
+	// matching hosts.
+	PublicName string
+}
+
+// policy returns the DialPolicy of host, or the zero value when no pattern
+// matches.
+func (d *Dialer[T]) policy(host string) DialPolicy {
+	if len(d.Policies) == 0 {
+		return DialPolicy{}
+	}
+	for p := range namePatterns(normalizeServerName(host)) {
+		if policy, ok := d.Policies[p]; ok {
+			return policy
+		}
+	}
+	return DialPolicy{}
+}
+
+// requireECH returns whether ECH must be used with host, and whether the
+// server must accept it.
+func (d *Dialer[T]) requireECH(ctx context.Context, host string) (require, requireAccepted bool) {
+	p := d.policy(host)
+	requireAccepted = (d.RequireECHAccepted || p.RequireECHAccepted) && !p.AllowPlaintextSNI
+	require = (d.RequireECH || p.RequireECH) && !p.AllowPlaintextSNI
+	return require || requireAccepted || requireECHFromContext(ctx), requireAccepted
+}
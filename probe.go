@@ -0,0 +1,187 @@
+package ech
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// ProbeReport is the result of [Probe]. The errors are recorded as strings so
+// that the report can be serialized, e.g. to JSON for monitoring dashboards.
+type ProbeReport struct {
+	// Host is the name of the host that was probed.
+	Host string
+	// DNSHasECH indicates whether the host's HTTPS records have an ech
+	// parameter.
+	DNSHasECH bool
+	// DNSConfigs are the ECH configs published in DNS, with their config
+	// IDs and cipher suites.
+	DNSConfigs []ConfigSpec
+	// PublicName is the name used in the outer ClientHello of the GREASE
+	// connection. It is the public name of the configs published in DNS,
+	// or Host if there are none.
+	PublicName string
+	// RetryConfigsPresented indicates whether the server returned
+	// retry_configs when it rejected the GREASE config.
+	RetryConfigsPresented bool
+	// RetryConfigs are the ECH configs that the server returned in
+	// retry_configs.
+	RetryConfigs []ConfigSpec
+	// GREASEError is set when the GREASE connection failed for a reason
+	// other than the rejection of ECH, e.g. when the server is
+	// unreachable, or when its certificate isn't valid for PublicName.
+	GREASEError string
+	// ECHAccepted indicates whether the server accepted ECH in a handshake
+	// with the configs published in DNS. It is always false when DNS
+	// doesn't have any.
+	ECHAccepted bool
+	// ECHError is set when the handshake with the configs published in
+	// DNS failed, e.g. because the server rejected them.
+	ECHError string
+	// Duration is the total time spent probing the host.
+	Duration time.Duration
+}
+
+// Probe checks the Encrypted Client Hello deployment of host, with
+// [DefaultResolver]. The host may include a port number. Otherwise, the port
+// number from DNS or 443 is used.
+//
+// Probe is equivalent to:
+//
+//	(&Prober{}).Probe(ctx, host)
+func Probe(ctx context.Context, host string) (*ProbeReport, error) {
+	return (&Prober{}).Probe(ctx, host)
+}
+
+// Prober contains options for probing the Encrypted Client Hello deployment of
+// hosts.
+type Prober struct {
+	// Resolver specifies the resolver to use for DNS lookups. If nil,
+	// DefaultResolver is used.
+	Resolver *Resolver
+	// TLSConfig, if set, is used as a template for the TLS connections,
+	// e.g. with RootCAs. Its ServerName and
+	// EncryptedClientHelloConfigList are ignored.
+	TLSConfig *tls.Config
+	// Timeout is the amount of time to wait for each connection to be
+	// established. The default value is 10s.
+	Timeout time.Duration
+}
+
+// Probe looks up the ECH configs of host in DNS, and connects to it twice:
+//
+//   - with a GREASE config, i.e. a valid config with a random key that the
+//     server can't decrypt, which makes the server reject ECH and, if it is
+//     configured to do so, return its current configs in retry_configs.
+//   - with the configs published in DNS, if any, to check that the server
+//     accepts them.
+//
+// An error is returned only when the name resolution fails. Connection errors
+// are recorded in the report.
+func (p *Prober) Probe(ctx context.Context, host string) (*ProbeReport, error) {
+	start := time.Now()
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	resolver := p.Resolver
+	if resolver == nil {
+		resolver = DefaultResolver
+	}
+	result, err := resolver.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	report := &ProbeReport{
+		Host:       name,
+		PublicName: name,
+	}
+	for _, h := range result.HTTPS {
+		if len(h.ECH) == 0 {
+			continue
+		}
+		report.DNSHasECH = true
+		specs, err := ParseConfigList(h.ECH)
+		if err != nil {
+			continue
+		}
+		report.DNSConfigs = append(report.DNSConfigs, specs...)
+	}
+	if len(report.DNSConfigs) > 0 {
+		report.PublicName = string(report.DNSConfigs[0].PublicName)
+	}
+
+	greaseConfigList, err := greaseConfigList(report.PublicName)
+	if err != nil {
+		return nil, err
+	}
+	err = p.connect(ctx, host, resolver, greaseConfigList)
+	var echErr *tls.ECHRejectionError
+	switch {
+	case errors.As(err, &echErr):
+		if len(echErr.RetryConfigList) > 0 {
+			report.RetryConfigsPresented = true
+			report.RetryConfigs, _ = ParseConfigList(echErr.RetryConfigList)
+		}
+	case err != nil:
+		report.GREASEError = err.Error()
+	default:
+		report.GREASEError = "ECH accepted with a GREASE config"
+	}
+
+	if report.DNSHasECH {
+		if err := p.connect(ctx, host, resolver, nil); err != nil {
+			report.ECHError = err.Error()
+		} else {
+			report.ECHAccepted = true
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// connect establishes a TLS connection to host with configList, or with the
+// config list from DNS if configList is nil. It returns nil only if the
+// server accepted ECH.
+func (p *Prober) connect(ctx context.Context, host string, resolver *Resolver, configList []byte) error {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	dialer := NewDialer()
+	dialer.Resolver = resolver
+	dialer.RequireECHAccepted = true
+	dialer.DisableECHRetry = true
+	dialer.Timeout = timeout
+
+	var tc *tls.Config
+	if p.TLSConfig != nil {
+		tc = p.TLSConfig.Clone()
+	} else {
+		tc = &tls.Config{}
+	}
+	tc.ServerName = ""
+	tc.EncryptedClientHelloConfigList = configList
+	conn, err := dialer.Dial(ctx, "tcp", host, tc)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// greaseConfigList returns a config list with a random config for publicName.
+func greaseConfigList(publicName string) ([]byte, error) {
+	id := make([]byte, 1)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	_, config, err := NewConfig(id[0], []byte(publicName))
+	if err != nil {
+		return nil, err
+	}
+	return ConfigList([]Config{config})
+}
@@ -0,0 +1,101 @@
+package ech
+
+import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func TestProbe(t *testing.T) {
+	addr, configList, rootCAs := startTestECHServer(t)
+	addrPort := netip.MustParseAddrPort(addr)
+	_, staleConfig, err := NewConfig(2, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	staleConfigList, err := ConfigList([]Config{staleConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+
+	ip := net.IP(addrPort.Addr().AsSlice())
+	port := addrPort.Port()
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "private.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 1, Port: port, IPv4Hint: []net.IP{ip}, ECH: configList},
+	}, {
+		Name: "stale.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 1, Port: port, IPv4Hint: []net.IP{ip}, ECH: staleConfigList},
+	}, {
+		Name: "noech.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: ip,
+	}})
+	defer dnsServer.Close()
+	resolver, err := NewResolver("http://" + dnsServer.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	prober := &Prober{
+		Resolver:  resolver,
+		TLSConfig: &tls.Config{RootCAs: rootCAs},
+	}
+
+	report, err := prober.Probe(t.Context(), "private.example.com")
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !report.DNSHasECH || len(report.DNSConfigs) != 1 || report.DNSConfigs[0].ID != 1 {
+		t.Errorf("DNSHasECH = %v, DNSConfigs = %v, want true, [ID 1]", report.DNSHasECH, report.DNSConfigs)
+	}
+	if got, want := report.PublicName, "public.example.com"; got != want {
+		t.Errorf("PublicName = %q, want %q", got, want)
+	}
+	if !report.RetryConfigsPresented || len(report.RetryConfigs) != 1 || report.RetryConfigs[0].ID != 1 {
+		t.Errorf("RetryConfigsPresented = %v, RetryConfigs = %v, want true, [ID 1]", report.RetryConfigsPresented, report.RetryConfigs)
+	}
+	if report.GREASEError != "" {
+		t.Errorf("GREASEError = %q", report.GREASEError)
+	}
+	if !report.ECHAccepted || report.ECHError != "" {
+		t.Errorf("ECHAccepted = %v, ECHError = %q, want true, \"\"", report.ECHAccepted, report.ECHError)
+	}
+
+	// The config in DNS is stale. The server rejects it.
+	report, err = prober.Probe(t.Context(), "stale.example.com")
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if !report.DNSHasECH || len(report.DNSConfigs) != 1 || report.DNSConfigs[0].ID != 2 {
+		t.Errorf("DNSHasECH = %v, DNSConfigs = %v, want true, [ID 2]", report.DNSHasECH, report.DNSConfigs)
+	}
+	if !report.RetryConfigsPresented || len(report.RetryConfigs) != 1 || report.RetryConfigs[0].ID != 1 {
+		t.Errorf("RetryConfigsPresented = %v, RetryConfigs = %v, want true, [ID 1]", report.RetryConfigsPresented, report.RetryConfigs)
+	}
+	if report.ECHAccepted || report.ECHError == "" {
+		t.Errorf("ECHAccepted = %v, ECHError = %q, want false, error", report.ECHAccepted, report.ECHError)
+	}
+
+	// Without ECH in DNS, the host name is used as public name. The
+	// server's certificate isn't valid for this name.
+	report, err = prober.Probe(t.Context(), net.JoinHostPort("noech.example.com", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if report.DNSHasECH || len(report.DNSConfigs) != 0 {
+		t.Errorf("DNSHasECH = %v, DNSConfigs = %v, want false, []", report.DNSHasECH, report.DNSConfigs)
+	}
+	if got, want := report.PublicName, "noech.example.com"; got != want {
+		t.Errorf("PublicName = %q, want %q", got, want)
+	}
+	if report.RetryConfigsPresented || report.GREASEError == "" {
+		t.Errorf("RetryConfigsPresented = %v, GREASEError = %q, want false, error", report.RetryConfigsPresented, report.GREASEError)
+	}
+	if report.ECHAccepted || report.ECHError != "" {
+		t.Errorf("ECHAccepted = %v, ECHError = %q, want false, \"\"", report.ECHAccepted, report.ECHError)
+	}
+}
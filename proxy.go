@@ -0,0 +1,140 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Proxy establishes network connections through a proxy server. The TLS
+// handshake, including Encrypted Client Hello, is done end-to-end with the
+// remote server through the proxy.
+//
+// See [NewSOCKS5Proxy], [NewHTTPConnectProxy], and [NewXNetProxy].
+type Proxy interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// ProxyFunc is an adapter to allow the use of ordinary functions as [Proxy],
+// e.g. to connect to a Unix socket:
+//
+//	dialer.Proxy = ech.ProxyFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+//		var d net.Dialer
+//		return d.DialContext(ctx, "unix", "/run/tunnel.sock")
+//	})
+type ProxyFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// DialContext calls f(ctx, network, addr).
+func (f ProxyFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return f(ctx, network, addr)
+}
+
+// The Dialers can be used by proxy-chaining code that expects a
+// [proxy.ContextDialer].
+var _ proxy.ContextDialer = (*Dialer[*tls.Conn])(nil)
+
+// NewXNetProxy returns a [Proxy] that establishes the network connections
+// with d, e.g. a [proxy.Dialer] returned by [proxy.SOCKS5] or
+// [proxy.FromURL]. If d doesn't implement [proxy.ContextDialer], the context
+// only stops waiting for the connection.
+func NewXNetProxy(d proxy.Dialer) Proxy {
+	if cd, ok := d.(proxy.ContextDialer); ok {
+		return cd
+	}
+	return &xNetProxy{d: d}
+}
+
+type xNetProxy struct {
+	d proxy.Dialer
+}
+
+func (p *xNetProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := p.d.Dial(network, addr)
+		ch <- result{conn, err}
+	}()
+	select {
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	case r := <-ch:
+		return r.conn, r.err
+	}
+}
+
+// proxyFromURL returns the Proxy for a proxy URL, e.g. from
+// [http.ProxyFromEnvironment]. The http and https schemes use HTTP CONNECT,
+// and the socks5 and socks5h schemes use SOCKS5.
+func proxyFromURL(u *url.URL) (Proxy, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return NewHTTPConnectProxy(u, nil)
+	case "socks5", "socks5h":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "1080")
+		}
+		password, _ := u.User.Password()
+		return NewSOCKS5Proxy(addr, u.User.Username(), password), nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// contextProxy returns the Proxy that Transport selected for a request, or
+// proxy if there is none.
+func contextProxy(ctx context.Context, proxy Proxy) Proxy {
+	if p, ok := ctx.Value(transportProxyKey).(Proxy); ok {
+		return p
+	}
+	return proxy
+}
+
+// dialNet connects to addr, through proxy if it isn't nil. Otherwise, the
+// TCP options are applied to the connection.
+func dialNet(ctx context.Context, proxy Proxy, d *net.Dialer, opts *TCPOptions, network, addr string) (net.Conn, error) {
+	if proxy != nil {
+		return proxy.DialContext(ctx, network, addr)
+	}
+	if !haveSockets {
+		return nil, errNoSockets
+	}
+	conn, err := opts.netDialer(d).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.applyConn(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// withContextDeadline applies the context's deadline and cancellation to conn
+// until the returned function is called.
+func withContextDeadline(ctx context.Context, conn net.Conn) (stop func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stopAfter := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+	return func() {
+		stopAfter()
+		conn.SetDeadline(time.Time{})
+	}
+}
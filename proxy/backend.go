@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// backend is a backend server. The same backend is shared by all the routes
+// that use its address.
+type backend struct {
+	addr    string
+	healthy atomic.Bool
+	// changed is called when the health status of the backend changes,
+	// until the backend is retired.
+	changed func(addr string, healthy bool)
+	retired atomic.Bool
+}
+
+func (b *backend) setHealthy(healthy bool) {
+	if b.healthy.Swap(healthy) != healthy && !b.retired.Load() && b.changed != nil {
+		b.changed(b.addr, healthy)
+	}
+}
+
+// pool is the list of backends of a route.
+type pool struct {
+	backends []*backend
+	next     atomic.Uint32
+}
+
+// order returns the backends in the order in which they should be tried: the
+// healthy backends first, in round-robin order, then the unhealthy ones.
+func (p *pool) order() []*backend {
+	n := len(p.backends)
+	start := int(p.next.Add(1)-1) % n
+	healthy := make([]*backend, 0, n)
+	var unhealthy []*backend
+	for i := range n {
+		b := p.backends[(start+i)%n]
+		if b.healthy.Load() {
+			healthy = append(healthy, b)
+		} else {
+			unhealthy = append(unhealthy, b)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// dial connects to the first backend that accepts the connection. The
+// backends that fail are marked as unhealthy when markUnhealthy is true.
+func (p *pool) dial(ctx context.Context, timeout time.Duration, markUnhealthy bool) (net.Conn, *backend, error) {
+	var err error
+	for _, b := range p.order() {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		var conn net.Conn
+		if conn, err = dialTimeout(ctx, b.addr, timeout); err == nil {
+			b.setHealthy(true)
+			return conn, b, nil
+		}
+		if markUnhealthy {
+			b.setHealthy(false)
+		}
+	}
+	return nil, nil, err
+}
+
+func dialTimeout(ctx context.Context, addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// healthCheck checks the backends every interval until ctx is canceled. A
+// backend is healthy when a TCP connection to it can be established within
+// timeout.
+func healthCheck(ctx context.Context, backends []*backend, interval, timeout time.Duration) {
+	check := func() {
+		var wg sync.WaitGroup
+		for _, b := range backends {
+			wg.Go(func() {
+				conn, err := dialTimeout(ctx, b.addr, timeout)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					b.setHealthy(false)
+					return
+				}
+				conn.Close()
+				b.setHealthy(true)
+			})
+		}
+		wg.Wait()
+	}
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
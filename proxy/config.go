@@ -0,0 +1,232 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// Config is the configuration of a [Server]. It is usually loaded from a JSON
+// file with [LoadConfig], e.g.
+//
+//	{
+//	  "handshakeTimeout": "10s",
+//	  "idleTimeout": "5m",
+//	  "healthCheck": {"interval": "10s", "timeout": "2s"},
+//	  "routes": [
+//	    {"serverName": "public.example.com", "backends": ["127.0.0.1:8443"]},
+//	    {"serverName": "*.example.com", "alpn": "h2", "backends": ["10.0.0.1:443", "10.0.0.2:443"], "proxyProtocol": 2},
+//	    {"serverName": "*.example.com", "backends": ["10.0.0.3:443"]}
+//	  ]
+//	}
+type Config struct {
+	// HandshakeTimeout is the maximum amount of time to receive the
+	// ClientHello. The default value is 10s.
+	HandshakeTimeout Duration `json:"handshakeTimeout,omitempty"`
+	// IdleTimeout is the maximum amount of time that a connection can
+	// remain idle. The default value is 5m.
+	IdleTimeout Duration `json:"idleTimeout,omitempty"`
+	// ConnectTimeout is the maximum amount of time to connect to a
+	// backend. The default value is 5s.
+	ConnectTimeout Duration `json:"connectTimeout,omitempty"`
+	// DrainTimeout is the maximum amount of time that the connections in
+	// progress can take to complete after [Server.Serve]'s context is
+	// canceled. Then, they are closed. The default value is 30s.
+	DrainTimeout Duration `json:"drainTimeout,omitempty"`
+	// HealthCheck configures the health checks of the backends.
+	HealthCheck HealthCheck `json:"healthCheck"`
+	// Routes are the routing rules. See [ech.Router] for the
+	// ServerName patterns and the order of precedence.
+	Routes []Route `json:"routes"`
+}
+
+// HealthCheck configures the health checks of the backends. A backend is
+// healthy when a TCP connection to it can be established. The connections are
+// only sent to the healthy backends of a route, unless all of them are
+// unhealthy.
+type HealthCheck struct {
+	// Disabled disables the health checks. All the backends are then
+	// considered healthy. When a connection to a backend fails, the next
+	// backend of the route is still tried.
+	Disabled bool `json:"disabled,omitempty"`
+	// Interval is the amount of time between two health checks of the same
+	// backend. The default value is 10s.
+	Interval Duration `json:"interval,omitempty"`
+	// Timeout is the maximum amount of time of a health check. The default
+	// value is 2s.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// Route forwards the connections that match ServerName and ALPN to Backends.
+type Route struct {
+	// Name identifies the route in logs and metrics. The default value is
+	// ServerName, followed by "/" and ALPN when ALPN is set.
+	Name string `json:"name,omitempty"`
+	// ServerName is a ServerName pattern, as defined by [ech.Router].
+	ServerName string `json:"serverName"`
+	// ALPN, if set, restricts the route to the connections that offer
+	// this ALPN protocol.
+	ALPN string `json:"alpn,omitempty"`
+	// Backends are the addresses of the backends. The connections are
+	// distributed among the healthy backends in round-robin order.
+	Backends []string `json:"backends"`
+	// ProxyProtocol, if set, is the version of the PROXY protocol, 1 or
+	// 2, that is used to send the client's address to the backends.
+	ProxyProtocol int `json:"proxyProtocol,omitempty"`
+}
+
+// Duration is a [time.Duration] that is encoded in JSON as a string, e.g.
+// "10s".
+type Duration time.Duration
+
+// MarshalJSON implements [json.Marshaler].
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements [json.Unmarshaler].
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads and validates the JSON configuration in the file at path.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ParseConfig decodes and validates a JSON configuration. Unknown fields are
+// rejected.
+func ParseConfig(data []byte) (*Config, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate verifies that the configuration is valid.
+func (c *Config) Validate() error {
+	for _, d := range []Duration{c.HandshakeTimeout, c.IdleTimeout, c.ConnectTimeout, c.DrainTimeout, c.HealthCheck.Interval, c.HealthCheck.Timeout} {
+		if d < 0 {
+			return errors.New("negative duration")
+		}
+	}
+	if len(c.Routes) == 0 {
+		return errors.New("no routes")
+	}
+	if _, err := newRouter(c.Routes, nil); err != nil {
+		return err
+	}
+	for i, r := range c.Routes {
+		if len(r.Backends) == 0 {
+			return fmt.Errorf("route %d (%s): no backends", i, r.ServerName)
+		}
+		for _, b := range r.Backends {
+			if _, _, err := net.SplitHostPort(b); err != nil {
+				return fmt.Errorf("route %d (%s): %w", i, r.ServerName, err)
+			}
+		}
+		if r.ProxyProtocol < 0 || r.ProxyProtocol > 2 {
+			return fmt.Errorf("route %d (%s): invalid proxy protocol version %d", i, r.ServerName, r.ProxyProtocol)
+		}
+	}
+	return nil
+}
+
+// newRouter returns a router with a handler for each route. The handler calls
+// serve with the route's index.
+func newRouter(routes []Route, serve func(int, *ech.Conn)) (router *ech.Router, err error) {
+	// The router panics when a pattern is invalid.
+	var i int
+	defer func() {
+		if r := recover(); r != nil {
+			router = nil
+			err = fmt.Errorf("route %d (%s): %v", i, routes[i].ServerName, r)
+		}
+	}()
+	router = ech.NewRouter()
+	for i = range routes {
+		idx := i
+		h := func(conn *ech.Conn) {
+			if serve != nil {
+				serve(idx, conn)
+			}
+		}
+		if routes[i].ALPN == "" {
+			router.HandleFunc(routes[i].ServerName, h)
+		} else {
+			router.HandleALPNFunc(routes[i].ServerName, routes[i].ALPN, h)
+		}
+	}
+	return router, nil
+}
+
+func (r *Route) name() string {
+	switch {
+	case r.Name != "":
+		return r.Name
+	case r.ALPN != "":
+		return r.ServerName + "/" + r.ALPN
+	default:
+		return r.ServerName
+	}
+}
+
+func (c *Config) handshakeTimeout() time.Duration {
+	return durationOrDefault(c.HandshakeTimeout, 10*time.Second)
+}
+
+func (c *Config) idleTimeout() time.Duration {
+	return durationOrDefault(c.IdleTimeout, 5*time.Minute)
+}
+
+func (c *Config) connectTimeout() time.Duration {
+	return durationOrDefault(c.ConnectTimeout, 5*time.Second)
+}
+
+func (c *Config) drainTimeout() time.Duration {
+	return durationOrDefault(c.DrainTimeout, 30*time.Second)
+}
+
+func (c *Config) healthCheckInterval() time.Duration {
+	return durationOrDefault(c.HealthCheck.Interval, 10*time.Second)
+}
+
+func (c *Config) healthCheckTimeout() time.Duration {
+	return durationOrDefault(c.HealthCheck.Timeout, 2*time.Second)
+}
+
+func durationOrDefault(d Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return time.Duration(d)
+	}
+	return def
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`{
+		"handshakeTimeout": "5s",
+		"drainTimeout": "1m",
+		"healthCheck": {"interval": "3s"},
+		"routes": [
+			{"serverName": "public.example.com", "backends": ["127.0.0.1:8443"]},
+			{"serverName": "*.example.com", "alpn": "h2", "backends": ["10.0.0.1:443", "10.0.0.2:443"], "proxyProtocol": 2},
+			{"name": "default", "serverName": "*", "backends": ["[::1]:443"]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if got, want := cfg.handshakeTimeout(), 5*time.Second; got != want {
+		t.Errorf("handshakeTimeout = %v, want %v", got, want)
+	}
+	if got, want := cfg.idleTimeout(), 5*time.Minute; got != want {
+		t.Errorf("idleTimeout = %v, want %v", got, want)
+	}
+	if got, want := cfg.drainTimeout(), time.Minute; got != want {
+		t.Errorf("drainTimeout = %v, want %v", got, want)
+	}
+	if got, want := cfg.healthCheckInterval(), 3*time.Second; got != want {
+		t.Errorf("healthCheckInterval = %v, want %v", got, want)
+	}
+	if got, want := cfg.healthCheckTimeout(), 2*time.Second; got != want {
+		t.Errorf("healthCheckTimeout = %v, want %v", got, want)
+	}
+	var names []string
+	for _, r := range cfg.Routes {
+		names = append(names, r.name())
+	}
+	if got, want := strings.Join(names, ","), "public.example.com,*.example.com/h2,default"; got != want {
+		t.Errorf("route names = %q, want %q", got, want)
+	}
+	if got, want := cfg.Routes[1].ProxyProtocol, 2; got != want {
+		t.Errorf("ProxyProtocol = %d, want %d", got, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  string
+		want string
+	}{
+		{"syntax", `{`, "unexpected EOF"},
+		{"unknown field", `{"foo": 1}`, "unknown field"},
+		{"bad duration", `{"idleTimeout": "1x"}`, "unknown unit"},
+		{"negative duration", `{"idleTimeout": "-1s", "routes": [{"serverName": "*", "backends": ["a:1"]}]}`, "negative duration"},
+		{"no routes", `{}`, "no routes"},
+		{"bad pattern", `{"routes": [{"serverName": "a.*.com", "backends": ["a:1"]}]}`, "invalid pattern"},
+		{"no backends", `{"routes": [{"serverName": "*"}]}`, "no backends"},
+		{"bad backend", `{"routes": [{"serverName": "*", "backends": ["foo"]}]}`, "missing port"},
+		{"bad proxy protocol", `{"routes": [{"serverName": "*", "backends": ["a:1"], "proxyProtocol": 3}]}`, "invalid proxy protocol version"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tc.cfg))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("ParseConfig() = %v, want %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("LoadConfig succeeded unexpectedly")
+	}
+	if err := os.WriteFile(path, []byte(`{"routes": [{"serverName": "*"}]}`), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+		t.Fatalf("LoadConfig() = %v, want error with path", err)
+	}
+	if err := os.WriteFile(path, []byte(`{"routes": [{"serverName": "*", "backends": ["127.0.0.1:443"]}]}`), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+// Package proxy implements a split-mode Encrypted Client Hello (ECH) reverse
+// proxy, i.e. a Client-Facing Server that decrypts the ClientHello of the
+// incoming TLS connections and forwards them to backend servers without
+// terminating TLS.
+//
+// The connections are routed based on their ServerName and ALPN protocols, as
+// defined by [ech.Router], with the rules loaded from a JSON configuration
+// file. Each route can have more than one backend. The connections are
+// distributed among the healthy backends, and the client addresses can be
+// sent to the backends with the PROXY protocol.
+//
+// The configuration and the keys can be reloaded while the server is running,
+// and the server drains the connections in progress when it shuts down.
+//
+// See [Server] and [Config].
+package proxy
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// proxyProtoSignature is the signature of the PROXY protocol version 2
+// header.
+var proxyProtoSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader returns the PROXY protocol header that sends the addresses of a
+// client connection to a backend, as defined in
+// https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt. The src address
+// is the client's address, and dst is the address that the client connected
+// to. When they aren't both TCP addresses, the header doesn't have any
+// address.
+func proxyHeader(version int, src, dst net.Addr) ([]byte, error) {
+	srcAddr, srcOK := tcpAddrPort(src)
+	dstAddr, dstOK := tcpAddrPort(dst)
+	known := srcOK && dstOK
+	v4 := known && srcAddr.Addr().Is4() && dstAddr.Addr().Is4()
+
+	switch version {
+	case 1:
+		if !known {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP6"
+		if v4 {
+			proto = "TCP4"
+		} else {
+			srcAddr = netip.AddrPortFrom(netip.AddrFrom16(srcAddr.Addr().As16()), srcAddr.Port())
+			dstAddr = netip.AddrPortFrom(netip.AddrFrom16(dstAddr.Addr().As16()), dstAddr.Port())
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", proto, srcAddr.Addr(), dstAddr.Addr(), srcAddr.Port(), dstAddr.Port()), nil
+
+	case 2:
+		// Version 2, PROXY command.
+		h := append([]byte{}, proxyProtoSignature...)
+		h = append(h, 0x21)
+		switch {
+		case !known:
+			// AF_UNSPEC, no address.
+			h = append(h, 0x00, 0x00, 0x00)
+		case v4:
+			// TCP over IPv4.
+			h = append(h, 0x11, 0x00, 12)
+			s, d := srcAddr.Addr().As4(), dstAddr.Addr().As4()
+			h = append(h, s[:]...)
+			h = append(h, d[:]...)
+		default:
+			// TCP over IPv6.
+			h = append(h, 0x21, 0x00, 36)
+			s, d := srcAddr.Addr().As16(), dstAddr.Addr().As16()
+			h = append(h, s[:]...)
+			h = append(h, d[:]...)
+		}
+		if known {
+			h = binary.BigEndian.AppendUint16(h, srcAddr.Port())
+			h = binary.BigEndian.AppendUint16(h, dstAddr.Port())
+		}
+		return h, nil
+
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol version %d", version)
+	}
+}
+
+// tcpAddrPort returns the address and port of a TCP address. IPv4-mapped IPv6
+// addresses are converted to IPv4.
+func tcpAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	a, ok := addr.(*net.TCPAddr)
+	if !ok || a == nil {
+		return netip.AddrPort{}, false
+	}
+	ap := a.AddrPort()
+	if !ap.Addr().IsValid() {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
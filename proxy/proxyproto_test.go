@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyHeader(t *testing.T) {
+	tcp := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr(%q): %v", s, err)
+		}
+		return a
+	}
+	sig := string(proxyProtoSignature)
+	for _, tc := range []struct {
+		name     string
+		version  int
+		src, dst net.Addr
+		want     string
+	}{
+		{"v1 tcp4", 1, tcp("192.0.2.1:56324"), tcp("192.0.2.2:443"), "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"},
+		{"v1 mapped", 1, tcp("[::ffff:192.0.2.1]:56324"), tcp("192.0.2.2:443"), "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"},
+		{"v1 tcp6", 1, tcp("[2001:db8::1]:56324"), tcp("[2001:db8::2]:443"), "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"},
+		{"v1 mixed", 1, tcp("192.0.2.1:56324"), tcp("[2001:db8::2]:443"), "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 443\r\n"},
+		{"v1 unknown", 1, &net.UnixAddr{Name: "/tmp/a", Net: "unix"}, tcp("192.0.2.2:443"), "PROXY UNKNOWN\r\n"},
+		{"v2 tcp4", 2, tcp("192.0.2.1:56324"), tcp("192.0.2.2:443"),
+			sig + "\x21\x11\x00\x0c" + "\xc0\x00\x02\x01" + "\xc0\x00\x02\x02" + "\xdc\x04" + "\x01\xbb"},
+		{"v2 tcp6", 2, tcp("[2001:db8::1]:56324"), tcp("[2001:db8::2]:443"),
+			sig + "\x21\x21\x00\x24" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01" +
+				"\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02" +
+				"\xdc\x04" + "\x01\xbb"},
+		{"v2 unknown", 2, nil, tcp("192.0.2.2:443"), sig + "\x21\x00\x00\x00"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := proxyHeader(tc.version, tc.src, tc.dst)
+			if err != nil {
+				t.Fatalf("proxyHeader: %v", err)
+			}
+			if !bytes.Equal(got, []byte(tc.want)) {
+				t.Errorf("proxyHeader() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+	if _, err := proxyHeader(3, nil, nil); err == nil {
+		t.Error("proxyHeader(3) succeeded unexpectedly")
+	}
+}
@@ -0,0 +1,368 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// Metrics receives metrics from a [Server]. The methods are called
+// synchronously from the Server's goroutines. Implementations must be safe for
+// concurrent use and should return quickly.
+type Metrics interface {
+	// ConnectionDone is called when a client connection is closed. route
+	// is the name of the route that matched the connection, and backend
+	// is the address of the backend that it was forwarded to. They are
+	// empty when the connection failed before that. bytesIn is the number
+	// of bytes forwarded from the client to the backend, and bytesOut is
+	// the number of bytes forwarded from the backend to the client.
+	ConnectionDone(route, backend string, echAccepted bool, bytesIn, bytesOut int64, duration time.Duration, err error)
+	// BackendHealthChanged is called when the health status of a backend
+	// changes.
+	BackendHealthChanged(backend string, healthy bool)
+}
+
+// Server is a split-mode Encrypted Client Hello reverse proxy. It decrypts the
+// ClientHello of the incoming TLS connections with the keys from KeyStore,
+// and forwards the connections to backend servers, based on the routes in
+// ConfigFile. The TLS connections are terminated by the backends.
+//
+//	server := &proxy.Server{
+//		ConfigFile: "/etc/ech-proxy/config.json",
+//		KeyStore:   ech.NewKeyStore("/etc/ech-proxy/keys", ech.NewPassphraseKeyWrapper(passphrase)),
+//	}
+//	ln, err := net.Listen("tcp", ":443")
+//	...
+//	err := server.Serve(ctx, ln)
+//
+// The configuration and the keys can be reloaded without interrupting the
+// connections in progress with [Server.Reload], e.g. after the keys are
+// rotated.
+type Server struct {
+	// ConfigFile is the path of the JSON configuration file. See
+	// [Config].
+	ConfigFile string
+	// KeyStore contains the ECH keys. If nil, ECH isn't used, and the
+	// connections are routed based on their plaintext ServerName.
+	KeyStore *ech.KeyStore
+	// Metrics, if set, receives metrics from the server.
+	Metrics Metrics
+	// Logf, if set, is used to log errors and events.
+	Logf func(format string, args ...any)
+
+	mu    sync.Mutex
+	state atomic.Pointer[state]
+}
+
+// state is the configuration of the server at a given time. It is replaced
+// atomically by Reload.
+type state struct {
+	cfg    *Config
+	keys   []ech.Key
+	router *ech.Router
+	pools  []*pool
+	names  []string
+	// backends are all the backends, indexed by address.
+	backends map[string]*backend
+	stop     context.CancelFunc
+}
+
+// session is attached to the connections with [ech.Conn.SetMetadata].
+type session struct {
+	ctx     context.Context
+	route   string
+	backend string
+	in, out int64
+	err     error
+}
+
+// Reload loads the configuration file and the keys, and applies them to the
+// new connections. The connections in progress aren't affected. When an error
+// is returned, the previous configuration remains in effect.
+func (s *Server) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg, err := LoadConfig(s.ConfigFile)
+	if err != nil {
+		return err
+	}
+	var keys []ech.Key
+	if s.KeyStore != nil {
+		if keys, err = s.KeyStore.Load(); err != nil {
+			return err
+		}
+	}
+	old := s.state.Load()
+	st := &state{
+		cfg:      cfg,
+		keys:     keys,
+		backends: make(map[string]*backend),
+	}
+	if st.router, err = newRouter(cfg.Routes, func(i int, conn *ech.Conn) { s.forward(st, i, conn) }); err != nil {
+		return err
+	}
+	for _, r := range cfg.Routes {
+		p := &pool{}
+		for _, addr := range r.Backends {
+			b, exists := st.backends[addr]
+			if !exists {
+				b = &backend{addr: addr, changed: s.backendHealthChanged}
+				// The health status is carried over from the previous
+				// configuration. New backends are assumed to be healthy
+				// until they are checked.
+				if ob, ok := old.backend(addr); ok && !cfg.HealthCheck.Disabled {
+					b.healthy.Store(ob.healthy.Load())
+				} else {
+					b.healthy.Store(true)
+				}
+				st.backends[addr] = b
+			}
+			p.backends = append(p.backends, b)
+		}
+		st.pools = append(st.pools, p)
+		st.names = append(st.names, r.name())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	st.stop = cancel
+	if !cfg.HealthCheck.Disabled {
+		backends := make([]*backend, 0, len(st.backends))
+		for _, b := range st.backends {
+			backends = append(backends, b)
+		}
+		go healthCheck(ctx, backends, cfg.healthCheckInterval(), cfg.healthCheckTimeout())
+	}
+	s.state.Store(st)
+	if old != nil {
+		old.stop()
+		// The health changes of the old backends are no longer reported.
+		for _, b := range old.backends {
+			b.retired.Store(true)
+		}
+	}
+	s.logf("Loaded %s: %d routes, %d backends, %d keys", s.ConfigFile, len(cfg.Routes), len(st.backends), len(keys))
+	return nil
+}
+
+func (st *state) backend(addr string) (*backend, bool) {
+	if st == nil {
+		return nil, false
+	}
+	b, ok := st.backends[addr]
+	return b, ok
+}
+
+// Close stops the health checks. The connections in progress aren't
+// affected.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st := s.state.Swap(nil); st != nil {
+		st.stop()
+	}
+	return nil
+}
+
+// Serve accepts connections on ln and forwards them to the backends. The
+// configuration is loaded with [Server.Reload] if it wasn't already.
+//
+// When ctx is canceled, Serve closes ln, and waits for the connections in
+// progress to complete, for up to the configured DrainTimeout. This includes
+// the connections that are still in their handshake, or connecting to a
+// backend, which are bounded by the handshake and connect timeouts. Then, the
+// remaining connections are closed, and Serve returns nil. Serve also returns
+// when ln.Accept fails, without waiting for the connections.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	if s.state.Load() == nil {
+		if err := s.Reload(); err != nil {
+			return err
+		}
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+	)
+	stopAccept := context.AfterFunc(ctx, func() {
+		ln.Close()
+	})
+	defer stopAccept()
+	// The connections in progress aren't aborted when ctx is canceled.
+	// They are drained.
+	connCtx := context.WithoutCancel(ctx)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				return err
+			}
+			break
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Go(func() {
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+			}()
+			s.handle(connCtx, conn)
+		})
+	}
+
+	drainTimeout := 30 * time.Second
+	if st := s.state.Load(); st != nil {
+		drainTimeout = st.cfg.drainTimeout()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(drainTimeout):
+	}
+	mu.Lock()
+	s.logf("Closing %d connections after drain timeout", len(conns))
+	for conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+	<-done
+	return nil
+}
+
+// handle processes the ClientHello of conn, and routes the connection.
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	start := time.Now()
+	st := s.state.Load()
+	if st == nil {
+		conn.Close()
+		return
+	}
+	echConn, err := ech.NewConn(ctx, conn,
+		ech.WithKeys(st.keys),
+		ech.WithHandshakeTimeout(st.cfg.handshakeTimeout()),
+		ech.WithIdleTimeout(st.cfg.idleTimeout()),
+	)
+	if err != nil {
+		conn.Close()
+		s.logf("%s: NewConn: %v", conn.RemoteAddr(), err)
+		s.connectionDone(&session{err: err}, false, start)
+		return
+	}
+	sess := &session{ctx: ctx}
+	echConn.SetMetadata(sess)
+	if err := st.router.Serve(ctx, echConn); err != nil {
+		sess.err = err
+	}
+	if sess.err != nil {
+		s.logf("%s: %q: %v", conn.RemoteAddr(), echConn.ServerName(), sess.err)
+	}
+	s.connectionDone(sess, echConn.ECHAccepted(), start)
+}
+
+// forward connects conn to a backend of route i. It is called by the router.
+func (s *Server) forward(st *state, i int, conn *ech.Conn) {
+	sess := conn.Metadata().(*session)
+	sess.route = st.names[i]
+	route := st.cfg.Routes[i]
+
+	backendConn, b, err := st.pools[i].dial(sess.ctx, st.cfg.connectTimeout(), !st.cfg.HealthCheck.Disabled)
+	if err != nil {
+		conn.Close()
+		sess.err = err
+		return
+	}
+	sess.backend = b.addr
+	if route.ProxyProtocol > 0 {
+		h, err := proxyHeader(route.ProxyProtocol, conn.RemoteAddr(), conn.LocalAddr())
+		if err == nil {
+			_, err = backendConn.Write(h)
+		}
+		if err != nil {
+			conn.Close()
+			backendConn.Close()
+			sess.err = err
+			return
+		}
+	}
+	sess.in, sess.out, sess.err = relay(conn, backendConn)
+}
+
+func (s *Server) connectionDone(sess *session, echAccepted bool, start time.Time) {
+	if s.Metrics != nil {
+		s.Metrics.ConnectionDone(sess.route, sess.backend, echAccepted, sess.in, sess.out, time.Since(start), sess.err)
+	}
+}
+
+func (s *Server) backendHealthChanged(addr string, healthy bool) {
+	if healthy {
+		s.logf("Backend %s is healthy", addr)
+	} else {
+		s.logf("Backend %s is unhealthy", addr)
+	}
+	if s.Metrics != nil {
+		s.Metrics.BackendHealthChanged(addr, healthy)
+	}
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// relay copies data between client and backend in both directions until both
+// sides are done. Then, it closes both connections. It returns the number of
+// bytes copied from client to backend, and from backend to client.
+func relay(client, backend net.Conn) (in, out int64, err error) {
+	type result struct {
+		n   int64
+		err error
+	}
+	inCh := make(chan result, 1)
+	outCh := make(chan result, 1)
+	cp := func(dst, src net.Conn, ch chan<- result) {
+		n, err := io.Copy(dst, src)
+		closeWrite(dst)
+		ch <- result{n, err}
+	}
+	go cp(backend, client, inCh)
+	go cp(client, backend, outCh)
+	r1, r2 := <-inCh, <-outCh
+	client.Close()
+	backend.Close()
+	for _, r := range []result{r1, r2} {
+		if r.err != nil && !errors.Is(r.err, net.ErrClosed) {
+			err = r.err
+			break
+		}
+	}
+	return r1.n, r2.n, err
+}
+
+// closeWrite shuts down the writing side of conn, if supported. Otherwise, the
+// connection is closed.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(*ech.Conn); ok {
+		conn = c.Conn
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+		return
+	}
+	conn.Close()
+}
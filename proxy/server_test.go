@@ -0,0 +1,387 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+type testMetrics struct {
+	mu      sync.Mutex
+	conns   []string
+	healthy map[string]bool
+}
+
+func (m *testMetrics) ConnectionDone(route, backend string, echAccepted bool, bytesIn, bytesOut int64, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.conns = append(m.conns, fmt.Sprintf("%s %s %v %v", route, backend, echAccepted, err == nil))
+}
+
+func (m *testMetrics) BackendHealthChanged(backend string, healthy bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.healthy == nil {
+		m.healthy = make(map[string]bool)
+	}
+	m.healthy[backend] = healthy
+}
+
+func (m *testMetrics) backendHealthy(addr string) (healthy, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	healthy, ok = m.healthy[addr]
+	return
+}
+
+// startBackend starts a TLS backend server that writes a greeting with its
+// name and the connection's ServerName, and then echoes the data that it
+// receives. With proxyProto, the PROXY protocol header line is included in
+// the greeting.
+func startBackend(t *testing.T, name string, proxyProto bool, cert tls.Certificate, keys []ech.Key) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	tc := &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		EncryptedClientHelloKeys: keys,
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var header string
+				br := bufio.NewReader(conn)
+				if proxyProto {
+					if header, err = br.ReadString('\n'); err != nil {
+						return
+					}
+					header = " " + strings.TrimSpace(header)
+				}
+				server := tls.Server(&bufferedConn{Conn: conn, r: br}, tc)
+				if err := server.Handshake(); err != nil {
+					return
+				}
+				fmt.Fprintf(server, "%s %s%s\n", name, server.ConnectionState().ServerName, header)
+				io.Copy(server, server)
+				server.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+type testProxy struct {
+	addr       string
+	configList []byte
+	rootCAs    *x509.CertPool
+}
+
+// dial connects to the proxy with ECH, and returns the backend's greeting.
+func (p *testProxy) dial(t *testing.T, serverName string, alpn ...string) (*tls.Conn, string, error) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", p.addr, &tls.Config{
+		ServerName:                     serverName,
+		NextProtos:                     alpn,
+		RootCAs:                        p.rootCAs,
+		EncryptedClientHelloConfigList: p.configList,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if !conn.ConnectionState().ECHAccepted {
+		conn.Close()
+		return nil, "", fmt.Errorf("ECH not accepted")
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return conn, strings.TrimSpace(line), nil
+}
+
+// greeting connects to the proxy with ECH, and returns the backend's greeting.
+func (p *testProxy) greeting(t *testing.T, serverName string, alpn ...string) (string, error) {
+	t.Helper()
+	conn, line, err := p.dial(t, serverName, alpn...)
+	if err != nil {
+		return "", err
+	}
+	conn.Close()
+	return line, nil
+}
+
+func writeConfig(t *testing.T, path, cfg string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	store := ech.NewKeyStore(filepath.Join(dir, "keys"), ech.NewPassphraseKeyWrapper([]byte("passphrase")))
+	keys, err := store.Rotate([]byte("public.example.com"), 2)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{keys[0].Config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	cert, err := testutil.NewCert("public.example.com", "*.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+
+	publicBackend := startBackend(t, "public", false, cert, keys)
+	h2Backend := startBackend(t, "h2", true, cert, keys)
+	backend1 := startBackend(t, "backend1", false, cert, keys)
+	backend2 := startBackend(t, "backend2", false, cert, keys)
+	deadLn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	deadBackend := deadLn.Addr().String()
+	deadLn.Close()
+
+	configFile := filepath.Join(dir, "config.json")
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"drainTimeout": "500ms",
+		"healthCheck": {"interval": "100ms", "timeout": "1s"},
+		"routes": [
+			{"serverName": "public.example.com", "backends": [%q]},
+			{"serverName": "*.example.com", "alpn": "h2", "backends": [%q], "proxyProtocol": 1},
+			{"name": "private", "serverName": "*.example.com", "backends": [%q, %q]}
+		]
+	}`, publicBackend, h2Backend, deadBackend, backend1))
+
+	metrics := &testMetrics{}
+	server := &Server{
+		ConfigFile: configFile,
+		KeyStore:   store,
+		Metrics:    metrics,
+		Logf:       t.Logf,
+	}
+	defer server.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	serveErr := make(chan error)
+	go func() {
+		serveErr <- server.Serve(ctx, ln)
+	}()
+	p := &testProxy{addr: ln.Addr().String(), configList: configList, rootCAs: rootCAs}
+
+	for _, tc := range []struct {
+		serverName string
+		alpn       []string
+		want       string
+	}{
+		{"public.example.com", nil, "public public.example.com"},
+		{"www.example.com", []string{"h2"}, "h2 www.example.com PROXY TCP4 127.0.0.1 127.0.0.1 "},
+		{"www.example.com", []string{"http/1.1"}, "backend1 www.example.com"},
+		{"foo.example.com", nil, "backend1 foo.example.com"},
+		{"foo.example.com", nil, "backend1 foo.example.com"},
+	} {
+		got, err := p.greeting(t, tc.serverName, tc.alpn...)
+		if err != nil {
+			t.Fatalf("dial(%q): %v", tc.serverName, err)
+		}
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("dial(%q, %q) = %q, want %q", tc.serverName, tc.alpn, got, tc.want)
+		}
+	}
+	if _, err := p.greeting(t, "www.example.org"); err == nil || !strings.Contains(err.Error(), "unrecognized name") {
+		t.Errorf("dial(www.example.org) = %v, want unrecognized name", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if healthy, ok := metrics.backendHealthy(deadBackend); ok && !healthy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s wasn't reported as unhealthy", deadBackend)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The connection in progress isn't affected by the reload.
+	conn, got, err := p.dial(t, "foo.example.com")
+	if err != nil || got != "backend1 foo.example.com" {
+		t.Fatalf("dial(foo.example.com) = %q, %v, want backend1", got, err)
+	}
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"drainTimeout": "500ms",
+		"routes": [
+			{"name": "private", "serverName": "*.example.com", "backends": [%q]}
+		]
+	}`, backend2))
+	if err := server.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if got, err := p.greeting(t, "foo.example.com"); err != nil || got != "backend2 foo.example.com" {
+		t.Errorf("dial(foo.example.com) = %q, %v, want backend2", got, err)
+	}
+	if _, err := p.greeting(t, "public.example.com", "h2"); err != nil {
+		t.Errorf("dial(public.example.com): %v", err)
+	}
+	fmt.Fprintln(conn, "still there")
+	if line, err := bufio.NewReader(conn).ReadString('\n'); err != nil || line != "still there\n" {
+		t.Errorf("ReadString() = %q, %v", line, err)
+	}
+
+	// An invalid configuration isn't applied.
+	writeConfig(t, configFile, `{"routes": []}`)
+	if err := server.Reload(); err == nil {
+		t.Error("Reload succeeded unexpectedly")
+	}
+	if got, err := p.greeting(t, "foo.example.com"); err != nil || got != "backend2 foo.example.com" {
+		t.Errorf("dial(foo.example.com) = %q, %v, want backend2", got, err)
+	}
+
+	// The connection in progress is closed after the drain timeout.
+	start := time.Now()
+	cancel()
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	if d := time.Since(start); d < 500*time.Millisecond {
+		t.Errorf("Serve returned after %s, want >= 500ms", d)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadAll(conn); errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("ReadAll: %v", err)
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	for _, want := range []string{
+		"public.example.com " + publicBackend + " true true",
+		"*.example.com/h2 " + h2Backend + " true true",
+		"private " + backend1 + " true true",
+		"private " + backend2 + " true true",
+		"  true false",
+	} {
+		found := false
+		for _, c := range metrics.conns {
+			if c == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("ConnectionDone(%q) not called, got %q", want, metrics.conns)
+		}
+	}
+}
+
+func TestServerDrainHandshake(t *testing.T) {
+	dir := t.TempDir()
+	store := ech.NewKeyStore(filepath.Join(dir, "keys"), ech.NewPassphraseKeyWrapper([]byte("passphrase")))
+	keys, err := store.Rotate([]byte("public.example.com"), 1)
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{keys[0].Config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	cert, err := testutil.NewCert("public.example.com", "*.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+	backend := startBackend(t, "backend", false, cert, keys)
+
+	configFile := filepath.Join(dir, "config.json")
+	writeConfig(t, configFile, fmt.Sprintf(`{
+		"drainTimeout": "5s",
+		"healthCheck": {"disabled": true},
+		"routes": [
+			{"serverName": "*.example.com", "backends": [%q]}
+		]
+	}`, backend))
+	server := &Server{
+		ConfigFile: configFile,
+		KeyStore:   store,
+		Logf:       t.Logf,
+	}
+	defer server.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	serveErr := make(chan error)
+	go func() {
+		serveErr <- server.Serve(ctx, ln)
+	}()
+
+	// The connection is accepted, but its handshake hasn't started when
+	// the server is stopped.
+	rawConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("net.Dial: %v", err)
+	}
+	defer rawConn.Close()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName:                     "www.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: configList,
+	})
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "backend www.example.com\n" {
+		t.Fatalf("ReadString() = %q, %v, want backend greeting", line, err)
+	}
+	conn.Close()
+	if err := <-serveErr; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
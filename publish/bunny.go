@@ -0,0 +1,223 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+var bunnyBaseURL = url.URL{
+	Scheme: "https",
+	Host:   "api.bunny.net",
+	Path:   "/dnszone",
+}
+
+// bunnyTypeHTTPS is the record type of HTTPS records in the Bunny DNS API.
+const bunnyTypeHTTPS = 14
+
+// NewBunnyPublisher returns a new BunnyPublisher that uses the bunny.net API
+// access key.
+func NewBunnyPublisher(accessKey string) *BunnyPublisher {
+	bp := &BunnyPublisher{
+		baseURL:   bunnyBaseURL,
+		client:    retryablehttp.NewClient(),
+		accessKey: accessKey,
+	}
+	bp.client.Logger = nil
+	return bp
+}
+
+var _ ECHPublisher = (*BunnyPublisher)(nil)
+
+// BunnyPublisher publishes ECH Config Lists to DNS using the Bunny DNS API.
+//
+// The Zone of the targets is the domain name of the DNS zone, e.g.
+// example.com, and the Name is the fully qualified name of the records, e.g.
+// www.example.com. All the HTTPS records of a name are updated.
+type BunnyPublisher struct {
+	baseURL   url.URL
+	client    *retryablehttp.Client
+	accessKey string
+	logger    *slog.Logger
+}
+
+// SetLogger sets the logger that is used to log the result of each update.
+// The updated records are logged at the Info level, the unchanged records at
+// the Debug level, and the failures at the Warn level. The default is
+// [slog.Default].
+func (bp *BunnyPublisher) SetLogger(l *slog.Logger) {
+	bp.logger = l
+}
+
+type bunnyZone struct {
+	ID      int64         `json:"Id"`
+	Domain  string        `json:"Domain"`
+	Records []bunnyRecord `json:"Records"`
+}
+
+// bunnyRecord is a DNS record of the Bunny DNS API. The value of HTTPS records
+// is in presentation format, e.g. `1 . alpn="h2" ech="..."`. The name is
+// relative to the zone, and empty for the zone itself.
+type bunnyRecord struct {
+	ID    int64  `json:"Id"`
+	Type  int    `json:"Type"`
+	Name  string `json:"Name"`
+	Value string `json:"Value"`
+	TTL   int    `json:"Ttl,omitempty"`
+}
+
+type bunnyError struct {
+	ErrorKey string `json:"ErrorKey"`
+	Field    string `json:"Field"`
+	Message  string `json:"Message"`
+}
+
+func (e bunnyError) Error() string {
+	return fmt.Sprintf("%s: %s", e.ErrorKey, e.Message)
+}
+
+// PublishECH updates the target DNS records with a new config list.
+func (bp *BunnyPublisher) PublishECH(ctx context.Context, records []Target, configList []byte) []TargetResult {
+	zones := make(map[string]*bunnyZone)
+	zoneErrs := make(map[string]error)
+
+	newValue := base64.StdEncoding.EncodeToString(configList)
+	results := make([]TargetResult, 0, len(records))
+
+	for _, r := range records {
+		zone := strings.TrimSuffix(strings.ToLower(r.Zone), ".")
+		if _, exists := zones[zone]; !exists && zoneErrs[zone] == nil {
+			z, err := bp.getZone(ctx, zone)
+			if err != nil {
+				zoneErrs[zone] = err
+			}
+			zones[zone] = z
+		}
+		if err := zoneErrs[zone]; err == errNotFound {
+			results = append(results, TargetResult{Code: StatusNotFound})
+			continue
+		} else if err != nil {
+			results = append(results, TargetResult{Code: StatusError, Error: err})
+			continue
+		}
+		results = append(results, bp.publish(ctx, zones[zone], r, newValue))
+	}
+	logResults(ctx, bp.logger, records, results)
+	return results
+}
+
+// publish updates the HTTPS records of r in z.
+func (bp *BunnyPublisher) publish(ctx context.Context, z *bunnyZone, r Target, newValue string) TargetResult {
+	name, ok := relativeName(r.Zone, r.Name)
+	if !ok {
+		return TargetResult{Code: StatusNotFound}
+	}
+	// Bunny uses an empty name for the zone itself.
+	if name == "@" {
+		name = ""
+	}
+	result := TargetResult{Code: StatusNotFound}
+	for i := range z.Records {
+		rr := &z.Records[i]
+		if rr.Type != bunnyTypeHTTPS || strings.ToLower(rr.Name) != name {
+			continue
+		}
+		if result.Code == StatusNotFound {
+			result.Code = StatusNoChange
+		}
+		newData, oldValue := setECHParam(rr.Value, newValue)
+		if oldValue == newValue {
+			continue
+		}
+		rr.Value = newData
+		if err := bp.updateRecord(ctx, z.ID, *rr); err != nil {
+			return TargetResult{Code: StatusError, Error: err}
+		}
+		result.Code = StatusUpdated
+	}
+	return result
+}
+
+func (bp *BunnyPublisher) do(req *retryablehttp.Request) ([]byte, error) {
+	req.Header.Set("AccessKey", bp.accessKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := bp.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		var e bunnyError
+		if err := json.Unmarshal(b, &e); err == nil && e.Message != "" {
+			return nil, fmt.Errorf("status code %d: %w", resp.StatusCode, e)
+		}
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return b, nil
+}
+
+// getZone returns the DNS zone of domain, with all its records.
+func (bp *BunnyPublisher) getZone(ctx context.Context, domain string) (*bunnyZone, error) {
+	for page := 1; ; page++ {
+		u := bp.baseURL
+		q := u.Query()
+		q.Set("search", domain)
+		q.Set("page", strconv.Itoa(page))
+		u.RawQuery = q.Encode()
+		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := bp.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Items        []bunnyZone `json:"Items"`
+			HasMoreItems bool        `json:"HasMoreItems"`
+		}
+		if err := json.Unmarshal(b, &result); err != nil {
+			return nil, err
+		}
+		// The search also matches the zones that contain domain, e.g.
+		// sub.example.com.
+		for _, z := range result.Items {
+			if strings.TrimSuffix(strings.ToLower(z.Domain), ".") == domain {
+				return &z, nil
+			}
+		}
+		if !result.HasMoreItems || len(result.Items) == 0 {
+			return nil, errNotFound
+		}
+	}
+}
+
+func (bp *BunnyPublisher) updateRecord(ctx context.Context, zoneID int64, rr bunnyRecord) error {
+	b, err := json.Marshal(rr)
+	if err != nil {
+		return err
+	}
+	u := bp.baseURL
+	u.Path += "/" + strconv.FormatInt(zoneID, 10) + "/records/" + strconv.FormatInt(rr.ID, 10)
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = bp.do(req)
+	return err
+}
@@ -0,0 +1,119 @@
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+func TestBunny(t *testing.T) {
+	var mu sync.Mutex
+	zones := []bunnyZone{
+		{ID: 10, Domain: "sub.example.org"},
+		{ID: 11, Domain: "example.org", Records: []bunnyRecord{
+			{ID: 1, Type: bunnyTypeHTTPS, Name: "", Value: `1 . alpn="h3" ech="AQID"`, TTL: 300},
+			{ID: 2, Type: 0, Name: "www", Value: "192.0.2.1", TTL: 300},
+			{ID: 3, Type: bunnyTypeHTTPS, Name: "www", Value: `1 . alpn="h2,h3" ech="AAAA"`, TTL: 300},
+			{ID: 4, Type: bunnyTypeHTTPS, Name: "www", Value: `2 . alpn="h2"`, TTL: 300},
+		}},
+	}
+	var updates []int64
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if got, want := req.Header.Get("AccessKey"), "key"; got != want {
+			t.Errorf("AccessKey = %q, want %q", got, want)
+		}
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/dnszone":
+			var resp struct {
+				Items        []bunnyZone `json:"Items"`
+				HasMoreItems bool        `json:"HasMoreItems"`
+			}
+			resp.Items = []bunnyZone{}
+			for _, z := range zones {
+				if strings.Contains(z.Domain, req.URL.Query().Get("search")) {
+					resp.Items = append(resp.Items, z)
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		case req.Method == "POST" && strings.HasPrefix(req.URL.Path, "/dnszone/11/records/"):
+			var rr bunnyRecord
+			if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+				t.Errorf("json: %v", err)
+			}
+			for i := range zones[1].Records {
+				if zones[1].Records[i].ID == rr.ID {
+					zones[1].Records[i] = rr
+				}
+			}
+			updates = append(updates, rr.ID)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("ts.URL: %v", err)
+	}
+	u.Path = "/dnszone"
+
+	bp := &BunnyPublisher{
+		baseURL:   *u,
+		client:    retryablehttp.NewClient(),
+		accessKey: "key",
+	}
+	bp.client.Logger = nil
+
+	targets := []Target{
+		{Zone: "foo.org", Name: "foo.org"},
+		{Zone: "example.org", Name: "example.org"},
+		{Zone: "example.org", Name: "www.example.org"},
+		{Zone: "example.org", Name: "foo.example.org"},
+	}
+
+	t.Run("FirstUpdate", func(t *testing.T) {
+		got := bp.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusUpdated},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if want := []int64{3, 4}; !reflect.DeepEqual(updates, want) {
+			t.Errorf("updates = %v, want %v", updates, want)
+		}
+		if got, want := zones[1].Records[3].Value, `2 . alpn="h2" ech="AQID"`; got != want {
+			t.Errorf("Value = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("SecondUpdate", func(t *testing.T) {
+		got := bp.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusNoChange},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+}
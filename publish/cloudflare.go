@@ -8,10 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strconv"
-	"strings"
 
 	"github.com/hashicorp/go-retryablehttp"
 )
@@ -55,7 +55,7 @@ func (r TargetResult) Err() error {
 	case StatusUpdated, StatusNoChange:
 		return nil
 	case StatusError:
-		return fmt.Errorf("cloudflare error: %w", r.Error)
+		return fmt.Errorf("publish error: %w", r.Error)
 	default:
 		return errors.New(r.String())
 	}
@@ -106,6 +106,15 @@ type CloudflarePublisher struct {
 	client   *retryablehttp.Client
 	zoneIDs  map[string]string
 	apiToken string
+	logger   *slog.Logger
+}
+
+// SetLogger sets the logger that is used to log the result of each update.
+// The updated records are logged at the Info level, the unchanged records at
+// the Debug level, and the failures at the Warn level. The default is
+// [slog.Default].
+func (cf *CloudflarePublisher) SetLogger(l *slog.Logger) {
+	cf.logger = l
 }
 
 type zoneName struct {
@@ -174,23 +183,13 @@ func (cf *CloudflarePublisher) PublishECH(ctx context.Context, records []Target,
 			results = append(results, result)
 			continue
 		}
-		params := strings.Split(v.Data.Value, " ")
-		var newParams []string
-		var oldValue string
-		for _, p := range params {
-			if k, v, ok := strings.Cut(p, "="); ok && k == "ech" {
-				oldValue = strings.Trim(v, `"`)
-				continue
-			}
-			newParams = append(newParams, p)
-		}
+		newData, oldValue := setECHParam(v.Data.Value, newValue)
 		if newValue == oldValue {
 			result.Code = StatusNoChange
 			results = append(results, result)
 			continue
 		}
-		newParams = append(newParams, fmt.Sprintf(`ech="%s"`, newValue))
-		v.Data.Value = strings.Join(newParams, " ")
+		v.Data.Value = newData
 
 		if err := cf.updateRecord(ctx, v.ZoneID, v.RecordID, v.Data); err != nil {
 			result.Code = StatusError
@@ -201,9 +200,28 @@ func (cf *CloudflarePublisher) PublishECH(ctx context.Context, records []Target,
 		result.Code = StatusUpdated
 		results = append(results, result)
 	}
+	logResults(ctx, cf.logger, records, results)
 	return results
 }
 
+// logResults logs the result of each update with logger, or with
+// [slog.Default] if logger is nil.
+func logResults(ctx context.Context, logger *slog.Logger, records []Target, results []TargetResult) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	for i, r := range results {
+		level := slog.LevelWarn
+		switch r.Code {
+		case StatusUpdated:
+			level = slog.LevelInfo
+		case StatusNoChange:
+			level = slog.LevelDebug
+		}
+		logger.Log(ctx, level, "publish: "+r.String(), "zone", records[i].Zone, "name", records[i].Name)
+	}
+}
+
 func (cf *CloudflarePublisher) getZoneData(ctx context.Context, zone string, data map[zoneName]idData) error {
 	zoneID, exists := cf.zoneIDs[zone]
 	if !exists {
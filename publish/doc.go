@@ -1,3 +1,9 @@
 // Package publish is used to publish Encrypted Client Hello (ECH) Config Lists
 // to DNS HTTPS records (RFC 9460).
+//
+// [BunnyPublisher], [CloudflarePublisher], [GoDaddyPublisher],
+// [NameComPublisher], and [ScalewayPublisher] update the records with the APIs
+// of these DNS providers.
+//
+// The testutil package contains a fake [ECHPublisher] for unit tests.
 package publish
@@ -37,3 +37,17 @@ func Example() {
 		log.Printf("[%s] %s: %s", records[i].Zone, records[i].Name, result)
 	}
 }
+
+func ExampleNewGoDaddyPublisher() {
+	var configList []byte // from ech.ConfigList
+
+	pub := publish.NewGoDaddyPublisher("api-key", "api-secret")
+	records := []publish.Target{
+		{Zone: "example.com", Name: "private.example.com"},
+	}
+	for i, result := range pub.PublishECH(context.Background(), records, configList) {
+		if err := result.Err(); err != nil {
+			log.Printf("[%s] %s: %v", records[i].Zone, records[i].Name, err)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+var godaddyBaseURL = url.URL{
+	Scheme: "https",
+	Host:   "api.godaddy.com",
+	Path:   "/v1/domains",
+}
+
+// NewGoDaddyPublisher returns a new GoDaddyPublisher that uses the GoDaddy API
+// key and secret. The key must be allowed to manage the DNS records of the
+// target domain(s).
+func NewGoDaddyPublisher(apiKey, apiSecret string) *GoDaddyPublisher {
+	gd := &GoDaddyPublisher{
+		baseURL:   godaddyBaseURL,
+		client:    retryablehttp.NewClient(),
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+	}
+	gd.client.Logger = nil
+	return gd
+}
+
+var _ ECHPublisher = (*GoDaddyPublisher)(nil)
+
+// GoDaddyPublisher publishes ECH Config Lists to DNS using the GoDaddy API.
+//
+// The Zone of the targets is the domain name, e.g. example.com, and the Name
+// is the fully qualified name of the records, e.g. www.example.com. All the
+// HTTPS records of a name are updated.
+type GoDaddyPublisher struct {
+	baseURL   url.URL
+	client    *retryablehttp.Client
+	apiKey    string
+	apiSecret string
+	logger    *slog.Logger
+}
+
+// SetLogger sets the logger that is used to log the result of each update.
+// The updated records are logged at the Info level, the unchanged records at
+// the Debug level, and the failures at the Warn level. The default is
+// [slog.Default].
+func (gd *GoDaddyPublisher) SetLogger(l *slog.Logger) {
+	gd.logger = l
+}
+
+// gdRecord is a DNS record of the GoDaddy API. The data of HTTPS records is
+// in presentation format, e.g. `1 . alpn="h2" ech="..."`.
+type gdRecord struct {
+	Data     string `json:"data"`
+	Name     string `json:"name,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	Service  string `json:"service,omitempty"`
+	TTL      int    `json:"ttl,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+}
+
+type gdError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e gdError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// PublishECH updates the target DNS records with a new config list.
+func (gd *GoDaddyPublisher) PublishECH(ctx context.Context, records []Target, configList []byte) []TargetResult {
+	newValue := base64.StdEncoding.EncodeToString(configList)
+	results := make([]TargetResult, 0, len(records))
+
+	for _, r := range records {
+		results = append(results, gd.publish(ctx, r, newValue))
+	}
+	logResults(ctx, gd.logger, records, results)
+	return results
+}
+
+func (gd *GoDaddyPublisher) publish(ctx context.Context, r Target, newValue string) TargetResult {
+	name, ok := relativeName(r.Zone, r.Name)
+	if !ok {
+		return TargetResult{Code: StatusNotFound}
+	}
+	rrs, err := gd.getRecords(ctx, r.Zone, name)
+	if err == errNotFound || (err == nil && len(rrs) == 0) {
+		return TargetResult{Code: StatusNotFound}
+	}
+	if err != nil {
+		return TargetResult{Code: StatusError, Error: err}
+	}
+	changed := false
+	for i := range rrs {
+		newData, oldValue := setECHParam(rrs[i].Data, newValue)
+		if oldValue == newValue {
+			continue
+		}
+		rrs[i].Data = newData
+		changed = true
+	}
+	if !changed {
+		return TargetResult{Code: StatusNoChange}
+	}
+	if err := gd.putRecords(ctx, r.Zone, name, rrs); err != nil {
+		return TargetResult{Code: StatusError, Error: err}
+	}
+	return TargetResult{Code: StatusUpdated}
+}
+
+// relativeName returns name relative to zone, as used by the GoDaddy API, i.e.
+// "@" for the zone itself.
+func relativeName(zone, name string) (string, bool) {
+	zone = strings.TrimSuffix(strings.ToLower(zone), ".")
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == zone {
+		return "@", true
+	}
+	if n, ok := strings.CutSuffix(name, "."+zone); ok && n != "" {
+		return n, true
+	}
+	return "", false
+}
+
+func (gd *GoDaddyPublisher) recordsURL(zone, name string) string {
+	u := gd.baseURL
+	u.Path += "/" + zone + "/records/HTTPS/" + name
+	return u.String()
+}
+
+func (gd *GoDaddyPublisher) do(req *retryablehttp.Request) ([]byte, error) {
+	req.Header.Set("Authorization", "sso-key "+gd.apiKey+":"+gd.apiSecret)
+	req.Header.Set("Accept", "application/json")
+	resp, err := gd.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e gdError
+		if err := json.Unmarshal(b, &e); err == nil && e.Code != "" {
+			return nil, fmt.Errorf("status code %d: %w", resp.StatusCode, e)
+		}
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return b, nil
+}
+
+func (gd *GoDaddyPublisher) getRecords(ctx context.Context, zone, name string) ([]gdRecord, error) {
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, gd.recordsURL(zone, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	b, err := gd.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []gdRecord
+	if err := json.Unmarshal(b, &rrs); err != nil {
+		return nil, err
+	}
+	return rrs, nil
+}
+
+// putRecords replaces all the HTTPS records of name with rrs.
+func (gd *GoDaddyPublisher) putRecords(ctx context.Context, zone, name string, rrs []gdRecord) error {
+	b, err := json.Marshal(rrs)
+	if err != nil {
+		return err
+	}
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPut, gd.recordsURL(zone, name), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = gd.do(req)
+	return err
+}
@@ -0,0 +1,143 @@
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+func TestGoDaddy(t *testing.T) {
+	var mu sync.Mutex
+	domains := map[string]map[string][]gdRecord{
+		"example.org": {
+			"@": {
+				{Data: `1 . alpn="h3" ech="AQID"`, Name: "@", TTL: 600, Type: "HTTPS"},
+			},
+			"www": {
+				{Data: `1 . alpn="h2,h3" ech="AAAA"`, Name: "www", TTL: 600, Type: "HTTPS"},
+				{Data: `2 . alpn="h2"`, Name: "www", TTL: 600, Type: "HTTPS"},
+			},
+		},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if got, want := req.Header.Get("Authorization"), "sso-key key:secret"; got != want {
+			t.Errorf("Authorization = %q, want %q", got, want)
+		}
+		// /v1/domains/{domain}/records/HTTPS/{name}
+		parts := strings.Split(req.URL.Path, "/")
+		if len(parts) != 7 || parts[4] != "records" || parts[5] != "HTTPS" {
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		domain, name := parts[3], parts[6]
+		records, ok := domains[domain]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"code":"NOT_FOUND","message":"Domain not found"}`))
+			return
+		}
+		switch req.Method {
+		case "GET":
+			rrs := records[name]
+			if rrs == nil {
+				rrs = []gdRecord{}
+			}
+			json.NewEncoder(w).Encode(rrs)
+		case "PUT":
+			var rrs []gdRecord
+			if err := json.NewDecoder(req.Body).Decode(&rrs); err != nil {
+				t.Errorf("json: %v", err)
+			}
+			records[name] = rrs
+		default:
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("ts.URL: %v", err)
+	}
+	u.Path = "/v1/domains"
+
+	gd := &GoDaddyPublisher{
+		baseURL:   *u,
+		client:    retryablehttp.NewClient(),
+		apiKey:    "key",
+		apiSecret: "secret",
+	}
+	gd.client.Logger = nil
+
+	targets := []Target{
+		{Zone: "foo.org", Name: "foo.org"},
+		{Zone: "example.org", Name: "example.org"},
+		{Zone: "example.org", Name: "www.example.org"},
+		{Zone: "example.org", Name: "foo.example.org"},
+		{Zone: "example.org", Name: "www.example.com"},
+	}
+
+	t.Run("FirstUpdate", func(t *testing.T) {
+		got := gd.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusUpdated},
+			{Code: StatusNotFound},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		wantRecords := []gdRecord{
+			{Data: `1 . alpn="h2,h3" ech="AQID"`, Name: "www", TTL: 600, Type: "HTTPS"},
+			{Data: `2 . alpn="h2" ech="AQID"`, Name: "www", TTL: 600, Type: "HTTPS"},
+		}
+		if got := domains["example.org"]["www"]; !reflect.DeepEqual(got, wantRecords) {
+			t.Errorf("records = %#v, want %#v", got, wantRecords)
+		}
+	})
+
+	t.Run("SecondUpdate", func(t *testing.T) {
+		got := gd.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusNoChange},
+			{Code: StatusNotFound},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+}
+
+func TestSetECHParam(t *testing.T) {
+	for _, tc := range []struct {
+		data, ech         string
+		wantData, wantOld string
+	}{
+		{`alpn="h3" ech="AQID"`, "BBBB", `alpn="h3" ech="BBBB"`, "AQID"},
+		{`1 . alpn=h2`, "BBBB", `1 . alpn=h2 ech="BBBB"`, ""},
+		{`1  svc.example.com.  ech=AQID   port=8443`, "BBBB", `1 svc.example.com. port=8443 ech="BBBB"`, "AQID"},
+		{`1 . key65000="a \"b\" c" ech="AQID"`, "AQID", `1 . key65000="a \"b\" c" ech="AQID"`, "AQID"},
+	} {
+		gotData, gotOld := setECHParam(tc.data, tc.ech)
+		if gotData != tc.wantData || gotOld != tc.wantOld {
+			t.Errorf("setECHParam(%q, %q) = %q, %q, want %q, %q", tc.data, tc.ech, gotData, gotOld, tc.wantData, tc.wantOld)
+		}
+	}
+}
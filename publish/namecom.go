@@ -0,0 +1,210 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+var namecomBaseURL = url.URL{
+	Scheme: "https",
+	Host:   "api.name.com",
+	Path:   "/v4/domains",
+}
+
+// NewNameComPublisher returns a new NameComPublisher that uses the Name.com
+// API username and token.
+func NewNameComPublisher(username, apiToken string) *NameComPublisher {
+	nc := &NameComPublisher{
+		baseURL:  namecomBaseURL,
+		client:   retryablehttp.NewClient(),
+		username: username,
+		apiToken: apiToken,
+	}
+	nc.client.Logger = nil
+	return nc
+}
+
+var _ ECHPublisher = (*NameComPublisher)(nil)
+
+// NameComPublisher publishes ECH Config Lists to DNS using the Name.com API.
+//
+// The Zone of the targets is the domain name, e.g. example.com, and the Name
+// is the fully qualified name of the records, e.g. www.example.com. All the
+// HTTPS records of a name are updated.
+type NameComPublisher struct {
+	baseURL  url.URL
+	client   *retryablehttp.Client
+	username string
+	apiToken string
+	logger   *slog.Logger
+}
+
+// SetLogger sets the logger that is used to log the result of each update.
+// The updated records are logged at the Info level, the unchanged records at
+// the Debug level, and the failures at the Warn level. The default is
+// [slog.Default].
+func (nc *NameComPublisher) SetLogger(l *slog.Logger) {
+	nc.logger = l
+}
+
+// ncRecord is a DNS record of the Name.com API. The answer of HTTPS records is
+// in presentation format, e.g. `1 . alpn="h2" ech="..."`.
+type ncRecord struct {
+	ID         int    `json:"id"`
+	DomainName string `json:"domainName"`
+	Host       string `json:"host"`
+	FQDN       string `json:"fqdn"`
+	Type       string `json:"type"`
+	Answer     string `json:"answer"`
+	TTL        int    `json:"ttl,omitempty"`
+	Priority   int    `json:"priority,omitempty"`
+}
+
+type ncError struct {
+	Message string `json:"message"`
+	Details string `json:"details"`
+}
+
+func (e ncError) Error() string {
+	if e.Details != "" {
+		return e.Message + ": " + e.Details
+	}
+	return e.Message
+}
+
+// PublishECH updates the target DNS records with a new config list.
+func (nc *NameComPublisher) PublishECH(ctx context.Context, records []Target, configList []byte) []TargetResult {
+	zones := make(map[string][]ncRecord)
+	zoneErrs := make(map[string]error)
+
+	newValue := base64.StdEncoding.EncodeToString(configList)
+	results := make([]TargetResult, 0, len(records))
+
+	for _, r := range records {
+		zone := strings.TrimSuffix(strings.ToLower(r.Zone), ".")
+		if _, exists := zones[zone]; !exists && zoneErrs[zone] == nil {
+			rrs, err := nc.getRecords(ctx, zone)
+			if err != nil {
+				zoneErrs[zone] = err
+			}
+			zones[zone] = rrs
+		}
+		if err := zoneErrs[zone]; err == errNotFound {
+			results = append(results, TargetResult{Code: StatusNotFound})
+			continue
+		} else if err != nil {
+			results = append(results, TargetResult{Code: StatusError, Error: err})
+			continue
+		}
+		results = append(results, nc.publish(ctx, zones[zone], r.Name, newValue))
+	}
+	logResults(ctx, nc.logger, records, results)
+	return results
+}
+
+// publish updates the HTTPS records of name in rrs.
+func (nc *NameComPublisher) publish(ctx context.Context, rrs []ncRecord, name, newValue string) TargetResult {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	result := TargetResult{Code: StatusNotFound}
+	for i := range rrs {
+		rr := &rrs[i]
+		if rr.Type != "HTTPS" || strings.TrimSuffix(strings.ToLower(rr.FQDN), ".") != name {
+			continue
+		}
+		if result.Code == StatusNotFound {
+			result.Code = StatusNoChange
+		}
+		newAnswer, oldValue := setECHParam(rr.Answer, newValue)
+		if oldValue == newValue {
+			continue
+		}
+		rr.Answer = newAnswer
+		if err := nc.updateRecord(ctx, *rr); err != nil {
+			return TargetResult{Code: StatusError, Error: err}
+		}
+		result.Code = StatusUpdated
+	}
+	return result
+}
+
+func (nc *NameComPublisher) do(req *retryablehttp.Request) ([]byte, error) {
+	req.SetBasicAuth(nc.username, nc.apiToken)
+	req.Header.Set("Accept", "application/json")
+	resp, err := nc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e ncError
+		if err := json.Unmarshal(b, &e); err == nil && e.Message != "" {
+			return nil, fmt.Errorf("status code %d: %w", resp.StatusCode, e)
+		}
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return b, nil
+}
+
+// getRecords returns all the records of zone.
+func (nc *NameComPublisher) getRecords(ctx context.Context, zone string) ([]ncRecord, error) {
+	var rrs []ncRecord
+	for page := 1; page > 0; {
+		u := nc.baseURL
+		u.Path += "/" + zone + "/records"
+		q := u.Query()
+		q.Set("page", strconv.Itoa(page))
+		u.RawQuery = q.Encode()
+		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := nc.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Records  []ncRecord `json:"records"`
+			NextPage int        `json:"nextPage"`
+		}
+		if err := json.Unmarshal(b, &result); err != nil {
+			return nil, err
+		}
+		rrs = append(rrs, result.Records...)
+		if result.NextPage <= page {
+			break
+		}
+		page = result.NextPage
+	}
+	return rrs, nil
+}
+
+func (nc *NameComPublisher) updateRecord(ctx context.Context, rr ncRecord) error {
+	b, err := json.Marshal(rr)
+	if err != nil {
+		return err
+	}
+	u := nc.baseURL
+	u.Path += "/" + rr.DomainName + "/records/" + strconv.Itoa(rr.ID)
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = nc.do(req)
+	return err
+}
@@ -0,0 +1,135 @@
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+func TestNameCom(t *testing.T) {
+	var mu sync.Mutex
+	records := []*ncRecord{
+		{ID: 1, DomainName: "example.org", Host: "", FQDN: "example.org.", Type: "HTTPS", Answer: `1 . alpn="h3" ech="AQID"`, TTL: 300},
+		{ID: 2, DomainName: "example.org", Host: "www", FQDN: "www.example.org.", Type: "A", Answer: "192.0.2.1", TTL: 300},
+		{ID: 3, DomainName: "example.org", Host: "www", FQDN: "www.example.org.", Type: "HTTPS", Answer: `1 . alpn="h2,h3" ech="AAAA"`, TTL: 300},
+		{ID: 4, DomainName: "example.org", Host: "www", FQDN: "www.example.org.", Type: "HTTPS", Answer: `2 . alpn="h2"`, TTL: 300},
+	}
+	var updates []int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "token" {
+			t.Errorf("BasicAuth = %q, %q, %v", user, pass, ok)
+		}
+		// /v4/domains/{domain}/records[/{id}]
+		parts := strings.Split(req.URL.Path, "/")
+		if len(parts) < 5 || parts[4] != "records" {
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		if parts[3] != "example.org" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		switch {
+		case req.Method == "GET" && len(parts) == 5:
+			// One record per page.
+			page, _ := strconv.Atoi(req.URL.Query().Get("page"))
+			resp := struct {
+				Records  []*ncRecord `json:"records"`
+				NextPage int         `json:"nextPage,omitempty"`
+				LastPage int         `json:"lastPage"`
+			}{LastPage: len(records)}
+			if page >= 1 && page <= len(records) {
+				resp.Records = records[page-1 : page]
+			}
+			if page < len(records) {
+				resp.NextPage = page + 1
+			}
+			json.NewEncoder(w).Encode(resp)
+		case req.Method == "PUT" && len(parts) == 6:
+			id, _ := strconv.Atoi(parts[5])
+			for _, rr := range records {
+				if rr.ID != id {
+					continue
+				}
+				if err := json.NewDecoder(req.Body).Decode(rr); err != nil {
+					t.Errorf("json: %v", err)
+				}
+				updates = append(updates, id)
+				json.NewEncoder(w).Encode(rr)
+				return
+			}
+			http.NotFound(w, req)
+		default:
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("ts.URL: %v", err)
+	}
+	u.Path = "/v4/domains"
+
+	nc := &NameComPublisher{
+		baseURL:  *u,
+		client:   retryablehttp.NewClient(),
+		username: "user",
+		apiToken: "token",
+	}
+	nc.client.Logger = nil
+
+	targets := []Target{
+		{Zone: "foo.org", Name: "foo.org"},
+		{Zone: "example.org", Name: "example.org"},
+		{Zone: "example.org", Name: "www.example.org"},
+		{Zone: "example.org", Name: "foo.example.org"},
+	}
+
+	t.Run("FirstUpdate", func(t *testing.T) {
+		got := nc.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusUpdated},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if want := []int{3, 4}; !reflect.DeepEqual(updates, want) {
+			t.Errorf("updates = %v, want %v", updates, want)
+		}
+		if got, want := records[3].Answer, `2 . alpn="h2" ech="AQID"`; got != want {
+			t.Errorf("answer = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("SecondUpdate", func(t *testing.T) {
+		got := nc.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusNoChange},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+var scalewayBaseURL = url.URL{
+	Scheme: "https",
+	Host:   "api.scaleway.com",
+	Path:   "/domain/v2beta1/dns-zones",
+}
+
+// NewScalewayPublisher returns a new ScalewayPublisher that uses the Scaleway
+// API secret key. The key must have the DomainsDNSFullAccess permission on the
+// target zone(s).
+func NewScalewayPublisher(secretKey string) *ScalewayPublisher {
+	sw := &ScalewayPublisher{
+		baseURL:   scalewayBaseURL,
+		client:    retryablehttp.NewClient(),
+		secretKey: secretKey,
+	}
+	sw.client.Logger = nil
+	return sw
+}
+
+var _ ECHPublisher = (*ScalewayPublisher)(nil)
+
+// ScalewayPublisher publishes ECH Config Lists to DNS using the Scaleway
+// Domains and DNS API.
+//
+// The Zone of the targets is the DNS zone, e.g. example.com, and the Name is
+// the fully qualified name of the records, e.g. www.example.com. All the HTTPS
+// records of a name are updated.
+type ScalewayPublisher struct {
+	baseURL   url.URL
+	client    *retryablehttp.Client
+	secretKey string
+	logger    *slog.Logger
+}
+
+// SetLogger sets the logger that is used to log the result of each update.
+// The updated records are logged at the Info level, the unchanged records at
+// the Debug level, and the failures at the Warn level. The default is
+// [slog.Default].
+func (sw *ScalewayPublisher) SetLogger(l *slog.Logger) {
+	sw.logger = l
+}
+
+// swRecord is a DNS record of the Scaleway API. The data of HTTPS records is in
+// presentation format, e.g. `1 . alpn="h2" ech="..."`.
+type swRecord struct {
+	ID       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Data     string `json:"data"`
+	TTL      int    `json:"ttl"`
+	Priority int    `json:"priority"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type swSetChange struct {
+	ID      string     `json:"id"`
+	Records []swRecord `json:"records"`
+}
+
+type swChange struct {
+	Set swSetChange `json:"set"`
+}
+
+type swError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (e swError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// PublishECH updates the target DNS records with a new config list.
+func (sw *ScalewayPublisher) PublishECH(ctx context.Context, records []Target, configList []byte) []TargetResult {
+	newValue := base64.StdEncoding.EncodeToString(configList)
+	results := make([]TargetResult, 0, len(records))
+
+	for _, r := range records {
+		results = append(results, sw.publish(ctx, r, newValue))
+	}
+	logResults(ctx, sw.logger, records, results)
+	return results
+}
+
+func (sw *ScalewayPublisher) publish(ctx context.Context, r Target, newValue string) TargetResult {
+	name, ok := relativeName(r.Zone, r.Name)
+	if !ok {
+		return TargetResult{Code: StatusNotFound}
+	}
+	// Scaleway uses an empty name for the zone itself.
+	if name == "@" {
+		name = ""
+	}
+	rrs, err := sw.getRecords(ctx, r.Zone, name)
+	if err == errNotFound || (err == nil && len(rrs) == 0) {
+		return TargetResult{Code: StatusNotFound}
+	}
+	if err != nil {
+		return TargetResult{Code: StatusError, Error: err}
+	}
+	var changes []swChange
+	for _, rr := range rrs {
+		newData, oldValue := setECHParam(rr.Data, newValue)
+		if oldValue == newValue {
+			continue
+		}
+		id := rr.ID
+		rr.ID = ""
+		rr.Data = newData
+		changes = append(changes, swChange{Set: swSetChange{ID: id, Records: []swRecord{rr}}})
+	}
+	if len(changes) == 0 {
+		return TargetResult{Code: StatusNoChange}
+	}
+	if err := sw.updateRecords(ctx, r.Zone, changes); err != nil {
+		return TargetResult{Code: StatusError, Error: err}
+	}
+	return TargetResult{Code: StatusUpdated}
+}
+
+func (sw *ScalewayPublisher) do(req *retryablehttp.Request) ([]byte, error) {
+	req.Header.Set("X-Auth-Token", sw.secretKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := sw.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e swError
+		if err := json.Unmarshal(b, &e); err == nil && e.Message != "" {
+			return nil, fmt.Errorf("status code %d: %w", resp.StatusCode, e)
+		}
+		return nil, fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return b, nil
+}
+
+// getRecords returns the HTTPS records of name in zone.
+func (sw *ScalewayPublisher) getRecords(ctx context.Context, zone, name string) ([]swRecord, error) {
+	var rrs []swRecord
+	for page := 1; ; page++ {
+		u := sw.baseURL
+		u.Path += "/" + zone + "/records"
+		q := u.Query()
+		q.Set("name", name)
+		q.Set("type", "HTTPS")
+		q.Set("page", strconv.Itoa(page))
+		q.Set("page_size", "100")
+		u.RawQuery = q.Encode()
+		req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := sw.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Records    []swRecord `json:"records"`
+			TotalCount int        `json:"total_count"`
+		}
+		if err := json.Unmarshal(b, &result); err != nil {
+			return nil, err
+		}
+		// The name filter may also match other names, e.g. when it is
+		// empty.
+		for _, rr := range result.Records {
+			if rr.Name == name && rr.Type == "HTTPS" {
+				rrs = append(rrs, rr)
+			}
+		}
+		if len(result.Records) == 0 || page*100 >= result.TotalCount {
+			break
+		}
+	}
+	return rrs, nil
+}
+
+// updateRecords applies the changes to the records of zone.
+func (sw *ScalewayPublisher) updateRecords(ctx context.Context, zone string, changes []swChange) error {
+	b, err := json.Marshal(struct {
+		Changes []swChange `json:"changes"`
+	}{Changes: changes})
+	if err != nil {
+		return err
+	}
+	u := sw.baseURL
+	u.Path += "/" + zone + "/records"
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPatch, u.String(), bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = sw.do(req)
+	return err
+}
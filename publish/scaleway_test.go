@@ -0,0 +1,131 @@
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+func TestScaleway(t *testing.T) {
+	var mu sync.Mutex
+	records := []*swRecord{
+		{ID: "r1", Name: "", Type: "HTTPS", Data: `1 . alpn="h3" ech="AQID"`, TTL: 300},
+		{ID: "r2", Name: "www", Type: "A", Data: "192.0.2.1", TTL: 300},
+		{ID: "r3", Name: "www", Type: "HTTPS", Data: `1 . alpn="h2,h3" ech="AAAA"`, TTL: 300},
+		{ID: "r4", Name: "www", Type: "HTTPS", Data: `2 . alpn="h2"`, TTL: 300},
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if got, want := req.Header.Get("X-Auth-Token"), "secret"; got != want {
+			t.Errorf("X-Auth-Token = %q, want %q", got, want)
+		}
+		// /domain/v2beta1/dns-zones/{zone}/records
+		parts := strings.Split(req.URL.Path, "/")
+		if len(parts) != 6 || parts[5] != "records" {
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+			http.NotFound(w, req)
+			return
+		}
+		if parts[4] != "example.org" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"type":"not_found","message":"resource is not found"}`))
+			return
+		}
+		switch req.Method {
+		case "GET":
+			q := req.URL.Query()
+			var resp struct {
+				Records    []*swRecord `json:"records"`
+				TotalCount int         `json:"total_count"`
+			}
+			resp.Records = []*swRecord{}
+			for _, rr := range records {
+				if strings.Contains(rr.Name, q.Get("name")) && rr.Type == q.Get("type") {
+					resp.Records = append(resp.Records, rr)
+				}
+			}
+			resp.TotalCount = len(resp.Records)
+			json.NewEncoder(w).Encode(resp)
+		case "PATCH":
+			var body struct {
+				Changes []swChange `json:"changes"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Errorf("json: %v", err)
+			}
+			for _, c := range body.Changes {
+				for _, rr := range records {
+					if rr.ID == c.Set.ID && len(c.Set.Records) == 1 {
+						*rr = c.Set.Records[0]
+						rr.ID = c.Set.ID
+					}
+				}
+			}
+			w.Write([]byte(`{"records":[]}`))
+		default:
+			t.Errorf("Received %s request for %q", req.Method, req.URL.Path)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("ts.URL: %v", err)
+	}
+	u.Path = "/domain/v2beta1/dns-zones"
+
+	sw := &ScalewayPublisher{
+		baseURL:   *u,
+		client:    retryablehttp.NewClient(),
+		secretKey: "secret",
+	}
+	sw.client.Logger = nil
+
+	targets := []Target{
+		{Zone: "foo.org", Name: "foo.org"},
+		{Zone: "example.org", Name: "example.org"},
+		{Zone: "example.org", Name: "www.example.org"},
+		{Zone: "example.org", Name: "foo.example.org"},
+	}
+
+	t.Run("FirstUpdate", func(t *testing.T) {
+		got := sw.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusUpdated},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		want3 := swRecord{ID: "r3", Name: "www", Type: "HTTPS", Data: `1 . alpn="h2,h3" ech="AQID"`, TTL: 300}
+		want4 := swRecord{ID: "r4", Name: "www", Type: "HTTPS", Data: `2 . alpn="h2" ech="AQID"`, TTL: 300}
+		if *records[2] != want3 || *records[3] != want4 {
+			t.Errorf("records = %+v, %+v, want %+v, %+v", *records[2], *records[3], want3, want4)
+		}
+	})
+
+	t.Run("SecondUpdate", func(t *testing.T) {
+		got := sw.PublishECH(t.Context(), targets, []byte{1, 2, 3})
+		want := []TargetResult{
+			{Code: StatusNotFound},
+			{Code: StatusNoChange},
+			{Code: StatusNoChange},
+			{Code: StatusNotFound},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("results = %#v, want %#v", got, want)
+		}
+	})
+}
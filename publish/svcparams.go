@@ -0,0 +1,57 @@
+package publish
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitFields splits the presentation format of HTTPS record data, e.g.
+// `1 . alpn="h2,h3" ech="AEn+DQ..."`, or of its SvcParams only, into its
+// space-separated fields. Quoted values can contain spaces and escaped
+// characters. RFC 9460 Section 2.1
+func splitFields(s string) []string {
+	var fields []string
+	var cur strings.Builder
+	var inQuotes, escaped, inField bool
+	for _, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			inQuotes = !inQuotes
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+			continue
+		}
+		cur.WriteRune(c)
+		inField = true
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields
+}
+
+// setECHParam returns the HTTPS record data in presentation format with its
+// ech SvcParam set to ech, which is base64-encoded, and the previous value of
+// the ech SvcParam, if any. The other fields are unchanged. The ech SvcParam
+// is moved to the end.
+func setECHParam(data, ech string) (newData, oldECH string) {
+	fields := splitFields(data)
+	out := make([]string, 0, len(fields)+1)
+	for _, f := range fields {
+		if k, v, ok := strings.Cut(f, "="); ok && k == "ech" {
+			oldECH = strings.Trim(v, `"`)
+			continue
+		}
+		out = append(out, f)
+	}
+	out = append(out, fmt.Sprintf(`ech="%s"`, ech))
+	return strings.Join(out, " "), oldECH
+}
@@ -0,0 +1,116 @@
+// Package testutil contains test helpers for the applications that use the
+// publish package.
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/c2FmZQ/ech/publish"
+)
+
+var _ publish.ECHPublisher = (*FakePublisher)(nil)
+
+// FakePublisher is an in-memory [publish.ECHPublisher] that records the calls
+// to PublishECH, so that the logic that publishes ECH config lists can be
+// tested without a DNS provider. Its zero value is ready to use.
+//
+// By default, the records are updated in memory like a real DNS provider
+// would: the result is [publish.StatusUpdated] when the config list changes,
+// and [publish.StatusNoChange] otherwise. SetResult overrides the result of
+// specific targets.
+type FakePublisher struct {
+	mu       sync.Mutex
+	calls    []PublishCall
+	records  map[publish.Target][]byte
+	results  map[publish.Target]publish.TargetResult
+	fallback *publish.TargetResult
+}
+
+// PublishCall is a recorded call to PublishECH.
+type PublishCall struct {
+	Records    []publish.Target
+	ConfigList []byte
+	Results    []publish.TargetResult
+}
+
+// PublishECH implements [publish.ECHPublisher].
+func (p *FakePublisher) PublishECH(ctx context.Context, records []publish.Target, configList []byte) []publish.TargetResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.records == nil {
+		p.records = make(map[publish.Target][]byte)
+	}
+	results := make([]publish.TargetResult, len(records))
+	for i, t := range records {
+		if err := ctx.Err(); err != nil {
+			results[i] = publish.TargetResult{Code: publish.StatusError, Error: err}
+			continue
+		}
+		if r, ok := p.results[t]; ok {
+			results[i] = r
+			continue
+		}
+		if p.fallback != nil {
+			results[i] = *p.fallback
+			continue
+		}
+		if v, ok := p.records[t]; ok && bytes.Equal(v, configList) {
+			results[i] = publish.TargetResult{Code: publish.StatusNoChange}
+			continue
+		}
+		p.records[t] = bytes.Clone(configList)
+		results[i] = publish.TargetResult{Code: publish.StatusUpdated}
+	}
+	p.calls = append(p.calls, PublishCall{
+		Records:    slices.Clone(records),
+		ConfigList: bytes.Clone(configList),
+		Results:    slices.Clone(results),
+	})
+	return results
+}
+
+// SetResult makes PublishECH return result for target, instead of updating
+// the record, e.g. to simulate errors or missing records.
+func (p *FakePublisher) SetResult(target publish.Target, result publish.TargetResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.results == nil {
+		p.results = make(map[publish.Target]publish.TargetResult)
+	}
+	p.results[target] = result
+}
+
+// SetDefaultResult makes PublishECH return result for all the targets that
+// don't have a result set with SetResult.
+func (p *FakePublisher) SetDefaultResult(result publish.TargetResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fallback = &result
+}
+
+// ClearResults removes the results set with SetResult and SetDefaultResult.
+func (p *FakePublisher) ClearResults() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results = nil
+	p.fallback = nil
+}
+
+// Calls returns the calls to PublishECH, in order.
+func (p *FakePublisher) Calls() []PublishCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.calls)
+}
+
+// ConfigList returns the config list that was last published successfully to
+// target.
+func (p *FakePublisher) ConfigList(target publish.Target) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v, ok := p.records[target]
+	return bytes.Clone(v), ok
+}
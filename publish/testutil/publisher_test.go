@@ -0,0 +1,70 @@
+package testutil_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/c2FmZQ/ech/publish"
+	"github.com/c2FmZQ/ech/publish/testutil"
+)
+
+func TestFakePublisher(t *testing.T) {
+	ctx := context.Background()
+	var pub publish.ECHPublisher = &testutil.FakePublisher{}
+	fake := pub.(*testutil.FakePublisher)
+
+	a := publish.Target{Zone: "example.com", Name: "a.example.com"}
+	b := publish.Target{Zone: "example.com", Name: "b.example.com"}
+
+	codes := func(results []publish.TargetResult) []publish.StatusCode {
+		var out []publish.StatusCode
+		for _, r := range results {
+			out = append(out, r.Code)
+		}
+		return out
+	}
+	check := func(got []publish.TargetResult, want ...publish.StatusCode) {
+		t.Helper()
+		if g := codes(got); !slices.Equal(g, want) {
+			t.Errorf("PublishECH() = %v, want %v", g, want)
+		}
+	}
+
+	check(pub.PublishECH(ctx, []publish.Target{a, b}, []byte("one")), publish.StatusUpdated, publish.StatusUpdated)
+	check(pub.PublishECH(ctx, []publish.Target{a, b}, []byte("one")), publish.StatusNoChange, publish.StatusNoChange)
+
+	fake.SetResult(b, publish.TargetResult{Code: publish.StatusNotFound})
+	check(pub.PublishECH(ctx, []publish.Target{a, b}, []byte("two")), publish.StatusUpdated, publish.StatusNotFound)
+	if v, _ := fake.ConfigList(a); string(v) != "two" {
+		t.Errorf("ConfigList(a) = %q, want two", v)
+	}
+	if v, _ := fake.ConfigList(b); string(v) != "one" {
+		t.Errorf("ConfigList(b) = %q, want one", v)
+	}
+
+	errFail := errors.New("fail")
+	fake.SetDefaultResult(publish.TargetResult{Code: publish.StatusError, Error: errFail})
+	results := pub.PublishECH(ctx, []publish.Target{a, b}, []byte("three"))
+	check(results, publish.StatusError, publish.StatusNotFound)
+	if err := results[0].Err(); !errors.Is(err, errFail) {
+		t.Errorf("Err() = %v, want %v", err, errFail)
+	}
+
+	fake.ClearResults()
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	check(pub.PublishECH(cctx, []publish.Target{a}, []byte("three")), publish.StatusError)
+	check(pub.PublishECH(ctx, []publish.Target{a, b}, []byte("three")), publish.StatusUpdated, publish.StatusUpdated)
+
+	calls := fake.Calls()
+	if got, want := len(calls), 6; got != want {
+		t.Fatalf("len(Calls()) = %d, want %d", got, want)
+	}
+	last := calls[len(calls)-1]
+	if !bytes.Equal(last.ConfigList, []byte("three")) || len(last.Records) != 2 || last.Records[1] != b {
+		t.Errorf("Calls()[5] = %+v", last)
+	}
+}
@@ -3,11 +3,18 @@
 // It uses [ech.Dialer] for name resolution and finding the Encrypted Client
 // Hello (ECH) Config List, and [quic.DialAddr] for establishing the QUIC
 // connection.
+//
+// It also implements a [Frontend] that routes QUIC connections to backend
+// servers based on their encrypted SNI, a DNS-over-QUIC resolver with
+// [NewDoQResolver], and a Dialer that tunnels the connections through a MASQUE
+// proxy with [NewMASQUEDialer].
 package quic
 
 import (
 	"context"
 	"crypto/tls"
+	"net"
+	"sync"
 
 	"github.com/c2FmZQ/ech"
 	"github.com/quic-go/quic-go"
@@ -20,6 +27,11 @@ import (
 // If the name resolution returns multiple IP addresses, Dial iterates over them
 // until a connection is successfully established.
 //
+// With [WithEarlyData], the TLS sessions and QUIC address validation tokens
+// are shared by all the calls to Dial, so that repeated connections to the
+// same server skip a round trip. Likewise, with [WithTransport] and a nil
+// Transport, all the calls to Dial share the same UDP socket.
+//
 // Dial is equivalent to:
 //
 //	NewDialer(...).Dial(...)
@@ -30,15 +42,178 @@ import (
 //	dialer := NewDialer(&quic.Config{})
 //	dialer.RequireECH = true
 //	conn, err := dialer.Dial(...)
-func Dial(ctx context.Context, network, addr string, tc *tls.Config, qc *quic.Config) (*quic.Conn, error) {
-	return NewDialer(qc).Dial(ctx, network, addr, tc)
+func Dial(ctx context.Context, network, addr string, tc *tls.Config, qc *quic.Config, opts ...Option) (*quic.Conn, error) {
+	opts = append([]Option{withShared(sharedSessionCache, sharedTokenStore, sharedTransport)}, opts...)
+	return NewDialer(qc, opts...).Dial(ctx, network, addr, tc)
+}
+
+var (
+	// sharedSessionCache, sharedTokenStore, and sharedTransport are used
+	// by Dial.
+	sharedSessionCache = tls.NewLRUClientSessionCache(0)
+	sharedTokenStore   = quic.NewLRUTokenStore(100, 4)
+	sharedTransport    = &lazyTransport{}
+)
+
+// Option is an option for [Dial] and [NewDialer].
+type Option func(*options)
+
+type options struct {
+	earlyData     bool
+	sessionCache  tls.ClientSessionCache
+	tokenStore    quic.TokenStore
+	withTransport bool
+	transport     *lazyTransport
+}
+
+// WithEarlyData makes the Dialer send early data (0-RTT) when it resumes a
+// previous session with the same server. The sessions are stored in the
+// tls.Config's ClientSessionCache, or in a cache shared by all the
+// connections of the Dialer if it is nil. Likewise, the address validation
+// tokens issued by the servers are stored in the quic.Config's TokenStore, or
+// in a shared store if it is nil, so that the servers don't need to validate
+// the client's address with a Retry.
+//
+// The connection is returned as soon as 0-RTT data can be sent, i.e. before
+// the handshake completes. Early data isn't protected against replay attacks.
+// Only replay-safe data, e.g. idempotent requests, should be sent before
+// conn.HandshakeComplete() is closed. After that,
+// conn.ConnectionState().Used0RTT reports whether the server accepted the
+// early data. Handshake errors, including the rejection of Encrypted Client
+// Hello, are then reported by the connection instead of Dial. For the same
+// reason, the Dialer can't tell whether the server accepted ECH, and its
+// RequireECHAccepted and ConfigPins options can't be used.
+func WithEarlyData() Option {
+	return func(o *options) {
+		o.earlyData = true
+	}
+}
+
+// WithTransport makes the Dialer establish all its connections with tr, i.e.
+// with a single UDP socket, instead of opening a new socket for each
+// connection attempt. The concurrent attempts to connect to multiple
+// addresses, and the subsequent connections, share the same socket, which is
+// also required for connection migration.
+//
+// If tr is nil, a Transport is created when the Dialer is first used. Its
+// socket is bound to an ephemeral port on all the interfaces, and remains open
+// for the lifetime of the program.
+func WithTransport(tr *quic.Transport) Option {
+	return func(o *options) {
+		o.withTransport = true
+		if tr != nil {
+			o.transport = &lazyTransport{tr: tr}
+		}
+	}
+}
+
+// withShared sets the caches and the transport shared by the calls to Dial.
+func withShared(sessionCache tls.ClientSessionCache, tokenStore quic.TokenStore, transport *lazyTransport) Option {
+	return func(o *options) {
+		o.sessionCache = sessionCache
+		o.tokenStore = tokenStore
+		o.transport = transport
+	}
 }
 
 // NewDialer returns a [quic.Connection] Dialer.
-func NewDialer(qc *quic.Config) *ech.Dialer[*quic.Conn] {
+func NewDialer(qc *quic.Config, opts ...Option) *ech.Dialer[*quic.Conn] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dialAddr := quic.DialAddr
+	if o.earlyData {
+		dialAddr = quic.DialAddrEarly
+	}
+	if o.withTransport {
+		if o.transport == nil {
+			o.transport = &lazyTransport{}
+		}
+		dialAddr = o.transport.dialFunc(o.earlyData)
+	}
+	if !o.earlyData {
+		return &ech.Dialer[*quic.Conn]{
+			DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*quic.Conn, error) {
+				return dialAddr(ctx, addr, tc, qc)
+			},
+			ConnectionStateFunc: tlsConnectionState,
+		}
+	}
+	if o.sessionCache == nil {
+		o.sessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	if o.tokenStore == nil {
+		o.tokenStore = quic.NewLRUTokenStore(100, 4)
+	}
+	if qc == nil {
+		qc = &quic.Config{}
+	}
+	if qc.TokenStore == nil {
+		qc = qc.Clone()
+		qc.TokenStore = o.tokenStore
+	}
 	return &ech.Dialer[*quic.Conn]{
 		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*quic.Conn, error) {
-			return quic.DialAddr(ctx, addr, tc, qc)
+			if tc.ClientSessionCache == nil {
+				tc = tc.Clone()
+				tc.ClientSessionCache = o.sessionCache
+			}
+			return dialAddr(ctx, addr, tc, qc)
 		},
 	}
 }
+
+// tlsConnectionState returns the TLS state of a connection whose handshake is
+// complete.
+func tlsConnectionState(conn *quic.Conn) tls.ConnectionState {
+	return conn.ConnectionState().TLS
+}
+
+// lazyTransport is a [quic.Transport] that is created when it is first used.
+type lazyTransport struct {
+	mu sync.Mutex
+	tr *quic.Transport
+}
+
+func (t *lazyTransport) get() (*quic.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tr != nil {
+		return t.tr, nil
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	t.tr = &quic.Transport{Conn: conn}
+	return t.tr, nil
+}
+
+// dialFunc returns a function that dials with the transport, like
+// quic.DialAddr, or quic.DialAddrEarly if early is true.
+func (t *lazyTransport) dialFunc(early bool) func(context.Context, string, *tls.Config, *quic.Config) (*quic.Conn, error) {
+	return func(ctx context.Context, addr string, tc *tls.Config, qc *quic.Config) (*quic.Conn, error) {
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		tr, err := t.get()
+		if err != nil {
+			return nil, err
+		}
+		if early {
+			return tr.DialEarly(ctx, raddr, tc, qc)
+		}
+		return tr.Dial(ctx, raddr, tc, qc)
+	}
+}
+
+// NewEarlyDialer returns a [quic.Connection] Dialer that sends early data
+// (0-RTT) when it resumes a previous session with the same server. It is
+// equivalent to:
+//
+//	NewDialer(qc, WithEarlyData())
+func NewEarlyDialer(qc *quic.Config) *ech.Dialer[*quic.Conn] {
+	return NewDialer(qc, WithEarlyData())
+}
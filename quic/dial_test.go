@@ -149,6 +149,29 @@ func TestDial(t *testing.T) {
 			t.Errorf("Got %q, want %q", got, want)
 		}
 	}
+
+	// h2.example.com is pinned with the retry configs, which don't match
+	// the config list from DNS after that.
+	pins, err := ech.NewConfigPinStore("", time.Hour)
+	if err != nil {
+		t.Fatalf("NewConfigPinStore: %v", err)
+	}
+	dialer := NewDialer(nil)
+	dialer.RequireECHAccepted = true
+	dialer.ConfigPins = pins
+	for range 2 {
+		conn, err := dialer.Dial(t.Context(), "udp", "h2.example.com", &tls.Config{
+			RootCAs:    rootCAs,
+			NextProtos: []string{"foo"},
+		})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		conn.CloseWithError(0, "")
+		if err := pins.Check("h2.example.com", configList2); !errors.Is(err, ech.ErrConfigPinMismatch) {
+			t.Errorf("Check() = %v, want %v", err, ech.ErrConfigPinMismatch)
+		}
+	}
 }
 
 func Example() {
@@ -202,3 +225,201 @@ func ExampleNewDialer() {
 	defer stream.Close()
 	fmt.Fprintln(stream, "Hello!")
 }
+
+func TestEarlyDial(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	ln, err := quic.ListenAddrEarly("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:      config,
+			PrivateKey:  privKey.Bytes(),
+			SendAsRetry: true,
+		}},
+	}, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatalf("quic.ListenAddrEarly: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		ctx := t.Context()
+		for {
+			server, err := ln.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := server.AcceptStream(ctx)
+				if err != nil {
+					server.CloseWithError(0x11, err.Error())
+					return
+				}
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+
+	tc := &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		NextProtos:                     []string{"foo"},
+		EncryptedClientHelloConfigList: configList,
+	}
+	// The first connection of each Dialer can't use 0-RTT. Dial's cache
+	// is shared by all the calls, including previous test runs.
+	sharedSessionCache = tls.NewLRUClientSessionCache(0)
+	dialer := NewEarlyDialer(nil)
+	for i, d := range []struct {
+		dial     func() (*quic.Conn, error)
+		want0RTT bool
+	}{
+		{func() (*quic.Conn, error) { return dialer.Dial(t.Context(), "udp", ln.Addr().String(), tc) }, false},
+		{func() (*quic.Conn, error) { return dialer.Dial(t.Context(), "udp", ln.Addr().String(), tc) }, true},
+		{func() (*quic.Conn, error) {
+			return Dial(t.Context(), "udp", ln.Addr().String(), tc, nil, WithEarlyData())
+		}, false},
+		{func() (*quic.Conn, error) {
+			return Dial(t.Context(), "udp", ln.Addr().String(), tc, nil, WithEarlyData())
+		}, true},
+		{func() (*quic.Conn, error) { return Dial(t.Context(), "udp", ln.Addr().String(), tc, nil) }, false},
+	} {
+		client, err := d.dial()
+		if err != nil {
+			t.Fatalf("[%d] Dial: %v", i, err)
+		}
+		stream, err := client.OpenStream()
+		if err != nil {
+			t.Fatalf("[%d] OpenStream: %v", i, err)
+		}
+		stream.Write([]byte("Hi\n"))
+		stream.Close()
+		b, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("[%d] ReadAll: %v", i, err)
+		}
+		if got, want := string(b), "Hi\n"; got != want {
+			t.Errorf("[%d] Got %q, want %q", i, got, want)
+		}
+		<-client.HandshakeComplete()
+		state := client.ConnectionState()
+		if !state.TLS.ECHAccepted {
+			t.Errorf("[%d] ECHAccepted is false", i)
+		}
+		if state.Used0RTT != d.want0RTT {
+			t.Errorf("[%d] Used0RTT = %v, want %v", i, state.Used0RTT, d.want0RTT)
+		}
+		client.CloseWithError(0, "")
+	}
+}
+
+func TestDialTransport(t *testing.T) {
+	tlsCert, err := testutil.NewCert("private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("quic.ListenAddr: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			if _, err := ln.Accept(t.Context()); err != nil {
+				return
+			}
+		}
+	}()
+
+	pc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	tr := &quic.Transport{Conn: pc}
+	defer tr.Close()
+
+	config := &tls.Config{
+		ServerName: "private.example.com",
+		RootCAs:    rootCAs,
+		NextProtos: []string{"foo"},
+	}
+	for _, tc := range []struct {
+		name       string
+		dial       func() (*quic.Conn, error)
+		sameSocket bool
+		localAddr  net.Addr
+	}{
+		{
+			name: "NoTransport",
+			dial: func() (*quic.Conn, error) {
+				return Dial(t.Context(), "udp", ln.Addr().String(), config, nil)
+			},
+		},
+		{
+			name: "Transport",
+			dial: func() (*quic.Conn, error) {
+				return Dial(t.Context(), "udp", ln.Addr().String(), config, nil, WithTransport(tr))
+			},
+			sameSocket: true,
+			localAddr:  pc.LocalAddr(),
+		},
+		{
+			name: "SharedTransport",
+			dial: func() (*quic.Conn, error) {
+				return Dial(t.Context(), "udp", ln.Addr().String(), config, nil, WithTransport(nil))
+			},
+			sameSocket: true,
+		},
+		{
+			name: "DialerTransport",
+			dial: func() func() (*quic.Conn, error) {
+				dialer := NewDialer(nil, WithTransport(nil), WithEarlyData())
+				return func() (*quic.Conn, error) {
+					return dialer.Dial(t.Context(), "udp", ln.Addr().String(), config)
+				}
+			}(),
+			sameSocket: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var addrs []string
+			for range 2 {
+				conn, err := tc.dial()
+				if err != nil {
+					t.Fatalf("Dial: %v", err)
+				}
+				<-conn.HandshakeComplete()
+				addrs = append(addrs, conn.LocalAddr().String())
+				conn.CloseWithError(0, "")
+			}
+			if got := addrs[0] == addrs[1]; got != tc.sameSocket {
+				t.Errorf("LocalAddr = %q, same socket = %v, want %v", addrs, got, tc.sameSocket)
+			}
+			if tc.localAddr != nil && addrs[0] != tc.localAddr.String() {
+				t.Errorf("LocalAddr = %q, want %q", addrs[0], tc.localAddr)
+			}
+		})
+	}
+}
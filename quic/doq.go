@@ -0,0 +1,220 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// The DoQ error codes. RFC 9250 Section 4.3
+const (
+	doqNoError          = 0x0
+	doqInternalError    = 0x1
+	doqProtocolError    = 0x2
+	doqRequestCancelled = 0x3
+)
+
+var errDoQProtocol = errors.New("doq protocol error")
+
+// NewDoQResolver returns a [ech.Resolver] that uses the RFC 9250
+// DNS-over-QUIC (DoQ) service at addr, e.g. "dns.adguard-dns.com:853". The
+// default port number is 853. The tc argument can be nil. Otherwise, it is
+// used to configure the TLS connection, e.g. with RootCAs.
+//
+// Each query is sent on its own stream of a QUIC connection that is reused
+// until it is closed by the server. When the connection is re-established,
+// the queries are sent as 0-RTT early data, which saves a round trip.
+//
+// The returned Resolver can be used as [ech.DefaultResolver].
+//
+//	resolver, err := quic.NewDoQResolver("dns.adguard-dns.com:853", nil)
+//	if err != nil {
+//		// ...
+//	}
+//	ech.DefaultResolver = resolver
+func NewDoQResolver(addr string, tc *tls.Config) (*ech.Resolver, error) {
+	c, err := newDoQClient(addr, tc)
+	if err != nil {
+		return nil, err
+	}
+	return ech.NewResolverFunc(c.exchange), nil
+}
+
+// DoQ sends a RFC 9250 DoQ (DNS-over-QUIC) request to the name server at addr,
+// e.g. "dns.adguard-dns.com" or "94.140.14.14:853", on a new connection. The
+// default port number is 853. The tc argument can be nil. Otherwise, it is
+// used to configure the TLS connection, e.g. with RootCAs. When tc doesn't
+// have a ServerName, the host part of addr is used. The message ID is 0.
+func DoQ(ctx context.Context, msg *dns.Message, addr string, tc *tls.Config) (*dns.Message, error) {
+	c, err := newDoQClient(addr, tc)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	return c.exchange(ctx, msg)
+}
+
+func newDoQClient(addr string, tc *tls.Config) (*doqClient, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+		addr = net.JoinHostPort(addr, "853")
+	}
+	if host == "" {
+		return nil, errors.New("missing host in address")
+	}
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc = tc.Clone()
+	if tc.ServerName == "" {
+		tc.ServerName = host
+	}
+	tc.NextProtos = []string{"doq"}
+	if tc.ClientSessionCache == nil {
+		tc.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	}
+	return &doqClient{addr: addr, tc: tc}, nil
+}
+
+// doqClient sends DNS queries to a DoQ server.
+type doqClient struct {
+	addr string
+	tc   *tls.Config
+
+	mu   sync.Mutex
+	conn *quic.Conn
+}
+
+// getConn returns the current connection, or a new one.
+func (c *doqClient) getConn(ctx context.Context) (*quic.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil && c.conn.Context().Err() == nil {
+		return c.conn, nil
+	}
+	conn, err := quic.DialAddrEarly(ctx, c.addr, c.tc, &quic.Config{
+		MaxIdleTimeout: 30 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// close closes the current connection, if any.
+func (c *doqClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.CloseWithError(doqNoError, "")
+		c.conn = nil
+	}
+}
+
+// reset forgets conn and closes it with code after a connection error.
+func (c *doqClient) reset(conn *quic.Conn, code quic.ApplicationErrorCode, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	conn.CloseWithError(code, err.Error())
+}
+
+func (c *doqClient) exchange(ctx context.Context, msg *dns.Message) (*dns.Message, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	// The message ID must be 0. RFC 9250 Section 4.2.1
+	m := *msg
+	m.ID = 0
+	b := m.Bytes()
+	req := binary.BigEndian.AppendUint16(nil, uint16(len(b)))
+	req = append(req, b...)
+
+	// The connection may have been closed by the server, or the early data
+	// may be rejected. In that case, the query is sent again on a new
+	// connection. Other errors only affect the query's own stream, and the
+	// connection is still used by the other queries.
+	var err error
+	for range 2 {
+		var conn *quic.Conn
+		if conn, err = c.getConn(ctx); err != nil {
+			return nil, err
+		}
+		var result *dns.Message
+		if result, err = doqQuery(ctx, conn, req); err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		// A malformed response is a fatal error. RFC 9250 Section 4.3.3
+		if errors.Is(err, errDoQProtocol) {
+			c.reset(conn, doqProtocolError, err)
+			break
+		}
+		if conn.Context().Err() == nil && !errors.Is(err, quic.Err0RTTRejected) {
+			break
+		}
+		c.reset(conn, doqInternalError, err)
+	}
+	return nil, err
+}
+
+// doqQuery sends a query on a new stream, and reads the response.
+func doqQuery(ctx context.Context, conn *quic.Conn, req []byte) (_ *dns.Message, err error) {
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelRead(doqRequestCancelled)
+		stream.CancelWrite(doqRequestCancelled)
+	})
+	defer stop()
+	defer func() {
+		if err != nil {
+			stream.CancelRead(doqRequestCancelled)
+			stream.CancelWrite(doqRequestCancelled)
+		}
+	}()
+	if _, err := stream.Write(req); err != nil {
+		return nil, err
+	}
+	// The client must indicate that no further data will be sent on the
+	// stream. RFC 9250 Section 4.2
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	var sz [2]byte
+	if _, err := io.ReadFull(stream, sz[:]); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(sz[:]))
+	if _, err := io.ReadFull(stream, body); err != nil {
+		return nil, err
+	}
+	result, err := dns.DecodeMessage(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDoQProtocol, err)
+	}
+	if result.ID != 0 {
+		return nil, fmt.Errorf("%w: invalid message id", errDoQProtocol)
+	}
+	return result, nil
+}
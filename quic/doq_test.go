@@ -0,0 +1,208 @@
+package quic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+	"github.com/quic-go/quic-go"
+)
+
+type testDoQServer struct {
+	addr string
+
+	mu    sync.Mutex
+	conns []*quic.Conn
+}
+
+// startTestDoQServer starts a DNS-over-QUIC server that answers the queries
+// with the records in db.
+func startTestDoQServer(t *testing.T, tlsCert tls.Certificate, db []dns.RR) *testDoQServer {
+	t.Helper()
+	ln, err := quic.ListenAddrEarly("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"doq"},
+	}, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatalf("quic.ListenAddrEarly: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &testDoQServer{addr: ln.Addr().String()}
+	go func() {
+		ctx := t.Context()
+		for {
+			conn, err := ln.Accept(ctx)
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(ctx)
+					if err != nil {
+						return
+					}
+					go func() {
+						defer stream.Close()
+						var sz [2]byte
+						if _, err := io.ReadFull(stream, sz[:]); err != nil {
+							t.Errorf("ReadFull: %v", err)
+							return
+						}
+						body := make([]byte, binary.BigEndian.Uint16(sz[:]))
+						if _, err := io.ReadFull(stream, body); err != nil {
+							t.Errorf("ReadFull: %v", err)
+							return
+						}
+						qq, err := dns.DecodeMessage(body)
+						if err != nil {
+							t.Errorf("DecodeMessage: %v", err)
+							return
+						}
+						if qq.ID != 0 {
+							t.Errorf("Message ID = %d, want 0", qq.ID)
+						}
+						// Refuse the query with DOQ_EXCESSIVE_LOAD.
+						if len(qq.Question) == 1 && qq.Question[0].Name == "busy.example.com" {
+							stream.CancelRead(0x4)
+							stream.CancelWrite(0x4)
+							return
+						}
+						b := testutil.Answer(t, db, qq).Bytes()
+						stream.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+					}()
+				}
+			}()
+		}
+	}()
+	return s
+}
+
+// closeConns closes all the connections of the server.
+func (s *testDoQServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.CloseWithError(0, "bye")
+	}
+}
+
+// stats returns the number of connections, and whether each one used 0-RTT.
+func (s *testDoQServer) stats() (int, []bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var early []bool
+	for _, c := range s.conns {
+		<-c.HandshakeComplete()
+		early = append(early, c.ConnectionState().Used0RTT)
+	}
+	return len(s.conns), early
+}
+
+func TestDoQResolver(t *testing.T) {
+	tlsCert, err := testutil.NewCert("dns.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	server := startTestDoQServer(t, tlsCert, []dns.RR{{
+		Name: "a.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: net.IP{192, 0, 2, 1},
+	}, {
+		Name: "b.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: net.IP{192, 0, 2, 2},
+	}})
+
+	if _, err := NewDoQResolver(":853", nil); err == nil {
+		t.Error("NewDoQResolver succeeded unexpectedly")
+	}
+	resolver, err := NewDoQResolver(server.addr, &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    rootCAs,
+	})
+	if err != nil {
+		t.Fatalf("NewDoQResolver: %v", err)
+	}
+	resolver.SetCacheSize(0)
+
+	resolve := func(name string, want net.IP) {
+		t.Helper()
+		res, err := resolver.Resolve(t.Context(), name)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", name, err)
+		}
+		if len(res.Address) != 1 || !res.Address[0].Equal(want) {
+			t.Errorf("Resolve(%q) = %v, want %v", name, res.Address, want)
+		}
+	}
+	resolve("a.example.com", net.IP{192, 0, 2, 1})
+	resolve("b.example.com", net.IP{192, 0, 2, 2})
+	if n, _ := server.stats(); n != 1 {
+		t.Errorf("Connections = %d, want 1", n)
+	}
+
+	// The connection is re-established with 0-RTT.
+	server.closeConns()
+	resolve("a.example.com", net.IP{192, 0, 2, 1})
+	n, early := server.stats()
+	if n != 2 {
+		t.Errorf("Connections = %d, want 2", n)
+	}
+	if len(early) != 2 || early[0] || !early[1] {
+		t.Errorf("Used0RTT = %v, want [false true]", early)
+	}
+
+	// A stream error doesn't close the connection.
+	if _, err := resolver.Resolve(t.Context(), "busy.example.com"); err == nil {
+		t.Error("Resolve(busy.example.com) succeeded unexpectedly")
+	}
+	resolve("b.example.com", net.IP{192, 0, 2, 2})
+	if n, _ := server.stats(); n != 2 {
+		t.Errorf("Connections = %d, want 2", n)
+	}
+}
+
+func TestDoQ(t *testing.T) {
+	tlsCert, err := testutil.NewCert("dns.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	server := startTestDoQServer(t, tlsCert, []dns.RR{{
+		Name: "a.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: net.IP{192, 0, 2, 1},
+	}})
+
+	qq := &dns.Message{
+		ID: 1234,
+		RD: 1,
+		Question: []dns.Question{{
+			Name:  "a.example.com",
+			Type:  1,
+			Class: 1,
+		}},
+	}
+	result, err := DoQ(t.Context(), qq, server.addr, &tls.Config{
+		ServerName: "dns.example.com",
+		RootCAs:    rootCAs,
+	})
+	if err != nil {
+		t.Fatalf("DoQ: %v", err)
+	}
+	if len(result.Answer) != 1 || !result.Answer[0].Data.(net.IP).Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("DoQ() = %+v", result.Answer)
+	}
+	if qq.ID != 1234 {
+		t.Errorf("ID = %d, want 1234", qq.ID)
+	}
+}
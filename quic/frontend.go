@@ -0,0 +1,131 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// Frontend is a UDP front end that routes QUIC connections to backend QUIC
+// servers based on the ServerName of their ClientHello, i.e. the encrypted SNI
+// when ECH is accepted, or the plaintext SNI otherwise. It is the QUIC
+// analogue of [ech.Conn] and [ech.Conn.ProxyTo].
+//
+// The Frontend removes the protection of the client's Initial packets, which
+// only depends on public values, reassembles the ClientHello from their CRYPTO
+// frames, and decrypts the ClientHelloInner with Keys. Then, all the packets
+// of the connection are forwarded unmodified to the backend, and the backend's
+// packets are sent back to the client.
+//
+// Unlike TLS over TCP, the ClientHelloOuter can't be replaced with the
+// ClientHelloInner without terminating the QUIC connection. So, the backends
+// must also have the ECH keys, e.g. in tls.Config.EncryptedClientHelloKeys,
+// to accept ECH.
+//
+// Connections are identified by the client's address. Connection migration
+// isn't supported.
+//
+// For finer control, [Frontend.Listen] returns a [Listener] whose connections
+// can be inspected and forwarded individually.
+//
+//	f := &quic.Frontend{
+//		Keys: keys,
+//		Route: func(outer, inner *ech.ClientHelloInfo) (string, error) {
+//			if inner == nil {
+//				return "", ech.ErrNoRoute
+//			}
+//			return backends[inner.ServerName], nil
+//		},
+//	}
+//	conn, err := net.ListenPacket("udp", ":443")
+//	...
+//	err = f.Serve(ctx, conn)
+type Frontend struct {
+	// Keys are used to decrypt the Encrypted Client Hello, e.g. the keys
+	// from an [ech.KeyStore].
+	Keys []ech.Key
+	// KeysFunc, if set, selects the keys used to decrypt the Encrypted
+	// Client Hello of each connection, like [ech.WithKeysFunc]. The keys
+	// that it returns replace Keys. When it returns an error, the
+	// connection's packets are dropped.
+	KeysFunc func(outer *ech.ClientHelloInfo) ([]ech.Key, error)
+	// Route returns the address of the backend for a new connection. The
+	// outer argument is the ClientHelloOuter, or the only ClientHello when
+	// ECH isn't used. The inner argument is the decrypted ClientHelloInner,
+	// or nil when ECH isn't accepted. When Route returns an error, the
+	// connection's packets are dropped.
+	Route func(outer, inner *ech.ClientHelloInfo) (string, error)
+	// Router is used to find the backend for a new connection when Route
+	// isn't set, like on the TCP side with [ech.Router.Serve]. Only the
+	// routes with a backend address are used.
+	Router *ech.Router
+	// HandshakeTimeout is the maximum amount of time between the first
+	// packet of a new connection and the start of its forwarding, which
+	// includes receiving the whole ClientHello. The default value is 10s.
+	HandshakeTimeout time.Duration
+	// IdleTimeout is the amount of time after which a connection without
+	// any packet in either direction is forgotten. The default value is 1m.
+	IdleTimeout time.Duration
+	// Debugf, if set, is used to log the dropped packets and connections.
+	Debugf func(format string, arg ...any)
+}
+
+// Serve accepts the connections received on conn and forwards them to the
+// backends returned by Route or Router until ctx is canceled or conn fails.
+func (f *Frontend) Serve(ctx context.Context, conn net.PacketConn) error {
+	if f.Route == nil && f.Router == nil {
+		return errors.New("Route or Router must be set")
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	l := f.Listen(conn)
+	defer l.Close()
+	for {
+		c, err := l.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		wg.Go(func() {
+			addr, err := f.route(c)
+			if err != nil {
+				f.debugf("%s: %v", c.RemoteAddr(), err)
+				c.Close()
+				return
+			}
+			if err := c.ProxyTo(ctx, addr); err != nil {
+				f.debugf("%s: %v", c.RemoteAddr(), err)
+			}
+		})
+	}
+}
+
+func (f *Frontend) route(c *IncomingConn) (string, error) {
+	if f.Route != nil {
+		return f.Route(c.outer, c.inner)
+	}
+	return f.Router.Backend(c.ServerName(), c.ALPNProtos())
+}
+
+func (f *Frontend) handshakeTimeout() time.Duration {
+	if f.HandshakeTimeout > 0 {
+		return f.HandshakeTimeout
+	}
+	return 10 * time.Second
+}
+
+func (f *Frontend) idleTimeout() time.Duration {
+	if f.IdleTimeout > 0 {
+		return f.IdleTimeout
+	}
+	return time.Minute
+}
+
+func (f *Frontend) debugf(format string, arg ...any) {
+	if f.Debugf != nil {
+		f.Debugf(format, arg...)
+	}
+}
@@ -0,0 +1,203 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+	"github.com/quic-go/quic-go"
+)
+
+// startTestQUICServer starts a QUIC server that writes its name on the first
+// stream of each connection.
+func startTestQUICServer(t *testing.T, name string, tc *tls.Config) string {
+	t.Helper()
+	ln, err := quic.ListenAddr("127.0.0.1:0", tc, &quic.Config{
+		Versions: []quic.Version{quic.Version1, quic.Version2},
+	})
+	if err != nil {
+		t.Fatalf("quic.ListenAddr: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		ctx := t.Context()
+		for {
+			conn, err := ln.Accept(ctx)
+			if err != nil {
+				return
+			}
+			go func() {
+				stream, err := conn.AcceptStream(ctx)
+				if err != nil {
+					conn.CloseWithError(0x11, err.Error())
+					return
+				}
+				stream.Write([]byte(name))
+				stream.CancelRead(0)
+				stream.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestFrontend(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("public.example.com", "a.example.com", "b.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:     config,
+			PrivateKey: privKey.Bytes(),
+		}},
+	}
+	backends := map[string]string{
+		"a.example.com":      startTestQUICServer(t, "a", serverConfig),
+		"b.example.com":      startTestQUICServer(t, "b", serverConfig),
+		"public.example.com": startTestQUICServer(t, "public", serverConfig),
+	}
+
+	var mu sync.Mutex
+	var routed []string
+	f := &Frontend{
+		Keys: []ech.Key{{Config: config, PrivateKey: privKey.Bytes()}},
+		Route: func(outer, inner *ech.ClientHelloInfo) (string, error) {
+			name := outer.ServerName
+			if inner != nil {
+				name = inner.ServerName
+			}
+			mu.Lock()
+			routed = append(routed, name)
+			mu.Unlock()
+			if addr, ok := backends[name]; ok {
+				return addr, nil
+			}
+			return "", ech.ErrNoRoute
+		},
+		Debugf: t.Logf,
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	ctx, cancel := context.WithCancel(t.Context())
+	ch := make(chan error)
+	go func() {
+		ch <- f.Serve(ctx, pc)
+	}()
+
+	for _, tc := range []struct {
+		name        string
+		serverName  string
+		configList  []byte
+		version     quic.Version
+		want        string
+		wantRoute   string
+		wantECH     bool
+		wantFailure bool
+	}{
+		{"ECH", "a.example.com", configList, quic.Version1, "a", "a.example.com", true, false},
+		{"ECHv2", "b.example.com", configList, quic.Version2, "b", "b.example.com", true, false},
+		{"NoECH", "public.example.com", nil, quic.Version1, "public", "public.example.com", false, false},
+		{"NoRoute", "c.example.com", nil, quic.Version1, "", "c.example.com", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mu.Lock()
+			routed = nil
+			mu.Unlock()
+			ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+			defer cancel()
+			conn, err := quic.DialAddr(ctx, pc.LocalAddr().String(), &tls.Config{
+				ServerName:                     tc.serverName,
+				RootCAs:                        rootCAs,
+				NextProtos:                     []string{"foo"},
+				EncryptedClientHelloConfigList: tc.configList,
+			}, &quic.Config{
+				Versions: []quic.Version{tc.version},
+			})
+			mu.Lock()
+			gotRoute := routed
+			mu.Unlock()
+			if len(gotRoute) == 0 || gotRoute[0] != tc.wantRoute {
+				t.Errorf("Routed = %v, want %q", gotRoute, tc.wantRoute)
+			}
+			if tc.wantFailure {
+				if err == nil {
+					t.Fatal("DialAddr succeeded unexpectedly")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DialAddr: %v", err)
+			}
+			defer conn.CloseWithError(0, "")
+			if got, want := conn.ConnectionState().TLS.ECHAccepted, tc.wantECH; got != want {
+				t.Errorf("ECHAccepted = %v, want %v", got, want)
+			}
+			stream, err := conn.OpenStreamSync(ctx)
+			if err != nil {
+				t.Fatalf("OpenStreamSync: %v", err)
+			}
+			stream.Write([]byte("Hi\n"))
+			b, err := io.ReadAll(stream)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if got := string(b); got != tc.want {
+				t.Errorf("Got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	cancel()
+	if err := <-ch; !errors.Is(err, context.Canceled) {
+		t.Errorf("Serve() = %v, want context.Canceled", err)
+	}
+}
+
+func ExampleFrontend() {
+	store := ech.NewKeyStore("/path/to/keys", ech.NewPassphraseKeyWrapper([]byte("passphrase")))
+	keys, err := store.Load()
+	if err != nil {
+		log.Fatalf("Load: %v", err)
+	}
+	router := ech.NewRouter()
+	router.Handle("a.example.com", "10.0.0.1:443")
+	router.Handle("b.example.com", "10.0.0.2:443")
+
+	f := &Frontend{
+		Keys:   keys,
+		Router: router,
+	}
+	conn, err := net.ListenPacket("udp", ":443")
+	if err != nil {
+		log.Fatalf("ListenPacket: %v", err)
+	}
+	if err := f.Serve(context.Background(), conn); err != nil {
+		log.Fatalf("Serve: %v", err)
+	}
+}
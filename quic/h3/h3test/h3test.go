@@ -0,0 +1,230 @@
+// Package h3test provides an ECH-enabled HTTP/3 server for end-to-end tests,
+// with a fake DNS server that publishes its HTTPS records.
+//
+//	srv := h3test.NewServer(t, h3test.WithHandler(handler))
+//	resp, err := srv.Client().Get("https://private.example.com/")
+//	...
+package h3test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/quic/h3"
+	"github.com/c2FmZQ/ech/testutil"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Option configures the server.
+type Option func(*options)
+
+type options struct {
+	publicName  string
+	serverNames []string
+	handler     http.Handler
+	quicConfig  *quic.Config
+	records     []dns.RR
+}
+
+// WithPublicName sets the public name of the ECH config. The default value is
+// "public.example.com".
+func WithPublicName(name string) Option {
+	return func(o *options) {
+		o.publicName = name
+	}
+}
+
+// WithServerNames sets the names of the server. Each name has HTTPS and A
+// records in the fake DNS server, and is in the server's certificate. The
+// default value is "private.example.com".
+func WithServerNames(names ...string) Option {
+	return func(o *options) {
+		o.serverNames = names
+	}
+}
+
+// WithHandler sets the handler of the HTTP/3 server. The default handler
+// responds with the request's method and URI, and whether ECH was accepted,
+// e.g. "GET /foo ECHAccepted:true\n".
+func WithHandler(h http.Handler) Option {
+	return func(o *options) {
+		o.handler = h
+	}
+}
+
+// WithQUICConfig sets the QUIC config of the server.
+func WithQUICConfig(qc *quic.Config) Option {
+	return func(o *options) {
+		o.quicConfig = qc
+	}
+}
+
+// WithDNSRecords adds records to the fake DNS server, e.g. for other servers
+// used in the same test.
+func WithDNSRecords(records ...dns.RR) Option {
+	return func(o *options) {
+		o.records = append(o.records, records...)
+	}
+}
+
+// Server is an HTTP/3 server with ECH, and a fake DNS-over-HTTPS server that
+// has the HTTPS and A records of the server names.
+type Server struct {
+	// Addr is the UDP address of the server, e.g. "127.0.0.1:1234".
+	Addr string
+	// PublicName is the public name of the ECH config.
+	PublicName string
+	// ServerNames are the names of the server.
+	ServerNames []string
+	// Keys are the server's ECH keys.
+	Keys []ech.Key
+	// ConfigList is the ECH config list of the server, which is published
+	// in the HTTPS records.
+	ConfigList []byte
+	// Certificate is the self-signed certificate of the server, for the
+	// public name and all the server names.
+	Certificate tls.Certificate
+	// RootCAs contains Certificate.
+	RootCAs *x509.CertPool
+	// DNSServer is the fake DNS-over-HTTPS server.
+	DNSServer *httptest.Server
+	// Resolver uses DNSServer.
+	Resolver *ech.Resolver
+
+	udpConn *net.UDPConn
+	ln      *quic.EarlyListener
+	server  *http3.Server
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+// NewServer starts a new server on a loopback address. The server is closed
+// when the test ends.
+func NewServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	o := &options{
+		publicName:  "public.example.com",
+		serverNames: []string{"private.example.com"},
+		handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%s %s ECHAccepted:%v\n", req.Method, req.RequestURI, req.TLS != nil && req.TLS.ECHAccepted)
+		}),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	privKey, config, err := ech.NewConfig(1, []byte(o.publicName))
+	if err != nil {
+		t.Fatalf("ech.NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ech.ConfigList: %v", err)
+	}
+	cert, err := testutil.NewCert(append([]string{o.publicName}, o.serverNames...)...)
+	if err != nil {
+		t.Fatalf("testutil.NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+	keys := []ech.Key{{
+		Config:      config,
+		PrivateKey:  privKey.Bytes(),
+		SendAsRetry: true,
+	}}
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	udpAddr := udpConn.LocalAddr().(*net.UDPAddr)
+	ln, err := quic.ListenEarly(udpConn, &tls.Config{
+		Certificates:             []tls.Certificate{cert},
+		NextProtos:               []string{http3.NextProtoH3},
+		EncryptedClientHelloKeys: keys,
+	}, o.quicConfig)
+	if err != nil {
+		udpConn.Close()
+		t.Fatalf("quic.ListenEarly: %v", err)
+	}
+
+	db := make([]dns.RR, 0, 2*len(o.serverNames)+len(o.records))
+	for _, name := range o.serverNames {
+		db = append(db, dns.RR{
+			Name: name, Type: 65, Class: 1, TTL: 60,
+			Data: dns.HTTPS{Priority: 1, Port: uint16(udpAddr.Port), ALPN: []string{"h3"}, NoDefaultALPN: true, ECH: configList},
+		}, dns.RR{
+			Name: name, Type: 1, Class: 1, TTL: 60,
+			Data: udpAddr.IP,
+		})
+	}
+	db = append(db, o.records...)
+	dnsServer := testutil.StartTestDNSServer(t, db)
+	resolver, err := ech.NewResolver(dnsServer.URL + "/dns-query")
+	if err != nil {
+		dnsServer.Close()
+		ln.Close()
+		udpConn.Close()
+		t.Fatalf("ech.NewResolver: %v", err)
+	}
+
+	s := &Server{
+		Addr:        udpAddr.String(),
+		PublicName:  o.publicName,
+		ServerNames: o.serverNames,
+		Keys:        keys,
+		ConfigList:  configList,
+		Certificate: cert,
+		RootCAs:     rootCAs,
+		DNSServer:   dnsServer,
+		Resolver:    resolver,
+		udpConn:     udpConn,
+		ln:          ln,
+		server:      &http3.Server{Handler: o.handler},
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.server.ServeListener(ln)
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Transport returns a new [ech.Transport] that uses HTTP/3, the fake DNS
+// server, and the server's root CAs. ECH is required.
+func (s *Server) Transport() *ech.Transport {
+	t := h3.NewTransport(nil)
+	t.Dialer.RequireECH = true
+	t.Resolver = s.Resolver
+	t.TLSConfig = &tls.Config{
+		RootCAs:    s.RootCAs,
+		NextProtos: []string{"h3", "h2"},
+	}
+	return t
+}
+
+// Client returns a new [http.Client] that uses [Server.Transport].
+func (s *Server) Client() *http.Client {
+	return &http.Client{Transport: s.Transport()}
+}
+
+// Close stops the servers.
+func (s *Server) Close() {
+	s.once.Do(func() {
+		s.server.Close()
+		s.ln.Close()
+		s.wg.Wait()
+		s.udpConn.Close()
+		s.DNSServer.Close()
+	})
+}
@@ -0,0 +1,33 @@
+package h3test_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/c2FmZQ/ech/quic/h3/h3test"
+)
+
+func TestServer(t *testing.T) {
+	srv := h3test.NewServer(t, h3test.WithServerNames("a.example.com", "b.example.com"))
+	client := srv.Client()
+	defer client.CloseIdleConnections()
+
+	for _, name := range srv.ServerNames {
+		resp, err := client.Get("https://" + name + "/foo")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := resp.StatusCode, http.StatusOK; got != want {
+			t.Errorf("StatusCode = %d, want %d", got, want)
+		}
+		if got, want := resp.Proto, "HTTP/3.0"; got != want {
+			t.Errorf("Proto = %q, want %q", got, want)
+		}
+		if got, want := string(body), "GET /foo ECHAccepted:true\n"; got != want {
+			t.Errorf("Body = %q, want %q", got, want)
+		}
+	}
+}
@@ -3,6 +3,9 @@ package h3
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
 	"sync"
 
 	"github.com/c2FmZQ/ech"
@@ -10,28 +13,223 @@ import (
 	"github.com/quic-go/quic-go/http3"
 )
 
+// Option is an option for [NewTransport].
+type Option func(*options)
+
+type options struct {
+	earlyData map[string]string
+}
+
+// WithEarlyData makes the Transport send requests with the given methods as
+// 0-RTT early data when it resumes a previous session with the same server,
+// which saves a round trip on repeat visits. Only GET and HEAD requests
+// without a body can be sent as early data. The default methods are GET and
+// HEAD.
+//
+// Early data isn't protected against replay attacks. It should only be used
+// with requests that are safe to replay.
+func WithEarlyData(methods ...string) Option {
+	return func(o *options) {
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet, http.MethodHead}
+		}
+		o.earlyData = make(map[string]string)
+		for _, m := range methods {
+			switch m {
+			case http.MethodGet:
+				o.earlyData[m] = http3.MethodGet0RTT
+			case http.MethodHead:
+				o.earlyData[m] = http3.MethodHead0RTT
+			}
+		}
+	}
+}
+
 // NewTransport returns a [ech.Transport] that is ready to be used with
 // [http.Client]. This Transport uses the HTTP/3 protocol with the hostname
 // has a HTTPS RR with h3 in its ALPN list.
-func NewTransport(qc *quic.Config) *ech.Transport {
+//
+// With [WithEarlyData], safe requests are sent as 0-RTT early data on resumed
+// connections.
+//
+// The HTTP/3 connections are closed gracefully. When a server sends a GOAWAY
+// frame, the requests in progress complete normally and new requests use a
+// new connection. Likewise, CloseIdleConnections closes the idle connections
+// immediately, and the connections that are in use when their requests
+// complete. New requests never use these connections, which makes it possible
+// to rotate all the connections, e.g. after an ECH key rotation, without
+// interrupting any request.
+func NewTransport(qc *quic.Config, opts ...Option) *ech.Transport {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	cache := tls.NewLRUClientSessionCache(0)
 	dialer := &ech.Dialer[*quic.Conn]{
 		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*quic.Conn, error) {
+			// Early data can only be sent when a session is
+			// resumed.
+			if o.earlyData != nil && tc.ClientSessionCache == nil {
+				tc = tc.Clone()
+				tc.ClientSessionCache = cache
+			}
 			return quic.DialAddrEarly(ctx, addr, tc, qc)
 		},
 	}
 	var once sync.Once
 
 	t := ech.NewTransport()
-	t.HTTP3Transport = &http3.Transport{
-		Dial: func(ctx context.Context, addr string, _ *tls.Config, _ *quic.Config) (*quic.Conn, error) {
-			once.Do(func() {
-				dialer.RequireECH = t.Dialer.RequireECH
-				dialer.PublicName = t.Dialer.PublicName
-				dialer.MaxConcurrency = t.Dialer.MaxConcurrency
-				dialer.ConcurrencyDelay = t.Dialer.ConcurrencyDelay
-			})
-			return dialer.Dial(ctx, "udp", addr, t.TLSConfig)
+	dial := func(ctx context.Context, addr string, _ *tls.Config, _ *quic.Config) (*quic.Conn, error) {
+		once.Do(func() {
+			dialer.RequireECH = t.Dialer.RequireECH
+			dialer.PublicName = t.Dialer.PublicName
+			dialer.Policies = t.Dialer.Policies
+			dialer.KeyLogWriter = t.Dialer.KeyLogWriter
+			dialer.MaxConcurrency = t.Dialer.MaxConcurrency
+			dialer.ConcurrencyDelay = t.Dialer.ConcurrencyDelay
+			dialer.Logger = t.Dialer.Logger
+			dialer.TargetFilter = t.Dialer.TargetFilter
+		})
+		return dialer.Dial(ctx, "udp", addr, t.TLSConfig)
+	}
+	t.HTTP3Transport = &transport{
+		newTransport: func() *http3.Transport {
+			return &http3.Transport{Dial: dial}
 		},
+		earlyData: o.earlyData,
 	}
 	return t
 }
+
+// transport is a HTTP/3 RoundTripper that drains its connections when
+// CloseIdleConnections is called. The connections that are in use belong to
+// a generation that is closed when its last request completes, and new
+// requests use a new generation.
+type transport struct {
+	newTransport func() *http3.Transport
+	// earlyData maps the methods of the requests that are sent as 0-RTT
+	// early data.
+	earlyData map[string]string
+
+	mu       sync.Mutex
+	cur      *generation
+	draining map[*generation]struct{}
+}
+
+// generation is a http3.Transport and the number of its requests that are in
+// progress.
+type generation struct {
+	tr       *http3.Transport
+	inflight int
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if m, ok := t.earlyData[req.Method]; ok && (req.Body == nil || req.Body == http.NoBody) {
+		r := *req
+		r.Method = m
+		req = &r
+	}
+	g := t.acquire()
+	resp, err := g.tr.RoundTrip(req)
+	if err != nil {
+		t.release(g)
+		return nil, err
+	}
+	if resp.Body == nil {
+		t.release(g)
+		return resp, nil
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, done: func() { t.release(g) }}
+	return resp, nil
+}
+
+// acquire returns the current generation, and increments its number of
+// requests in progress.
+func (t *transport) acquire() *generation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cur == nil {
+		t.cur = &generation{tr: t.newTransport()}
+	}
+	t.cur.inflight++
+	return t.cur
+}
+
+// release decrements the number of requests in progress of g, and closes g
+// if it is draining and has no more requests.
+func (t *transport) release(g *generation) {
+	t.mu.Lock()
+	g.inflight--
+	_, draining := t.draining[g]
+	if draining && g.inflight == 0 {
+		delete(t.draining, g)
+	} else {
+		draining = false
+	}
+	t.mu.Unlock()
+	if draining {
+		g.tr.Close()
+	}
+}
+
+// CloseIdleConnections closes the connections that aren't in use, and drains
+// the ones that are. They are closed when their requests complete.
+func (t *transport) CloseIdleConnections() {
+	t.mu.Lock()
+	g := t.cur
+	if g != nil && g.inflight > 0 {
+		if t.draining == nil {
+			t.draining = make(map[*generation]struct{})
+		}
+		t.draining[g] = struct{}{}
+		t.cur = nil
+	}
+	t.mu.Unlock()
+	if g != nil {
+		g.tr.CloseIdleConnections()
+	}
+}
+
+// Close closes all the connections, including the ones that are in use.
+func (t *transport) Close() error {
+	t.mu.Lock()
+	gens := make([]*generation, 0, len(t.draining)+1)
+	if t.cur != nil {
+		gens = append(gens, t.cur)
+	}
+	for g := range t.draining {
+		gens = append(gens, g)
+	}
+	t.cur = nil
+	t.draining = nil
+	t.mu.Unlock()
+	var errs []error
+	for _, g := range gens {
+		if err := g.tr.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// trackedBody is a response body that calls done when it is closed, or when
+// it is read completely.
+type trackedBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
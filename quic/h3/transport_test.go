@@ -1,13 +1,19 @@
 package h3
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/c2FmZQ/ech"
 	"github.com/c2FmZQ/ech/dns"
@@ -122,8 +128,10 @@ func TestNewTransport(t *testing.T) {
 	}})
 	defer dnsServer.Close()
 
+	var keyLog keyLogCounter
 	transport := NewTransport(nil)
 	transport.Dialer.RequireECH = true
+	transport.Dialer.KeyLogWriter = &keyLog
 	resolver, err := ech.NewResolver(fmt.Sprintf("http://%s/dns-query", dnsServer.Listener.Addr()))
 	if err != nil {
 		t.Fatalf("ech.NewResolver: %v", err)
@@ -154,6 +162,9 @@ func TestNewTransport(t *testing.T) {
 		if got, want := resp.TLS.ECHAccepted, true; got != want {
 			t.Errorf("ECHAccepted = %v, want %v", got, want)
 		}
+		if keyLog.lines() == 0 {
+			t.Error("KeyLogWriter wasn't used")
+		}
 	})
 
 	t.Run("H2", func(t *testing.T) {
@@ -175,3 +186,325 @@ func TestNewTransport(t *testing.T) {
 		}
 	})
 }
+
+type connCtxKey struct{}
+
+func TestNewTransportEarlyData(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("ech.NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ech.ConfigList: %v", err)
+	}
+
+	tlsCert, err := testutil.NewCert("public.example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	udpln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	defer udpln.Close()
+
+	udpAddr := udpln.LocalAddr().(*net.UDPAddr)
+
+	qln, err := quic.ListenEarly(udpln, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"h3"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:      config,
+			PrivateKey:  privKey.Bytes(),
+			SendAsRetry: true,
+		}},
+	}, &quic.Config{Allow0RTT: true})
+	if err != nil {
+		t.Fatalf("quic.ListenEarly: %v", err)
+	}
+
+	go func() {
+		server := &http3.Server{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				conn := req.Context().Value(connCtxKey{}).(*quic.Conn)
+				fmt.Fprintf(w, "%s Used0RTT:%v\n", req.Method, conn.ConnectionState().Used0RTT)
+			}),
+			ConnContext: func(ctx context.Context, c *quic.Conn) context.Context {
+				return context.WithValue(ctx, connCtxKey{}, c)
+			},
+		}
+		for {
+			conn, err := qln.Accept(t.Context())
+			if err != nil {
+				return
+			}
+			go server.ServeQUICConn(conn)
+		}
+	}()
+
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "private.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 1, Port: uint16(udpAddr.Port), ALPN: []string{"h3"}, NoDefaultALPN: true, ECH: configList},
+	}, {
+		Name: "private.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: udpAddr.IP,
+	}})
+	defer dnsServer.Close()
+
+	resolver, err := ech.NewResolver(fmt.Sprintf("http://%s/dns-query", dnsServer.Listener.Addr()))
+	if err != nil {
+		t.Fatalf("ech.NewResolver: %v", err)
+	}
+	get := func(t *testing.T, transport *ech.Transport) string {
+		t.Helper()
+		// Each request uses a new connection.
+		transport.HTTP3Transport.(interface{ CloseIdleConnections() }).CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		if !resp.TLS.ECHAccepted {
+			t.Error("ECHAccepted = false")
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		want []string
+	}{
+		// The first connection can't use 0-RTT.
+		{"EarlyData", []Option{WithEarlyData()}, []string{"GET Used0RTT:false\n", "GET Used0RTT:true\n", "GET Used0RTT:true\n"}},
+		{"NoEarlyData", nil, []string{"GET Used0RTT:false\n", "GET Used0RTT:false\n"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			transport := NewTransport(nil, tc.opts...)
+			transport.Dialer.RequireECH = true
+			transport.Resolver = resolver
+			transport.TLSConfig = &tls.Config{
+				RootCAs:    rootCAs,
+				NextProtos: []string{"h3"},
+			}
+			for i, want := range tc.want {
+				if got := get(t, transport); got != want {
+					t.Errorf("GET #%d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTransportDrain(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("ech.NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ech.ConfigList: %v", err)
+	}
+
+	tlsCert, err := testutil.NewCert("public.example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	udpln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	defer udpln.Close()
+
+	udpAddr := udpln.LocalAddr().(*net.UDPAddr)
+
+	qln, err := quic.Listen(udpln, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"h3"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:     config,
+			PrivateKey: privKey.Bytes(),
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("quic.Listen: %v", err)
+	}
+
+	// The connections are identified by the client's address. Each
+	// connection uses its own UDP socket.
+	var mu sync.Mutex
+	conns := make(map[string]*quic.Conn)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-release
+		}
+		fmt.Fprint(w, req.RemoteAddr)
+	})
+	newServer := func() *http3.Server {
+		return &http3.Server{
+			Handler: handler,
+			ConnContext: func(ctx context.Context, c *quic.Conn) context.Context {
+				mu.Lock()
+				defer mu.Unlock()
+				conns[c.RemoteAddr().String()] = c
+				return ctx
+			},
+		}
+	}
+	var server atomic.Pointer[http3.Server]
+	server.Store(newServer())
+	go func() {
+		for {
+			conn, err := qln.Accept(t.Context())
+			if err != nil {
+				return
+			}
+			go server.Load().ServeQUICConn(conn)
+		}
+	}()
+	closed := func(addr string) bool {
+		mu.Lock()
+		conn := conns[addr]
+		mu.Unlock()
+		select {
+		case <-conn.Context().Done():
+			return true
+		case <-time.After(2 * time.Second):
+			return false
+		}
+	}
+
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "private.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 1, Port: uint16(udpAddr.Port), ALPN: []string{"h3"}, NoDefaultALPN: true, ECH: configList},
+	}, {
+		Name: "private.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: udpAddr.IP,
+	}})
+	defer dnsServer.Close()
+
+	resolver, err := ech.NewResolver(fmt.Sprintf("http://%s/dns-query", dnsServer.Listener.Addr()))
+	if err != nil {
+		t.Fatalf("ech.NewResolver: %v", err)
+	}
+	transport := NewTransport(nil)
+	transport.Dialer.RequireECH = true
+	transport.Resolver = resolver
+	transport.TLSConfig = &tls.Config{
+		RootCAs:    rootCAs,
+		NextProtos: []string{"h3"},
+	}
+	defer transport.HTTP3Transport.(io.Closer).Close()
+	client := &http.Client{Transport: transport}
+
+	get := func(path string) string {
+		resp, err := client.Get("https://private.example.com" + path)
+		if err != nil {
+			t.Errorf("GET %s: %v", path, err)
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	getSlow := func() chan string {
+		ch := make(chan string)
+		go func() {
+			ch <- get("/slow")
+		}()
+		<-started
+		return ch
+	}
+
+	// CloseIdleConnections drains the connection that is in use.
+	addr1 := get("/")
+	slow := getSlow()
+	transport.CloseIdleConnections()
+	addr2 := get("/")
+	if addr2 == addr1 {
+		t.Errorf("Request after CloseIdleConnections used connection %s", addr1)
+	}
+	release <- struct{}{}
+	if got := <-slow; got != addr1 {
+		t.Errorf("Slow request used connection %s, want %s", got, addr1)
+	}
+	if !closed(addr1) {
+		t.Errorf("Connection %s wasn't closed", addr1)
+	}
+
+	// GOAWAY from the server drains the connection that is in use.
+	slow = getSlow()
+	oldServer := server.Swap(newServer())
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- oldServer.Shutdown(t.Context())
+	}()
+	// The server stops accepting requests on the old connection when
+	// Shutdown is called, which happens asynchronously.
+	var addr3 string
+	for range 20 {
+		if addr3 = get("/"); addr3 != addr2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if addr3 == addr2 {
+		t.Errorf("Request after GOAWAY used connection %s", addr2)
+	}
+	release <- struct{}{}
+	if got := <-slow; got != addr2 {
+		t.Errorf("Slow request used connection %s, want %s", got, addr2)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+	if !closed(addr2) {
+		t.Errorf("Connection %s wasn't closed", addr2)
+	}
+}
+
+func TestWithEarlyData(t *testing.T) {
+	for _, tc := range []struct {
+		methods []string
+		want    map[string]string
+	}{
+		{nil, map[string]string{"GET": http3.MethodGet0RTT, "HEAD": http3.MethodHead0RTT}},
+		{[]string{"HEAD", "POST"}, map[string]string{"HEAD": http3.MethodHead0RTT}},
+	} {
+		var o options
+		WithEarlyData(tc.methods...)(&o)
+		if !maps.Equal(o.earlyData, tc.want) {
+			t.Errorf("WithEarlyData(%q) = %v, want %v", tc.methods, o.earlyData, tc.want)
+		}
+	}
+}
+
+// keyLogCounter counts the lines written to a tls.Config's KeyLogWriter.
+type keyLogCounter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (c *keyLogCounter) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n += bytes.Count(b, []byte("\n"))
+	return len(b), nil
+}
+
+func (c *keyLogCounter) lines() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
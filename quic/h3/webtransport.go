@@ -0,0 +1,83 @@
+package h3
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"slices"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+// DialClientConn establishes a QUIC connection to addr with dialer, and returns
+// a HTTP/3 client connection with HTTP Datagrams (RFC 9297) enabled. Its
+// request streams can send and receive datagrams, e.g. after an extended
+// CONNECT request.
+//
+// The dialer must enable datagrams in its quic.Config, e.g. with
+// [github.com/c2FmZQ/ech/quic.NewDialer], and the server must support them:
+//
+//	dialer := echquic.NewDialer(&quic.Config{EnableDatagrams: true})
+//	cc, err := h3.DialClientConn(ctx, dialer, "private.example.com:443", nil)
+//	if err != nil {
+//		// ...
+//	}
+//	str, err := cc.OpenRequestStream(ctx)
+//	...
+//	err = str.SendDatagram(data)
+func DialClientConn(ctx context.Context, dialer *ech.Dialer[*quic.Conn], addr string, tc *tls.Config) (*http3.ClientConn, error) {
+	conn, err := dialer.Dial(ctx, "udp", addr, h3TLSConfig(tc))
+	if err != nil {
+		return nil, err
+	}
+	if !conn.ConnectionState().SupportsDatagrams {
+		conn.CloseWithError(0, "")
+		return nil, errors.New("datagrams are not supported")
+	}
+	tr := &http3.Transport{EnableDatagrams: true}
+	return tr.NewClientConn(conn), nil
+}
+
+// NewWebTransportDialer returns a [webtransport.Dialer] that establishes its
+// QUIC connections with dialer, so that WebTransport sessions can use ECH. The
+// dialer must enable datagrams in its quic.Config, e.g. with
+// [github.com/c2FmZQ/ech/quic.NewDialer]:
+//
+//	dialer := echquic.NewDialer(&quic.Config{EnableDatagrams: true})
+//	dialer.RequireECH = true
+//	wt := h3.NewWebTransportDialer(dialer)
+//	resp, session, err := wt.Dial(ctx, "https://private.example.com/webtransport", nil)
+func NewWebTransportDialer(dialer *ech.Dialer[*quic.Conn]) *webtransport.Dialer {
+	return &webtransport.Dialer{
+		// This config is only checked by the webtransport.Dialer. The
+		// connections use the dialer's config.
+		QUICConfig: &quic.Config{EnableDatagrams: true},
+		DialAddr: func(ctx context.Context, addr string, tc *tls.Config, _ *quic.Config) (*quic.Conn, error) {
+			conn, err := dialer.Dial(ctx, "udp", addr, h3TLSConfig(tc))
+			if err != nil {
+				return nil, err
+			}
+			if !conn.ConnectionState().SupportsDatagrams {
+				conn.CloseWithError(0, "")
+				return nil, errors.New("datagrams are not supported")
+			}
+			return conn, nil
+		},
+	}
+}
+
+// h3TLSConfig returns a copy of tc with the h3 ALPN protocol.
+func h3TLSConfig(tc *tls.Config) *tls.Config {
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	if slices.Contains(tc.NextProtos, http3.NextProtoH3) {
+		return tc
+	}
+	tc = tc.Clone()
+	tc.NextProtos = append(tc.NextProtos, http3.NextProtoH3)
+	return tc
+}
@@ -0,0 +1,218 @@
+package h3
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/dns"
+	"github.com/c2FmZQ/ech/testutil"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+)
+
+func TestWebTransport(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("ech.NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ech.ConfigList: %v", err)
+	}
+
+	tlsCert, err := testutil.NewCert("public.example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	udpln, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IP{127, 0, 0, 1}})
+	if err != nil {
+		t.Fatalf("net.ListenUDP: %v", err)
+	}
+	defer udpln.Close()
+
+	udpAddr := udpln.LocalAddr().(*net.UDPAddr)
+
+	qln, err := quic.Listen(udpln, &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"h3"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:     config,
+			PrivateKey: privKey.Bytes(),
+		}},
+	}, &quic.Config{EnableDatagrams: true})
+	if err != nil {
+		t.Fatalf("quic.Listen: %v", err)
+	}
+
+	server := &webtransport.Server{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/wt", func(w http.ResponseWriter, req *http.Request) {
+		if !req.TLS.ECHAccepted {
+			http.Error(w, "ECH not accepted", http.StatusForbidden)
+			return
+		}
+		session, err := server.Upgrade(w, req)
+		if err != nil {
+			t.Errorf("Upgrade: %v", err)
+			return
+		}
+		ctx := session.Context()
+		b, err := session.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		session.SendDatagram(b)
+		stream, err := session.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		io.Copy(stream, stream)
+	})
+	mux.HandleFunc("/dg", func(w http.ResponseWriter, req *http.Request) {
+		if !req.TLS.ECHAccepted {
+			http.Error(w, "ECH not accepted", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		stream := w.(http3.HTTPStreamer).HTTPStream()
+		defer stream.Close()
+		b, err := stream.ReceiveDatagram(req.Context())
+		if err != nil {
+			return
+		}
+		stream.SendDatagram(b)
+		io.Copy(io.Discard, stream)
+	})
+	server.H3.Handler = mux
+
+	go func() {
+		for {
+			conn, err := qln.Accept(t.Context())
+			if err != nil {
+				return
+			}
+			go server.ServeQUICConn(conn)
+		}
+	}()
+	defer server.Close()
+
+	dnsServer := testutil.StartTestDNSServer(t, []dns.RR{{
+		Name: "private.example.com", Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 1, Port: uint16(udpAddr.Port), ALPN: []string{"h3"}, NoDefaultALPN: true, ECH: configList},
+	}, {
+		Name: "private.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: udpAddr.IP,
+	}})
+	defer dnsServer.Close()
+
+	resolver, err := ech.NewResolver(fmt.Sprintf("http://%s/dns-query", dnsServer.Listener.Addr()))
+	if err != nil {
+		t.Fatalf("ech.NewResolver: %v", err)
+	}
+	newDialer := func(qc *quic.Config) *ech.Dialer[*quic.Conn] {
+		return &ech.Dialer[*quic.Conn]{
+			RequireECH: true,
+			Resolver:   resolver,
+			DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*quic.Conn, error) {
+				return quic.DialAddr(ctx, addr, tc, qc)
+			},
+		}
+	}
+	tc := &tls.Config{RootCAs: rootCAs}
+
+	t.Run("WebTransport", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+
+		wt := NewWebTransportDialer(newDialer(&quic.Config{EnableDatagrams: true}))
+		wt.TLSClientConfig = tc
+		resp, session, err := wt.Dial(ctx, "https://private.example.com/wt", nil)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer session.CloseWithError(0, "")
+		if got, want := resp.StatusCode, 200; got != want {
+			t.Errorf("StatusCode = %d, want %d", got, want)
+		}
+
+		if err := session.SendDatagram([]byte("datagram")); err != nil {
+			t.Fatalf("SendDatagram: %v", err)
+		}
+		if b, err := session.ReceiveDatagram(ctx); err != nil || string(b) != "datagram" {
+			t.Errorf("ReceiveDatagram() = %q, %v, want %q", b, err, "datagram")
+		}
+
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatalf("OpenStreamSync: %v", err)
+		}
+		stream.Write([]byte("stream"))
+		stream.Close()
+		if b, err := io.ReadAll(stream); err != nil || string(b) != "stream" {
+			t.Errorf("ReadAll() = %q, %v, want %q", b, err, "stream")
+		}
+	})
+
+	t.Run("ClientConn", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+		defer cancel()
+
+		cc, err := DialClientConn(ctx, newDialer(&quic.Config{EnableDatagrams: true}), "private.example.com:443", tc)
+		if err != nil {
+			t.Fatalf("DialClientConn: %v", err)
+		}
+		defer cc.CloseWithError(0, "")
+		select {
+		case <-cc.ReceivedSettings():
+		case <-ctx.Done():
+			t.Fatalf("ReceivedSettings: %v", ctx.Err())
+		}
+		if !cc.Settings().EnableDatagrams {
+			t.Fatal("Server doesn't support datagrams")
+		}
+
+		stream, err := cc.OpenRequestStream(ctx)
+		if err != nil {
+			t.Fatalf("OpenRequestStream: %v", err)
+		}
+		defer stream.Close()
+		if err := stream.SendRequestHeader(&http.Request{
+			Method: http.MethodConnect,
+			Proto:  "echo",
+			Host:   "private.example.com",
+			Header: http.Header{},
+			URL:    &url.URL{Scheme: "https", Host: "private.example.com", Path: "/dg"},
+		}); err != nil {
+			t.Fatalf("SendRequestHeader: %v", err)
+		}
+		resp, err := stream.ReadResponse()
+		if err != nil {
+			t.Fatalf("ReadResponse: %v", err)
+		}
+		// The handler rejects requests without ECH.
+		if got, want := resp.StatusCode, 200; got != want {
+			t.Fatalf("StatusCode = %d, want %d", got, want)
+		}
+		if err := stream.SendDatagram([]byte("datagram")); err != nil {
+			t.Fatalf("SendDatagram: %v", err)
+		}
+		if b, err := stream.ReceiveDatagram(ctx); err != nil || string(b) != "datagram" {
+			t.Errorf("ReceiveDatagram() = %q, %v, want %q", b, err, "datagram")
+		}
+	})
+}
@@ -0,0 +1,258 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/quic-go/quic-go/quicvarint"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// The QUIC versions whose Initial packets can be decrypted. RFC 9000, RFC 9369
+const (
+	version1 = 0x00000001
+	version2 = 0x6b3343cf
+)
+
+var (
+	initialSaltV1 = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+	initialSaltV2 = []byte{0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93, 0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9}
+
+	errNotInitial    = errors.New("not a client initial packet")
+	errInvalidPacket = errors.New("invalid packet")
+)
+
+// maxClientHelloSize is the maximum size of a ClientHello message that is
+// reassembled from the CRYPTO frames.
+const maxClientHelloSize = 1 << 16
+
+// decryptInitialPacket removes the packet protection of the first packet in
+// datagram, which must be a client Initial packet, and returns its payload.
+// The Initial packet protection only depends on the version and on the
+// Destination Connection ID chosen by the client. RFC 9001 Section 5
+func decryptInitialPacket(datagram []byte) ([]byte, error) {
+	b := cryptobyte.String(datagram)
+	var first uint8
+	var version uint32
+	if !b.ReadUint8(&first) || first&0x80 == 0 || !b.ReadUint32(&version) {
+		return nil, errNotInitial
+	}
+	var salt []byte
+	var label string
+	var initialType uint8
+	switch version {
+	case version1:
+		salt, label, initialType = initialSaltV1, "quic", 0
+	case version2:
+		salt, label, initialType = initialSaltV2, "quicv2", 1
+	default:
+		return nil, errNotInitial
+	}
+	if (first>>4)&0x03 != initialType {
+		return nil, errNotInitial
+	}
+	var dcid, scid cryptobyte.String
+	if !b.ReadUint8LengthPrefixed(&dcid) || len(dcid) > 20 || !b.ReadUint8LengthPrefixed(&scid) || len(scid) > 20 {
+		return nil, errInvalidPacket
+	}
+	tokenLen, err := readVarint(&b)
+	if err != nil || !b.Skip(int(min(tokenLen, uint64(len(b)+1)))) {
+		return nil, errInvalidPacket
+	}
+	length, err := readVarint(&b)
+	// The packet number and the 16-byte sample for the header protection
+	// must be in the packet. RFC 9001 Section 5.4.2
+	if err != nil || length < 20 || length > uint64(len(b)) {
+		return nil, errInvalidPacket
+	}
+	pnOffset := len(datagram) - len(b)
+
+	aead, iv, hp, err := initialKeys(salt, label, dcid)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, hp.BlockSize())
+	hp.Encrypt(mask, datagram[pnOffset+4:pnOffset+20])
+
+	header := slices.Clone(datagram[:pnOffset+4])
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&0x03) + 1
+	header = header[:pnOffset+pnLen]
+	var pn uint64
+	for i := range pnLen {
+		header[pnOffset+i] ^= mask[1+i]
+		pn = pn<<8 | uint64(header[pnOffset+i])
+	}
+	// The client's first packet numbers are small enough that the
+	// truncated packet number is the full packet number.
+	nonce := slices.Clone(iv)
+	for i := range 8 {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * i))
+	}
+	payload, err := aead.Open(nil, nonce, datagram[pnOffset+pnLen:pnOffset+int(length)], header)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidPacket, err)
+	}
+	return payload, nil
+}
+
+// initialKeys derives the keys that protect the client Initial packets.
+// RFC 9001 Section 5.2
+func initialKeys(salt []byte, label string, dcid []byte) (cipher.AEAD, []byte, cipher.Block, error) {
+	initialSecret, err := hkdf.Extract(sha256.New, dcid, salt)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	clientSecret, err := expandLabel(initialSecret, "client in", 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := expandLabel(clientSecret, label+" key", 16)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv, err := expandLabel(clientSecret, label+" iv", 12)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hpKey, err := expandLabel(clientSecret, label+" hp", 16)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hp, err := aes.NewCipher(hpKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return aead, iv, hp, nil
+}
+
+// expandLabel implements HKDF-Expand-Label with an empty context.
+// RFC 8446 Section 7.1
+func expandLabel(secret []byte, label string, length int) ([]byte, error) {
+	b := cryptobyte.NewBuilder(nil)
+	b.AddUint16(uint16(length))
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte("tls13 " + label))
+	})
+	b.AddUint8(0)
+	info, err := b.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Expand(sha256.New, secret, string(info), length)
+}
+
+func readVarint(b *cryptobyte.String) (uint64, error) {
+	v, n, err := quicvarint.Parse(*b)
+	if err != nil {
+		return 0, err
+	}
+	*b = (*b)[n:]
+	return v, nil
+}
+
+// cryptoStream reassembles the ClientHello from the CRYPTO frames of the
+// client's Initial packets, which can be split and received out of order.
+type cryptoStream struct {
+	data   []byte
+	frames []cryptoFrame
+}
+
+type cryptoFrame struct {
+	offset uint64
+	data   []byte
+}
+
+// addPacket adds the CRYPTO frames from the payload of an Initial packet.
+// RFC 9000 Section 19
+func (s *cryptoStream) addPacket(payload []byte) error {
+	b := cryptobyte.String(payload)
+	for !b.Empty() {
+		typ, err := readVarint(&b)
+		if err != nil {
+			return errInvalidPacket
+		}
+		var skip int
+		switch typ {
+		case 0x00, 0x01: // PADDING, PING
+		case 0x02, 0x03: // ACK
+			// Largest Acknowledged, ACK Delay, ACK Range Count, First
+			// ACK Range, ACK Ranges, ECN Counts
+			var v [4]uint64
+			for i := range v {
+				if v[i], err = readVarint(&b); err != nil {
+					return errInvalidPacket
+				}
+			}
+			skip = 2 * int(min(v[2], uint64(len(b))))
+			if typ == 0x03 {
+				skip += 3
+			}
+			for range skip {
+				if _, err := readVarint(&b); err != nil {
+					return errInvalidPacket
+				}
+			}
+		case 0x06: // CRYPTO
+			offset, err := readVarint(&b)
+			if err != nil {
+				return errInvalidPacket
+			}
+			var data []byte
+			length, err := readVarint(&b)
+			if err != nil || length > uint64(len(b)) || !b.ReadBytes(&data, int(length)) {
+				return errInvalidPacket
+			}
+			if offset+length > maxClientHelloSize {
+				return fmt.Errorf("%w: ClientHello too large", errInvalidPacket)
+			}
+			s.frames = append(s.frames, cryptoFrame{offset: offset, data: slices.Clone(data)})
+		case 0x1c: // CONNECTION_CLOSE
+			return errors.New("connection closed by client")
+		default:
+			return fmt.Errorf("%w: unexpected frame type 0x%x", errInvalidPacket, typ)
+		}
+	}
+	// Append the frames that are contiguous with the data received so far.
+	for progress := true; progress; {
+		progress = false
+		for _, f := range s.frames {
+			n := uint64(len(s.data))
+			if f.offset <= n && f.offset+uint64(len(f.data)) > n {
+				s.data = append(s.data, f.data[n-f.offset:]...)
+				progress = true
+			}
+		}
+	}
+	return nil
+}
+
+// clientHello returns the ClientHello message when it has been received
+// completely.
+func (s *cryptoStream) clientHello() ([]byte, bool, error) {
+	if len(s.data) < 4 {
+		return nil, false, nil
+	}
+	if s.data[0] != 0x01 { // msg_type: ClientHello
+		return nil, false, fmt.Errorf("%w: unexpected message type 0x%x", errInvalidPacket, s.data[0])
+	}
+	n := 4 + int(binary.BigEndian.Uint32(s.data)&0xffffff)
+	if len(s.data) < n {
+		return nil, false, nil
+	}
+	return s.data[:n], true, nil
+}
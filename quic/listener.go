@@ -0,0 +1,360 @@
+package quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+)
+
+// maxPendingPackets is the maximum number of packets that are buffered for a
+// connection before it is forwarded to a backend.
+const maxPendingPackets = 32
+
+// Listener receives the packets of new QUIC connections, and returns them
+// with [Listener.Accept] when their ClientHello has been received and
+// decrypted. Each [IncomingConn] can then be forwarded to a backend with
+// [IncomingConn.ProxyTo], or dropped with [IncomingConn.Close].
+//
+// Listeners are created with [Frontend.Listen].
+type Listener struct {
+	f      *Frontend
+	conn   net.PacketConn
+	accept chan *IncomingConn
+	done   chan struct{}
+	err    error
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	closed   bool
+	sessions map[string]*session
+}
+
+// session is a connection seen by the Listener.
+type session struct {
+	key      string
+	addr     net.Addr
+	crypto   cryptoStream
+	pending  [][]byte
+	accepted bool
+	backend  net.Conn
+	timer    *time.Timer
+	active   atomic.Int64
+}
+
+func (s *session) touch() {
+	s.active.Store(time.Now().UnixNano())
+}
+
+func (s *session) idle() time.Duration {
+	return time.Since(time.Unix(0, s.active.Load()))
+}
+
+// IncomingConn is a new QUIC connection whose ClientHello has been received
+// and decrypted. Its packets are buffered until it is forwarded with ProxyTo.
+// It is dropped if it isn't forwarded within the Frontend's HandshakeTimeout.
+type IncomingConn struct {
+	l     *Listener
+	s     *session
+	outer *ech.ClientHelloInfo
+	inner *ech.ClientHelloInfo
+}
+
+// Listen returns a [Listener] that reads QUIC packets from conn. The Frontend's
+// Route and Router are not used by the Listener.
+func (f *Frontend) Listen(conn net.PacketConn) *Listener {
+	l := &Listener{
+		f:        f,
+		conn:     conn,
+		accept:   make(chan *IncomingConn, 16),
+		done:     make(chan struct{}),
+		sessions: make(map[string]*session),
+	}
+	go l.readLoop()
+	return l
+}
+
+// Accept waits for and returns the next connection.
+func (l *Listener) Accept(ctx context.Context) (*IncomingConn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the local address of the Listener.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Close stops the Listener and the forwarding of all its connections. It
+// doesn't close the underlying PacketConn, and it clears its read deadline so
+// that the PacketConn can be used again.
+func (l *Listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	for _, s := range l.sessions {
+		l.removeLocked(s)
+	}
+	l.mu.Unlock()
+	l.conn.SetReadDeadline(time.Now())
+	<-l.done
+	l.conn.SetReadDeadline(time.Time{})
+	l.wg.Wait()
+	return nil
+}
+
+func (l *Listener) readLoop() {
+	defer close(l.done)
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			l.mu.Lock()
+			if l.closed {
+				err = net.ErrClosed
+			}
+			l.mu.Unlock()
+			l.err = err
+			return
+		}
+		if backend := l.handlePacket(addr, buf[:n]); backend != nil {
+			backend.Write(buf[:n])
+		}
+	}
+}
+
+// handlePacket returns the backend of the packet's connection when it is
+// forwarded. Otherwise, the packet is buffered, or dropped.
+func (l *Listener) handlePacket(addr net.Addr, datagram []byte) net.Conn {
+	key := addr.String()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := l.sessions[key]
+	if s != nil && s.backend != nil {
+		s.touch()
+		return s.backend
+	}
+	if s == nil {
+		// Clients must send their Initial packets in datagrams of
+		// at least 1200 bytes. RFC 9000 Section 14.1
+		if len(datagram) < 1200 || l.closed {
+			l.f.debugf("%s: dropped %d-byte datagram", key, len(datagram))
+			return nil
+		}
+		s = &session{key: key, addr: addr}
+		s.timer = time.AfterFunc(l.f.handshakeTimeout(), func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.sessions[key] == s && s.backend == nil {
+				l.f.debugf("%s: handshake timeout", key)
+				l.removeLocked(s)
+			}
+		})
+		l.sessions[key] = s
+	}
+	if err := l.handleInitial(s, datagram); err != nil {
+		l.f.debugf("%s: %v", key, err)
+		l.removeLocked(s)
+	}
+	return nil
+}
+
+// handleInitial buffers a packet of a connection that isn't forwarded yet.
+// When the whole ClientHello is received, the connection is decrypted and
+// returned by Accept.
+func (l *Listener) handleInitial(s *session, datagram []byte) error {
+	if len(s.pending) == maxPendingPackets {
+		return errors.New("too many packets before forwarding")
+	}
+	if s.accepted {
+		s.pending = append(s.pending, slices.Clone(datagram))
+		return nil
+	}
+	payload, err := decryptInitialPacket(datagram)
+	if errors.Is(err, errNotInitial) {
+		// The other packets, e.g. 0-RTT, are forwarded with the
+		// Initial packets.
+		if len(s.pending) == 0 {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := s.crypto.addPacket(payload); err != nil {
+		return err
+	}
+	s.pending = append(s.pending, slices.Clone(datagram))
+
+	msg, ok, err := s.crypto.clientHello()
+	if err != nil || !ok {
+		return err
+	}
+	keys := l.f.Keys
+	if l.f.KeysFunc != nil {
+		info, _, err := ech.DecryptClientHello(msg, nil)
+		if err != nil {
+			return err
+		}
+		if keys, err = l.f.KeysFunc(info); err != nil {
+			return err
+		}
+	}
+	outer, inner, err := ech.DecryptClientHello(msg, keys)
+	if err != nil {
+		return err
+	}
+	s.accepted = true
+	s.crypto = cryptoStream{}
+	select {
+	case l.accept <- &IncomingConn{l: l, s: s, outer: outer, inner: inner}:
+		return nil
+	default:
+		return errors.New("accept queue is full")
+	}
+}
+
+// removeLocked forgets a connection. l.mu must be held.
+func (l *Listener) removeLocked(s *session) {
+	if l.sessions[s.key] == s {
+		delete(l.sessions, s.key)
+	}
+	s.timer.Stop()
+	s.pending = nil
+	if s.backend != nil {
+		s.backend.Close()
+	}
+}
+
+// ServerName returns the ServerName of the connection, i.e. the ServerName
+// of the ClientHelloInner when ECH is accepted, or of the ClientHelloOuter
+// otherwise.
+func (c *IncomingConn) ServerName() string {
+	if c.inner != nil {
+		return c.inner.ServerName
+	}
+	return c.outer.ServerName
+}
+
+// ALPNProtos returns the ALPN protocols offered by the client, from the
+// ClientHelloInner when ECH is accepted, or from the ClientHelloOuter
+// otherwise.
+func (c *IncomingConn) ALPNProtos() []string {
+	if c.inner != nil {
+		return slices.Clone(c.inner.ALPNProtos)
+	}
+	return slices.Clone(c.outer.ALPNProtos)
+}
+
+// ECHPresented returns true if the client presented an Encrypted Client Hello.
+func (c *IncomingConn) ECHPresented() bool {
+	return c.outer.ECHPresented
+}
+
+// ECHAccepted returns true if the Encrypted Client Hello was decrypted.
+func (c *IncomingConn) ECHAccepted() bool {
+	return c.inner != nil
+}
+
+// OuterClientHello returns the ClientHelloOuter, or the only ClientHello when
+// ECH isn't used.
+func (c *IncomingConn) OuterClientHello() *ech.ClientHelloInfo {
+	return c.outer
+}
+
+// InnerClientHello returns the decrypted ClientHelloInner, or nil when ECH
+// isn't accepted.
+func (c *IncomingConn) InnerClientHello() *ech.ClientHelloInfo {
+	return c.inner
+}
+
+// RemoteAddr returns the client's address.
+func (c *IncomingConn) RemoteAddr() net.Addr {
+	return c.s.addr
+}
+
+// Close drops the connection.
+func (c *IncomingConn) Close() error {
+	c.l.mu.Lock()
+	defer c.l.mu.Unlock()
+	c.l.removeLocked(c.s)
+	return nil
+}
+
+// ProxyTo forwards the connection's packets to the QUIC server at addr, and
+// the server's packets back to the client. The packets are forwarded
+// unmodified, so the server must also have the ECH keys to accept ECH.
+// ProxyTo returns when the connection has been idle for the Frontend's
+// IdleTimeout, or when the Listener is closed.
+//
+// The ctx is used while connecting to the backend server only.
+func (c *IncomingConn) ProxyTo(ctx context.Context, addr string) error {
+	var d net.Dialer
+	backend, err := d.DialContext(ctx, "udp", addr)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	l, s := c.l, c.s
+	l.mu.Lock()
+	if l.sessions[s.key] != s || s.backend != nil {
+		l.mu.Unlock()
+		backend.Close()
+		return net.ErrClosed
+	}
+	for _, p := range s.pending {
+		if _, err := backend.Write(p); err != nil {
+			l.removeLocked(s)
+			l.mu.Unlock()
+			backend.Close()
+			return err
+		}
+	}
+	s.pending = nil
+	s.backend = backend
+	s.timer.Stop()
+	s.touch()
+	l.wg.Add(1)
+	l.mu.Unlock()
+
+	defer l.wg.Done()
+	defer c.Close()
+	return l.relay(s)
+}
+
+// relay sends the backend's packets to the client until the connection is
+// idle for IdleTimeout.
+func (l *Listener) relay(s *session) error {
+	idleTimeout := l.f.idleTimeout()
+	buf := make([]byte, 65536)
+	for {
+		s.backend.SetReadDeadline(time.Now().Add(idleTimeout - s.idle()))
+		n, err := s.backend.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			if s.idle() < idleTimeout {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.touch()
+		if _, err := l.conn.WriteTo(buf[:n], s.addr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+	"github.com/quic-go/quic-go"
+)
+
+func TestListener(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("public.example.com", "a.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	backend := startTestQUICServer(t, "a", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo", "bar"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:     config,
+			PrivateKey: privKey.Bytes(),
+		}},
+	})
+
+	var keysFuncCalls atomic.Int32
+	f := &Frontend{
+		KeysFunc: func(outer *ech.ClientHelloInfo) ([]ech.Key, error) {
+			keysFuncCalls.Add(1)
+			if !outer.ECHPresented {
+				return nil, errors.New("ech required")
+			}
+			return []ech.Key{{Config: config, PrivateKey: privKey.Bytes()}}, nil
+		},
+		Debugf: t.Logf,
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	ln := f.Listen(pc)
+
+	type result struct {
+		conn *quic.Conn
+		err  error
+	}
+	dial := func(configList []byte) chan result {
+		ch := make(chan result, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+			defer cancel()
+			conn, err := quic.DialAddr(ctx, ln.Addr().String(), &tls.Config{
+				ServerName:                     "a.example.com",
+				RootCAs:                        rootCAs,
+				NextProtos:                     []string{"bar", "foo"},
+				EncryptedClientHelloConfigList: configList,
+			}, nil)
+			ch <- result{conn, err}
+		}()
+		return ch
+	}
+
+	ch := dial(configList)
+	c, err := ln.Accept(t.Context())
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	if got, want := c.ServerName(), "a.example.com"; got != want {
+		t.Errorf("ServerName = %q, want %q", got, want)
+	}
+	if got, want := c.OuterClientHello().ServerName, "public.example.com"; got != want {
+		t.Errorf("Outer ServerName = %q, want %q", got, want)
+	}
+	if got, want := c.ALPNProtos(), []string{"bar", "foo"}; !slices.Equal(got, want) {
+		t.Errorf("ALPNProtos = %q, want %q", got, want)
+	}
+	if !c.ECHPresented() || !c.ECHAccepted() {
+		t.Errorf("ECHPresented = %v, ECHAccepted = %v, want true, true", c.ECHPresented(), c.ECHAccepted())
+	}
+	proxyErr := make(chan error)
+	go func() {
+		proxyErr <- c.ProxyTo(t.Context(), backend)
+	}()
+	r := <-ch
+	if r.err != nil {
+		t.Fatalf("DialAddr: %v", r.err)
+	}
+	if !r.conn.ConnectionState().TLS.ECHAccepted {
+		t.Error("ECHAccepted is false")
+	}
+	stream, err := r.conn.OpenStreamSync(t.Context())
+	if err != nil {
+		t.Fatalf("OpenStreamSync: %v", err)
+	}
+	stream.Write([]byte("Hi\n"))
+	if b, err := io.ReadAll(stream); err != nil || string(b) != "a" {
+		t.Errorf("ReadAll() = %q, %v, want %q", b, err, "a")
+	}
+	r.conn.CloseWithError(0, "")
+
+	// Without ECH, KeysFunc rejects the connection.
+	ch = dial(nil)
+	if r := <-ch; r.err == nil {
+		t.Error("DialAddr succeeded unexpectedly")
+	}
+	if n := keysFuncCalls.Load(); n < 2 {
+		t.Errorf("KeysFunc calls = %d, want >= 2", n)
+	}
+
+	if err := ln.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if err := <-proxyErr; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("ProxyTo() = %v, want net.ErrClosed", err)
+	}
+	if _, err := ln.Accept(t.Context()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept() = %v, want net.ErrClosed", err)
+	}
+
+	// The PacketConn can be used after Close.
+	client, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	buf := make([]byte, 10)
+	if n, _, err := pc.ReadFrom(buf); err != nil || string(buf[:n]) != "hello" {
+		t.Errorf("ReadFrom() = %q, %v, want hello", buf[:n], err)
+	}
+}
+
+func TestFrontendRouter(t *testing.T) {
+	tlsCert, err := testutil.NewCert("a.example.com", "b.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo", "bar"},
+	}
+	router := ech.NewRouter()
+	router.Handle("a.example.com", startTestQUICServer(t, "a", serverConfig))
+	router.HandleALPN("a.example.com", "bar", startTestQUICServer(t, "a bar", serverConfig))
+	router.HandleFunc("b.example.com", func(*ech.Conn) {})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	f := &Frontend{Router: router, Debugf: t.Logf}
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		f.Serve(ctx, pc)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for _, tc := range []struct {
+		serverName string
+		alpn       string
+		want       string
+	}{
+		{"a.example.com", "foo", "a"},
+		{"a.example.com", "bar", "a bar"},
+		{"b.example.com", "foo", ""},
+	} {
+		ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
+		defer cancel()
+		conn, err := quic.DialAddr(ctx, pc.LocalAddr().String(), &tls.Config{
+			ServerName: tc.serverName,
+			RootCAs:    rootCAs,
+			NextProtos: []string{tc.alpn},
+		}, nil)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("DialAddr(%q) succeeded unexpectedly", tc.serverName)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("DialAddr(%q): %v", tc.serverName, err)
+		}
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatalf("OpenStreamSync: %v", err)
+		}
+		stream.Write([]byte("Hi\n"))
+		if b, err := io.ReadAll(stream); err != nil || string(b) != tc.want {
+			t.Errorf("ReadAll() = %q, %v, want %q", b, err, tc.want)
+		}
+		conn.CloseWithError(0, "")
+	}
+}
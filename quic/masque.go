@@ -0,0 +1,113 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/quic-go/masque-go"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+// NewMASQUEDialer returns a [quic.Connection] Dialer that tunnels the QUIC
+// connections through a MASQUE proxy with CONNECT-UDP (RFC 9298), so that the
+// local network only sees the connection to the proxy, and not the server's IP
+// address. Combined with ECH, neither the server's name nor its address are
+// visible to the local network.
+//
+// The proxy is identified by a URI template, e.g.
+// "https://proxy.example.com/.well-known/masque/udp/{target_host}/{target_port}/".
+// The tc argument can be nil. Otherwise, it is used to configure the TLS
+// connection to the proxy. The qc argument is used for the tunneled
+// connections.
+//
+// Names are resolved by the Dialer, and only IP addresses are sent to the
+// proxy. All the tunneled connections share the same connection to the proxy,
+// which must support QUIC packets of at least 1350 bytes so that the tunneled
+// packets fit in its datagrams.
+//
+//	dialer, err := quic.NewMASQUEDialer("https://proxy.example.com/masque?h={target_host}&p={target_port}", nil, nil)
+//	if err != nil {
+//		// ...
+//	}
+//	dialer.RequireECH = true
+//	conn, err := dialer.Dial(ctx, "udp", "private.example.com:443", nil)
+func NewMASQUEDialer(proxyTemplate string, tc *tls.Config, qc *quic.Config) (*ech.Dialer[*quic.Conn], error) {
+	template, err := uritemplate.New(proxyTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if tc == nil {
+		tc = &tls.Config{}
+	}
+	tc = tc.Clone()
+	if !slices.Contains(tc.NextProtos, http3.NextProtoH3) {
+		tc.NextProtos = append(tc.NextProtos, http3.NextProtoH3)
+	}
+	p := &masqueProxy{template: template, tc: tc, qc: qc}
+	return &ech.Dialer[*quic.Conn]{
+		DialFunc:            p.dial,
+		ConnectionStateFunc: tlsConnectionState,
+	}, nil
+}
+
+// masqueProxy manages the connection to a MASQUE proxy.
+type masqueProxy struct {
+	template *uritemplate.Template
+	tc       *tls.Config
+	qc       *quic.Config
+
+	mu     sync.Mutex
+	client *masque.Client
+}
+
+func (p *masqueProxy) getClient() *masque.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == nil {
+		p.client = &masque.Client{TLSClientConfig: p.tc}
+	}
+	return p.client
+}
+
+// reset closes the connection to the proxy after an error, so that the next
+// dial reconnects.
+func (p *masqueProxy) reset(client *masque.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.client == client {
+		p.client = nil
+	}
+	client.Close()
+}
+
+func (p *masqueProxy) dial(ctx context.Context, network, addr string, tc *tls.Config) (*quic.Conn, error) {
+	raddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	client := p.getClient()
+	pc, resp, err := client.Dial(ctx, p.template, raddr)
+	if err != nil {
+		// Without a response, the connection to the proxy failed.
+		if resp == nil && ctx.Err() == nil {
+			p.reset(client)
+		}
+		return nil, err
+	}
+	conn, err := quic.Dial(ctx, pc, raddr, tc, p.qc)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	// The proxied flow isn't closed with the connection.
+	context.AfterFunc(conn.Context(), func() {
+		pc.Close()
+	})
+	return conn, nil
+}
@@ -0,0 +1,116 @@
+package quic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+	"github.com/quic-go/masque-go"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/yosida95/uritemplate/v3"
+)
+
+func TestMASQUEDialer(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("public.example.com", "a.example.com", "proxy.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+	backend := startTestQUICServer(t, "a", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		NextProtos:   []string{"foo"},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:     config,
+			PrivateKey: privKey.Bytes(),
+		}},
+	})
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	defer pc.Close()
+	rawTemplate := fmt.Sprintf("https://%s/masque?h={target_host}&p={target_port}", pc.LocalAddr())
+	template := uritemplate.MustNew(rawTemplate)
+
+	var mu sync.Mutex
+	var targets []string
+	var proxy masque.Proxy
+	defer proxy.Close()
+	server := &http3.Server{
+		TLSConfig:       &tls.Config{Certificates: []tls.Certificate{tlsCert}},
+		QUICConfig:      &quic.Config{EnableDatagrams: true, InitialPacketSize: 1350},
+		EnableDatagrams: true,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			r, err := masque.ParseRequest(req, template)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			targets = append(targets, r.Target)
+			mu.Unlock()
+			proxy.Proxy(w, r)
+		}),
+	}
+	defer server.Close()
+	go server.Serve(pc)
+
+	if _, err := NewMASQUEDialer("https://{", nil, nil); err == nil {
+		t.Error("NewMASQUEDialer succeeded unexpectedly")
+	}
+	dialer, err := NewMASQUEDialer(rawTemplate, &tls.Config{
+		ServerName: "proxy.example.com",
+		RootCAs:    rootCAs,
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewMASQUEDialer: %v", err)
+	}
+	dialer.RequireECH = true
+
+	for range 2 {
+		conn, err := dialer.Dial(t.Context(), "udp", backend, &tls.Config{
+			ServerName:                     "a.example.com",
+			RootCAs:                        rootCAs,
+			NextProtos:                     []string{"foo"},
+			EncryptedClientHelloConfigList: configList,
+		})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		if !conn.ConnectionState().TLS.ECHAccepted {
+			t.Error("ECHAccepted is false")
+		}
+		stream, err := conn.OpenStreamSync(t.Context())
+		if err != nil {
+			t.Fatalf("OpenStreamSync: %v", err)
+		}
+		stream.Write([]byte("Hi\n"))
+		if b, err := io.ReadAll(stream); err != nil || string(b) != "a" {
+			t.Errorf("ReadAll() = %q, %v, want %q", b, err, "a")
+		}
+		conn.CloseWithError(0, "")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(targets) != 2 || targets[0] != backend || targets[1] != backend {
+		t.Errorf("Proxy targets = %v, want [%s %s]", targets, backend, backend)
+	}
+}
@@ -0,0 +1,138 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"math/rand/v2"
+	"time"
+)
+
+// RedialerState is the state of a [Redialer].
+type RedialerState int
+
+const (
+	// RedialerConnecting means that a connection is being established.
+	RedialerConnecting RedialerState = iota
+	// RedialerConnected means that a connection is established.
+	RedialerConnected
+	// RedialerBackoff means that the Redialer is waiting before
+	// reconnecting.
+	RedialerBackoff
+	// RedialerClosed means that the Redialer stopped.
+	RedialerClosed
+)
+
+func (s RedialerState) String() string {
+	switch s {
+	case RedialerConnecting:
+		return "connecting"
+	case RedialerConnected:
+		return "connected"
+	case RedialerBackoff:
+		return "backoff"
+	case RedialerClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// NewRedialer returns a [Redialer] that uses d to connect to addr.
+func NewRedialer[T any](d *Dialer[T], network, addr string, tc *tls.Config) *Redialer[T] {
+	return &Redialer[T]{
+		Dialer:    d,
+		Network:   network,
+		Addr:      addr,
+		TLSConfig: tc,
+	}
+}
+
+// Redialer maintains a persistent connection, e.g. for a long-lived tunnel. It
+// reconnects automatically when the connection is lost, with exponential
+// backoff and jitter between attempts.
+//
+// Names are resolved again on each reconnect, bypassing the [Resolver]'s
+// cache, so that a new ECH config list published in DNS is picked up.
+//
+//	r := ech.NewRedialer(ech.NewDialer(), "tcp", "private.example.com:443", nil)
+//	err := r.Run(ctx, func(ctx context.Context, conn *tls.Conn) error {
+//		// Use conn until it fails.
+//	})
+type Redialer[T any] struct {
+	// Dialer is used to establish the connections.
+	Dialer *Dialer[T]
+	// Network, Addr, and TLSConfig are the arguments passed to
+	// Dialer.Dial.
+	Network   string
+	Addr      string
+	TLSConfig *tls.Config
+	// MinBackoff is the amount of time to wait before reconnecting after
+	// the first failure. The default value is 1s.
+	MinBackoff time.Duration
+	// MaxBackoff is the maximum amount of time to wait between attempts.
+	// The backoff doubles after each failed attempt, up to MaxBackoff. The
+	// default value is 1m.
+	MaxBackoff time.Duration
+	// Jitter is the fraction of the backoff, between 0 and 1, that is
+	// randomized to avoid synchronized reconnects. The default value is
+	// 0.2.
+	Jitter float64
+	// OnStateChange, if set, is called when the state changes. err is the
+	// reason for RedialerBackoff and RedialerClosed.
+	OnStateChange func(state RedialerState, err error)
+}
+
+// Run connects and calls handle with each new connection. When handle returns,
+// the connection is closed and Run reconnects after a backoff. Run returns
+// only when ctx is canceled.
+func (r *Redialer[T]) Run(ctx context.Context, handle func(ctx context.Context, conn T) error) error {
+	minBackoff := r.MinBackoff
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	maxBackoff := r.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Minute
+	}
+	maxBackoff = max(maxBackoff, minBackoff)
+	jitter := r.Jitter
+	if jitter <= 0 || jitter > 1 {
+		jitter = 0.2
+	}
+
+	backoff := minBackoff
+	dialCtx := ctx
+	for {
+		r.setState(RedialerConnecting, nil)
+		conn, err := r.Dialer.Dial(dialCtx, r.Network, r.Addr, r.TLSConfig)
+		if err == nil {
+			r.setState(RedialerConnected, nil)
+			backoff = minBackoff
+			err = handle(ctx, conn)
+			if c, ok := any(conn).(io.Closer); ok {
+				c.Close()
+			}
+		}
+		if ctx.Err() != nil {
+			r.setState(RedialerClosed, ctx.Err())
+			return ctx.Err()
+		}
+		r.setState(RedialerBackoff, err)
+		wait := backoff - time.Duration(jitter*rand.Float64()*float64(backoff))
+		select {
+		case <-ctx.Done():
+			r.setState(RedialerClosed, ctx.Err())
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, maxBackoff)
+		dialCtx = withFreshResolve(ctx)
+	}
+}
+
+func (r *Redialer[T]) setState(state RedialerState, err error) {
+	if r.OnStateChange != nil {
+		r.OnStateChange(state, err)
+	}
+}
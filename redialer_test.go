@@ -0,0 +1,67 @@
+package ech
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRedialer(t *testing.T) {
+	var dials int
+	var fresh []bool
+	dialer := &Dialer[string]{
+		Hosts: map[string]StaticHost{
+			"example.com": {Addresses: []netip.Addr{netip.MustParseAddr("192.0.2.1")}},
+		},
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (string, error) {
+			dials++
+			f, _ := ctx.Value(freshResolveKey).(bool)
+			fresh = append(fresh, f)
+			if dials == 1 {
+				return "", errors.New("dial failed")
+			}
+			return addr, nil
+		},
+	}
+	r := NewRedialer(dialer, "tcp", "example.com:443", nil)
+	r.MinBackoff = time.Millisecond
+	r.MaxBackoff = 4 * time.Millisecond
+
+	var states []RedialerState
+	r.OnStateChange = func(state RedialerState, err error) {
+		states = append(states, state)
+		if state == RedialerBackoff && err == nil {
+			t.Error("RedialerBackoff without error")
+		}
+	}
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	var conns int
+	err := r.Run(ctx, func(ctx context.Context, conn string) error {
+		if conn != "192.0.2.1:443" {
+			t.Errorf("conn = %q", conn)
+		}
+		if conns++; conns == 2 {
+			cancel()
+		}
+		return errors.New("connection lost")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want context.Canceled", err)
+	}
+	want := []RedialerState{
+		RedialerConnecting, RedialerBackoff,
+		RedialerConnecting, RedialerConnected, RedialerBackoff,
+		RedialerConnecting, RedialerConnected, RedialerClosed,
+	}
+	if !slices.Equal(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+	if want := []bool{false, true, true}; !slices.Equal(fresh, want) {
+		t.Errorf("fresh = %v, want %v", fresh, want)
+	}
+}
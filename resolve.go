@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"iter"
-	"log"
+	"log/slog"
 	"maps"
 	"net"
 	"net/netip"
@@ -50,12 +50,21 @@ type ResolveResult struct {
 	Address    []net.IP
 	HTTPS      []dns.HTTPS
 	Additional map[string][]net.IP
+	// Zone is the IPv6 zone, e.g. "eth0", of the link-local addresses in
+	// Address, Additional, and the IPv6 hints. DNS answers don't have zones.
+	// The zone comes from the name when it is an IP address with a zone,
+	// e.g. "[fe80::1%eth0]:443", or from the address of the bootstrap
+	// resolver that answered the queries.
+	Zone string
 }
 
 type Target struct {
 	Address netip.AddrPort
 	ECH     []byte
 	ALPN    []string
+	// HTTPS is the HTTPS record that the target comes from, or nil if the
+	// target comes from an A or AAAA record.
+	HTTPS *dns.HTTPS
 }
 
 func (r ResolveResult) clone() ResolveResult {
@@ -64,9 +73,22 @@ func (r ResolveResult) clone() ResolveResult {
 		Address:    slices.Clone(r.Address),
 		HTTPS:      slices.Clone(r.HTTPS),
 		Additional: maps.Clone(r.Additional),
+		Zone:       r.Zone,
 	}
 }
 
+// withZone returns addr with zone when addr is an IPv6 link-local address,
+// which can't be reached without a zone. Other addresses are returned as is.
+func withZone(addr netip.Addr, zone string) netip.Addr {
+	if zone == "" || !addr.Is6() || addr.Is4In6() {
+		return addr
+	}
+	if addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() {
+		return addr.WithZone(zone)
+	}
+	return addr
+}
+
 // Targets computes the target addresses to attempt in preferred order.
 func (r ResolveResult) Targets(network string) iter.Seq[Target] {
 	address := func(ip net.IP, port uint16) netip.AddrPort {
@@ -80,11 +102,11 @@ func (r ResolveResult) Targets(network string) iter.Seq[Target] {
 		if !ok {
 			return netip.AddrPort{}
 		}
-		return netip.AddrPortFrom(addr, port)
+		return netip.AddrPortFrom(withZone(addr, r.Zone), port)
 	}
 	return func(yield func(Target) bool) {
 		seen := make(map[netip.AddrPort]bool)
-		add := func(ip net.IP, port uint16, ech []byte, alpn []string) bool {
+		add := func(ip net.IP, port uint16, ech []byte, alpn []string, h *dns.HTTPS) bool {
 			if port == 0 {
 				port = r.Port
 			}
@@ -96,7 +118,7 @@ func (r ResolveResult) Targets(network string) iter.Seq[Target] {
 				return true
 			}
 			seen[addr] = true
-			return yield(Target{Address: addr, ECH: ech, ALPN: alpn})
+			return yield(Target{Address: addr, ECH: ech, ALPN: alpn, HTTPS: h})
 		}
 
 		for _, h := range r.HTTPS {
@@ -118,25 +140,25 @@ func (r ResolveResult) Targets(network string) iter.Seq[Target] {
 			}
 			if h.Target != "" {
 				for _, a := range r.Additional[h.Target] {
-					if !add(a, port, h.ECH, alpn) {
+					if !add(a, port, h.ECH, alpn, &h) {
 						return
 					}
 				}
 				continue
 			}
 			for _, a := range r.Address {
-				if !add(a, port, h.ECH, alpn) {
+				if !add(a, port, h.ECH, alpn, &h) {
 					return
 				}
 			}
 			if len(r.Address) == 0 {
 				for _, a := range h.IPv4Hint {
-					if !add(a, port, h.ECH, alpn) {
+					if !add(a, port, h.ECH, alpn, &h) {
 						return
 					}
 				}
 				for _, a := range h.IPv6Hint {
-					if !add(a, port, h.ECH, alpn) {
+					if !add(a, port, h.ECH, alpn, &h) {
 						return
 					}
 				}
@@ -146,7 +168,7 @@ func (r ResolveResult) Targets(network string) iter.Seq[Target] {
 			return
 		}
 		for _, a := range r.Address {
-			if !add(a, r.Port, nil, nil) {
+			if !add(a, r.Port, nil, nil, nil) {
 				return
 			}
 		}
@@ -207,7 +229,7 @@ func NewResolver(URL string) (*Resolver, error) {
 		return nil, err
 	}
 	if u.Scheme != "https" && u.Hostname() != "127.0.0.1" {
-		return nil, errors.New("service url must use https")
+		return nil, fmt.Errorf("%w: service url must use https", ErrInvalidConfiguration)
 	}
 	return &Resolver{
 		baseURL: *u,
@@ -215,6 +237,42 @@ func NewResolver(URL string) (*Resolver, error) {
 	}, nil
 }
 
+// NewResolverFunc returns a resolver that sends its DNS queries with exchange,
+// e.g. a DNS-over-QUIC client like
+// [github.com/c2FmZQ/ech/quic.NewDoQResolver]. The queries are padded, and the
+// responses are cached like those of the other resolvers.
+func NewResolverFunc(exchange func(ctx context.Context, msg *dns.Message) (*dns.Message, error)) *Resolver {
+	return &Resolver{
+		exchange: exchange,
+		cache:    newResolverCache(),
+	}
+}
+
+// NewRoutingResolver returns a resolver that sends the DNS queries for each
+// name to one of the routes' resolvers, e.g. an internal DoH service for the
+// names of a private domain, and a public one for everything else.
+//
+// The route patterns are the same as those of [Router], e.g.
+// ".corp.example.com" for any name under corp.example.com, and "*" for all the
+// other names. When no route matches, [DefaultResolver] is used. The routes'
+// resolvers cache the responses, not the routing resolver.
+func NewRoutingResolver(routes map[string]*Resolver) (*Resolver, error) {
+	r := &Resolver{
+		routes: make(map[string]*Resolver, len(routes)),
+	}
+	for pattern, upstream := range routes {
+		p := normalizeServerName(pattern)
+		if !validPattern(p) {
+			return nil, fmt.Errorf("%w: invalid pattern %q", ErrInvalidConfiguration, pattern)
+		}
+		if upstream == nil {
+			return nil, fmt.Errorf("%w: nil resolver for %q", ErrInvalidConfiguration, pattern)
+		}
+		r.routes[p] = upstream
+	}
+	return r, nil
+}
+
 // Resolver is a RFC 8484 DNS-over-HTTPS (DoH) client.
 //
 // The resolver uses HTTPS DNS Resource Records whenever possible to retrieve
@@ -228,6 +286,29 @@ type Resolver struct {
 	cache   *lru.TwoQueueCache[cacheKey, *cacheValue]
 
 	insecureUseGoResolver bool
+	// bootstrapAddr is the address of the unencrypted resolver used by a
+	// bootstrap Resolver.
+	bootstrapAddr string
+	// exchange sends the DNS queries of a Resolver created with
+	// NewResolverFunc.
+	exchange func(ctx context.Context, msg *dns.Message) (*dns.Message, error)
+	// routes maps the name patterns of a Resolver created with
+	// NewRoutingResolver to their resolvers.
+	routes map[string]*Resolver
+	logger *slog.Logger
+
+	// minTTL and maxTTL clamp the TTLs of the DNS records. Zero means no
+	// limit.
+	minTTL, maxTTL time.Duration
+	// maxEntrySize is the maximum size of the records of a cache entry.
+	// Zero means no limit.
+	maxEntrySize int
+}
+
+// SetLogger sets the logger of the Resolver. When it isn't set, the logger set
+// with the package's [SetLogger] is used.
+func (r *Resolver) SetLogger(l *slog.Logger) {
+	r.logger = l
 }
 
 // SetCacheSize sets the size of the DNS cache. The default size is 32. A zero
@@ -243,6 +324,29 @@ func (r *Resolver) SetCacheSize(n int) {
 	r.cache.Resize(n)
 }
 
+// SetCacheTTL clamps the TTLs of the DNS records between minTTL and maxTTL,
+// instead of trusting the TTLs of the name servers. A zero value means no
+// limit, which is the default.
+//
+// For example, a maximum of 5 minutes makes the rotations of ECH config lists
+// propagate to the clients quickly, even when the records have long TTLs. A
+// minimum of 5 seconds avoids sending the same queries over and over for
+// records with very short TTLs. Negative answers are cached for 5 minutes,
+// which is also clamped.
+func (r *Resolver) SetCacheTTL(minTTL, maxTTL time.Duration) {
+	r.minTTL = max(minTTL, 0)
+	r.maxTTL = max(maxTTL, 0)
+}
+
+// SetMaxCacheEntrySize sets the maximum size, in bytes, of the records of one
+// DNS answer that can be cached, e.g. to bound the memory used by HTTPS
+// records with large ECH config lists. The size is approximately that of the
+// records' data in DNS messages. Larger answers are still used, but not
+// cached. A zero or negative value means no limit, which is the default.
+func (r *Resolver) SetMaxCacheEntrySize(n int) {
+	r.maxEntrySize = max(n, 0)
+}
+
 func newResolverCache() *lru.TwoQueueCache[cacheKey, *cacheValue] {
 	c, err := lru.New2Q[cacheKey, *cacheValue](defaultResolverCacheSize)
 	if err != nil {
@@ -251,6 +355,16 @@ func newResolverCache() *lru.TwoQueueCache[cacheKey, *cacheValue] {
 	return c
 }
 
+type ctxResolveKey int
+
+var freshResolveKey ctxResolveKey = 1
+
+// withFreshResolve returns a context that makes the Resolver ignore the cached
+// results. The new results are still cached.
+func withFreshResolve(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshResolveKey, true)
+}
+
 type cacheKey struct {
 	name string
 	typ  string
@@ -282,11 +396,83 @@ type cacheValue struct {
 // QNAME used is always the hostname by itself, without _port and _service.
 //
 // A and AAAA RRs are looked up with just the hostname as QNAME.
-func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, error) {
+//
+// The options apply to this call only, e.g. so that a shared Resolver can
+// serve callers with different freshness requirements.
+func (r *Resolver) Resolve(ctx context.Context, name string, opts ...ResolveOption) (ResolveResult, error) {
+	o := &resolveOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	for _, typ := range o.types {
+		if typ != "A" && typ != "AAAA" && typ != "HTTPS" {
+			return ResolveResult{}, fmt.Errorf("%w: unsupported record type %q", ErrInvalidConfiguration, typ)
+		}
+	}
+	if o.noCache {
+		ctx = withFreshResolve(ctx)
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	result, _, err := r.resolve(ctx, name, o)
+	return result, err
+}
+
+// ResolveOption is an option for [Resolver.Resolve].
+type ResolveOption func(*resolveOptions)
+
+type resolveOptions struct {
+	noCache bool
+	types   []string
+	timeout time.Duration
+}
+
+// want indicates whether records of type typ should be looked up.
+func (o *resolveOptions) want(typ string) bool {
+	return o == nil || len(o.types) == 0 || slices.Contains(o.types, typ)
+}
+
+// WithNoCache makes Resolve ignore the cached DNS records, and send new
+// queries. The new records are still cached for the other calls.
+func WithNoCache() ResolveOption {
+	return func(o *resolveOptions) {
+		o.noCache = true
+	}
+}
+
+// WithRecordTypes limits the lookups to the record types, any of "A", "AAAA",
+// and "HTTPS". The default is all of them. For example, with only "A" and
+// "AAAA", no HTTPS records are looked up, and the result has no ECH config
+// list. With only "HTTPS", the result has the HTTPS records without the
+// addresses of their targets, except for the IP hints.
+func WithRecordTypes(types ...string) ResolveOption {
+	return func(o *resolveOptions) {
+		o.types = types
+	}
+}
+
+// WithResolveTimeout sets the maximum amount of time that Resolve can take,
+// including all its DNS queries. The default is the deadline of the context,
+// if any.
+func WithResolveTimeout(d time.Duration) ResolveOption {
+	return func(o *resolveOptions) {
+		o.timeout = d
+	}
+}
+
+// resolve is like Resolve, and also returns the time when the first of the
+// DNS records that were used expires. The expiration time is zero when no
+// DNS records were used. A nil o looks up all the record types.
+func (r *Resolver) resolve(ctx context.Context, name string, o *resolveOptions) (ResolveResult, time.Time, error) {
+	var exp expiry
 	result := ResolveResult{
 		Port: 443,
 	}
 	scheme := "https"
+	origName := name
 
 	if u, err := url.Parse(name); err == nil && u.Scheme != "" && u.Host != "" {
 		scheme = strings.ToLower(u.Scheme)
@@ -303,34 +489,47 @@ func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, err
 			}
 		}
 	}
+	// IPv6 addresses in URLs are in brackets, with or without a port.
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		name = name[1 : len(name)-1]
+	}
 	if name == "localhost" {
 		result.Address = []net.IP{
 			net.IP{127, 0, 0, 1},
 			net.IPv6loopback,
 		}
-		return result, nil
+		return result, exp.t, nil
 	}
-	if ip := net.ParseIP(name); ip != nil {
-		if ipv4 := ip.To4(); ipv4 != nil {
-			result.Address = []net.IP{ipv4}
-		} else {
-			result.Address = []net.IP{ip}
-		}
-		return result, nil
+	if addr, err := netip.ParseAddr(name); err == nil {
+		result.Address = []net.IP{net.IP(addr.Unmap().AsSlice())}
+		result.Zone = addr.Zone()
+		return result, exp.t, nil
 	}
 	if len(name) > 255 {
-		return result, ErrInvalidName
+		return result, exp.t, ErrInvalidName
 	}
 	for _, p := range strings.Split(name, ".") {
 		if len(p) > 63 {
-			return result, ErrInvalidName
+			return result, exp.t, ErrInvalidName
 		}
 	}
+	if r.routes != nil {
+		return r.route(name).resolve(ctx, origName, o)
+	}
 
 	if r.insecureUseGoResolver {
-		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", name)
+		network := "ip"
+		switch {
+		case !o.want("A") && !o.want("AAAA"):
+			return result, exp.t, nil
+		case !o.want("A"):
+			network = "ip6"
+		case !o.want("AAAA"):
+			network = "ip4"
+		}
+		ips, err := net.DefaultResolver.LookupIP(ctx, network, name)
 		if err != nil {
-			return result, err
+			return result, exp.t, err
 		}
 		result.Address = make([]net.IP, 0, len(ips))
 		for _, ip := range ips {
@@ -340,9 +539,11 @@ func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, err
 			}
 			result.Address = append(result.Address, ip)
 		}
-		return result, nil
+		return result, exp.t, nil
 	}
 
+	result.Zone = r.bootstrapZone()
+
 	// https://www.rfc-editor.org/rfc/rfc9460.html#section-2.3
 	svcbName := name
 	if result.Port != 80 && result.Port != 443 {
@@ -354,21 +555,21 @@ func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, err
 	// First, resolve HTTPS Aliases.
 	want := svcbName
 	seen := make(map[string]bool)
-	for {
+	for o.want("HTTPS") {
 		if seen[want] {
-			log.Printf("ERR Resolve(%q): alias loop detected", name)
+			logger(r.logger).Warn("ech: alias loop detected", "name", name)
 			want = name
 			break
 		}
 		seen[want] = true
 		if len(seen) >= 5 {
-			log.Printf("ERR Resolve(%q): alias chain too long", name)
+			logger(r.logger).Warn("ech: alias chain too long", "name", name)
 			want = name
 			break
 		}
-		https, err := r.resolveOne(ctx, want, "HTTPS")
+		https, err := r.resolveOne(ctx, want, "HTTPS", &exp)
 		if err != nil && !errors.Is(err, ErrNonExistentDomain) {
-			return result, err
+			return result, exp.t, err
 		}
 		if len(https) > 0 {
 			// Alias Mode: Priority = 0
@@ -398,7 +599,7 @@ func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, err
 			continue
 		}
 		if len(h.Target) > 0 {
-			if err := r.resolveTarget(ctx, h.Target, &result); err != nil {
+			if err := r.resolveTarget(ctx, h.Target, &result, &exp, o); err != nil {
 				continue
 			}
 		}
@@ -407,51 +608,71 @@ func (r *Resolver) Resolve(ctx context.Context, name string) (ResolveResult, err
 		want = name
 	}
 	// Then, resolve IP addresses.
-	a, err := r.resolveOne(ctx, want, "A")
-	if err != nil {
-		return result, err
-	}
-	for _, v := range a {
-		result.Address = append(result.Address, v.(net.IP))
-	}
-	aaaa, err := r.resolveOne(ctx, want, "AAAA")
-	if err != nil {
-		return result, err
-	}
-	for _, v := range aaaa {
-		result.Address = append(result.Address, v.(net.IP))
+	for _, typ := range []string{"A", "AAAA"} {
+		if !o.want(typ) {
+			continue
+		}
+		addrs, err := r.resolveOne(ctx, want, typ, &exp)
+		if err != nil {
+			return result, exp.t, err
+		}
+		for _, v := range addrs {
+			result.Address = append(result.Address, v.(net.IP))
+		}
 	}
-	return result, nil
+	return result, exp.t, nil
 }
 
-func (r *Resolver) resolveTarget(ctx context.Context, name string, res *ResolveResult) error {
+func (r *Resolver) resolveTarget(ctx context.Context, name string, res *ResolveResult, exp *expiry, o *resolveOptions) error {
 	if res.Additional == nil {
 		res.Additional = make(map[string][]net.IP)
 	}
 	if _, exists := res.Additional[name]; exists {
 		return nil
 	}
-	a, err := r.resolveOne(ctx, name, "A")
-	if err != nil {
-		return err
-	}
-	for _, v := range a {
-		res.Additional[name] = append(res.Additional[name], v.(net.IP))
+	for _, typ := range []string{"A", "AAAA"} {
+		if !o.want(typ) {
+			continue
+		}
+		addrs, err := r.resolveOne(ctx, name, typ, exp)
+		if err != nil {
+			return err
+		}
+		for _, v := range addrs {
+			res.Additional[name] = append(res.Additional[name], v.(net.IP))
+		}
 	}
-	aaaa, err := r.resolveOne(ctx, name, "AAAA")
-	if err != nil {
-		return err
+	return nil
+}
+
+// route returns the resolver of a routing Resolver for name.
+func (r *Resolver) route(name string) *Resolver {
+	for p := range namePatterns(normalizeServerName(name)) {
+		if upstream, ok := r.routes[p]; ok {
+			return upstream
+		}
 	}
-	for _, v := range aaaa {
-		res.Additional[name] = append(res.Additional[name], v.(net.IP))
+	return DefaultResolver
+}
+
+// expiry tracks the earliest expiration time of a set of DNS records.
+type expiry struct {
+	t time.Time
+}
+
+func (e *expiry) update(t time.Time) {
+	if e.t.IsZero() || t.Before(e.t) {
+		e.t = t
 	}
-	return nil
 }
 
-func (r *Resolver) resolveOne(ctx context.Context, name, typ string) ([]any, error) {
+func (r *Resolver) resolveOne(ctx context.Context, name, typ string, exp *expiry) ([]any, error) {
 	cache := r.cache
 	if cache == nil {
-		v, _, err := r.resolveOneNoCache(ctx, name, typ)
+		v, ttl, err := r.resolveOneNoCache(ctx, name, typ)
+		if err == nil {
+			exp.update(timeNow().Add(r.recordTTL(v, ttl)))
+		}
 		return v, err
 	}
 	key := cacheKey{name, typ}
@@ -460,18 +681,21 @@ func (r *Resolver) resolveOne(ctx context.Context, name, typ string) ([]any, err
 		v = &cacheValue{}
 		cache.Add(key, v)
 	}
+	fresh, _ := ctx.Value(freshResolveKey).(bool)
 	// fast path
 	v.mu.RLock()
-	exp, res := v.expiration, v.result
+	expiration, res := v.expiration, v.result
 	v.mu.RUnlock()
-	if !exp.IsZero() && timeNow().Before(exp) {
+	if !fresh && !expiration.IsZero() && timeNow().Before(expiration) {
+		exp.update(expiration)
 		return res, nil
 	}
 
 	// slow path
 	v.mu.Lock()
 	defer v.mu.Unlock()
-	if !v.expiration.IsZero() && timeNow().Before(v.expiration) {
+	if !fresh && !v.expiration.IsZero() && timeNow().Before(v.expiration) {
+		exp.update(v.expiration)
 		return v.result, nil
 	}
 	res, ttl, err := r.resolveOneNoCache(ctx, name, typ)
@@ -479,14 +703,57 @@ func (r *Resolver) resolveOne(ctx context.Context, name, typ string) ([]any, err
 		cache.Remove(key)
 		return nil, err
 	}
-	if len(res) == 0 {
-		ttl = 300
+	if r.maxEntrySize > 0 && recordsSize(res) > r.maxEntrySize {
+		cache.Remove(key)
+		exp.update(timeNow().Add(r.recordTTL(res, ttl)))
+		return res, nil
 	}
-	v.expiration = timeNow().Add(time.Second * time.Duration(ttl))
+	v.expiration = timeNow().Add(r.recordTTL(res, ttl))
 	v.result = res
+	exp.update(v.expiration)
 	return res, nil
 }
 
+// recordTTL returns the amount of time that the result of a DNS query can be
+// cached. Negative answers are cached for 5 minutes. The TTL is clamped with
+// the values set with SetCacheTTL.
+func (r *Resolver) recordTTL(res []any, ttl uint32) time.Duration {
+	if len(res) == 0 {
+		ttl = 300
+	}
+	d := time.Second * time.Duration(ttl)
+	if r.maxTTL > 0 {
+		d = min(d, r.maxTTL)
+	}
+	return max(d, r.minTTL)
+}
+
+// recordsSize returns the approximate size of the data of the records in res.
+func recordsSize(res []any) int {
+	var n int
+	for _, v := range res {
+		switch v := v.(type) {
+		case net.IP:
+			n += len(v)
+		case string:
+			n += len(v)
+		case dns.HTTPS:
+			n += 8 + len(v.Target) + len(v.ECH) + 4*len(v.IPv4Hint) + 16*len(v.IPv6Hint)
+			for _, a := range v.ALPN {
+				n += 1 + len(a)
+			}
+		case dns.SVCB:
+			n += 2 + len(v.Target)
+			for _, p := range v.Params {
+				n += 4 + len(p.Value)
+			}
+		default:
+			n += 16
+		}
+	}
+	return n
+}
+
 func (r *Resolver) resolveOneNoCache(ctx context.Context, name, typ string) ([]any, uint32, error) {
 	qq := &dns.Message{
 		ID: 0x0000,
@@ -497,18 +764,24 @@ func (r *Resolver) resolveOneNoCache(ctx context.Context, name, typ string) ([]a
 			Class: 1,
 		}},
 	}
-	qq.AddPadding()
-
-	result, err := dns.DoH(ctx, qq, r.baseURL.String())
+	var result *dns.Message
+	var err error
+	switch {
+	case r.bootstrapAddr != "":
+		result, err = dns.Do53(ctx, qq, r.bootstrapAddr)
+	case r.exchange != nil:
+		qq.AddPadding()
+		result, err = r.exchange(ctx, qq)
+	default:
+		qq.AddPadding()
+		result, err = dns.DoH(ctx, qq, r.baseURL.String())
+	}
 	if err != nil {
 		return nil, 0, err
 	}
 
 	if rc := result.ResponseCode(); rc != 0 {
-		if err := rcode[rc]; err != nil {
-			return nil, 0, fmt.Errorf("%s (%s): %w (%d)", name, typ, rcode[rc], rc)
-		}
-		return nil, 0, fmt.Errorf("%s (%s): response code %d", name, typ, rc)
+		return nil, 0, &DNSError{Name: name, Type: typ, RCode: rc}
 	}
 	var res []any
 	var ttl uint32
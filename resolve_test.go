@@ -1,6 +1,8 @@
 package ech
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/url"
 	"reflect"
@@ -202,15 +204,20 @@ func TestResolverCache(t *testing.T) {
 	resolver.SetCacheSize(10)
 
 	want := []any{net.IP{192, 168, 0, 1}, net.IP{192, 168, 0, 2}}
+	wantExp := now.Add(5 * time.Second)
 
 	for range 5 {
-		got, err := resolver.resolveOne(t.Context(), "example.com", "A")
+		var exp expiry
+		got, err := resolver.resolveOne(t.Context(), "example.com", "A", &exp)
 		if err != nil {
 			t.Fatalf("resolver.resolveOne: %v", err)
 		}
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("resolver.resolveOne() = %#v, want %#v", got, want)
 		}
+		if !exp.t.Equal(wantExp) {
+			t.Errorf("expiration = %v, want %v", exp.t, wantExp)
+		}
 		now = now.Add(time.Second)
 		db[0].Data = net.IP{192, 168, 1, 1}
 		db[1].Data = net.IP{192, 168, 1, 2}
@@ -219,7 +226,7 @@ func TestResolverCache(t *testing.T) {
 	want = []any{net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 2}}
 
 	for range 5 {
-		got, err := resolver.resolveOne(t.Context(), "example.com", "A")
+		got, err := resolver.resolveOne(t.Context(), "example.com", "A", &expiry{})
 		if err != nil {
 			t.Fatalf("resolver.resolveOne: %v", err)
 		}
@@ -231,10 +238,27 @@ func TestResolverCache(t *testing.T) {
 		db[1].Name = "foo.example.com"
 	}
 
+	// A fresh resolve ignores the cache.
+	db[0].Name, db[1].Name = "example.com", "example.com"
+	db[0].Data = net.IP{192, 168, 2, 1}
+	db[1].Data = net.IP{192, 168, 2, 2}
+	want = []any{net.IP{192, 168, 2, 1}, net.IP{192, 168, 2, 2}}
+	for _, ctx := range []context.Context{withFreshResolve(t.Context()), t.Context()} {
+		got, err := resolver.resolveOne(ctx, "example.com", "A", &expiry{})
+		if err != nil {
+			t.Fatalf("resolver.resolveOne: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("resolver.resolveOne() = %#v, want %#v", got, want)
+		}
+	}
+	now = now.Add(10 * time.Second)
+	db[0].Name, db[1].Name = "foo.example.com", "foo.example.com"
+
 	want = nil
 
 	for range 5 {
-		got, err := resolver.resolveOne(t.Context(), "example.com", "A")
+		got, err := resolver.resolveOne(t.Context(), "example.com", "A", &expiry{})
 		if err != nil {
 			t.Fatalf("resolver.resolveOne: %v", err)
 		}
@@ -244,6 +268,33 @@ func TestResolverCache(t *testing.T) {
 	}
 }
 
+func TestNewResolverFunc(t *testing.T) {
+	db := []dns.RR{{
+		Name: "example.com", Type: 1, Class: 1, TTL: 60,
+		Data: net.IP{192, 168, 0, 1},
+	}}
+	var calls int
+	resolver := NewResolverFunc(func(ctx context.Context, msg *dns.Message) (*dns.Message, error) {
+		calls++
+		if len(msg.Additional) != 1 || msg.Additional[0].Type != 41 || len(msg.Bytes())%128 != 0 {
+			t.Errorf("query isn't padded: %#v", msg.Additional)
+		}
+		return testutil.Answer(t, db, msg), nil
+	})
+	for range 3 {
+		got, err := resolver.resolveOne(t.Context(), "example.com", "A", &expiry{})
+		if err != nil {
+			t.Fatalf("resolver.resolveOne: %v", err)
+		}
+		if want := []any{net.IP{192, 168, 0, 1}}; !reflect.DeepEqual(got, want) {
+			t.Errorf("resolver.resolveOne() = %#v, want %#v", got, want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("exchange calls = %d, want 1", calls)
+	}
+}
+
 func TestResolveResultTargets(t *testing.T) {
 	for i, tc := range []struct {
 		result ResolveResult
@@ -317,6 +368,14 @@ func TestResolveResultTargets(t *testing.T) {
 			},
 			want: "10.10.10.1:8004",
 		},
+		{
+			result: ResolveResult{
+				Port:    443,
+				Address: []net.IP{net.ParseIP("fe80::1"), net.ParseIP("2001:db8::1"), {192, 168, 0, 1}},
+				Zone:    "eth0",
+			},
+			want: "[fe80::1%eth0]:443 | [2001:db8::1]:443 | 192.168.0.1:443",
+		},
 	} {
 		var s []string
 		for target := range tc.result.Targets("tcp") {
@@ -332,3 +391,288 @@ func TestResolveResultTargets(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveEdgeCases(t *testing.T) {
+	db := []dns.RR{
+		// www.example.com A 192.0.2.1
+		{
+			Name: "www.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 1},
+		},
+		// alias1.example.com HTTPS 0 alias2.example.com
+		// alias2.example.com HTTPS 0 svc.example.com
+		// svc.example.com HTTPS 1 . ech=...
+		testutil.HTTPSAlias("alias1.example.com", "alias2.example.com"),
+		testutil.HTTPSAlias("alias2.example.com", "svc.example.com"),
+		{
+			Name: "svc.example.com", Type: 65, Class: 1, TTL: 60,
+			Data: dns.HTTPS{Priority: 1, ECH: []byte{0, 1, 2}},
+		},
+		{
+			Name: "svc.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 2},
+		},
+		// loop1.example.com HTTPS 0 loop2.example.com
+		// loop2.example.com HTTPS 0 loop1.example.com
+		testutil.HTTPSAlias("loop1.example.com", "loop2.example.com"),
+		testutil.HTTPSAlias("loop2.example.com", "loop1.example.com"),
+		{
+			Name: "loop1.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 3},
+		},
+	}
+	for i := range 30 {
+		// many.example.com A 192.0.2.100-129
+		db = append(db, dns.RR{
+			Name: "many.example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, byte(100 + i)},
+		})
+	}
+	ts := testutil.StartTestDNSServer(t, db,
+		testutil.WithNXDOMAIN(testutil.SOA("example.com", 30)),
+		testutil.WithRCode("broken.example.com", 2),
+	)
+	defer ts.Close()
+	resolver, err := NewResolver("http://" + ts.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+
+	// NODATA for HTTPS, and an address.
+	res, err := resolver.Resolve(t.Context(), "www.example.com")
+	if err != nil {
+		t.Fatalf("Resolve(www.example.com): %v", err)
+	}
+	if len(res.HTTPS) != 0 || len(res.Address) != 1 || !res.Address[0].Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("Resolve(www.example.com) = %+v", res)
+	}
+
+	if _, err := resolver.Resolve(t.Context(), "missing.example.com"); !errors.Is(err, ErrNonExistentDomain) {
+		t.Errorf("Resolve(missing.example.com) = %v, want ErrNonExistentDomain", err)
+	}
+	if _, err := resolver.Resolve(t.Context(), "broken.example.com"); !errors.Is(err, ErrServerFailure) {
+		t.Errorf("Resolve(broken.example.com) = %v, want ErrServerFailure", err)
+	}
+
+	// The alias chain is followed.
+	if res, err = resolver.Resolve(t.Context(), "alias1.example.com"); err != nil {
+		t.Fatalf("Resolve(alias1.example.com): %v", err)
+	}
+	if len(res.HTTPS) != 1 || !reflect.DeepEqual(res.HTTPS[0].ECH, []byte{0, 1, 2}) || len(res.Address) != 1 || !res.Address[0].Equal(net.IP{192, 0, 2, 2}) {
+		t.Errorf("Resolve(alias1.example.com) = %+v", res)
+	}
+
+	// The alias loop is detected, and the name's own addresses are used.
+	if res, err = resolver.Resolve(t.Context(), "loop1.example.com"); err != nil {
+		t.Fatalf("Resolve(loop1.example.com): %v", err)
+	}
+	if len(res.HTTPS) != 0 || len(res.Address) != 1 || !res.Address[0].Equal(net.IP{192, 0, 2, 3}) {
+		t.Errorf("Resolve(loop1.example.com) = %+v", res)
+	}
+
+	// Slow server.
+	slow := testutil.StartTestDNSServer(t, db, testutil.WithLatency(time.Second))
+	defer slow.Close()
+	if resolver, err = NewResolver("http://" + slow.Listener.Addr().String() + "/dns-query"); err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := resolver.Resolve(ctx, "www.example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Resolve(www.example.com) = %v, want DeadlineExceeded", err)
+	}
+
+	// The truncated UDP response is retried over TCP.
+	addr := testutil.StartTestDo53Server(t, db, testutil.WithTruncation(512))
+	if resolver, err = NewBootstrapResolver(addr); err != nil {
+		t.Fatalf("NewBootstrapResolver: %v", err)
+	}
+	if res, err = resolver.Resolve(t.Context(), "many.example.com"); err != nil {
+		t.Fatalf("Resolve(many.example.com): %v", err)
+	}
+	if got, want := len(res.Address), 30; got != want {
+		t.Errorf("len(Address) = %d, want %d", got, want)
+	}
+}
+
+func TestResolveOptions(t *testing.T) {
+	db := []dns.RR{
+		{
+			Name: "example.com", Type: 65, Class: 1, TTL: 60,
+			Data: dns.HTTPS{Priority: 1, ECH: []byte{0, 1, 2}},
+		},
+		{
+			Name: "example.com", Type: 1, Class: 1, TTL: 60,
+			Data: net.IP{192, 0, 2, 1},
+		},
+		{
+			Name: "example.com", Type: 28, Class: 1, TTL: 60,
+			Data: net.ParseIP("2001:db8::1"),
+		},
+	}
+	ts := testutil.StartTestDNSServer(t, db)
+	defer ts.Close()
+	resolver, err := NewResolver("http://" + ts.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+
+	res, err := resolver.Resolve(t.Context(), "example.com", WithRecordTypes("A"))
+	if err != nil {
+		t.Fatalf("Resolve(A): %v", err)
+	}
+	if len(res.HTTPS) != 0 || len(res.Address) != 1 || !res.Address[0].Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("Resolve(A) = %+v", res)
+	}
+	if res, err = resolver.Resolve(t.Context(), "example.com", WithRecordTypes("HTTPS")); err != nil {
+		t.Fatalf("Resolve(HTTPS): %v", err)
+	}
+	if len(res.HTTPS) != 1 || len(res.Address) != 0 {
+		t.Errorf("Resolve(HTTPS) = %+v", res)
+	}
+	if _, err := resolver.Resolve(t.Context(), "example.com", WithRecordTypes("MX")); !errors.Is(err, ErrInvalidConfiguration) {
+		t.Errorf("Resolve(MX) = %v, want ErrInvalidConfiguration", err)
+	}
+
+	// The cached records are used, unless WithNoCache is set.
+	db[1].Data = net.IP{192, 0, 2, 2}
+	if res, err = resolver.Resolve(t.Context(), "example.com"); err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if len(res.Address) != 2 || !res.Address[0].Equal(net.IP{192, 0, 2, 1}) {
+		t.Errorf("Resolve() = %+v, want cached address", res)
+	}
+	if res, err = resolver.Resolve(t.Context(), "example.com", WithNoCache()); err != nil {
+		t.Fatalf("Resolve(WithNoCache): %v", err)
+	}
+	if len(res.Address) != 2 || !res.Address[0].Equal(net.IP{192, 0, 2, 2}) {
+		t.Errorf("Resolve(WithNoCache) = %+v, want new address", res)
+	}
+
+	slow := testutil.StartTestDNSServer(t, db, testutil.WithLatency(time.Second))
+	defer slow.Close()
+	slowResolver, err := NewResolver("http://" + slow.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	start := time.Now()
+	if _, err := slowResolver.Resolve(t.Context(), "example.com", WithResolveTimeout(50*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Resolve(WithResolveTimeout) = %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Resolve(WithResolveTimeout) took %v", d)
+	}
+}
+
+func TestResolverCacheTuning(t *testing.T) {
+	now := time.Date(2025, 2, 25, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time {
+		return now
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+
+	db := []dns.RR{
+		{
+			Name: "long.example.com", Type: 1, Class: 1, TTL: 86400,
+			Data: net.IP{192, 0, 2, 1},
+		},
+		{
+			Name: "short.example.com", Type: 1, Class: 1, TTL: 1,
+			Data: net.IP{192, 0, 2, 2},
+		},
+		{
+			Name: "large.example.com", Type: 65, Class: 1, TTL: 60,
+			Data: dns.HTTPS{Priority: 1, ECH: make([]byte, 500)},
+		},
+	}
+	ts := testutil.StartTestDNSServer(t, db)
+	defer ts.Close()
+	resolver := &Resolver{baseURL: url.URL{Scheme: "http", Host: ts.Listener.Addr().String(), Path: "/dns-query"}}
+	resolver.SetCacheSize(10)
+	resolver.SetCacheTTL(5*time.Second, 5*time.Minute)
+	resolver.SetMaxCacheEntrySize(100)
+
+	for _, tc := range []struct {
+		name string
+		typ  string
+		want time.Duration
+	}{
+		{"long.example.com", "A", 5 * time.Minute},
+		{"short.example.com", "A", 5 * time.Second},
+		{"missing.example.com", "A", 5 * time.Minute},
+		{"large.example.com", "HTTPS", time.Minute},
+	} {
+		var exp expiry
+		if _, err := resolver.resolveOne(t.Context(), tc.name, tc.typ, &exp); err != nil {
+			t.Fatalf("resolveOne(%q): %v", tc.name, err)
+		}
+		if want := now.Add(tc.want); !exp.t.Equal(want) {
+			t.Errorf("resolveOne(%q) expiration = %v, want %v", tc.name, exp.t, want)
+		}
+	}
+
+	// The large answer isn't cached.
+	if resolver.cache.Contains(cacheKey{"large.example.com", "HTTPS"}) {
+		t.Error("large.example.com is cached")
+	}
+	if !resolver.cache.Contains(cacheKey{"long.example.com", "A"}) {
+		t.Error("long.example.com isn't cached")
+	}
+}
+
+func TestRoutingResolver(t *testing.T) {
+	internal := testutil.StartTestDNSServer(t, []dns.RR{
+		{Name: "www.corp.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{10, 0, 0, 1}},
+		{Name: "a.b.corp.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{10, 0, 0, 2}},
+		{Name: "www.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{10, 0, 0, 3}},
+	})
+	defer internal.Close()
+	external := testutil.StartTestDNSServer(t, []dns.RR{
+		{Name: "www.example.com", Type: 1, Class: 1, TTL: 60, Data: net.IP{192, 0, 2, 1}},
+	})
+	defer external.Close()
+
+	internalResolver, err := NewResolver("http://" + internal.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	externalResolver, err := NewResolver("http://" + external.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	resolver, err := NewRoutingResolver(map[string]*Resolver{
+		".Corp.Example.com": internalResolver,
+		"*":                 externalResolver,
+	})
+	if err != nil {
+		t.Fatalf("NewRoutingResolver: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		want []net.IP
+	}{
+		{"www.corp.example.com", []net.IP{{10, 0, 0, 1}}},
+		{"https://a.b.corp.example.com:8443/", []net.IP{{10, 0, 0, 2}}},
+		{"www.example.com", []net.IP{{192, 0, 2, 1}}},
+		{"192.0.2.2", []net.IP{{192, 0, 2, 2}}},
+	} {
+		res, err := resolver.Resolve(t.Context(), tc.name)
+		if err != nil {
+			t.Fatalf("Resolve(%q): %v", tc.name, err)
+		}
+		if got, want := res.Address, tc.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("Resolve(%q) = %v, want %v", tc.name, got, want)
+		}
+	}
+
+	for _, routes := range []map[string]*Resolver{
+		{"": externalResolver},
+		{"www.*.example.com": externalResolver},
+		{".example.com": nil},
+	} {
+		if _, err := NewRoutingResolver(routes); !errors.Is(err, ErrInvalidConfiguration) {
+			t.Errorf("NewRoutingResolver(%v) = %v, want ErrInvalidConfiguration", routes, err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package ech
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"sync"
+)
+
+// ErrNoRoute is returned by [Router.Serve] when no route matches the
+// connection's ServerName.
+var ErrNoRoute = errors.New("no route")
+
+// NewRouter returns a new empty [Router].
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[string]*routeSet),
+	}
+}
+
+// Router routes [Conn] connections to backend servers or handler functions
+// based on the ServerName extracted from the ClientHello, i.e. the encrypted
+// SNI when ECH is accepted, or the plaintext SNI otherwise.
+//
+// Patterns can be:
+//   - an exact name, e.g. "www.example.com"
+//   - a wildcard name, e.g. "*.example.com", matching exactly one label
+//     before example.com, e.g. "www.example.com" but not "example.com" or
+//     "a.b.example.com"
+//   - a suffix, e.g. ".example.com", matching any name ending with
+//     .example.com, e.g. "www.example.com" and "a.b.example.com" but not
+//     "example.com"
+//   - "*", matching any name, including an empty one
+//
+// When more than one pattern matches, the exact name is used first, then the
+// wildcard name, then the longest suffix, then "*".
+//
+// Routes can also be restricted to a specific ALPN protocol with
+// [Router.HandleALPN] and [Router.HandleALPNFunc]. For a given pattern, a
+// route for one of the ALPN protocols offered by the client takes precedence
+// over a route without ALPN. The client's order of preference is used when
+// more than one protocol matches. If a pattern has no route for the client's
+// ALPN protocols and no route without ALPN, the next matching pattern is used.
+//
+//	router := ech.NewRouter()
+//	router.Handle("*.internal.example.com", "10.0.0.1:443")
+//	router.HandleALPN("*.internal.example.com", "grpc-exp", "10.0.0.2:443")
+//	router.HandleFunc("public.example.com", func(conn *ech.Conn) {
+//		// Terminate the TLS connection here.
+//	})
+//	...
+//	conn, err := ech.NewConn(ctx, serverConn, ech.WithKeys(keys))
+//	if err != nil {
+//		// ...
+//	}
+//	err := router.Serve(ctx, conn)
+type Router struct {
+	mu     sync.RWMutex
+	routes map[string]*routeSet
+}
+
+type routeSet struct {
+	def  *route
+	alpn map[string]route
+}
+
+type route struct {
+	backend string
+	handler func(*Conn)
+}
+
+// Handle registers a backend address for the ServerName pattern. The
+// connections that match the pattern are forwarded to backend with
+// [Conn.ProxyTo].
+func (r *Router) Handle(pattern, backend string) {
+	r.add(pattern, "", route{backend: backend})
+}
+
+// HandleFunc registers a handler function for the ServerName pattern. The
+// handler takes ownership of the connections that match the pattern.
+func (r *Router) HandleFunc(pattern string, handler func(*Conn)) {
+	if handler == nil {
+		panic("ech: nil handler")
+	}
+	r.add(pattern, "", route{handler: handler})
+}
+
+// HandleALPN registers a backend address for the ServerName pattern and ALPN
+// protocol. The connections that match the pattern and offer the ALPN protocol
+// are forwarded to backend with [Conn.ProxyTo].
+func (r *Router) HandleALPN(pattern, alpn, backend string) {
+	if alpn == "" {
+		panic("ech: empty alpn")
+	}
+	r.add(pattern, alpn, route{backend: backend})
+}
+
+// HandleALPNFunc registers a handler function for the ServerName pattern and
+// ALPN protocol. The handler takes ownership of the connections that match the
+// pattern and offer the ALPN protocol.
+func (r *Router) HandleALPNFunc(pattern, alpn string, handler func(*Conn)) {
+	if alpn == "" {
+		panic("ech: empty alpn")
+	}
+	if handler == nil {
+		panic("ech: nil handler")
+	}
+	r.add(pattern, alpn, route{handler: handler})
+}
+
+func (r *Router) add(pattern, alpn string, rt route) {
+	p := normalizeServerName(pattern)
+	if !validPattern(p) {
+		panic(fmt.Sprintf("ech: invalid pattern %q", pattern))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes == nil {
+		r.routes = make(map[string]*routeSet)
+	}
+	rs, exists := r.routes[p]
+	if !exists {
+		rs = &routeSet{}
+		r.routes[p] = rs
+	}
+	if alpn == "" {
+		rs.def = &rt
+		return
+	}
+	if rs.alpn == nil {
+		rs.alpn = make(map[string]route)
+	}
+	rs.alpn[alpn] = rt
+}
+
+// Serve routes conn to the backend server or handler function registered for
+// its ServerName and ALPN protocols. When no route matches, an
+// unrecognized_name alert is sent to the client, conn is closed, and an
+// [AlertError] that wraps [ErrNoRoute] is returned.
+//
+// The ctx is used while connecting to a backend server only.
+func (r *Router) Serve(ctx context.Context, conn *Conn) error {
+	rt, ok := r.match(conn.ServerName(), conn.ALPNProtos())
+	if !ok {
+		return abort(conn.Conn, conn.alertFunc, fmt.Errorf("%w for %q", ErrNoRoute, conn.ServerName()))
+	}
+	if rt.handler != nil {
+		rt.handler(conn)
+		return nil
+	}
+	return conn.ProxyTo(ctx, rt.backend)
+}
+
+// Backend returns the backend address of the route that matches serverName
+// and the client's ALPN protocols, e.g. to forward connections that aren't
+// [Conn] connections, like QUIC connections. It returns an error that wraps
+// [ErrNoRoute] when no route matches, or when the matching route has a handler
+// function instead of a backend address.
+func (r *Router) Backend(serverName string, alpn []string) (string, error) {
+	rt, ok := r.match(serverName, alpn)
+	if !ok || rt.handler != nil {
+		return "", fmt.Errorf("%w for %q", ErrNoRoute, serverName)
+	}
+	return rt.backend, nil
+}
+
+func (r *Router) match(serverName string, alpn []string) (route, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for p := range namePatterns(normalizeServerName(serverName)) {
+		rs, exists := r.routes[p]
+		if !exists {
+			continue
+		}
+		for _, proto := range alpn {
+			if rt, ok := rs.alpn[proto]; ok {
+				return rt, true
+			}
+		}
+		if rs.def != nil {
+			return *rs.def, true
+		}
+	}
+	return route{}, false
+}
+
+// validPattern returns whether the normalized pattern p is valid.
+func validPattern(p string) bool {
+	return p != "" && p != "." && p != "*." && (!strings.Contains(p, "*") || p == "*" || strings.HasPrefix(p, "*.")) && strings.Count(p, "*") <= 1
+}
+
+// namePatterns returns the patterns that match name in order of precedence.
+func namePatterns(name string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if name != "" {
+			if !yield(name) {
+				return
+			}
+			if _, parent, ok := strings.Cut(name, "."); ok {
+				if !yield("*." + parent) {
+					return
+				}
+				for ok {
+					if !yield("." + parent) {
+						return
+					}
+					_, parent, ok = strings.Cut(parent, ".")
+				}
+			}
+		}
+		yield("*")
+	}
+}
+
+func normalizeServerName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
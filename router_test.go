@@ -0,0 +1,213 @@
+package ech
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+func TestRouterMatch(t *testing.T) {
+	router := NewRouter()
+	router.Handle("www.example.com", "exact")
+	router.Handle("*.example.com", "wildcard")
+	router.Handle(".example.com", "suffix")
+	router.Handle(".b.example.com", "longer suffix")
+	router.Handle("*", "default")
+
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"www.example.com", "exact"},
+		{"WWW.Example.COM.", "exact"},
+		{"foo.example.com", "wildcard"},
+		{"a.foo.example.com", "suffix"},
+		{"a.b.example.com", "longer suffix"},
+		{"b.example.com", "wildcard"},
+		{"example.com", "default"},
+		{"example.org", "default"},
+		{"", "default"},
+	} {
+		rt, ok := router.match(tc.name, nil)
+		if !ok {
+			t.Errorf("match(%q) failed", tc.name)
+			continue
+		}
+		if got := rt.backend; got != tc.want {
+			t.Errorf("match(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	router = NewRouter()
+	router.Handle("*.example.com", "wildcard")
+	if _, ok := router.match("example.com", nil); ok {
+		t.Errorf("match(%q) succeeded unexpectedly", "example.com")
+	}
+}
+
+func TestRouterMatchALPN(t *testing.T) {
+	router := NewRouter()
+	router.Handle("www.example.com", "www")
+	router.HandleALPN("www.example.com", "h2", "www h2")
+	router.HandleALPN("www.example.com", "foo", "www foo")
+	router.HandleALPN("*.example.com", "ssh", "wildcard ssh")
+	router.HandleALPN("*", "h2", "default h2")
+	router.Handle("*", "default")
+
+	for _, tc := range []struct {
+		name string
+		alpn []string
+		want string
+	}{
+		{"www.example.com", nil, "www"},
+		{"www.example.com", []string{"h2", "http/1.1"}, "www h2"},
+		{"www.example.com", []string{"foo", "h2"}, "www foo"},
+		{"www.example.com", []string{"http/1.1"}, "www"},
+		{"www.example.com", []string{"ssh"}, "www"},
+		{"foo.example.com", []string{"ssh"}, "wildcard ssh"},
+		{"foo.example.com", []string{"h2"}, "default h2"},
+		{"foo.example.com", []string{"http/1.1"}, "default"},
+	} {
+		rt, ok := router.match(tc.name, tc.alpn)
+		if !ok {
+			t.Errorf("match(%q, %q) failed", tc.name, tc.alpn)
+			continue
+		}
+		if got := rt.backend; got != tc.want {
+			t.Errorf("match(%q, %q) = %q, want %q", tc.name, tc.alpn, got, tc.want)
+		}
+	}
+}
+
+func TestRouterBackend(t *testing.T) {
+	router := NewRouter()
+	router.Handle("*.example.com", "wildcard")
+	router.HandleALPN("*.example.com", "h3", "wildcard h3")
+	router.HandleFunc("public.example.com", func(*Conn) {})
+
+	for _, tc := range []struct {
+		name    string
+		alpn    []string
+		want    string
+		wantErr error
+	}{
+		{"www.example.com", nil, "wildcard", nil},
+		{"www.example.com", []string{"h3"}, "wildcard h3", nil},
+		{"public.example.com", nil, "", ErrNoRoute},
+		{"example.com", nil, "", ErrNoRoute},
+	} {
+		got, err := router.Backend(tc.name, tc.alpn)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("Backend(%q, %q) = %v, want %v", tc.name, tc.alpn, err, tc.wantErr)
+		}
+		if got != tc.want {
+			t.Errorf("Backend(%q, %q) = %q, want %q", tc.name, tc.alpn, got, tc.want)
+		}
+	}
+}
+
+func TestRouterServe(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ConfigList([]Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("public.example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	backendLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer backendLn.Close()
+	go func() {
+		for {
+			conn, err := backendLn.Accept()
+			if err != nil {
+				return
+			}
+			server := tls.Server(conn, &tls.Config{
+				Certificates: []tls.Certificate{tlsCert},
+			})
+			server.Write([]byte("Hello from backend\n"))
+			server.Close()
+		}
+	}()
+
+	router := NewRouter()
+	router.Handle("*.example.com", backendLn.Addr().String())
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	errCh := make(chan error)
+	go func() {
+		for {
+			serverConn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn, err := NewConn(t.Context(), serverConn, WithKeys([]Key{{
+					Config:      config,
+					PrivateKey:  privKey.Bytes(),
+					SendAsRetry: true,
+				}}))
+				if err != nil {
+					errCh <- err
+					return
+				}
+				errCh <- router.Serve(t.Context(), conn)
+			}()
+		}
+	}()
+
+	for _, tc := range []struct {
+		serverName string
+		wantErr    error
+	}{
+		{"private.example.com", nil},
+		{"private.example.org", ErrNoRoute},
+	} {
+		client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+			ServerName:                     tc.serverName,
+			RootCAs:                        rootCAs,
+			EncryptedClientHelloConfigList: configList,
+		})
+		if tc.wantErr == nil {
+			if err != nil {
+				t.Fatalf("[%s] tls.Dial: %v", tc.serverName, err)
+			}
+			b, err := io.ReadAll(client)
+			if err != nil {
+				t.Fatalf("[%s] ReadAll: %v", tc.serverName, err)
+			}
+			if got, want := string(b), "Hello from backend\n"; got != want {
+				t.Errorf("[%s] Got %q, want %q", tc.serverName, got, want)
+			}
+			if !client.ConnectionState().ECHAccepted {
+				t.Errorf("[%s] ECHAccepted is false", tc.serverName)
+			}
+			client.Close()
+		} else if err == nil {
+			t.Errorf("[%s] tls.Dial succeeded unexpectedly", tc.serverName)
+		}
+		if err := <-errCh; !errors.Is(err, tc.wantErr) {
+			t.Errorf("[%s] Serve: %v, want %v", tc.serverName, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build js
+
+package ech
+
+// haveSockets is false on js/wasm, where the network is only accessible with
+// the Fetch API. The connections must be established with a custom
+// Dialer.DialFunc, e.g. over a WebSocket tunnel.
+const haveSockets = false
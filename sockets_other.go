@@ -0,0 +1,5 @@
+//go:build !js
+
+package ech
+
+const haveSockets = true
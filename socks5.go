@@ -0,0 +1,180 @@
+package ech
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+var _ Proxy = (*socks5Proxy)(nil)
+
+// NewSOCKS5Proxy returns a [Proxy] that connects through the SOCKS5 proxy
+// server at addr, as specified in RFC 1928. If username isn't empty, the
+// Username/Password authentication method, specified in RFC 1929, is used.
+//
+// The proxy server receives the IP address of the remote server, not its
+// name. Name resolution is still done by the [Dialer]'s [Resolver], which
+// also retrieves the Encrypted Client Hello (ECH) Config List.
+func NewSOCKS5Proxy(addr, username, password string) Proxy {
+	return &socks5Proxy{
+		addr:     addr,
+		username: username,
+		password: password,
+	}
+}
+
+type socks5Proxy struct {
+	addr     string
+	username string
+	password string
+	dialer   net.Dialer
+}
+
+// socks5Replies are the values of the REP field in RFC 1928 Section 6.
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// DialContext connects to addr through the SOCKS5 proxy. Only TCP is
+// supported.
+func (p *socks5Proxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("socks5: unsupported network %q", network)
+	}
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	stop := withContextDeadline(ctx, conn)
+	err = p.connect(conn, addr)
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, fmt.Errorf("socks5: %w", err)
+	}
+	return conn, nil
+}
+
+func (p *socks5Proxy) connect(conn net.Conn, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	// RFC 1928 Section 3: Version identifier/method selection message.
+	methods := []byte{0x00} // NO AUTHENTICATION REQUIRED
+	if p.username != "" {
+		methods = []byte{0x02} // USERNAME/PASSWORD
+	}
+	if _, err := conn.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != 5 {
+		return fmt.Errorf("unexpected version %d", buf[0])
+	}
+	switch buf[1] {
+	case 0x00:
+	case 0x02:
+		if p.username == "" {
+			return errors.New("unexpected authentication method")
+		}
+		// RFC 1929 Section 2.
+		if len(p.username) > 255 || len(p.password) > 255 {
+			return errors.New("username or password too long")
+		}
+		req := []byte{1, byte(len(p.username))}
+		req = append(req, p.username...)
+		req = append(req, byte(len(p.password)))
+		req = append(req, p.password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0 {
+			return errors.New("authentication failed")
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	// RFC 1928 Section 4: Requests.
+	req := []byte{5, 1, 0} // VER, CMD=CONNECT, RSV
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ip = ip.Unmap()
+		if ip.Is4() {
+			req = append(req, 1) // IP V4 address
+		} else {
+			req = append(req, 4) // IP V6 address
+		}
+		req = append(req, ip.AsSlice()...)
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name too long")
+		}
+		req = append(req, 3, byte(len(host))) // DOMAINNAME
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// RFC 1928 Section 6: Replies.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[0] != 5 {
+		return fmt.Errorf("unexpected version %d", buf[0])
+	}
+	if buf[1] != 0 {
+		if msg, ok := socks5Replies[buf[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("request failed with code %d", buf[1])
+	}
+	var skip int
+	switch buf[3] {
+	case 1:
+		skip = 4
+	case 4:
+		skip = 16
+	case 3:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("unexpected address type %d", buf[3])
+	}
+	// BND.ADDR and BND.PORT are not used.
+	if _, err := io.CopyN(io.Discard, conn, int64(skip+2)); err != nil {
+		return err
+	}
+	return nil
+}
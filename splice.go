@@ -0,0 +1,123 @@
+package ech
+
+import (
+	"io"
+	"net"
+)
+
+var (
+	_ io.WriterTo   = (*Conn)(nil)
+	_ io.ReaderFrom = (*Conn)(nil)
+)
+
+// WithSplice lets the kernel copy the data, e.g. with splice(2), once the TLS
+// handshake messages are no longer inspected, when data is copied with
+// [io.Copy] between Conn and a TCP connection, e.g. with [Conn.ProxyTo]. This
+// avoids copying the data to and from user space.
+//
+// The fast path is only available on Linux. It is disabled when
+// [WithIdleTimeout] is set because the idle timeout can't be refreshed while
+// the kernel copies the data. The deadlines of [WithHandshakeTimeout] and
+// [WithMaxLifetime] are set before the kernel copies the data.
+func WithSplice() Option {
+	return func(c *Conn) {
+		c.splice = true
+	}
+}
+
+// WriteTo implements [io.WriterTo]. It copies data from the connection to w
+// until EOF is reached. Without [WithSplice], or with [WithIdleTimeout], it
+// behaves like [io.Copy] with a Conn that doesn't implement io.WriterTo.
+func (c *Conn) WriteTo(w io.Writer) (int64, error) {
+	if !c.canSplice() {
+		return io.Copy(w, struct{ io.Reader }{c})
+	}
+	var total int64
+	buf := make([]byte, 32*1024)
+	for {
+		if raw, ok := c.spliceReader(); ok {
+			if err := c.refreshDeadlines(); err != nil {
+				return total, err
+			}
+			n, err := io.Copy(w, raw)
+			c.bytesRead.Add(n)
+			return total + n, err
+		}
+		n, err := c.Read(buf)
+		if n > 0 {
+			nw, werr := w.Write(buf[:n])
+			total += int64(nw)
+			if werr != nil {
+				return total, werr
+			}
+			if nw != n {
+				return total, io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// ReadFrom implements [io.ReaderFrom]. It copies data from r to the connection
+// until EOF is reached. Without [WithSplice], or with [WithIdleTimeout], it
+// behaves like [io.Copy] with a Conn that doesn't implement io.ReaderFrom.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if !c.canSplice() {
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+	var total int64
+	buf := make([]byte, 32*1024)
+	for {
+		if raw, ok := c.spliceWriter(); ok {
+			if err := c.refreshDeadlines(); err != nil {
+				return total, err
+			}
+			n, err := io.Copy(raw, r)
+			c.bytesWritten.Add(n)
+			return total + n, err
+		}
+		n, err := r.Read(buf)
+		if n > 0 {
+			nw, werr := c.Write(buf[:n])
+			total += int64(nw)
+			if werr != nil {
+				return total, werr
+			}
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// canSplice returns whether the kernel may copy the data once the handshake
+// messages are no longer inspected.
+func (c *Conn) canSplice() bool {
+	return c.splice && c.idleTimeout == 0
+}
+
+// spliceReader returns the underlying connection when it can be read from
+// directly by the kernel.
+func (c *Conn) spliceReader() (net.Conn, bool) {
+	if !c.canSplice() || !c.readPassthrough || len(c.readBuf) > 0 || c.readRemaining > 0 || c.readErr != nil {
+		return nil, false
+	}
+	return spliceConn(c.Conn)
+}
+
+// spliceWriter returns the underlying connection when it can be written to
+// directly by the kernel.
+func (c *Conn) spliceWriter() (net.Conn, bool) {
+	if !c.canSplice() || !c.writePassthrough || len(c.writeBuf) > 0 {
+		return nil, false
+	}
+	return spliceConn(c.Conn)
+}
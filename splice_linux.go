@@ -0,0 +1,15 @@
+//go:build linux
+
+package ech
+
+import "net"
+
+// spliceConn returns conn if the kernel can copy data to or from it with
+// splice(2).
+func spliceConn(conn net.Conn) (net.Conn, bool) {
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return conn, true
+	}
+	return nil, false
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package ech
+
+import "net"
+
+// spliceConn always returns false. The kernel copy fast path is only
+// available on Linux.
+func spliceConn(net.Conn) (net.Conn, bool) {
+	return nil, false
+}
@@ -0,0 +1,97 @@
+package ech
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+// TestSplice verifies that data is copied correctly in both directions with
+// WithSplice.
+func TestSplice(t *testing.T) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ConfigList([]Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	tlsCert, err := testutil.NewCert("private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	backendLn, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer backendLn.Close()
+	go func() {
+		conn, err := backendLn.Accept()
+		if err != nil {
+			return
+		}
+		server := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+		})
+		io.Copy(server, server)
+		server.Close()
+	}()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+	statsCh := make(chan ConnStats, 1)
+	go func() {
+		serverConn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn, err := NewConn(t.Context(), serverConn, WithKeys([]Key{{Config: config, PrivateKey: privKey.Bytes()}}), WithSplice())
+		if err != nil {
+			t.Errorf("NewConn: %v", err)
+			close(statsCh)
+			return
+		}
+		if err := conn.ProxyTo(t.Context(), backendLn.Addr().String()); err != nil {
+			t.Errorf("ProxyTo: %v", err)
+		}
+		statsCh <- conn.Stats()
+	}()
+
+	client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{
+		ServerName:                     "private.example.com",
+		RootCAs:                        rootCAs,
+		EncryptedClientHelloConfigList: configList,
+	})
+	if err != nil {
+		t.Fatalf("tls.Dial: %v", err)
+	}
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	go func() {
+		client.Write(payload)
+		client.CloseWrite()
+	}()
+	got, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	client.Close()
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("Received %d bytes, want %d", len(got), len(payload))
+	}
+	stats := <-statsCh
+	if stats.BytesRead < int64(len(payload)) || stats.BytesWritten < int64(len(payload)) {
+		t.Errorf("Stats() bytes = %d, %d, want >= %d", stats.BytesRead, stats.BytesWritten, len(payload))
+	}
+}
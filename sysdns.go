@@ -0,0 +1,148 @@
+package ech
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// errNoSystemNameServers is returned when the system doesn't have any name
+// server configured.
+var errNoSystemNameServers = errors.New("no name server configured")
+
+// resolvConfPath is the path of the resolver configuration file on Unix
+// systems. It is set by tests.
+var resolvConfPath = "/etc/resolv.conf"
+
+// SystemNameServers returns the addresses of the name servers that are
+// configured on the system, in order of preference. They come from
+// /etc/resolv.conf on Linux and the other Unix systems, from the
+// SystemConfiguration framework on macOS, and from the network adapters that
+// are up on Windows.
+//
+// These name servers usually come from DHCP, and are queried without
+// encryption. They should only be used to bootstrap an encrypted resolver.
+func SystemNameServers() ([]netip.AddrPort, error) {
+	servers, err := systemNameServers()
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, errNoSystemNameServers
+	}
+	return servers, nil
+}
+
+// NewSystemResolver returns a bootstrap Resolver, see [NewBootstrapResolver],
+// that uses the first name server returned by [SystemNameServers].
+// [Transport] upgrades it automatically to the encrypted resolver that the
+// name server designates, if any.
+func NewSystemResolver() (*Resolver, error) {
+	servers, err := SystemNameServers()
+	if err != nil {
+		return nil, err
+	}
+	return NewBootstrapResolver(servers[0].String())
+}
+
+// readResolvConf returns the name servers in the resolver configuration file.
+func readResolvConf() ([]netip.AddrPort, error) {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseResolvConf(f)
+}
+
+// parseResolvConf parses the nameserver lines of a resolv.conf file.
+func parseResolvConf(r io.Reader) ([]netip.AddrPort, error) {
+	var servers []netip.AddrPort
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		addr, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		servers = append(servers, netip.AddrPortFrom(addr, 53))
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", resolvConfPath, err)
+	}
+	return servers, nil
+}
+
+// parseScutilDNS parses the output of macOS's scutil --dns command, and
+// returns the name servers of the default resolvers, i.e. the resolvers that
+// aren't specific to a domain. The scoped resolvers are ignored.
+//
+//	DNS configuration
+//
+//	resolver #1
+//	  search domain[0] : example.com
+//	  nameserver[0] : 192.168.1.1
+//	  nameserver[1] : fe80::1%en0
+//	  ...
+//
+//	resolver #2
+//	  domain   : local
+//	  ...
+//
+//	DNS configuration (for scoped queries)
+//	...
+func parseScutilDNS(r io.Reader) ([]netip.AddrPort, error) {
+	var servers []netip.AddrPort
+	var current []netip.AddrPort
+	var port uint16 = 53
+	specific := false
+	flush := func() {
+		if !specific {
+			for _, s := range current {
+				servers = append(servers, netip.AddrPortFrom(s.Addr(), port))
+			}
+		}
+		current, port, specific = nil, 53, false
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "DNS configuration (") {
+			break
+		}
+		if strings.HasPrefix(line, "resolver #") {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case key == "domain":
+			specific = true
+		case key == "port":
+			var p uint16
+			if _, err := fmt.Sscanf(value, "%d", &p); err == nil {
+				port = p
+			}
+		case strings.HasPrefix(key, "nameserver["):
+			if addr, err := netip.ParseAddr(value); err == nil {
+				current = append(current, netip.AddrPortFrom(addr, 0))
+			}
+		}
+	}
+	flush()
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
@@ -0,0 +1,22 @@
+//go:build darwin
+
+package ech
+
+import (
+	"bytes"
+	"net/netip"
+	"os/exec"
+)
+
+// systemNameServers returns the name servers from the SystemConfiguration
+// framework, which is queried with scutil to avoid cgo. /etc/resolv.conf is
+// used when scutil fails. It only has the default resolvers.
+func systemNameServers() ([]netip.AddrPort, error) {
+	out, err := exec.Command("/usr/sbin/scutil", "--dns").Output()
+	if err == nil {
+		if servers, err := parseScutilDNS(bytes.NewReader(out)); err == nil && len(servers) > 0 {
+			return servers, nil
+		}
+	}
+	return readResolvConf()
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package ech
+
+import (
+	"errors"
+	"net/netip"
+)
+
+func systemNameServers() ([]netip.AddrPort, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,103 @@
+package ech
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	conf := `# Generated by NetworkManager
+search example.com
+nameserver 192.0.2.53
+nameserver 2001:db8::53 # comment
+nameserver not-an-address
+options edns0
+`
+	got, err := parseResolvConf(strings.NewReader(conf))
+	if err != nil {
+		t.Fatalf("parseResolvConf: %v", err)
+	}
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("192.0.2.53:53"),
+		netip.MustParseAddrPort("[2001:db8::53]:53"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseResolvConf() = %v, want %v", got, want)
+	}
+}
+
+func TestParseScutilDNS(t *testing.T) {
+	out := `DNS configuration
+
+resolver #1
+  search domain[0] : example.com
+  nameserver[0] : 192.168.1.1
+  nameserver[1] : fe80::1%en0
+  if_index : 6 (en0)
+  flags    : Request A records, Request AAAA records
+  reach    : 0x00020002 (Reachable,Directly Reachable Address)
+
+resolver #2
+  domain   : local
+  options  : mdns
+  timeout  : 5
+  flags    : Request A records, Request AAAA records
+  reach    : 0x00000000 (Not Reachable)
+  order    : 300000
+
+resolver #3
+  nameserver[0] : 192.0.2.53
+  port     : 5353
+
+DNS configuration (for scoped queries)
+
+resolver #1
+  search domain[0] : example.com
+  nameserver[0] : 192.168.1.2
+  if_index : 6 (en0)
+`
+	got, err := parseScutilDNS(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("parseScutilDNS: %v", err)
+	}
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("192.168.1.1:53"),
+		netip.MustParseAddrPort("[fe80::1%en0]:53"),
+		netip.MustParseAddrPort("192.0.2.53:5353"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("parseScutilDNS() = %v, want %v", got, want)
+	}
+}
+
+func TestSystemNameServers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("resolv.conf is only used on linux")
+	}
+	dir := t.TempDir()
+	defer func(p string) { resolvConfPath = p }(resolvConfPath)
+	resolvConfPath = filepath.Join(dir, "resolv.conf")
+
+	if err := os.WriteFile(resolvConfPath, []byte("search example.com\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := SystemNameServers(); err == nil {
+		t.Fatal("SystemNameServers() succeeded without name servers")
+	}
+
+	if err := os.WriteFile(resolvConfPath, []byte("nameserver 192.0.2.53\nnameserver 192.0.2.54\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	resolver, err := NewSystemResolver()
+	if err != nil {
+		t.Fatalf("NewSystemResolver: %v", err)
+	}
+	if got, want := resolver.bootstrapAddr, "192.0.2.53:53"; got != want {
+		t.Errorf("bootstrapAddr = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build unix && !darwin
+
+package ech
+
+import "net/netip"
+
+func systemNameServers() ([]netip.AddrPort, error) {
+	return readResolvConf()
+}
@@ -0,0 +1,74 @@
+//go:build windows
+
+package ech
+
+import (
+	"errors"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// systemNameServers returns the name servers of the network adapters that are
+// up and have a gateway, like the net package does.
+func systemNameServers() ([]netip.AddrPort, error) {
+	aas, err := adapterAddresses()
+	if err != nil {
+		return nil, err
+	}
+	var servers []netip.AddrPort
+	for _, aa := range aas {
+		if aa.OperStatus != windows.IfOperStatusUp || aa.FirstGatewayAddress == nil {
+			continue
+		}
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			sa, err := dns.Address.Sockaddr.Sockaddr()
+			if err != nil {
+				continue
+			}
+			var addr netip.Addr
+			switch sa := sa.(type) {
+			case *syscall.SockaddrInet4:
+				addr = netip.AddrFrom4(sa.Addr)
+			case *syscall.SockaddrInet6:
+				addr = netip.AddrFrom16(sa.Addr)
+				// fec0::/10 are the deprecated site local
+				// anycast addresses that Windows sets by default.
+				if sa.Addr[0] == 0xfe && sa.Addr[1]&0xc0 == 0xc0 {
+					continue
+				}
+			default:
+				continue
+			}
+			servers = append(servers, netip.AddrPortFrom(addr, 53))
+		}
+	}
+	return servers, nil
+}
+
+func adapterAddresses() ([]*windows.IpAdapterAddresses, error) {
+	var b []byte
+	l := uint32(15000) // recommended initial size
+	for {
+		b = make([]byte, l)
+		const flags = windows.GAA_FLAG_INCLUDE_PREFIX | windows.GAA_FLAG_INCLUDE_GATEWAYS
+		err := windows.GetAdaptersAddresses(syscall.AF_UNSPEC, flags, 0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])), &l)
+		if err == nil {
+			if l == 0 {
+				return nil, nil
+			}
+			break
+		}
+		if !errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) || l <= uint32(len(b)) {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+	}
+	var aas []*windows.IpAdapterAddresses
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])); aa != nil; aa = aa.Next {
+		aas = append(aas, aa)
+	}
+	return aas, nil
+}
@@ -0,0 +1,61 @@
+package ech
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPOptions contains socket options for the TCP connections established by
+// [NewDialer] and [NewTransport]. They are not used when the connections go
+// through a [Proxy].
+type TCPOptions struct {
+	// KeepAlive configures the TCP keep-alive probes. Long-lived
+	// connections through NATs typically need a shorter Idle and Interval
+	// than the defaults.
+	KeepAlive net.KeepAliveConfig
+	// DisableNoDelay enables Nagle's algorithm, i.e. it clears
+	// TCP_NODELAY, which Go sets by default.
+	DisableNoDelay bool
+	// UserTimeout is the maximum amount of time that transmitted data may
+	// remain unacknowledged before the connection is closed, i.e.
+	// TCP_USER_TIMEOUT. It is only supported on Linux, and ignored on
+	// other platforms.
+	UserTimeout time.Duration
+	// Control, if set, is called after creating the socket and before
+	// connecting. See [net.Dialer.Control].
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+// netDialer returns a copy of d with the options applied. It returns d if o is
+// nil.
+func (o *TCPOptions) netDialer(d *net.Dialer) *net.Dialer {
+	if o == nil {
+		return d
+	}
+	nd := *d
+	nd.KeepAliveConfig = o.KeepAlive
+	nd.Control = func(network, address string, c syscall.RawConn) error {
+		if o.UserTimeout > 0 {
+			if err := setUserTimeout(c, o.UserTimeout); err != nil {
+				return err
+			}
+		}
+		if o.Control != nil {
+			return o.Control(network, address, c)
+		}
+		return nil
+	}
+	return &nd
+}
+
+// applyConn sets the options that can only be set after connecting.
+func (o *TCPOptions) applyConn(conn net.Conn) error {
+	if o == nil || !o.DisableNoDelay {
+		return nil
+	}
+	if c, ok := conn.(*net.TCPConn); ok {
+		return c.SetNoDelay(false)
+	}
+	return nil
+}
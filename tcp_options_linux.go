@@ -0,0 +1,22 @@
+//go:build linux
+
+package ech
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT from linux/tcp.h. It is missing from
+// package syscall on some architectures.
+const tcpUserTimeout = 0x12
+
+func setUserTimeout(c syscall.RawConn, d time.Duration) error {
+	var serr error
+	if err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(d.Milliseconds()))
+	}); err != nil {
+		return err
+	}
+	return serr
+}
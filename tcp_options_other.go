@@ -0,0 +1,12 @@
+//go:build !linux
+
+package ech
+
+import (
+	"syscall"
+	"time"
+)
+
+func setUserTimeout(c syscall.RawConn, d time.Duration) error {
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
+	"net"
 	"time"
 )
 
@@ -25,7 +26,13 @@ func NewCert(names ...string) (tls.Certificate, error) {
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
-		DNSNames:              names,
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			templ.IPAddresses = append(templ.IPAddresses, ip)
+			continue
+		}
+		templ.DNSNames = append(templ.DNSNames, name)
 	}
 	b, err := x509.CreateCertificate(rand.Reader, templ, templ, key.Public(), key)
 	if err != nil {
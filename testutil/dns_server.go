@@ -1,15 +1,104 @@
 package testutil
 
 import (
+	"crypto/tls"
+	"encoding/binary"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/c2FmZQ/ech/dns"
 )
 
-func StartTestDNSServer(t *testing.T, db []dns.RR) *httptest.Server {
+// Option configures the behavior of the test DNS servers.
+type Option func(*serverOptions)
+
+type serverOptions struct {
+	latency  time.Duration
+	rcodes   map[string]uint8
+	soa      *dns.RR
+	truncate int
+}
+
+// WithLatency delays every response by d, e.g. to test timeouts.
+func WithLatency(d time.Duration) Option {
+	return func(o *serverOptions) {
+		o.latency = d
+	}
+}
+
+// WithRCode makes the server respond to all the queries for name with rcode,
+// e.g. 2 (SERVFAIL) or 5 (REFUSED), and no records.
+func WithRCode(name string, rcode uint8) Option {
+	return func(o *serverOptions) {
+		if o.rcodes == nil {
+			o.rcodes = make(map[string]uint8)
+		}
+		o.rcodes[name] = rcode
+	}
+}
+
+// WithNXDOMAIN makes the server respond with NXDOMAIN (3) to the queries for
+// the names that don't have any records in db. The soa record is added to the
+// authority section of the NXDOMAIN responses, and of the NODATA responses,
+// i.e. responses without any records for a name that exists, as described in
+// RFC 2308. Without this option, all the names exist.
+func WithNXDOMAIN(soa dns.RR) Option {
+	return func(o *serverOptions) {
+		o.soa = &soa
+	}
+}
+
+// WithTruncation makes the server truncate the responses that are larger than
+// size bytes over UDP. The records are removed, and the TC bit is set, so that
+// the clients retry over TCP.
+func WithTruncation(size int) Option {
+	return func(o *serverOptions) {
+		o.truncate = size
+	}
+}
+
+// SOA returns a SOA record for zone, with a minimum TTL of ttl seconds.
+func SOA(zone string, ttl uint32) dns.RR {
+	return dns.RR{
+		Name: zone, Type: 6, Class: 1, TTL: ttl,
+		Data: dns.SOA{
+			MName:   "ns." + zone,
+			RName:   "hostmaster." + zone,
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			Minimum: ttl,
+		},
+	}
+}
+
+// HTTPSAlias returns an AliasMode HTTPS record for name that points to target.
+// RFC 9460 Section 2.4.2
+func HTTPSAlias(name, target string) dns.RR {
+	return dns.RR{
+		Name: name, Type: 65, Class: 1, TTL: 60,
+		Data: dns.HTTPS{Priority: 0, Target: target},
+	}
+}
+
+func newServerOptions(opts []Option) *serverOptions {
+	o := &serverOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// StartTestDNSServer starts a DNS-over-HTTPS server that answers the queries
+// from the records in db. The server must be closed by the caller.
+func StartTestDNSServer(t *testing.T, db []dns.RR, opts ...Option) *httptest.Server {
+	o := newServerOptions(opts)
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
 		body, _ := io.ReadAll(req.Body)
@@ -18,26 +107,200 @@ func StartTestDNSServer(t *testing.T, db []dns.RR) *httptest.Server {
 			t.Errorf("dns.DecodeMessage: %v", err)
 			return
 		}
-		qq.QR = 1
-		want := qq.Question[0].Name
-		for i := 0; i < len(db); i++ {
-			rr := db[i]
-			if want != rr.Name {
-				continue
+		w.Write(o.answer(t, db, qq).Bytes())
+	}))
+}
+
+// StartTestDo53Server starts an unencrypted DNS server on UDP and TCP, and
+// returns its address. The server is stopped when the test ends.
+func StartTestDo53Server(t *testing.T, db []dns.RR, opts ...Option) string {
+	o := newServerOptions(opts)
+	// The UDP and TCP servers use the same port number.
+	var conn net.PacketConn
+	var ln net.Listener
+	for range 10 {
+		var err error
+		if ln, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			t.Fatalf("net.Listen: %v", err)
+		}
+		if conn, err = net.ListenPacket("udp", ln.Addr().String()); err == nil {
+			break
+		}
+		ln.Close()
+		ln = nil
+	}
+	if ln == nil {
+		t.Fatal("net.ListenPacket: no port available")
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		ln.Close()
+	})
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
 			}
-			if rr.Type == 5 { // CNAME
-				qq.Answer = append(qq.Answer, rr)
-				want = rr.Data.(string)
-				i = -1
+			qq, err := dns.DecodeMessage(buf[:n])
+			if err != nil {
+				t.Errorf("dns.DecodeMessage: %v", err)
 				continue
 			}
-			if qq.Question[0].Type == rr.Type {
-				qq.Answer = append(qq.Answer, rr)
+			m := o.answer(t, db, qq)
+			b := m.Bytes()
+			if o.truncate > 0 && len(b) > o.truncate {
+				m.TC = 1
+				m.Answer, m.Authority, m.Additional = nil, nil, nil
+				b = m.Bytes()
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+	serveStream(t, ln, db, o)
+	return conn.LocalAddr().String()
+}
+
+// StartTestDoTServer starts a DNS-over-TLS server with cert and returns its
+// address. The server is stopped when the test ends.
+func StartTestDoTServer(t *testing.T, db []dns.RR, cert tls.Certificate, opts ...Option) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	serveStream(t, ln, db, newServerOptions(opts))
+	return ln.Addr().String()
+}
+
+// serveStream answers the queries received on the connections accepted by ln.
+// The messages are prefixed with their length, as on TCP.
+func serveStream(t *testing.T, ln net.Listener, db []dns.RR, o *serverOptions) {
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					var sz [2]byte
+					if _, err := io.ReadFull(conn, sz[:]); err != nil {
+						return
+					}
+					buf := make([]byte, binary.BigEndian.Uint16(sz[:]))
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					qq, err := dns.DecodeMessage(buf)
+					if err != nil {
+						t.Errorf("dns.DecodeMessage: %v", err)
+						return
+					}
+					b := o.answer(t, db, qq).Bytes()
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(b))), b...))
+				}
+			}()
+		}
+	}()
+}
+
+// Answer returns the response to the query qq from the records in db.
+func Answer(t *testing.T, db []dns.RR, qq *dns.Message) *dns.Message {
+	return (&serverOptions{}).answer(t, db, qq)
+}
+
+// maxChain is the maximum number of CNAME or AliasMode records that are
+// followed in a response, so that loops can be served.
+const maxChain = 8
+
+func (o *serverOptions) answer(t *testing.T, db []dns.RR, qq *dns.Message) *dns.Message {
+	if o.latency > 0 {
+		time.Sleep(o.latency)
+	}
+	qq.QR = 1
+	q := qq.Question[0]
+	if rc, ok := o.rcodes[q.Name]; ok {
+		qq.RCode = rc
+		t.Logf("QQ %#v RCODE %d", qq.Question, rc)
+		return qq
+	}
+	want := q.Name
+	seen := make(map[string]bool)
+	exists := false
+	for i := 0; i < len(db); i++ {
+		rr := db[i]
+		if want != rr.Name {
+			continue
+		}
+		exists = true
+		if rr.Type == 5 { // CNAME
+			qq.Answer = append(qq.Answer, rr)
+			if seen[want] || len(seen) >= maxChain {
+				break
+			}
+			seen[want] = true
+			want = rr.Data.(string)
+			i = -1
+			continue
+		}
+		if q.Type == rr.Type {
+			qq.Answer = append(qq.Answer, rr)
+			continue
+		}
+	}
+	// The records of the AliasMode targets are added to the additional
+	// section, like some recursive resolvers do. The clients must still
+	// follow the aliases themselves.
+	if q.Type == 64 || q.Type == 65 {
+		o.addAliasTargets(db, qq, q.Type)
+	}
+	if o.soa != nil && len(qq.Answer) == 0 {
+		if !exists {
+			qq.RCode = 3 // NXDOMAIN
+		}
+		soa := *o.soa
+		// RFC 2308 Section 5
+		soa.TTL = min(soa.TTL, soa.Data.(dns.SOA).Minimum)
+		qq.Authority = append(qq.Authority, soa)
+	}
+	t.Logf("QQ %#v", qq.Question)
+	t.Logf("AA %#v", qq.Answer)
+	return qq
+}
+
+// addAliasTargets adds the records of the AliasMode targets to the additional
+// section of qq.
+func (o *serverOptions) addAliasTargets(db []dns.RR, qq *dns.Message, typ uint16) {
+	seen := make(map[string]bool)
+	records := qq.Answer
+	for len(records) > 0 && len(seen) < maxChain {
+		var next []dns.RR
+		for _, rr := range records {
+			target, ok := aliasTarget(rr)
+			if !ok || target == "" || seen[target] {
 				continue
 			}
+			seen[target] = true
+			for _, r := range db {
+				if strings.EqualFold(r.Name, target) && (r.Type == typ || r.Type == 1 || r.Type == 28) {
+					qq.Additional = append(qq.Additional, r)
+					next = append(next, r)
+				}
+			}
 		}
-		t.Logf("QQ %#v", qq.Question)
-		t.Logf("AA %#v", qq.Answer)
-		w.Write(qq.Bytes())
-	}))
+		records = next
+	}
+}
+
+func aliasTarget(rr dns.RR) (string, bool) {
+	switch v := rr.Data.(type) {
+	case dns.HTTPS:
+		return v.Target, v.Priority == 0
+	case dns.SVCB:
+		return v.Target, v.Priority == 0
+	}
+	return "", false
 }
@@ -0,0 +1,175 @@
+// Package echtest provides an ECH-enabled TLS echo server for end-to-end
+// tests, similar to net/http/httptest.
+//
+//	srv := echtest.NewServer(t)
+//	conn, err := tls.Dial("tcp", srv.Addr, srv.ClientConfig("private.example.com"))
+//	...
+package echtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+)
+
+// Option configures the server.
+type Option func(*options)
+
+type options struct {
+	publicName  string
+	serverNames []string
+	connOpts    []ech.Option
+}
+
+// WithPublicName sets the public name of the ECH config. The default value is
+// "public.example.com".
+func WithPublicName(name string) Option {
+	return func(o *options) {
+		o.publicName = name
+	}
+}
+
+// WithServerNames sets the names of the private servers, which are added to
+// the server's certificate. The default value is "private.example.com".
+func WithServerNames(names ...string) Option {
+	return func(o *options) {
+		o.serverNames = names
+	}
+}
+
+// WithConnOptions sets additional options that are passed to [ech.NewConn]
+// with each connection.
+func WithConnOptions(opts ...ech.Option) Option {
+	return func(o *options) {
+		o.connOpts = append(o.connOpts, opts...)
+	}
+}
+
+// Server is a TLS server that manages ECH with [ech.Conn], and echoes back
+// everything that it receives on each connection.
+type Server struct {
+	// Addr is the address of the server, e.g. "127.0.0.1:1234".
+	Addr string
+	// PublicName is the public name of the ECH config.
+	PublicName string
+	// Keys are the server's ECH keys.
+	Keys []ech.Key
+	// ConfigList is the ECH config list that clients must use.
+	ConfigList []byte
+	// Certificate is the self-signed certificate of the server, for the
+	// public name and all the server names.
+	Certificate tls.Certificate
+	// RootCAs contains Certificate.
+	RootCAs *x509.CertPool
+
+	ln     net.Listener
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewServer starts a new server on a loopback address. The server is closed
+// when the test ends.
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+	o := &options{
+		publicName:  "public.example.com",
+		serverNames: []string{"private.example.com"},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	privKey, config, err := ech.NewConfig(1, []byte(o.publicName))
+	if err != nil {
+		t.Fatalf("ech.NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ech.ConfigList: %v", err)
+	}
+	cert, err := testutil.NewCert(append(o.serverNames, o.publicName)...)
+	if err != nil {
+		t.Fatalf("testutil.NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(cert.Leaf)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		Addr:       ln.Addr().String(),
+		PublicName: o.publicName,
+		Keys: []ech.Key{{
+			Config:      config,
+			PrivateKey:  privKey.Bytes(),
+			SendAsRetry: true,
+		}},
+		ConfigList:  configList,
+		Certificate: cert,
+		RootCAs:     rootCAs,
+		ln:          ln,
+		cancel:      cancel,
+	}
+	connOpts := append([]ech.Option{ech.WithKeys(s.Keys)}, o.connOpts...)
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer conn.Close()
+				context.AfterFunc(ctx, func() { conn.Close() })
+				echConn, err := ech.NewConn(ctx, conn, connOpts...)
+				if err != nil {
+					t.Logf("echtest: ech.NewConn: %v", err)
+					return
+				}
+				tlsConn := tls.Server(echConn, tlsConfig)
+				if err := tlsConn.HandshakeContext(ctx); err != nil {
+					t.Logf("echtest: handshake: %v", err)
+					return
+				}
+				io.Copy(tlsConn, tlsConn)
+			}()
+		}
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ClientConfig returns a TLS client config for serverName, with the server's
+// ECH config list and root CAs.
+func (s *Server) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:                     serverName,
+		RootCAs:                        s.RootCAs,
+		EncryptedClientHelloConfigList: s.ConfigList,
+	}
+}
+
+// Close stops the server, closes all its connections, and waits for them to
+// finish.
+func (s *Server) Close() {
+	s.cancel()
+	s.ln.Close()
+	s.wg.Wait()
+}
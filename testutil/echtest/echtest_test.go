@@ -0,0 +1,50 @@
+package echtest_test
+
+import (
+	"crypto/tls"
+	"io"
+	"testing"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil/echtest"
+)
+
+func TestServer(t *testing.T) {
+	var serverNames []string
+	srv := echtest.NewServer(t,
+		echtest.WithServerNames("a.example.com", "b.example.com"),
+		echtest.WithConnOptions(ech.WithOnClientHello(func(conn *ech.Conn, outer, inner *ech.ClientHelloInfo) error {
+			serverNames = append(serverNames, conn.ServerName())
+			return nil
+		})),
+	)
+
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		conn, err := tls.Dial("tcp", srv.Addr, srv.ClientConfig(name))
+		if err != nil {
+			t.Fatalf("tls.Dial: %v", err)
+		}
+		if !conn.ConnectionState().ECHAccepted {
+			t.Errorf("ECHAccepted = false")
+		}
+		if _, err := conn.Write([]byte("hello")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		conn.CloseWrite()
+		b, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatalf("ReadAll: %v", err)
+		}
+		if got, want := string(b), "hello"; got != want {
+			t.Errorf("Got %q, want %q", got, want)
+		}
+		conn.Close()
+	}
+	srv.Close()
+	if got, want := len(serverNames), 2; got != want {
+		t.Fatalf("OnClientHello called %d times, want %d", got, want)
+	}
+	if serverNames[0] != "a.example.com" || serverNames[1] != "b.example.com" {
+		t.Errorf("ServerNames = %v", serverNames)
+	}
+}
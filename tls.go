@@ -1,10 +1,12 @@
 package ech
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 )
 
 var (
@@ -13,6 +15,9 @@ var (
 	ErrDecodeError       = errors.New("decode error")
 	ErrMissingExtension  = errors.New("missing extension")
 	ErrDecryptError      = errors.New("decrypt error")
+	ErrHandshakeTooLarge = errors.New("handshake too large")
+	ErrProtocolVersion   = errors.New("protocol version")
+	ErrAccessDenied      = errors.New("access denied")
 	errNoMatch           = errors.New("ech key mismatch")
 
 	extensionNames = map[uint16]string{
@@ -42,6 +47,26 @@ var (
 		0xfe0d: "encrypted_client_hello",
 	}
 
+	alertNames = map[uint8]string{
+		0:   "close_notify",
+		10:  "unexpected_message",
+		20:  "bad_record_mac",
+		22:  "record_overflow",
+		40:  "handshake_failure",
+		42:  "bad_certificate",
+		47:  "illegal_parameter",
+		49:  "access_denied",
+		50:  "decode_error",
+		51:  "decrypt_error",
+		70:  "protocol_version",
+		80:  "internal_error",
+		109: "missing_extension",
+		110: "unsupported_extension",
+		112: "unrecognized_name",
+		120: "no_application_protocol",
+		121: "ech_required",
+	}
+
 	contentTypes = map[uint8]string{
 		0:  "invalid",
 		20: "change_cipher_spec",
@@ -77,6 +102,13 @@ func extensionName(t uint16) string {
 	return "unknown"
 }
 
+func alertName(t uint8) string {
+	if v, ok := alertNames[t]; ok {
+		return v
+	}
+	return "unknown"
+}
+
 func contentType(t uint8) string {
 	if v, ok := contentTypes[t]; ok {
 		return v
@@ -84,8 +116,15 @@ func contentType(t uint8) string {
 	return "unknown"
 }
 
-func readRecord(conn net.Conn) ([]byte, error) {
-	record := make([]byte, 16389)
+func readRecord(conn io.Reader) ([]byte, error) {
+	return readRecordLimit(conn, 16384)
+}
+
+// readRecordLimit reads one TLS record from conn. The record length must not
+// exceed limit, or 16384, whichever is smaller.
+func readRecordLimit(conn io.Reader, limit int) ([]byte, error) {
+	limit = min(limit, 16384)
+	record := make([]byte, 5+max(limit, 0))
 	n, err := io.ReadFull(conn, record[:5])
 	if err == io.ErrUnexpectedEOF {
 		err = io.EOF
@@ -97,6 +136,9 @@ func readRecord(conn net.Conn) ([]byte, error) {
 	if length > 16384 {
 		return record[:n], fmt.Errorf("%w: record length %d > 16384", ErrDecodeError, length)
 	}
+	if int(length) > limit {
+		return record[:n], fmt.Errorf("%w: record length %d > %d", ErrHandshakeTooLarge, length, max(limit, 0))
+	}
 	nn, err := io.ReadFull(conn, record[n:n+int(length)])
 	if err == io.ErrUnexpectedEOF {
 		err = io.EOF
@@ -104,22 +146,103 @@ func readRecord(conn net.Conn) ([]byte, error) {
 	return record[:n+nn], err
 }
 
-func convertErrorsToAlerts(conn net.Conn, err error) {
+// ParseError is returned when a message or a config can't be decoded. It
+// matches [ErrDecodeError] with [errors.Is].
+type ParseError struct {
+	// Message is the name of the structure that was being decoded, e.g.
+	// "ClientHello" or "ECHConfig".
+	Message string
+	// Index is the index of the config in the Config List, or -1.
+	Index int
+	// Field is the name of the field that couldn't be decoded.
+	Field string
+	// Offset is the byte offset in the message, or in the config, at which
+	// decoding failed.
+	Offset int
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Message
+	if e.Index >= 0 {
+		msg = fmt.Sprintf("%s #%d", msg, e.Index)
+	}
+	return fmt.Sprintf("%v: %s: %s at offset %d", ErrDecodeError, msg, e.Field, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return ErrDecodeError
+}
+
+// AlertError is returned when a fatal TLS alert was sent to the peer because
+// of Err.
+type AlertError struct {
+	// Alert is the AlertDescription that was sent, e.g. 47 for
+	// illegal_parameter.
+	Alert uint8
+	// Err is the error that caused the alert.
+	Err error
+}
+
+func (e *AlertError) Error() string {
+	return fmt.Sprintf("%v (sent alert %s(%d))", e.Err, alertName(e.Alert), e.Alert)
+}
+
+func (e *AlertError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultAlert returns the TLS alert that is sent to the peer when err occurs,
+// and whether an alert should be sent at all. It is the default mapping used
+// by [Conn]. Custom mappings can be set with [WithAlertFunc]. No alert is sent
+// when the connection was closed, canceled, or timed out.
+func DefaultAlert(err error) (alert uint8, send bool) {
 	switch {
-	case err == nil:
+	case err == nil, errors.Is(err, ErrPlaintextHTTP), errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+		return 0, false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+		return 0, false
 	case errors.Is(err, ErrUnexpectedMessage):
-		sendAlert(conn, 2 /* fatal */, 10 /* Unexpected message */)
+		return 10, true // Unexpected message
 	case errors.Is(err, ErrIllegalParameter):
-		sendAlert(conn, 2 /* fatal */, 47 /* Illegal parameter */)
+		return 47, true // Illegal parameter
 	case errors.Is(err, ErrDecodeError):
-		sendAlert(conn, 2 /* fatal */, 50 /* Decode error */)
+		return 50, true // Decode error
 	case errors.Is(err, ErrDecryptError):
-		sendAlert(conn, 2 /* fatal */, 51 /* Decrypt Error */)
+		return 51, true // Decrypt Error
 	case errors.Is(err, ErrMissingExtension):
-		sendAlert(conn, 2 /* fatal */, 109 /* Missing Extension */)
+		return 109, true // Missing Extension
+	case errors.Is(err, ErrProtocolVersion):
+		return 70, true // Protocol Version
+	case errors.Is(err, ErrAccessDenied):
+		return 49, true // Access Denied
+	case errors.Is(err, ErrNoRoute):
+		return 112, true // Unrecognized name
 	default:
-		sendAlert(conn, 2 /* fatal */, 40 /* Handshake failure */)
+		return 40, true // Handshake failure
+	}
+}
+
+// abort sends the fatal TLS alert that corresponds to err to conn, if any, and
+// closes conn. It returns an [AlertError] that wraps err when an alert is sent.
+// Errors that already are an AlertError are returned unchanged.
+func abort(conn net.Conn, alertFunc func(error) (uint8, bool), err error) error {
+	if err == nil {
+		return nil
+	}
+	var alertErr *AlertError
+	if errors.As(err, &alertErr) {
+		return err
+	}
+	if alertFunc == nil {
+		alertFunc = DefaultAlert
+	}
+	alert, send := alertFunc(err)
+	if !send {
+		conn.Close()
+		return err
 	}
+	sendAlert(conn, 2 /* fatal */, alert)
+	return &AlertError{Alert: alert, Err: err}
 }
 
 func sendAlert(w io.WriteCloser, level, description uint8) {
@@ -2,12 +2,17 @@ package ech
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/c2FmZQ/ech/dns"
@@ -22,16 +27,26 @@ var _ http.RoundTripper = (*Transport)(nil)
 // and refuses to execute plaintext HTTP transactions. This behavior can be changed
 // by modifiying the appropriate parameters.
 //
+// The proxies specified by the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+// variables are used, see [Transport.Proxy].
+//
 // For example, to require ECH, set Dialer.RequireECH = true.
 func NewTransport() *Transport {
 	t := &Transport{
 		Resolver: DefaultResolver,
 		Dialer:   NewDialer(),
+		Proxy:    http.ProxyFromEnvironment,
 	}
-	netDialer := newNetDialer()
+	netDialer := newNetDialer(func() *Dialer[*tls.Conn] {
+		return t.Dialer
+	})
 	t.HTTPTransport = &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if t.Dialer.RequireECH {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			if requireECH, _ := t.Dialer.requireECH(ctx, host); requireECH {
 				return nil, errors.New("unable to use ECH with plaintext HTTP")
 			}
 			return netDialer.Dial(ctx, network, addr, nil)
@@ -50,6 +65,16 @@ func NewTransport() *Transport {
 // Transport is a [http.RoundTripper] that uses [Resolver], [Dialer], and
 // [http.Transport] to execute an HTTP transaction using Encrypted Client Hello
 // in the underlying TLS connection.
+//
+// The DNS records of each origin are cached until their TTL expires, and they
+// are refreshed in the background shortly before then. When the ECH config
+// list of an origin changes, new connections are established with the new
+// config list.
+//
+// When the server rejects ECH because the config list from DNS is stale, and
+// Dialer doesn't retry with the server's retry configs, e.g. because
+// Dialer.DisableECHRetry is set, idempotent requests are retried once on a
+// new connection with the retry configs.
 type Transport struct {
 	// This http.Transport is used to execute the HTTP transaction. The
 	// DialContext and DialTLSContext functions are set by NewTransport
@@ -60,32 +85,195 @@ type Transport struct {
 	// the hostname has an HTTPS RR with h3 present in its ALPN list with a
 	// lower Priority value than any with h2 or http/1.1.
 	// See github.com/c2FmZQ/ech/quic/h3 NewTransport
+	//
+	// HTTP3Transport is also used when a previous response from the same
+	// origin advertised an h3 endpoint in its Alt-Svc header (RFC 7838),
+	// unless DisableAltSvc is set.
 	HTTP3Transport http.RoundTripper
+	// DisableAltSvc disables the use of the h3 endpoints advertised in
+	// Alt-Svc headers.
+	DisableAltSvc bool
+	// DisableH3Fallback disables the automatic fallback to HTTPTransport
+	// when a request with HTTP3Transport fails, e.g. because UDP is
+	// blocked. Requests to alternative services from Alt-Svc headers
+	// always fall back. Non-idempotent requests only fall back when the
+	// Dialer couldn't establish the QUIC connection, i.e. when they
+	// weren't sent.
+	DisableH3Fallback bool
+	// H3FailureTimeout is the amount of time during which h3 isn't used
+	// again with an origin after a failure, e.g. because UDP is blocked.
+	// The default is 5 minutes. A negative value disables it, and h3 is
+	// attempted again with every request.
+	H3FailureTimeout time.Duration
+	// Proxy, if set, returns the proxy to use for a request, e.g.
+	// [http.ProxyFromEnvironment], which NewTransport sets by default.
+	// The TLS connection, including Encrypted Client Hello, is tunneled
+	// end-to-end through the proxy with HTTP CONNECT, or with SOCKS5 when
+	// the proxy URL's scheme is socks5. HTTP3Transport isn't used with a
+	// proxy. Proxy takes precedence over Dialer.Proxy. The Proxy of
+	// HTTPTransport must not be set.
+	Proxy func(*http.Request) (*url.URL, error)
+	// DialConn, if set, establishes the network connections instead of
+	// Dialer, e.g. to connect to a Unix socket or to use a pre-established
+	// tunnel. addr is the address of the target that was selected from
+	// the DNS records. The name resolution, the ALPN selection, and the
+	// TLS handshake with Encrypted Client Hello are unchanged. Proxy and
+	// HTTP3Transport aren't used when DialConn is set.
+	DialConn func(ctx context.Context, network, addr string) (net.Conn, error)
 	// This Resolver is used for DNS name resolution. NewTransport() sets
-	// it to DefaultResolver. Any valid Resolver can be used.
+	// it to DefaultResolver. Any valid Resolver can be used. A bootstrap
+	// resolver, see [NewBootstrapResolver], is upgraded automatically to
+	// the encrypted resolver that it designates, if any.
 	Resolver *Resolver
+	// DisableResolverUpgrade disables the automatic upgrade of a bootstrap
+	// Resolver.
+	DisableResolverUpgrade bool
 	// This Dialer is used to dial the TLS connection. Its parameters can
 	// be modified as needed.
 	Dialer *Dialer[*tls.Conn]
 	// This tls.Config is used when dialing the TLS connection. A nil value
 	// is generally fine.
 	TLSConfig *tls.Config
+	// TLSConfigForHost, if set, returns the tls.Config to use for the
+	// connections to host instead of TLSConfig, e.g. to use pinned root
+	// CAs, a client certificate, or different NextProtos with some
+	// origins while sharing one Transport. base is TLSConfig, which can be
+	// nil, and must not be modified. The returned value can be base
+	// itself. It is used with HTTPTransport and HTTP3Transport. The idle
+	// connections are reused by the requests to the same host, so the
+	// config of a host shouldn't change.
+	TLSConfigForHost func(host string, base *tls.Config) *tls.Config
+	// Logger, if set, is used to log the retries after ECH rejections, and
+	// the fallbacks from HTTP/3. When it isn't set, the logger set with
+	// the package's [SetLogger] is used. Dialer and Resolver have their
+	// own loggers.
+	Logger *slog.Logger
+
+	altSvc     altSvcCache
+	h3Failures h3Failures
+	origins    originCache
+	upgrade    resolverUpgrade
 }
 
 // RoundTrip implements the [http.RoundTripper] interface.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
-	res, err := t.Resolver.Resolve(ctx, req.URL.String())
+	origin := req.URL.Scheme + "://" + req.URL.Host
+	resolver := t.resolver(ctx)
+	res, err := t.origins.resolve(ctx, resolver, origin)
 	if err != nil {
 		return nil, err
 	}
-	origReq := req
+	resp, err := t.roundTrip(req, res)
+	var echErr *tls.ECHRejectionError
+	if err != nil && errors.As(err, &echErr) && len(echErr.RetryConfigList) > 0 && canRetryRequest(req) && ctx.Err() == nil {
+		retryRes, ok := withRetryConfigList(res, echErr.RetryConfigList)
+		if !ok {
+			return nil, err
+		}
+		logger(t.Logger).Info("ech: retrying request after ECH rejection", "origin", origin)
+		// The config list from DNS is stale. Refresh the cached results
+		// for the next requests, but use the server's retry configs now,
+		// in case DNS hasn't caught up yet.
+		t.origins.refresh(ctx, resolver, origin)
+		var retryReq *http.Request
+		if retryReq, err = cloneRequest(req); err != nil {
+			return nil, err
+		}
+		resp, err = t.roundTrip(retryReq, retryRes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	return resp, nil
+}
+
+// resolver returns the Resolver to use, i.e. Resolver or the encrypted resolver
+// that it was upgraded to.
+func (t *Transport) resolver(ctx context.Context) *Resolver {
+	if t.DisableResolverUpgrade || t.Resolver.bootstrapAddr == "" {
+		return t.Resolver
+	}
+	return t.upgrade.get(ctx, t.Resolver)
+}
+
+// canRetryRequest returns true if req is idempotent and can be sent again.
+func canRetryRequest(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return canResendBody(req)
+}
+
+// canResendBody returns true if the body of req can be sent again.
+func canResendBody(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// cloneRequest returns a copy of req with a new body that can be sent again.
+// The RoundTripper must not modify req itself.
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	clone := req.Clone(req.Context())
+	var err error
+	if clone.Body, err = req.GetBody(); err != nil {
+		return nil, err
+	}
+	return clone, nil
+}
+
+// withRetryConfigList returns a copy of res that uses the retry configs that
+// a server returned when it rejected ECH. It returns false if none of the
+// retry configs can be used.
+func withRetryConfigList(res ResolveResult, retryList []byte) (ResolveResult, bool) {
+	result := res.clone()
+	var ok bool
+	for i, hh := range result.HTTPS {
+		if len(hh.ECH) == 0 {
+			continue
+		}
+		if configList := validateRetryConfigList(hh.ECH, retryList); configList != nil {
+			result.HTTPS[i].ECH = configList
+			ok = true
+		}
+	}
+	return result, ok
+}
+
+func (t *Transport) roundTrip(req *http.Request, res ResolveResult) (*http.Response, error) {
+	ctx := req.Context()
+	var err error
 	req = req.Clone(ctx)
 
 	if len(res.HTTPS) > 0 && req.URL.Scheme == "http" {
 		req.URL.Scheme = "https"
 	}
 
+	var proxyURL *url.URL
+	if t.Proxy != nil && t.DialConn == nil {
+		if proxyURL, err = t.Proxy(req); err != nil {
+			return nil, err
+		}
+	}
+	if proxyURL != nil {
+		proxy, err := proxyFromURL(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, transportProxyKey, proxy)
+	}
+	if t.DialConn != nil {
+		ctx = context.WithValue(ctx, transportProxyKey, Proxy(ProxyFunc(t.DialConn)))
+	}
+	// The TLS connections are tunneled through something that can't
+	// carry h3.
+	tunneled := proxyURL != nil || t.DialConn != nil
+
 	h, p, err := net.SplitHostPort(req.URL.Host)
 	if err != nil {
 		h = req.URL.Host
@@ -104,10 +292,10 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// The URL.Host is typically used by the http transport to decide which
 	// connections are equivalent and can be used or re-used interchangeably.
 	// The value is used as a key only. The format doesn't matter.
-	req.URL.Host = fmt.Sprintf("_%s._%s.%s._", p, req.URL.Scheme, h)
+	req.URL.Host = poolKey(req.URL.Scheme, h, p, res, requireECHFromContext(ctx), serverNameFromContext(ctx), proxyURL)
 
 	var useH3 bool
-	if t.HTTP3Transport != nil {
+	if t.HTTP3Transport != nil && !tunneled {
 		for _, hh := range res.HTTPS {
 			if hh.Priority == 0 {
 				continue
@@ -122,6 +310,18 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	origin := req.URL.Scheme + "://" + net.JoinHostPort(h, p)
+	if useH3 && t.h3Failures.contains(origin) {
+		useH3 = false
+	}
+	var altSvc *altSvcEntry
+	if !useH3 && t.HTTP3Transport != nil && !tunneled && !t.DisableAltSvc && !t.h3Failures.contains(origin) {
+		if e, ok := t.altSvc.get(origin); ok {
+			useH3 = true
+			altSvc = &e
+		}
+	}
+
 	filterResult := func(alpn map[string]bool, mustHave bool) ResolveResult {
 		result := res.clone()
 		result.HTTPS = slices.DeleteFunc(result.HTTPS, func(hh dns.HTTPS) bool {
@@ -144,22 +344,60 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		return result
 	}
 
+	var tc *tls.Config
+	if t.TLSConfigForHost != nil {
+		tc = t.TLSConfigForHost(h, t.TLSConfig)
+	}
+
 	var resp *http.Response
+	// fallback indicates that the request was retried after h3 failed.
+	var fallback bool
 	if useH3 {
-		resp, err = t.HTTP3Transport.RoundTrip(
-			req.WithContext(
-				context.WithValue(ctx, transportResolverKey, &transportResolver{
-					host:   h,
-					result: filterResult(map[string]bool{"h3": true}, true),
-				}),
-			),
-		)
-	} else {
+		var result ResolveResult
+		if altSvc != nil {
+			result, err = t.altSvcResult(ctx, res, *altSvc)
+		} else {
+			result, err = filterResult(map[string]bool{"h3": true}, true), nil
+		}
+		h3Resolver := &transportResolver{
+			host:      h,
+			result:    result,
+			tlsConfig: tc,
+		}
+		if err == nil {
+			resp, err = t.HTTP3Transport.RoundTrip(
+				req.WithContext(context.WithValue(ctx, transportResolverKey, h3Resolver)),
+			)
+		}
+		// When h3 fails, e.g. because UDP is blocked, the request is
+		// retried with h2 or http/1.1, if possible. Unless the QUIC
+		// connection couldn't be established, the request may already
+		// have been sent, and only idempotent requests are retried.
+		canRetry := ctx.Err() == nil && ((h3Resolver.dialFailed.Load() && canResendBody(req)) || canRetryRequest(req))
+		if err != nil && canRetry && (altSvc != nil || !t.DisableH3Fallback) {
+			logger(t.Logger).Debug("ech: HTTP/3 request failed, falling back", "origin", origin, "err", err)
+			if altSvc != nil {
+				t.altSvc.remove(origin)
+			}
+			failureTimeout := t.H3FailureTimeout
+			if failureTimeout == 0 {
+				failureTimeout = 5 * time.Minute
+			}
+			t.h3Failures.add(origin, failureTimeout)
+			if req, err = cloneRequest(req); err != nil {
+				return nil, err
+			}
+			useH3 = false
+			fallback = true
+		}
+	}
+	if !useH3 {
 		resp, err = t.HTTPTransport.RoundTrip(
 			req.WithContext(
 				context.WithValue(ctx, transportResolverKey, &transportResolver{
-					host:   h,
-					result: filterResult(map[string]bool{"h2": true, "http/1.1": true}, false),
+					host:      h,
+					result:    filterResult(map[string]bool{"h2": true, "http/1.1": true}, false),
+					tlsConfig: tc,
 				}),
 			),
 		)
@@ -167,19 +405,250 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp.Request = origReq
+	// The alternative service that just failed isn't learned again from
+	// the response of the fallback.
+	if !useH3 && !fallback && t.HTTP3Transport != nil && !t.DisableAltSvc {
+		if v := resp.Header.Values("Alt-Svc"); len(v) > 0 {
+			t.altSvc.update(origin, "h3", v)
+		}
+	}
 	return resp, nil
 }
 
+// ResponseECHAccepted reports whether resp was received on a connection that
+// negotiated Encrypted Client Hello. It returns false when the connection state
+// is unknown, e.g. when resp was received in plaintext, or when a custom
+// HTTP3Transport doesn't set resp.TLS.
+func ResponseECHAccepted(resp *http.Response) bool {
+	return resp != nil && resp.TLS != nil && resp.TLS.ECHAccepted
+}
+
+// poolKey returns the key that identifies the connections to an origin that
+// are equivalent. Connections are only re-used by requests that would
+// establish them the same way, i.e. with the same ECH config lists, ECH
+// requirement, server name, and proxy. When the origin's ECH config list
+// changes, new connections are established.
+func poolKey(scheme, host, port string, res ResolveResult, requireECH bool, serverName string, proxyURL *url.URL) string {
+	key := fmt.Sprintf("_%s._%s.%s._", port, scheme, host)
+	hash := sha256.New()
+	var variant bool
+	for _, hh := range res.HTTPS {
+		if len(hh.ECH) > 0 {
+			hash.Write(hh.ECH)
+			variant = true
+		}
+	}
+	// Connections that were established without ECH must not be re-used
+	// for requests that require it.
+	if requireECH {
+		hash.Write([]byte("require-ech"))
+		variant = true
+	}
+	if serverName != "" {
+		hash.Write([]byte("server-name=" + serverName + "\x00"))
+		variant = true
+	}
+	if proxyURL != nil {
+		hash.Write([]byte(proxyURL.String()))
+		variant = true
+	}
+	if variant {
+		key += fmt.Sprintf("%x", hash.Sum(nil)[:8])
+	}
+	return key
+}
+
+// PoolOptions contains the connection pool limits of a [Transport]. The
+// limits per origin apply to the connections with the same scheme, host name,
+// and port, that were established the same way, i.e. with the same ECH config
+// lists, ECH requirement, server name, and proxy. After the ECH config list of an origin
+// changes, its old connections remain idle until they time out.
+type PoolOptions struct {
+	// MaxIdleConns is the maximum number of idle connections across all
+	// origins. Zero means no limit.
+	MaxIdleConns int
+	// MaxIdleConnsPerOrigin is the maximum number of idle connections to
+	// keep per origin. Zero means [http.DefaultMaxIdleConnsPerHost].
+	MaxIdleConnsPerOrigin int
+	// MaxConnsPerOrigin is the maximum number of connections per origin,
+	// including the ones that are being established, active, and idle.
+	// Zero means no limit.
+	MaxConnsPerOrigin int
+	// IdleConnTimeout is the amount of time that an idle connection is
+	// kept. Zero means no limit.
+	IdleConnTimeout time.Duration
+}
+
+// SetPoolOptions sets the connection pool limits of HTTPTransport.
+func (t *Transport) SetPoolOptions(opts PoolOptions) {
+	t.HTTPTransport.MaxIdleConns = opts.MaxIdleConns
+	t.HTTPTransport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerOrigin
+	t.HTTPTransport.MaxConnsPerHost = opts.MaxConnsPerOrigin
+	t.HTTPTransport.IdleConnTimeout = opts.IdleConnTimeout
+}
+
+// CloseIdleConnections closes the idle connections of HTTPTransport and
+// HTTP3Transport. It doesn't interrupt the connections that are in use.
+func (t *Transport) CloseIdleConnections() {
+	if t.HTTPTransport != nil {
+		t.HTTPTransport.CloseIdleConnections()
+	}
+	if c, ok := t.HTTP3Transport.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// originCache contains the ResolveResult of each origin. The results are
+// refreshed in the background shortly before their DNS records expire, so that
+// requests don't wait for the Resolver, and so that new connections use the
+// new ECH config list promptly after it changes.
+type originCache struct {
+	mu      sync.Mutex
+	entries map[string]*originEntry
+}
+
+type originEntry struct {
+	result     ResolveResult
+	refreshAt  time.Time
+	expires    time.Time
+	refreshing bool
+}
+
+// resolve returns the ResolveResult of origin, from the cache if possible.
+func (c *originCache) resolve(ctx context.Context, r *Resolver, origin string) (ResolveResult, error) {
+	c.mu.Lock()
+	now := timeNow()
+	e, ok := c.entries[origin]
+	if ok && now.Before(e.expires) {
+		if !e.refreshing && !now.Before(e.refreshAt) {
+			e.refreshing = true
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+				c.refresh(ctx, r, origin)
+			}()
+		}
+		res := e.result.clone()
+		c.mu.Unlock()
+		return res, nil
+	}
+	c.mu.Unlock()
+	return c.update(ctx, r, origin)
+}
+
+// refresh resolves origin, bypassing the Resolver's cache, and updates the
+// cache.
+func (c *originCache) refresh(ctx context.Context, r *Resolver, origin string) (ResolveResult, error) {
+	return c.update(withFreshResolve(ctx), r, origin)
+}
+
+func (c *originCache) update(ctx context.Context, r *Resolver, origin string) (ResolveResult, error) {
+	res, expires, err := r.resolve(ctx, origin, nil)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*originEntry)
+	}
+	now := timeNow()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if err != nil {
+		if e, ok := c.entries[origin]; ok {
+			e.refreshing = false
+		}
+		return res, err
+	}
+	if expires.After(now) {
+		c.entries[origin] = &originEntry{
+			result: res,
+			// Refresh when 90% of the TTL has elapsed.
+			refreshAt: expires.Add(-expires.Sub(now) / 10),
+			expires:   expires,
+		}
+	} else {
+		delete(c.entries, origin)
+	}
+	return res.clone(), nil
+}
+
+// h3Failures contains the origins where h3 recently failed.
+type h3Failures struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (f *h3Failures) add(origin string, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.until == nil {
+		f.until = make(map[string]time.Time)
+	}
+	f.until[origin] = timeNow().Add(d)
+}
+
+func (f *h3Failures) contains(origin string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.until[origin]
+	if ok && !timeNow().Before(until) {
+		delete(f.until, origin)
+		return false
+	}
+	return ok
+}
+
+// altSvcResult returns the targets of an h3 alternative service, using the ECH
+// config list of the origin.
+func (t *Transport) altSvcResult(ctx context.Context, res ResolveResult, e altSvcEntry) (ResolveResult, error) {
+	result := res.clone()
+	rr := dns.HTTPS{
+		Priority:      1,
+		Port:          e.port,
+		ALPN:          []string{"h3"},
+		NoDefaultALPN: true,
+	}
+	for _, hh := range res.HTTPS {
+		if hh.Priority > 0 && len(hh.ECH) > 0 {
+			rr.ECH = hh.ECH
+			break
+		}
+	}
+	if e.host != "" {
+		alt, err := t.resolver(ctx).Resolve(ctx, e.host)
+		if err != nil {
+			return result, err
+		}
+		rr.Target = e.host
+		result.Additional = map[string][]net.IP{e.host: alt.Address}
+	}
+	result.HTTPS = []dns.HTTPS{rr}
+	return result, nil
+}
+
 type ctxTransportKey int
 
-var transportResolverKey ctxTransportKey = 1
+var (
+	transportResolverKey ctxTransportKey = 1
+	transportProxyKey    ctxTransportKey = 2
+)
 
 type transportResolver struct {
 	host   string
 	result ResolveResult
+	// tlsConfig, if set, is the tls.Config returned by
+	// Transport.TLSConfigForHost. It replaces the one passed to Dialer.
+	tlsConfig *tls.Config
+	// dialFailed is set when a Dialer couldn't establish a connection for
+	// the request.
+	dialFailed atomic.Bool
 }
 
-func (r *transportResolver) Resolve(ctx context.Context, name string) (ResolveResult, error) {
+func (r *transportResolver) Resolve(ctx context.Context, name string, opts ...ResolveOption) (ResolveResult, error) {
 	return r.result, nil
 }
@@ -1,15 +1,23 @@
 package ech
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/c2FmZQ/ech/dns"
 	"github.com/c2FmZQ/ech/testutil"
@@ -148,4 +156,660 @@ func TestTransportTCP(t *testing.T) {
 	if got, want := string(body), "GET /foo: Plaintext HTTP\n"; got != want {
 		t.Errorf("Body = %q, want %q", got, want)
 	}
+
+	// The idle connection must not be re-used when the request requires
+	// ECH.
+	req, err := http.NewRequestWithContext(WithRequireECH(t.Context()), "GET", url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	if _, err := client.Do(req); err == nil || !strings.Contains(err.Error(), "unable to use ECH with plaintext HTTP") {
+		t.Fatalf("GET should have failed. err=%v", err)
+	}
+}
+
+// fakeH3Transport is a HTTP3Transport that records the targets that it would
+// connect to.
+type fakeH3Transport struct {
+	targets []Target
+	// dialErr is returned by the Dialer, before the request is sent.
+	dialErr error
+	// err is returned after the request is sent.
+	err        error
+	calls      int
+	closeCalls int
+}
+
+func (f *fakeH3Transport) CloseIdleConnections() {
+	f.closeCalls++
+}
+
+func (f *fakeH3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls++
+	r := req.Context().Value(transportResolverKey).(*transportResolver)
+	f.targets = append(f.targets, slices.Collect(r.result.Targets("udp"))...)
+	if f.dialErr != nil {
+		d := &Dialer[net.Conn]{
+			DialFunc: func(context.Context, string, string, *tls.Config) (net.Conn, error) {
+				return nil, f.dialErr
+			},
+		}
+		_, err := d.Dial(req.Context(), "udp", req.URL.Host, nil)
+		return nil, err
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &http.Response{
+		StatusCode: 200,
+		Body:       io.NopCloser(strings.NewReader("h3\n")),
+	}, nil
+}
+
+// startTestH2Server starts a h2 server for private.example.com, with HTTPS
+// records that have the given ALPN lists, and returns a Transport that uses
+// a fake HTTP3Transport.
+func startTestH2Server(t *testing.T, alpns [][]string, handler http.HandlerFunc) (*Transport, *fakeH3Transport, []byte) {
+	privKey, config, err := NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ConfigList([]Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	addr := ln.Addr().(*net.TCPAddr)
+
+	tlsCert, err := testutil.NewCert("public.example.com", "private.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	server := &http.Server{
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{tlsCert},
+			NextProtos:   []string{"h2"},
+			EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+				Config:      config,
+				PrivateKey:  privKey.Bytes(),
+				SendAsRetry: true,
+			}},
+		},
+	}
+	go server.ServeTLS(ln, "", "")
+
+	var rrs []dns.RR
+	for i, alpn := range alpns {
+		rrs = append(rrs, dns.RR{
+			Name: "private.example.com", Type: 65, Class: 1, TTL: 60,
+			Data: dns.HTTPS{Priority: uint16(i + 1), Port: uint16(addr.Port), ALPN: alpn, NoDefaultALPN: true, ECH: configList},
+		})
+	}
+	rrs = append(rrs, dns.RR{
+		Name: "private.example.com", Type: 1, Class: 1, TTL: 60,
+		Data: addr.IP,
+	})
+	dnsServer := testutil.StartTestDNSServer(t, rrs)
+	t.Cleanup(dnsServer.Close)
+
+	h3 := &fakeH3Transport{}
+	transport := NewTransport()
+	transport.Resolver = &Resolver{baseURL: url.URL{Scheme: "http", Host: dnsServer.Listener.Addr().String(), Path: "/dns-query"}}
+	transport.TLSConfig = &tls.Config{RootCAs: rootCAs}
+	transport.HTTP3Transport = h3
+	return transport, h3, configList
+}
+
+func TestTransportAltSvc(t *testing.T) {
+	transport, h3, configList := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Alt-Svc", `h3=":8443"; ma=60`)
+		fmt.Fprintln(w, "h2")
+	})
+
+	client := &http.Client{Transport: transport}
+	get := func() string {
+		t.Helper()
+		resp, err := client.Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got, want := get(), "h2\n"; got != want {
+		t.Errorf("1st GET = %q, want %q", got, want)
+	}
+	if got, want := get(), "h3\n"; got != want {
+		t.Errorf("2nd GET = %q, want %q", got, want)
+	}
+	if len(h3.targets) != 1 || h3.targets[0].Address.Port() != 8443 || !bytes.Equal(h3.targets[0].ECH, configList) || !slices.Equal(h3.targets[0].ALPN, []string{"h3"}) {
+		t.Errorf("h3 targets = %+v", h3.targets)
+	}
+
+	// The alternative service fails. The request falls back to h2.
+	h3.err = errors.New("udp blocked")
+	if got, want := get(), "h2\n"; got != want {
+		t.Errorf("3rd GET = %q, want %q", got, want)
+	}
+
+	transport.DisableAltSvc = true
+	h3.err = nil
+	h3.calls = 0
+	if got, want := get(), "h2\n"; got != want {
+		t.Errorf("4th GET = %q, want %q", got, want)
+	}
+	if h3.calls != 0 {
+		t.Errorf("h3 calls = %d, want 0", h3.calls)
+	}
+}
+
+func TestTransportAltSvcUDPBlocked(t *testing.T) {
+	transport, h3, _ := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Alt-Svc", `h3=":8443"; ma=60`)
+		fmt.Fprintln(w, "h2")
+	})
+	h3.err = errors.New("udp blocked")
+
+	client := &http.Client{Transport: transport}
+	get := func() {
+		t.Helper()
+		resp, err := client.Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		if body, _ := io.ReadAll(resp.Body); string(body) != "h2\n" {
+			t.Errorf("GET = %q, want h2", body)
+		}
+	}
+
+	// The first response advertises h3.
+	get()
+	if h3.calls != 0 {
+		t.Fatalf("h3 calls = %d, want 0", h3.calls)
+	}
+	// Only the first of the next requests attempts h3.
+	get()
+	get()
+	if h3.calls != 1 {
+		t.Errorf("h3 calls = %d, want 1", h3.calls)
+	}
+}
+
+func TestTransportH3Fallback(t *testing.T) {
+	var h2Requests int
+	transport, h3, _ := startTestH2Server(t, [][]string{{"h3"}, {"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		h2Requests++
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(w, "h2 %s %s\n", req.Method, body)
+	})
+	h3.dialErr = errors.New("udp blocked")
+
+	client := &http.Client{Transport: transport}
+	post := func() (string, error) {
+		t.Helper()
+		resp, err := client.Post("https://private.example.com/", "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	if got, err := post(); err != nil || got != "h2 POST hello\n" {
+		t.Errorf("POST = %q, %v", got, err)
+	}
+	if h3.calls != 1 {
+		t.Errorf("h3 calls = %d, want 1", h3.calls)
+	}
+
+	// The failure is remembered.
+	for range 2 {
+		if got, err := post(); err != nil || got != "h2 POST hello\n" {
+			t.Errorf("POST = %q, %v", got, err)
+		}
+	}
+	if h3.calls != 1 {
+		t.Errorf("h3 calls = %d, want 1", h3.calls)
+	}
+
+	// With a negative H3FailureTimeout, h3 is attempted every time.
+	transport.h3Failures = h3Failures{}
+	transport.H3FailureTimeout = -1
+	for range 2 {
+		if got, err := post(); err != nil || got != "h2 POST hello\n" {
+			t.Errorf("POST = %q, %v", got, err)
+		}
+	}
+	if h3.calls != 3 {
+		t.Errorf("h3 calls = %d, want 3", h3.calls)
+	}
+
+	// The POST failed after it was sent. It isn't sent again.
+	transport.h3Failures = h3Failures{}
+	h3.dialErr = nil
+	h3.err = errors.New("stream reset")
+	h2Requests = 0
+	if _, err := post(); err == nil {
+		t.Error("POST succeeded unexpectedly")
+	}
+	if h2Requests != 0 {
+		t.Errorf("h2 requests = %d, want 0", h2Requests)
+	}
+
+	transport.h3Failures = h3Failures{}
+	h3.dialErr = errors.New("udp blocked")
+	h3.err = nil
+	transport.DisableH3Fallback = true
+	if _, err := post(); err == nil {
+		t.Error("POST succeeded unexpectedly")
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+	transport, h3, _ := startTestH2Server(t, [][]string{{"h3"}, {"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "h2 ECHAccepted:%v\n", req.TLS.ECHAccepted)
+	})
+	handler := &connectHandler{}
+	proxyServer := httptest.NewServer(handler)
+	defer proxyServer.Close()
+	proxyURL, err := url.Parse(proxyServer.URL)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	transport.Dialer.RequireECH = true
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	client := &http.Client{Transport: transport}
+	for range 2 {
+		resp, err := client.Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got, want := string(body), "h2 ECHAccepted:true\n"; got != want {
+			t.Errorf("Body = %q, want %q", got, want)
+		}
+	}
+	if got, want := handler.count.Load(), int32(1); got != want {
+		t.Errorf("Proxied connections = %d, want %d", got, want)
+	}
+	if h3.calls != 0 {
+		t.Errorf("h3 calls = %d, want 0", h3.calls)
+	}
+
+	// Without the proxy, the connection isn't re-used.
+	transport.Proxy = nil
+	transport.HTTP3Transport = nil
+	resp, err := client.Get("https://private.example.com/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if got, want := handler.count.Load(), int32(1); got != want {
+		t.Errorf("Proxied connections = %d, want %d", got, want)
+	}
+}
+
+func TestPoolKey(t *testing.T) {
+	res1 := ResolveResult{HTTPS: []dns.HTTPS{{Priority: 1, ECH: []byte("config1")}}}
+	res2 := ResolveResult{HTTPS: []dns.HTTPS{{Priority: 1, ECH: []byte("config2")}}}
+	proxyURL, _ := url.Parse("http://proxy.example.com:8080")
+
+	if got, want := poolKey("https", "example.com", "443", ResolveResult{}, false, "", nil), "_443._https.example.com._"; got != want {
+		t.Errorf("poolKey() = %q, want %q", got, want)
+	}
+	keys := []string{
+		poolKey("https", "example.com", "443", ResolveResult{}, false, "", nil),
+		poolKey("https", "example.com", "443", res1, false, "", nil),
+		poolKey("https", "example.com", "443", res2, false, "", nil),
+		poolKey("https", "example.com", "443", res1, true, "", nil),
+		poolKey("https", "example.com", "443", res1, false, "", proxyURL),
+		poolKey("https", "example.com", "443", res1, false, "vanity.example.com", nil),
+		poolKey("https", "example.com", "8443", res1, false, "", nil),
+	}
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		if seen[k] {
+			t.Errorf("duplicate pool key %q", k)
+		}
+		seen[k] = true
+	}
+	if a, b := poolKey("https", "example.com", "443", res1, false, "", nil), poolKey("https", "example.com", "443", res1.clone(), false, "", nil); a != b {
+		t.Errorf("poolKey() = %q and %q, want same key", a, b)
+	}
+}
+
+func TestTransportPoolOptions(t *testing.T) {
+	transport := NewTransport()
+	h3 := &fakeH3Transport{}
+	transport.HTTP3Transport = h3
+	transport.SetPoolOptions(PoolOptions{
+		MaxIdleConns:          10,
+		MaxIdleConnsPerOrigin: 2,
+		MaxConnsPerOrigin:     4,
+		IdleConnTimeout:       time.Minute,
+	})
+	ht := transport.HTTPTransport
+	if ht.MaxIdleConns != 10 || ht.MaxIdleConnsPerHost != 2 || ht.MaxConnsPerHost != 4 || ht.IdleConnTimeout != time.Minute {
+		t.Errorf("HTTPTransport = %d, %d, %d, %v", ht.MaxIdleConns, ht.MaxIdleConnsPerHost, ht.MaxConnsPerHost, ht.IdleConnTimeout)
+	}
+	(&http.Client{Transport: transport}).CloseIdleConnections()
+	if h3.closeCalls != 1 {
+		t.Errorf("h3 CloseIdleConnections calls = %d, want 1", h3.closeCalls)
+	}
+}
+
+func TestResponseECHAccepted(t *testing.T) {
+	transport, _, _ := startTestH2Server(t, [][]string{{"h3"}, {"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "h2")
+	})
+	client := &http.Client{Transport: transport}
+	get := func() bool {
+		t.Helper()
+		resp, err := client.Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		return ResponseECHAccepted(resp)
+	}
+
+	// The fake HTTP3Transport doesn't set resp.TLS.
+	if get() {
+		t.Error("ResponseECHAccepted(h3) = true, want false")
+	}
+	transport.HTTP3Transport = nil
+	if !get() {
+		t.Error("ResponseECHAccepted(h2) = false, want true")
+	}
+	if ResponseECHAccepted(nil) {
+		t.Error("ResponseECHAccepted(nil) = true, want false")
+	}
+}
+
+func TestTransportECHRejectionRetry(t *testing.T) {
+	transport, _, configList := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s ECHAccepted:%v\n", req.Method, req.TLS.ECHAccepted)
+	})
+	transport.HTTP3Transport = nil
+	transport.Dialer.DisableECHRetry = true
+	transport.Resolver.SetCacheSize(32)
+
+	_, staleConfig, err := NewConfig(2, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	staleConfigList, err := ConfigList([]Config{staleConfig})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+	do := func(method string) (string, error) {
+		t.Helper()
+		transport.CloseIdleConnections()
+		// Make the cached HTTPS record stale.
+		if _, err := transport.Resolver.Resolve(t.Context(), "private.example.com"); err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		v, _ := transport.Resolver.cache.Get(cacheKey{"private.example.com", "HTTPS"})
+		v.mu.Lock()
+		hh := v.result[0].(dns.HTTPS)
+		hh.ECH = staleConfigList
+		v.result = []any{hh}
+		v.mu.Unlock()
+		transport.origins = originCache{}
+
+		req, err := http.NewRequest(method, "https://private.example.com/", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	for _, method := range []string{"GET", "PUT"} {
+		if got, err := do(method); err != nil || got != method+" ECHAccepted:true\n" {
+			t.Errorf("%s = %q, %v", method, got, err)
+		}
+		// The cache was refreshed.
+		res, err := transport.Resolver.Resolve(t.Context(), "private.example.com")
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		if got := res.HTTPS[0].ECH; !bytes.Equal(got, configList) {
+			t.Errorf("ECH = %x, want %x", got, configList)
+		}
+	}
+
+	var echErr *tls.ECHRejectionError
+	if _, err := do("POST"); !errors.As(err, &echErr) {
+		t.Errorf("POST = %v, want ECHRejectionError", err)
+	}
+}
+
+func TestTransportResolveRefresh(t *testing.T) {
+	var now atomic.Int64
+	now.Store(time.Date(2025, 2, 25, 12, 0, 0, 0, time.UTC).UnixNano())
+	origTimeNow := timeNow
+	timeNow = func() time.Time {
+		return time.Unix(0, now.Load())
+	}
+	t.Cleanup(func() { timeNow = origTimeNow })
+	advance := func(d time.Duration) {
+		now.Add(int64(d))
+	}
+
+	transport, _, _ := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, "h2")
+	})
+	transport.HTTP3Transport = nil
+
+	// Count the DNS queries.
+	var queries atomic.Int32
+	upstream := transport.Resolver.baseURL.String()
+	dnsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries.Add(1)
+		resp, err := http.Post(upstream, req.Header.Get("Content-Type"), req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(dnsServer.Close)
+	transport.Resolver = &Resolver{baseURL: url.URL{Scheme: "http", Host: dnsServer.Listener.Addr().String(), Path: "/dns-query"}}
+
+	client := &http.Client{Transport: transport}
+	get := func() {
+		t.Helper()
+		resp, err := client.Get("https://private.example.com/")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	get()
+	n := queries.Load()
+	if n == 0 {
+		t.Fatal("no DNS queries")
+	}
+
+	// The result is cached.
+	advance(10 * time.Second)
+	get()
+	if got := queries.Load(); got != n {
+		t.Errorf("queries = %d, want %d", got, n)
+	}
+
+	// Shortly before the TTL (60s) expires, the result is refreshed in
+	// the background.
+	advance(45 * time.Second)
+	get()
+	deadline := time.Now().Add(5 * time.Second)
+	for queries.Load() != 2*n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := queries.Load(); got != 2*n {
+		t.Fatalf("queries = %d, want %d", got, 2*n)
+	}
+
+	// The refreshed result is used after the original TTL expired.
+	advance(10 * time.Second)
+	get()
+	if got := queries.Load(); got != 2*n {
+		t.Errorf("queries = %d, want %d", got, 2*n)
+	}
+}
+
+func TestTransportServerName(t *testing.T) {
+	transport, _, _ := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s ECHAccepted:%v\n", req.TLS.ServerName, req.TLS.ECHAccepted)
+	})
+	transport.HTTP3Transport = nil
+	client := &http.Client{Transport: transport}
+
+	for _, tc := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{t.Context(), "private.example.com ECHAccepted:true\n"},
+		{WithServerName(t.Context(), "public.example.com"), "public.example.com ECHAccepted:true\n"},
+		{t.Context(), "private.example.com ECHAccepted:true\n"},
+	} {
+		req, err := http.NewRequestWithContext(tc.ctx, "GET", "https://private.example.com/", nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if got := string(body); got != tc.want {
+			t.Errorf("GET = %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestTransportDialConn(t *testing.T) {
+	transport, h3, _ := startTestH2Server(t, [][]string{{"h3"}, {"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "h2 ECHAccepted:%v\n", req.TLS.ECHAccepted)
+	})
+
+	// The connections go through a Unix socket that is relayed to the
+	// server.
+	sock := filepath.Join(t.TempDir(), "sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				target, err := net.Dial("tcp", <-targets)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, conn)
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+
+	var dials atomic.Int32
+	transport.DialConn = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dials.Add(1)
+		targets <- addr
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", sock)
+	}
+
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("https://private.example.com/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if got, want := string(body), "h2 ECHAccepted:true\n"; got != want {
+		t.Errorf("GET = %q, want %q", got, want)
+	}
+	if got := dials.Load(); got != 1 {
+		t.Errorf("dials = %d, want 1", got)
+	}
+	if h3.calls != 0 {
+		t.Errorf("h3 calls = %d, want 0", h3.calls)
+	}
+}
+
+func TestTransportTLSConfigForHost(t *testing.T) {
+	transport, _, _ := startTestH2Server(t, [][]string{{"h2"}}, func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%s ECHAccepted:%v\n", req.TLS.ServerName, req.TLS.ECHAccepted)
+	})
+	transport.HTTP3Transport = nil
+	client := &http.Client{Transport: transport}
+
+	var hosts []string
+	transport.TLSConfigForHost = func(host string, base *tls.Config) *tls.Config {
+		hosts = append(hosts, host)
+		if base != transport.TLSConfig {
+			t.Errorf("base = %p, want %p", base, transport.TLSConfig)
+		}
+		tc := base.Clone()
+		tc.ServerName = "public.example.com"
+		return tc
+	}
+	resp, err := client.Get("https://private.example.com/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if got, want := string(body), "public.example.com ECHAccepted:true\n"; got != want {
+		t.Errorf("GET = %q, want %q", got, want)
+	}
+	if want := []string{"private.example.com"}; !slices.Equal(hosts, want) {
+		t.Errorf("hosts = %q, want %q", hosts, want)
+	}
+
+	// Without the root CA, the server's certificate isn't trusted.
+	transport.HTTPTransport.CloseIdleConnections()
+	transport.TLSConfigForHost = func(host string, base *tls.Config) *tls.Config {
+		tc := base.Clone()
+		tc.RootCAs = x509.NewCertPool()
+		return tc
+	}
+	if _, err := client.Get("https://private.example.com/"); err == nil {
+		t.Error("GET succeeded unexpectedly")
+	}
 }
@@ -0,0 +1,116 @@
+// Package utls implements a [ech.Dialer] that uses uTLS to mimic the
+// ClientHello of popular browsers.
+//
+// It uses [ech.Dialer] for name resolution and finding the Encrypted Client
+// Hello (ECH) Config List, and [utls.UClient] for establishing the TLS
+// connection. The ClientHello fingerprint must include an ECH extension, e.g.
+// [utls.HelloChrome_Auto], for ECH to be used.
+package utls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+
+	"github.com/c2FmZQ/ech"
+	utls "github.com/refraction-networking/utls"
+)
+
+// Dial connects to the given network and address. Name resolution is done with
+// [ech.DefaultResolver]. It uses HTTPS DNS records to retrieve the server's
+// Encrypted Client Hello (ECH) Config List and uses it automatically if found.
+//
+// Dial is equivalent to:
+//
+//	NewDialer(helloID).Dial(...)
+func Dial(ctx context.Context, network, addr string, tc *tls.Config, helloID utls.ClientHelloID) (*Conn, error) {
+	return NewDialer(helloID).Dial(ctx, network, addr, tc)
+}
+
+// NewDialer returns a [Conn] Dialer that uses the ClientHello fingerprint
+// identified by helloID. The connections are established with
+// [ech.NetDialContext], i.e. with the Dialer's Proxy and TCPOptions.
+//
+// Only the following fields of the tls.Config passed to Dial are used:
+// ServerName, RootCAs, NextProtos, InsecureSkipVerify, VerifyPeerCertificate,
+// VerifyConnection, MinVersion, MaxVersion, and EncryptedClientHelloConfigList.
+func NewDialer(helloID utls.ClientHelloID) *ech.Dialer[*Conn] {
+	return &ech.Dialer[*Conn]{
+		DialFunc: func(ctx context.Context, network, addr string, tc *tls.Config) (*Conn, error) {
+			rawConn, err := ech.NetDialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			conn := utls.UClient(rawConn, utlsConfig(tc), helloID)
+			if err := conn.HandshakeContext(ctx); err != nil {
+				rawConn.Close()
+				return nil, convertError(err)
+			}
+			return &Conn{UConn: conn}, nil
+		},
+	}
+}
+
+// Conn is a uTLS connection.
+type Conn struct {
+	*utls.UConn
+}
+
+// ConnectionState returns the connection's state as a [tls.ConnectionState].
+// The uTLS state is available with c.UConn.ConnectionState().
+func (c *Conn) ConnectionState() tls.ConnectionState {
+	cs := c.UConn.ConnectionState()
+	return tls.ConnectionState{
+		Version:                     cs.Version,
+		HandshakeComplete:           cs.HandshakeComplete,
+		DidResume:                   cs.DidResume,
+		CipherSuite:                 cs.CipherSuite,
+		NegotiatedProtocol:          cs.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  cs.NegotiatedProtocolIsMutual,
+		ServerName:                  cs.ServerName,
+		PeerCertificates:            cs.PeerCertificates,
+		VerifiedChains:              cs.VerifiedChains,
+		SignedCertificateTimestamps: cs.SignedCertificateTimestamps,
+		OCSPResponse:                cs.OCSPResponse,
+		ECHAccepted:                 cs.ECHAccepted,
+	}
+}
+
+func utlsConfig(tc *tls.Config) *utls.Config {
+	uc := &utls.Config{
+		ServerName:                     tc.ServerName,
+		RootCAs:                        tc.RootCAs,
+		NextProtos:                     tc.NextProtos,
+		InsecureSkipVerify:             tc.InsecureSkipVerify,
+		VerifyPeerCertificate:          tc.VerifyPeerCertificate,
+		MinVersion:                     tc.MinVersion,
+		MaxVersion:                     tc.MaxVersion,
+		EncryptedClientHelloConfigList: tc.EncryptedClientHelloConfigList,
+	}
+	if tc.VerifyConnection != nil {
+		uc.VerifyConnection = func(cs utls.ConnectionState) error {
+			return tc.VerifyConnection(tls.ConnectionState{
+				Version:            cs.Version,
+				HandshakeComplete:  cs.HandshakeComplete,
+				DidResume:          cs.DidResume,
+				CipherSuite:        cs.CipherSuite,
+				NegotiatedProtocol: cs.NegotiatedProtocol,
+				ServerName:         cs.ServerName,
+				PeerCertificates:   cs.PeerCertificates,
+				VerifiedChains:     cs.VerifiedChains,
+				ECHAccepted:        cs.ECHAccepted,
+			})
+		}
+	}
+	return uc
+}
+
+// convertError converts uTLS's ECH rejection errors to [tls.ECHRejectionError]
+// so that [ech.Dialer] can retry with the server's retry configs.
+func convertError(err error) error {
+	var echErr *utls.ECHRejectionError
+	if errors.As(err, &echErr) {
+		return &tls.ECHRejectionError{RetryConfigList: echErr.RetryConfigList}
+	}
+	return err
+}
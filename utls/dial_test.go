@@ -0,0 +1,125 @@
+package utls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/c2FmZQ/ech"
+	"github.com/c2FmZQ/ech/testutil"
+	utls "github.com/refraction-networking/utls"
+)
+
+func TestDial(t *testing.T) {
+	privKey, config, err := ech.NewConfig(1, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList, err := ech.ConfigList([]ech.Config{config})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+	_, config2, err := ech.NewConfig(2, []byte("public.example.com"))
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	configList2, err := ech.ConfigList([]ech.Config{config2})
+	if err != nil {
+		t.Fatalf("ConfigList: %v", err)
+	}
+
+	tlsCert, err := testutil.NewCert("private.example.com", "public.example.com")
+	if err != nil {
+		t.Fatalf("NewCert: %v", err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsCert.Leaf)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		EncryptedClientHelloKeys: []tls.EncryptedClientHelloKey{{
+			Config:      config,
+			PrivateKey:  privKey.Bytes(),
+			SendAsRetry: true,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("tls.Listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("Hello!\n"))
+			}()
+		}
+	}()
+
+	for _, tc := range []struct {
+		name       string
+		configList []byte
+		proxy      bool
+	}{
+		{"correct config list", configList, false},
+		{"incorrect config list (retry)", configList2, false},
+		{"proxy", configList, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dialer := NewDialer(utls.HelloChrome_Auto)
+			dialer.RequireECH = true
+			var proxied []string
+			if tc.proxy {
+				dialer.Proxy = ech.ProxyFunc(func(ctx context.Context, network, addr string) (net.Conn, error) {
+					proxied = append(proxied, addr)
+					var d net.Dialer
+					return d.DialContext(ctx, network, addr)
+				})
+			}
+			conn, err := dialer.Dial(t.Context(), "tcp", ln.Addr().String(), &tls.Config{
+				ServerName:                     "private.example.com",
+				RootCAs:                        rootCAs,
+				EncryptedClientHelloConfigList: tc.configList,
+			})
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+			if !conn.ConnectionState().ECHAccepted {
+				t.Errorf("ECHAccepted is false")
+			}
+			b, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if got, want := string(b), "Hello!\n"; got != want {
+				t.Errorf("Got %q, want %q", got, want)
+			}
+			if tc.proxy && len(proxied) != 1 {
+				t.Errorf("Proxy called with %v, want 1 address", proxied)
+			}
+		})
+	}
+}
+
+func ExampleNewDialer() {
+	dialer := NewDialer(utls.HelloChrome_Auto)
+	dialer.RequireECH = true
+
+	conn, err := dialer.Dial(context.Background(), "tcp", "private.example.com:443", &tls.Config{})
+	if err != nil {
+		log.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	log.Printf("ECH accepted: %v", conn.ConnectionState().ECHAccepted)
+}
+
+var _ net.Conn = (*Conn)(nil)
@@ -0,0 +1,22 @@
+module github.com/c2FmZQ/ech/utls
+
+go 1.26.0
+
+require (
+	github.com/c2FmZQ/ech v0.3.6
+	github.com/refraction-networking/utls v1.8.2
+)
+
+require (
+	github.com/andybalholm/brotli v1.0.6 // indirect
+	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
+	github.com/hashicorp/go-retryablehttp v0.7.8 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/klauspost/compress v1.17.4 // indirect
+	golang.org/x/crypto v0.48.0 // indirect
+	golang.org/x/net v0.49.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+)
+
+replace github.com/c2FmZQ/ech => ../